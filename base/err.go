@@ -23,7 +23,10 @@ SOFTWARE.
 
 package base
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+)
 
 
 var (
@@ -37,4 +40,20 @@ var (
 func BoilDownError(err error) error {
 	return err
 }
+// Returns the HTTP status code of err, shared by the frontends.
+func StatusOf(err error) int {
+	switch BoilDownError(err) {
+	case nil:
+		return http.StatusOK
+	case EOpNotSupp:
+		return http.StatusNotImplemented
+	case EServerAccessDenied,EDiskFailure:
+		return http.StatusInternalServerError
+	case ENotFound:
+		return http.StatusNotFound
+	case EExist:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusNotFound
+}
 //
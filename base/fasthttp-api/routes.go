@@ -31,19 +31,7 @@ import (
 )
 
 func statusFrom(err error) int {
-	switch base.BoilDownError(err) {
-	case nil:
-		return fasthttp.StatusOK
-	case base.EOpNotSupp:
-		return fasthttp.StatusNotImplemented
-	case base.EServerAccessDenied,base.EDiskFailure:
-		return fasthttp.StatusInternalServerError
-	case base.ENotFound:
-		return fasthttp.StatusNotFound
-	case base.EExist:
-		return fasthttp.StatusPreconditionFailed
-	}
-	return fasthttp.StatusNotFound
+	return base.StatusOf(err)
 }
 
 type apiOL struct{
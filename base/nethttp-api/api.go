@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package nhapi
+
+import (
+	"io"
+	"net/http"
+	
+	"github.com/byte-mug/hblobstore/base"
+)
+
+type reqCtx struct{
+	w    http.ResponseWriter
+	body []byte
+}
+
+func (r *reqCtx) SetBody(data []byte) {
+	r.w.Write(data)
+}
+func (r *reqCtx) GetBodyBuffer() io.Writer {
+	return r.w
+}
+func (r *reqCtx) GetRequestBody() []byte {
+	return r.body
+}
+
+// Wraps a request. The request body must have been read into body.
+func Wrap(w http.ResponseWriter,body []byte) base.ReqCtx {
+	return base.FromCtx(&reqCtx{w,body})
+}
+
+///
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Exposes base.ObjectLayer as net/http Handler, with the same routes and status
+codes as base/fasthttp-api.
+*/
+package nhapi
+
+import (
+	"io"
+	"strings"
+	"strconv"
+	"net/http"
+	
+	"github.com/byte-mug/hblobstore/base"
+)
+
+func setError(err error,w http.ResponseWriter) {
+	w.WriteHeader(base.StatusOf(err))
+	io.WriteString(w,err.Error())
+}
+
+type Handler struct{
+	ol base.ObjectLayer
+}
+
+func New(ol base.ObjectLayer) *Handler {
+	return &Handler{ol}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method=="HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path,"/o/")
+	if len(name)==len(r.URL.Path) || name=="" || strings.IndexByte(name,'/')>=0 {
+		http.NotFound(w,r)
+		return
+	}
+	obj := []byte(name)
+	switch r.Method {
+	case "OPTIONS": h.headObject(w,r,obj)
+	case "GET": h.getObject(w,r,obj)
+	case "PUT": h.putObject(w,r,obj)
+	case "POST": h.postObject(w,r,obj)
+	case "DELETE": h.deleteObject(w,r,obj)
+	default: w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	sz,err := h.ol.HeadObject(obj)
+	if err!=nil {
+		setError(err,w)
+		return
+	}
+	w.Header().Set("X-Length",strconv.FormatInt(sz,10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	var rang base.ByteRange
+	rang[0],_ = strconv.Atoi(r.Header.Get("X-Offset"))
+	rang[1],_ = strconv.Atoi(r.Header.Get("X-Length"))
+	
+	// base.ObjectLayer.GetObject writes the body before it returns, thus
+	// errors can only be reported, if nothing has been written.
+	if err := h.ol.GetObject(obj,rang,Wrap(w,nil)); err!=nil { setError(err,w) }
+}
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	body,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = h.ol.PutObject(obj,Wrap(w,body)); err!=nil {
+		setError(err,w)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+func (h *Handler) postObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	body,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = h.ol.AppendObject(obj,Wrap(w,body)); err!=nil {
+		setError(err,w)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if err := h.ol.DeleteObject(obj); err!=nil {
+		setError(err,w)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+///
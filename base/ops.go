@@ -48,4 +48,29 @@ func (r ReqCtx) GetRequestBody() []byte {
 	return r.Ops.GetRequestBody(r.Ptr)
 }
 
+// Type-safe request context. Use FromCtx to obtain a ReqCtx.
+type Ctx interface{
+	SetBody(data []byte)
+	GetBodyBuffer() io.Writer
+	GetRequestBody() []byte
+}
+
+type ctxBox struct{
+	c Ctx
+}
+func boxCast(p unsafe.Pointer) Ctx {
+	return (*ctxBox)(p).c
+}
+
+var boxOps = CtxOps{
+	SetBody: func(p unsafe.Pointer,data []byte) { boxCast(p).SetBody(data) },
+	GetBodyBuffer: func(p unsafe.Pointer) io.Writer { return boxCast(p).GetBodyBuffer() },
+	GetRequestBody: func(p unsafe.Pointer) []byte { return boxCast(p).GetRequestBody() },
+}
+
+// Creates a ReqCtx from c. Ops and Ptr are guaranteed to match.
+func FromCtx(c Ctx) ReqCtx {
+	return ReqCtx{Ops:&boxOps,Ptr:unsafe.Pointer(&ctxBox{c})}
+}
+
 ///
@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"bufio"
+	
+	"github.com/byte-mug/hblobstore/single/archive"
+	"github.com/byte-mug/hblobstore/single/client"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func cmdExport(args []string) int {
+	c := newCliFlags("export")
+	prefix := c.String("prefix","","only export objects with this prefix")
+	versions := c.Bool("versions",false,"export all versions of versioned stores")
+	out := c.String("o","","write to this file instead of stdout")
+	snapshot := c.String("snapshot","","export this snapshot, rather than the objects")
+	if !c.parse(args,1,1) { return 2 }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out!="" {
+		if f,err = os.Create(*out); err!=nil { return c.fail(err) }
+		w = f
+	}
+	bw := bufio.NewWriterSize(w,1<<20)
+	
+	// Servers create the archive themselves.
+	cl,remote := svc.(*client.Client)
+	switch {
+	case remote && *snapshot!="":
+		err = cl.ExportSnapshot(bw,*snapshot,*prefix)
+	case remote:
+		err = cl.Export(bw,*prefix,*versions)
+	case *snapshot!="":
+		if svc,err = files.OpenSnapshot(svc,*snapshot); err!=nil { break }
+		fallthrough
+	default:
+		err = archive.Export(svc,bw,archive.ExportOptions{Prefix:*prefix,Versions:*versions})
+	}
+	if err==nil { err = bw.Flush() }
+	if f!=nil {
+		if cerr := f.Close(); err==nil { err = cerr }
+	}
+	return c.result(err)
+}
+
+func cmdImport(args []string) int {
+	c := newCliFlags("import")
+	conflict := c.String("conflict","fail","what to do with existing objects: fail, skip or overwrite")
+	partSize := c.Int("part-size",defaultPartSize,"upload larger objects in parts of this size, if the store supports it")
+	if !c.parse(args,1,2) { return 2 }
+	cm,err := archive.ParseConflict(*conflict)
+	if err!=nil { return c.fail(err) }
+	in,err := openInput(c.Args()[1:])
+	if err!=nil { return c.fail(err) }
+	defer in.Close()
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	st,err := archive.Import(svc,bufio.NewReaderSize(in,1<<20),archive.ImportOptions{Conflict:cm,PartSize:*partSize})
+	c.print(st,fmt.Sprintf("%d objects, %d entries, %d bytes imported, %d skipped",st.Objects,st.Entries,st.Bytes,st.Skipped))
+	if st.Unretained!=0 { fmt.Fprintf(os.Stderr,"hblobstore import: the retention of %d objects was dropped\n",st.Unretained) }
+	return c.result(err)
+}
+
+///
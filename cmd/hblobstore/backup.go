@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"fmt"
+	"time"
+	
+	"github.com/byte-mug/hblobstore/single/archive"
+	"github.com/byte-mug/hblobstore/single/backup"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func cmdBackup(args []string) int {
+	c := newCliFlags("backup")
+	prefix := c.String("prefix","","only back up objects with this prefix")
+	full := c.Bool("full",false,"copy every object, rather than the changes since the last backup")
+	verify := c.Bool("verify",false,"read the backed up data again, rather than trusting checksums and modification times")
+	snapshot := c.String("snapshot","","back up this snapshot of a local data directory")
+	if !c.parse(args,2,2) { return 2 }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	target,err := c.open(c.Arg(1))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(target)
+	if *snapshot!="" {
+		if svc,err = files.OpenSnapshot(svc,*snapshot); err!=nil { return c.fail(err) }
+	}
+	
+	st,err := backup.Backup(svc,target,backup.BackupOptions{Prefix:*prefix,Full:*full,Verify:*verify})
+	c.print(st,fmt.Sprintf("generation %d: %d new, %d appended, %d unchanged, %d deleted, %d bytes copied",st.Generation,st.New,st.Appended,st.Unchanged,st.Deleted,st.Copied))
+	return c.result(err)
+}
+
+func cmdRestore(args []string) int {
+	c := newCliFlags("restore")
+	gen := c.Int("generation",0,"restore this generation, rather than the latest one")
+	prefix := c.String("prefix","","only restore objects with this prefix")
+	conflict := c.String("conflict","fail","what to do with existing objects: fail, skip or overwrite")
+	if !c.parse(args,2,2) { return 2 }
+	cm,err := archive.ParseConflict(*conflict)
+	if err!=nil { return c.fail(err) }
+	target,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(target)
+	svc,err := c.open(c.Arg(1))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	st,err := backup.Restore(target,svc,backup.RestoreOptions{Generation:*gen,Prefix:*prefix,Conflict:cm})
+	c.print(st,fmt.Sprintf("generation %d: %d objects, %d bytes restored, %d skipped",st.Generation,st.Objects,st.Bytes,st.Skipped))
+	return c.result(err)
+}
+
+func cmdGenerations(args []string) int {
+	c := newCliFlags("generations")
+	if !c.parse(args,1,1) { return 2 }
+	target,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(target)
+	
+	infos,err := backup.Generations(target)
+	if err!=nil { return c.fail(err) }
+	if c.asJson {
+		if infos==nil { infos = []backup.GenerationInfo{} }
+		c.print(infos,"")
+		return 0
+	}
+	for _,info := range infos {
+		fmt.Printf("%d\t%s\t%d objects\t%d bytes\t%d copied\n",info.Generation,info.Created.Format(time.RFC3339),info.Objects,info.Bytes,info.Copied)
+	}
+	return 0
+}
+
+///
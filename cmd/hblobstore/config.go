@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"os"
+	"time"
+	"bytes"
+	"encoding/json"
+	
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/events"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+// A time.Duration, that is written as string, like "30s", in the config file.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b,&s); err!=nil { return err }
+	v,err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+func (d duration) MarshalJSON() ([]byte,error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Settings, that require a restart to take effect.
+type storeConfig struct{
+	Listen  string `json:"listen"`
+	DataDir string `json:"data_dir"`
+	
+	// "files" for single/files or "base" for the deprecated base/fs.
+	Backend string `json:"backend"`
+	
+	// Certificate files are reloaded on change, nevertheless.
+	TLS tlsConfig `json:"tls"`
+	
+	Versioning         bool     `json:"versioning"`
+	WAL                bool     `json:"wal"`
+	Checksums          bool     `json:"checksums"`
+	DefaultRetention   duration `json:"default_retention"`
+	CheckpointInterval duration `json:"checkpoint_interval"`
+	UploadExpiry       duration `json:"upload_expiry"`
+	
+	// "/readyz" fails, once this many bytes or less are available on disk.
+	ReadyMinFree int64 `json:"ready_min_free"`
+	
+	// Serve "/metrics" and collect the metrics.
+	Metrics bool `json:"metrics"`
+	
+	AccessLog logConfig   `json:"access_log"`
+	AuditLog  auditConfig `json:"audit_log"`
+}
+
+// The options of the store in DataDir.
+func (sc *storeConfig) fileOptions() files.Options {
+	return files.Options{
+		Versioning: sc.Versioning,
+		WAL: sc.WAL,
+		Checksums: sc.Checksums,
+		DefaultRetention: time.Duration(sc.DefaultRetention),
+		CheckpointInterval: time.Duration(sc.CheckpointInterval),
+		UploadExpiry: time.Duration(sc.UploadExpiry),
+	}
+}
+
+// A log file, disabled if File is empty. It is rotated, once it reaches
+// MaxSize bytes, keeping MaxBackups rotated files.
+type logConfig struct{
+	File       string `json:"file"`
+	MaxSize    int64  `json:"max_size"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+type auditConfig struct{
+	logConfig
+	
+	// If set, the audit log is appended to daily objects with this prefix, too.
+	Object string `json:"object"`
+}
+
+// The s3 section of the config file. It requires a restart to take effect.
+type s3Config struct{
+	// A second listen address, that serves the S3 API.
+	Listen string `json:"listen"`
+	
+	// Maps bucket names to object name prefixes.
+	Buckets map[string]string `json:"buckets"`
+}
+
+// The events section of the config file. It requires a restart to take effect.
+type eventsConfig struct{
+	// The directory of the event log, outside of the data directory.
+	Dir string `json:"dir"`
+	
+	SegmentSize  int `json:"segment_size"`
+	KeepSegments int `json:"keep_segments"`
+	
+	Webhooks []events.Webhook `json:"webhooks"`
+}
+
+// The replica section of the config file. It requires a restart to take effect.
+type replicaConfig struct{
+	// The URL of the primary, like "http://primary:8080". It must have events.
+	Primary string `json:"primary"`
+	
+	// The credentials for the primary, which must be allowed to list and read every object.
+	Token  string `json:"token"`
+	KeyID  string `json:"key_id"`
+	Secret string `json:"secret"`
+	
+	// Keeps the position in the event log of the primary, outside of the data directory.
+	StateFile string `json:"state_file"`
+}
+
+// The config file of the server. All settings, except the storeConfig, are
+// reloaded on SIGHUP.
+type config struct{
+	storeConfig
+	
+	ReadOnly bool `json:"read_only"`
+	
+	// Authentication and authorization, disabled if nil.
+	Auth *auth.Config `json:"auth"`
+	
+	S3 *s3Config `json:"s3"`
+	
+	Events *eventsConfig `json:"events"`
+	
+	// Replicates the store from a primary, disabled if nil.
+	Replica *replicaConfig `json:"replica"`
+	
+	MaxRequestBodySize int      `json:"max_request_body_size"`
+	Concurrency        int      `json:"concurrency"`
+	MaxConnsPerIP      int      `json:"max_conns_per_ip"`
+	ReadTimeout        duration `json:"read_timeout"`
+	WriteTimeout       duration `json:"write_timeout"`
+	IdleTimeout        duration `json:"idle_timeout"`
+	
+	// How long to wait for in-flight requests on shutdown.
+	ShutdownTimeout duration `json:"shutdown_timeout"`
+}
+
+func loadConfig(pth string) (cfg *config,err error) {
+	data,err := os.ReadFile(pth)
+	if err!=nil { return }
+	cfg = &config{
+		storeConfig: storeConfig{
+			Listen: ":8080",
+			Backend: "files",
+		},
+		ShutdownTimeout: duration(30*time.Second),
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err!=nil { return nil,err }
+	return
+}
+
+///
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"encoding/json"
+	
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func cmdFsck(args []string) int {
+	fl := flag.NewFlagSet("fsck",flag.ExitOnError)
+	repair := fl.Bool("repair",false,"remove temporary files and orphaned sidecars, replay the intent log")
+	quarantine := fl.Bool("quarantine",false,"move corrupt and empty objects into the quarantine directory")
+	rate := fl.Int64("rate",0,"limit reads to this many bytes per second")
+	asJson := fl.Bool("json",false,"print a machine-readable report")
+	fl.Parse(args)
+	if fl.NArg()!=1 {
+		fmt.Fprintln(os.Stderr,"Usage: hblobstore "+commands["fsck"].usage)
+		fl.PrintDefaults()
+		return 2
+	}
+	
+	rep,err := files.Fsck(fl.Arg(0),files.FsckOptions{Repair:*repair,Quarantine:*quarantine,Rate:*rate})
+	if err!=nil {
+		fmt.Fprintln(os.Stderr,"fsck:",err)
+		return 1
+	}
+	if *asJson {
+		if rep.Problems==nil { rep.Problems = []files.Problem{} }
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("","\t")
+		enc.Encode(rep)
+	} else {
+		for _,p := range rep.Problems {
+			fmt.Printf("%s: %s",p.Path,p.Kind)
+			if p.Detail!="" { fmt.Printf(" (%s)",p.Detail) }
+			if p.Action!="" { fmt.Printf(", %s",p.Action) }
+			fmt.Println()
+		}
+		fmt.Printf("%d objects, %d bytes, %d verified, %d problems\n",rep.Objects,rep.Bytes,rep.Verified,len(rep.Problems))
+	}
+	if len(rep.Problems)>0 { return 1 }
+	return 0
+}
+
+///
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+The hblobstore command.
+
+Usage:
+
+	hblobstore <command> [arguments]
+
+The client commands take a store, which is either the URL of a server, like
+"http://localhost:8080", or a local data directory. The credentials for
+servers are taken from the environment variables HBLOBSTORE_TOKEN, or
+HBLOBSTORE_KEY_ID and HBLOBSTORE_SECRET.
+
+Run "hblobstore help" for a list of commands.
+*/
+package main
+
+import (
+	"os"
+	"fmt"
+	"sort"
+)
+
+type command struct{
+	run   func(args []string) int
+	usage string
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"fsck": {cmdFsck,"fsck [flags] <dir>\tverifies a data directory, which must not be in use"},
+		"serve": {cmdServe,"serve [-config file]\truns the server, SIGHUP reloads the config file"},
+		"put": {cmdPut,"put [flags] <store> <object> [file]\tcreates an object from a file or stdin"},
+		"get": {cmdGet,"get [flags] <store> <object>\twrites an object, or a range of it, to stdout"},
+		"append": {cmdAppend,"append [flags] <store> <object> [file]\tappends to an object and prints the written range"},
+		"stat": {cmdStat,"stat [flags] <store> <object>...\tprints the length of objects"},
+		"rm": {cmdRm,"rm [flags] <store> <object>...\tdeletes objects"},
+		"ls": {cmdLs,"ls [flags] <store>\tlists the objects"},
+		"presign": {cmdPresign,"presign [flags] <server> <object>\tprints a pre-signed URL for the object"},
+		"cp": {cmdCp,"cp [flags] <store> <object> <store> [object]\tcopies an object between stores"},
+		"export": {cmdExport,"export [flags] <store>\twrites the objects as tar archive to stdout"},
+		"import": {cmdImport,"import [flags] <store> [file]\timports a tar archive from a file or stdin"},
+		"snapshot": {cmdSnapshot,"snapshot [flags] <store> [name]\tcreates, lists or deletes snapshots of a data directory"},
+		"backup": {cmdBackup,"backup [flags] <store> <target>\tbacks up the changes since the last backup into the target store"},
+		"restore": {cmdRestore,"restore [flags] <target> <store>\trestores a backup generation into a store"},
+		"generations": {cmdGenerations,"generations [flags] <target>\tlists the backup generations of a target store"},
+		"replication": {cmdReplication,"replication [flags] <server>\tprints the state of a replica"},
+		"promote": {cmdPromote,"promote [flags] <server>\tpromotes a replica to a primary"},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr,"Usage: hblobstore <command> [arguments]\n\nCommands:")
+	names := make([]string,0,len(commands))
+	for name := range commands { names = append(names,name) }
+	sort.Strings(names)
+	for _,name := range names { fmt.Fprintln(os.Stderr,"\t"+commands[name].usage) }
+}
+
+func main() {
+	if len(os.Args)<2 { usage(); os.Exit(2) }
+	cmd,ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		if os.Args[1]=="help" { os.Exit(0) }
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+///
@@ -0,0 +1,315 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"strings"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/batch"
+	"github.com/byte-mug/hblobstore/single/client"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+type objResult struct{
+	Object  string         `json:"object"`
+	Offset  int64          `json:"offset"`
+	Length  int64          `json:"length"`
+	Version single.Version `json:"version,omitempty"`
+}
+
+func cmdPut(args []string) int {
+	c := newCliFlags("put")
+	partSize := c.Int("part-size",0,"upload in parts of this size, which allows to resume the upload")
+	resume := c.String("resume","","resume the upload with this id, using the same part size")
+	if !c.parse(args,2,3) { return 2 }
+	if *partSize>0 || *resume!="" { return c.putParts(*partSize,*resume) }
+	data,err := readInput(c.Args()[2:])
+	if err!=nil { return c.fail(err) }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	res := objResult{Object:c.Arg(1),Length:int64(len(data))}
+	if vs := single.AsVersionSvc(svc); vs!=nil {
+		res.Version,err = vs.PutVersion([]byte(c.Arg(1)),data)
+	} else {
+		err = svc.PutObj([]byte(c.Arg(1)),data)
+	}
+	if err!=nil { return c.fail(err) }
+	text := ""
+	if res.Version!=0 { text = res.Version.String() }
+	c.print(res,text)
+	return 0
+}
+
+// The part size of resumed uploads, if -part-size is missing.
+const defaultPartSize = 16<<20
+
+/*
+Uploads the input in parts. When resuming, parts, that have been uploaded
+with the same length, are skipped. On failure, the upload is left in place,
+so that it can be resumed.
+*/
+func (c *cliFlags) putParts(size int,uploadId string) int {
+	if size<=0 { size = defaultPartSize }
+	in,err := openInput(c.Args()[2:])
+	if err!=nil { return c.fail(err) }
+	defer in.Close()
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	us := single.AsUploadSvc(svc)
+	if us==nil { return c.fail(single.EOpNotSupp) }
+	
+	name := []byte(c.Arg(1))
+	done := make(map[int]int64)
+	if uploadId=="" {
+		if uploadId,err = us.CreateUpload(name); err!=nil { return c.fail(err) }
+	} else {
+		parts,err := us.ListParts(name,uploadId)
+		if err!=nil { return c.fail(err) }
+		for _,pi := range parts { done[pi.Part] = pi.Length }
+	}
+	
+	res := objResult{Object:c.Arg(1)}
+	var parts []int
+	buf := make([]byte,size)
+	for len(parts)==0 || len(buf)==size {
+		var n int
+		n,err = io.ReadFull(in,buf[:size])
+		if err==io.EOF || err==io.ErrUnexpectedEOF { err = nil }
+		if err!=nil || n==0 { break }
+		buf = buf[:n]
+		part := len(parts)+1
+		if l,ok := done[part]; !ok || l!=int64(n) { err = us.UploadPart(name,uploadId,part,buf) }
+		if err!=nil { break }
+		parts = append(parts,part)
+		res.Length += int64(n)
+	}
+	if err==nil { res.Version,err = us.CompleteUpload(name,uploadId,parts) }
+	if err!=nil { return c.fail(fmt.Errorf("%v (resume with -resume %s)",err,uploadId)) }
+	text := ""
+	if res.Version!=0 { text = res.Version.String() }
+	c.print(res,text)
+	return 0
+}
+
+func cmdGet(args []string) int {
+	c := newCliFlags("get")
+	var pos single.ByteRange
+	c.Int64Var(&pos[0],"offset",0,"start reading at this offset")
+	c.Int64Var(&pos[1],"length",0,"read at most this many bytes, 0 reads to the end")
+	out := c.String("o","","write to this file instead of stdout")
+	follow := c.Bool("follow",false,"stream the data, that is appended, until the object is deleted")
+	if !c.parse(args,2,2) { return 2 }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	// Appends by other processes wouldn't wake up a local store.
+	if _,remote := svc.(*client.Client); *follow && !remote { return c.fail(errors.New("-follow requires a server URL")) }
+	read := func(w io.Writer) error {
+		if !*follow { return readTo(svc,[]byte(c.Arg(1)),pos,w) }
+		return svc.(*client.Client).Follow([]byte(c.Arg(1)),pos[0],nil,func(data []byte) (err error) {
+			_,err = w.Write(data)
+			return
+		})
+	}
+	if *out=="" { return c.result(read(os.Stdout)) }
+	f,err := os.Create(*out)
+	if err!=nil { return c.fail(err) }
+	err = read(f)
+	if cerr := f.Close(); err==nil { err = cerr }
+	return c.result(err)
+}
+func (c *cliFlags) result(err error) int {
+	if err!=nil { return c.fail(err) }
+	return 0
+}
+
+func cmdAppend(args []string) int {
+	c := newCliFlags("append")
+	if !c.parse(args,2,3) { return 2 }
+	data,err := readInput(c.Args()[2:])
+	if err!=nil { return c.fail(err) }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	pos,err := svc.Append([]byte(c.Arg(1)),data)
+	if err!=nil { return c.fail(err) }
+	c.print(objResult{Object:c.Arg(1),Offset:pos[0],Length:pos[1]},fmt.Sprint(pos[0]," ",pos[1]))
+	return 0
+}
+
+// Runs the operations in batches, with a request per batch on servers.
+func runBatch(svc single.ObjectSvc,ops []proto.BatchOp) (res []proto.BatchResult,err error) {
+	cl,remote := svc.(*client.Client)
+	b := batch.New(svc,batch.DefaultWorkers)
+	for len(ops)!=0 {
+		n := len(ops)
+		if n>proto.MaxBatch { n = proto.MaxBatch }
+		var r []proto.BatchResult
+		if remote {
+			if r,err = cl.Batch(ops[:n]); err!=nil { return }
+		} else {
+			r = b.Run(ops[:n],nil)
+		}
+		res = append(res,r...)
+		ops = ops[n:]
+	}
+	return
+}
+
+// Runs op on every object, that is named by the arguments after the store.
+func (c *cliFlags) batchArgs(op string) (res []proto.BatchResult,err error) {
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return }
+	defer closeStore(svc)
+	
+	ops := make([]proto.BatchOp,c.NArg()-1)
+	for i := range ops { ops[i] = proto.BatchOp{Op:op,Object:c.Arg(i+1)} }
+	return runBatch(svc,ops)
+}
+
+func cmdStat(args []string) int {
+	c := newCliFlags("stat")
+	if !c.parse(args,2,-1) { return 2 }
+	res,err := c.batchArgs("info")
+	if err!=nil { return c.fail(err) }
+	
+	code := 0
+	for i := range res {
+		name := c.Arg(i+1)
+		if err := res[i].Err(); err!=nil {
+			code = c.fail(fmt.Errorf("%s: %v",name,err))
+			continue
+		}
+		text := fmt.Sprint(res[i].Length)
+		if len(res)>1 { text = name+"\t"+text }
+		c.print(objResult{Object:name,Length:res[i].Length},text)
+	}
+	return code
+}
+
+func cmdRm(args []string) int {
+	c := newCliFlags("rm")
+	if !c.parse(args,2,-1) { return 2 }
+	res,err := c.batchArgs("delete")
+	if err!=nil { return c.fail(err) }
+	
+	code := 0
+	for i := range res {
+		if err := res[i].Err(); err!=nil { code = c.fail(fmt.Errorf("%s: %v",c.Arg(i+1),err)) }
+	}
+	return code
+}
+
+func cmdLs(args []string) int {
+	c := newCliFlags("ls")
+	prefix := c.String("prefix","","only list objects with this prefix")
+	if !c.parse(args,1,1) { return 2 }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	ls := single.AsListSvc(svc)
+	if ls==nil { return c.fail(single.EOpNotSupp) }
+	
+	all := []string{}
+	var after []byte
+	for {
+		names,err := ls.ListObjs([]byte(*prefix),after,1000)
+		if err!=nil { return c.fail(err) }
+		for _,name := range names {
+			if c.asJson { all = append(all,string(name)) } else { fmt.Printf("%s\n",name) }
+		}
+		if len(names)==0 { break }
+		after = names[len(names)-1]
+	}
+	c.print(all,"")
+	return 0
+}
+
+// Objects are copied in chunks of this size.
+const cpChunk = 4<<20
+
+func cmdCp(args []string) int {
+	c := newCliFlags("cp")
+	if !c.parse(args,3,4) { return 2 }
+	src,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(src)
+	dst,err := c.open(c.Arg(2))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(dst)
+	sname,dname := []byte(c.Arg(1)),[]byte(c.Arg(1))
+	if c.NArg()>3 { dname = []byte(c.Arg(3)) }
+	
+	lng,err := src.Info(sname)
+	if err!=nil { return c.fail(err) }
+	
+	// The first chunk creates the object, the others are appended.
+	var buf bytes.Buffer
+	for off := int64(0); off==0 || off<lng; off += cpChunk {
+		buf.Reset()
+		if err = readTo(src,sname,single.ByteRange{off,cpChunk},&buf); err!=nil { return c.fail(err) }
+		if off==0 {
+			err = dst.PutObj(dname,buf.Bytes())
+		} else {
+			_,err = dst.Append(dname,buf.Bytes())
+		}
+		if err!=nil { return c.fail(err) }
+	}
+	c.print(objResult{Object:string(dname),Length:lng},"")
+	return 0
+}
+
+func cmdPresign(args []string) int {
+	c := newCliFlags("presign")
+	method := c.String("method","GET","the method, GET or PUT")
+	ttl := c.Duration("ttl",15*time.Minute,"how long the URL is valid")
+	if !c.parse(args,2,2) { return 2 }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	
+	cl,ok := svc.(*client.Client)
+	if !ok { return c.fail(errors.New("the store must be a server URL")) }
+	u,err := cl.Presign(strings.ToUpper(*method),[]byte(c.Arg(1)),0,*ttl)
+	if err!=nil { return c.fail(err) }
+	c.print(struct{
+		URL string `json:"url"`
+	}{u},u)
+	return 0
+}
+
+///
@@ -0,0 +1,81 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"fmt"
+	"errors"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/client"
+)
+
+func replicationText(st single.ReplicationStatus) string {
+	s := fmt.Sprintf("primary %s: applied %d of %d events, %d behind, %.1fs lag, %d resyncs",st.Primary,st.Applied,st.Last,st.LagEvents,st.LagSeconds,st.Resyncs)
+	if st.Promoted { s += ", promoted" }
+	if st.ReadOnly { s += ", read only" }
+	if st.Error!="" { s += "\nerror: "+st.Error }
+	return s
+}
+
+// Opens a server, the commands of the admin API don't work on local data directories.
+func (c *cliFlags) openServer(spec string) (*client.Client,error) {
+	svc,err := c.open(spec)
+	if err!=nil { return nil,err }
+	cl,ok := svc.(*client.Client)
+	if !ok {
+		closeStore(svc)
+		return nil,errors.New("a server URL is required")
+	}
+	return cl,nil
+}
+
+func cmdReplication(args []string) int {
+	c := newCliFlags("replication")
+	if !c.parse(args,1,1) { return 2 }
+	cl,err := c.openServer(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer cl.Close()
+	
+	st,err := cl.Replication()
+	if err!=nil { return c.fail(err) }
+	c.print(st,replicationText(st))
+	return 0
+}
+
+func cmdPromote(args []string) int {
+	c := newCliFlags("promote")
+	if !c.parse(args,1,1) { return 2 }
+	cl,err := c.openServer(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer cl.Close()
+	
+	st,err := cl.Promote()
+	if err!=nil { return c.fail(err) }
+	c.print(st,replicationText(st))
+	return 0
+}
+
+///
@@ -0,0 +1,518 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"log"
+	"net"
+	"flag"
+	"sync"
+	"time"
+	"errors"
+	"reflect"
+	"strings"
+	"syscall"
+	"os/signal"
+	"path/filepath"
+	"crypto/tls"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/base/fs"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/admin"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/client"
+	"github.com/byte-mug/hblobstore/single/events"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/guard"
+	"github.com/byte-mug/hblobstore/single/logs"
+	"github.com/byte-mug/hblobstore/single/metrics"
+	"github.com/byte-mug/hblobstore/single/replica"
+	"github.com/byte-mug/hblobstore/util/hu"
+	
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+	bfhapi "github.com/byte-mug/hblobstore/base/fasthttp-api"
+	sfhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+	s3api "github.com/byte-mug/hblobstore/single/s3-api"
+)
+
+/*
+A net.Listener, that is fed by the server's accept loop.
+
+Each fasthttp.Server gets it's own connQueue, thus a server can be replaced
+on reload, while the old one drains it's connections.
+*/
+type connQueue struct{
+	ln   net.Listener
+	c    chan net.Conn
+	done chan struct{}
+	once sync.Once
+}
+func newConnQueue(ln net.Listener) *connQueue {
+	return &connQueue{ln:ln,c:make(chan net.Conn),done:make(chan struct{})}
+}
+func (q *connQueue) Accept() (net.Conn,error) {
+	select {
+	case c := <-q.c: return c,nil
+	case <-q.done: return nil,io.EOF // fasthttp treats io.EOF as regular close.
+	}
+}
+func (q *connQueue) Close() error {
+	q.once.Do(func(){ close(q.done) })
+	return nil
+}
+func (q *connQueue) Addr() net.Addr { return q.ln.Addr() }
+func (q *connQueue) push(c net.Conn) bool {
+	select {
+	case q.c <- c: return true
+	case <-q.done: return false
+	}
+}
+
+type server struct{
+	ln      net.Listener
+	handler fasthttp.RequestHandler
+	
+	// The S3 listener, if any. Both listeners feed the same fasthttp.Server,
+	// the handler tells them apart by the local port.
+	s3ln   net.Listener
+	s3port int
+	
+	// The store and its auth, nil for the base backend.
+	guard *guard.Guard
+	auth  *auth.Auth
+	store io.Closer
+	
+	// The event log, if any.
+	events *events.Log
+	
+	// The replica, if the store is one, and the admin of the store.
+	replica *replica.Replica
+	adm     *admin.Admin
+	
+	// Closed on shutdown, ends the follow streams.
+	stop chan struct{}
+	
+	// The log files, reopened on SIGHUP, and the access and audit logs, if any.
+	logs   []*logs.RotatingFile
+	access io.Writer
+	audit  io.Writer
+	
+	mu    sync.Mutex
+	cfg   *config
+	srv   *fasthttp.Server
+	queue *connQueue
+	
+	// Servers, that are being shut down.
+	draining sync.WaitGroup
+}
+
+func (s *server) open(cfg *config) (err error) {
+	router := fhr.New()
+	switch cfg.Backend {
+	case "files":
+		opts := cfg.fileOptions()
+		if cfg.S3!=nil {
+			// Abandoned multipart uploads of S3 must be removable.
+			prefixes := make([]string,0,len(cfg.S3.Buckets))
+			for _,p := range cfg.S3.Buckets { prefixes = append(prefixes,p) }
+			opts.NoRetention = s3api.UploadObjects(prefixes)
+		}
+		if cfg.Events!=nil {
+			if err = s.openEvents(cfg); err!=nil { return err }
+			opts.UploadExpired = func(objectId []byte,uploadId string) {
+				if err := s.events.Emit(events.Event{Type:events.Expired,Object:string(objectId),Upload:uploadId}); err!=nil { log.Println("events:",err) }
+			}
+		}
+		svc,err := files.ServeFileOpts(cfg.DataDir,opts)
+		if err!=nil { return err }
+		s.guard = guard.New(svc)
+		s.guard.SetReadOnly(cfg.ReadOnly)
+		s.store = s.guard
+		s.auth = auth.New(cfg.Auth)
+		
+		var store single.ObjectSvc = s.guard
+		var m *metrics.Metrics
+		if cfg.Metrics {
+			m = metrics.New()
+			store = metrics.Wrap(s.guard,m)
+			storeGauges(m,svc)
+			sfhapi.RegisterMetrics(m,router,s.auth)
+		}
+		if s.events!=nil {
+			store = events.Wrap(store,s.events)
+			sfhapi.RegisterEvents(s.events,router,s.auth)
+		}
+		if cfg.Replica!=nil {
+			// Replicas of the replica follow it's events.
+			var dst single.ObjectSvc = svc
+			if s.events!=nil { dst = events.Wrap(svc,s.events) }
+			if s.replica,err = openReplica(cfg,dst); err!=nil { return err }
+			if m!=nil { replicaGauges(m,s.replica) }
+		}
+		hu.RegisterBase(router)
+		s.stop = make(chan struct{})
+		sfhapi.RegisterObjectSvcStop(store,router,s.auth,s.stop)
+		sfhapi.RegisterExport(store,router,s.auth)
+		s.adm = admin.New(s.guard,uint64(cfg.ReadyMinFree))
+		if s.replica!=nil { s.adm.SetReplica(s.replica) }
+		sfhapi.RegisterAdmin(s.adm,router,s.auth)
+		api,err := s.openLogs(cfg,s.guard,router.Handler)
+		if err!=nil { return err }
+		s.handler = api
+		if cfg.S3!=nil {
+			if err = s.openS3(cfg.S3,time.Duration(cfg.UploadExpiry),store,api); err!=nil { return err }
+		}
+		if m!=nil { s.handler = m.Handler(s.handler) }
+		return nil
+	case "base":
+		if cfg.ReadOnly { return errors.New("read_only is not supported by the base backend") }
+		if cfg.Auth!=nil { return errors.New("auth is not supported by the base backend") }
+		if cfg.S3!=nil { return errors.New("s3 is not supported by the base backend") }
+		if cfg.Metrics { return errors.New("metrics are not supported by the base backend") }
+		if cfg.Events!=nil { return errors.New("events are not supported by the base backend") }
+		if cfg.Replica!=nil { return errors.New("replica is not supported by the base backend") }
+		if cfg.AccessLog.File!="" || cfg.AuditLog.File!="" || cfg.AuditLog.Object!="" {
+			return errors.New("access_log and audit_log are not supported by the base backend")
+		}
+		bfhapi.RegisterBase(router)
+		bfhapi.RegisterObjectLayer(fs.ServeFile(cfg.DataDir),router)
+	default:
+		return fmt.Errorf("unknown backend %q",cfg.Backend)
+	}
+	s.handler = router.Handler
+	return
+}
+
+func (s *server) openEvents(cfg *config) (err error) {
+	ec := cfg.Events
+	if ec.Dir=="" { return errors.New("events: dir is required") }
+	
+	// Fsck would quarantine the event log.
+	if rel,rerr := filepath.Rel(cfg.DataDir,ec.Dir); rerr==nil && !strings.HasPrefix(rel,"..") {
+		return errors.New("events: dir must be outside of data_dir")
+	}
+	s.events,err = events.Open(ec.Dir,events.Options{SegmentSize:ec.SegmentSize,KeepSegments:ec.KeepSegments})
+	if err!=nil { return }
+	s.events.Deliver(ec.Webhooks)
+	return
+}
+
+func openReplica(cfg *config,dst single.ObjectSvc) (*replica.Replica,error) {
+	rc := cfg.Replica
+	if rc.Primary=="" { return nil,errors.New("replica: primary is required") }
+	if rc.StateFile=="" { return nil,errors.New("replica: state_file is required") }
+	if rel,rerr := filepath.Rel(cfg.DataDir,rc.StateFile); rerr==nil && !strings.HasPrefix(rel,"..") {
+		return nil,errors.New("replica: state_file must be outside of data_dir")
+	}
+	src,err := client.New(rc.Primary,client.Options{
+		Timeout: time.Minute,
+		Retries: 3,
+		Token: rc.Token,
+		KeyID: rc.KeyID,
+		Secret: rc.Secret,
+	})
+	if err!=nil { return nil,err }
+	r,err := replica.New(src,dst,rc.StateFile,rc.Primary)
+	if err==replica.ErrPromoted { err = errors.New("replica: the server was promoted, remove the replica section") }
+	return r,err
+}
+
+// Exports the lag of the replica as gauges.
+func replicaGauges(m *metrics.Metrics,r *replica.Replica) {
+	m.GaugeFunc("hblobstore_replication_lag_events","Events of the primary, that the replica didn't apply yet.",func() float64 { return float64(r.Status().LagEvents) })
+	m.GaugeFunc("hblobstore_replication_lag_seconds","Seconds since the replica was up to date.",func() float64 { return r.Status().LagSeconds })
+}
+
+func (s *server) openLog(cfg logConfig) (w io.Writer,err error) {
+	rf,err := logs.OpenRotating(cfg.File,cfg.MaxSize,cfg.MaxBackups)
+	if err!=nil { return }
+	s.logs = append(s.logs,rf)
+	return rf,nil
+}
+
+// Opens the access and audit logs, and wraps the handler of the native API.
+// svc is the store, that the audit objects are appended to, below the events and metrics.
+func (s *server) openLogs(cfg *config,svc single.ObjectSvc,api fasthttp.RequestHandler) (fasthttp.RequestHandler,error) {
+	var audit []io.Writer
+	if cfg.AuditLog.File!="" {
+		w,err := s.openLog(cfg.AuditLog.logConfig)
+		if err!=nil { return nil,err }
+		audit = append(audit,w)
+	}
+	if pfx := cfg.AuditLog.Object; pfx!="" {
+		if strings.ContainsRune(pfx,'/') { return nil,errors.New("audit_log: the object prefix contains a '/'") }
+		// The objects would be retained after their first entry, refusing further ones.
+		if cfg.DefaultRetention>0 { return nil,errors.New("audit_log: object is not supported with default_retention") }
+		audit = append(audit,logs.ObjectWriter{Svc:svc,Prefix:pfx})
+	}
+	if len(audit)!=0 {
+		s.audit = io.MultiWriter(audit...)
+		api = logs.Audit(s.audit,"native",api)
+	}
+	if cfg.AccessLog.File!="" {
+		w,err := s.openLog(cfg.AccessLog)
+		if err!=nil { return nil,err }
+		s.access = w
+		api = logs.Access(w,"native",api)
+	}
+	return api,nil
+}
+
+// Exports the statistics of a single/files store as gauges.
+func storeGauges(m *metrics.Metrics,svc single.ObjectSvc) {
+	stat := func(f func(st files.Stats) int) func() float64 {
+		return func() float64 {
+			st,_ := files.StatsOf(svc)
+			return float64(f(st))
+		}
+	}
+	m.GaugeFunc("hblobstore_open_files","Object files, that are open.",stat(func(st files.Stats) int { return st.OpenFiles }))
+	m.GaugeFunc("hblobstore_deleting_files","Object files, that are being deleted.",stat(func(st files.Stats) int { return st.Deleting }))
+	m.GaugeFunc("hblobstore_pooled_paths","Object paths in the string pool.",stat(func(st files.Stats) int { return st.PooledPaths }))
+}
+
+func (s *server) openS3(cfg *s3Config,expiry time.Duration,store single.ObjectSvc,api fasthttp.RequestHandler) error {
+	if cfg.Listen=="" { return errors.New("s3: listen is required") }
+	buckets := make(map[string]s3api.Bucket,len(cfg.Buckets))
+	for name,prefix := range cfg.Buckets {
+		// Object names are file names in the files backend.
+		if strings.ContainsRune(prefix,'/') { return fmt.Errorf("s3: the prefix of bucket %q contains a '/'",name) }
+		buckets[name] = s3api.Bucket{Svc:store,Prefix:prefix,UploadExpiry:expiry}
+	}
+	s3h := s3api.New(buckets,s.auth).Handle
+	if s.audit!=nil { s3h = logs.Audit(s.audit,"s3",s3h) }
+	if s.access!=nil { s3h = logs.Access(s.access,"s3",s3h) }
+	s.handler = func(ctx *fasthttp.RequestCtx) {
+		if a,ok := ctx.LocalAddr().(*net.TCPAddr); ok && a.Port==s.s3port {
+			s3h(ctx)
+		} else {
+			api(ctx)
+		}
+	}
+	return nil
+}
+
+// Starts a new fasthttp.Server with the settings of cfg, and shuts down the old one.
+func (s *server) start(cfg *config) {
+	srv := &fasthttp.Server{
+		Handler: s.handler,
+		Name: "hblobstore",
+		Concurrency: cfg.Concurrency,
+		MaxConnsPerIP: cfg.MaxConnsPerIP,
+		MaxRequestBodySize: cfg.MaxRequestBodySize,
+		ReadTimeout: time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		IdleTimeout: time.Duration(cfg.IdleTimeout),
+	}
+	q := newConnQueue(s.ln)
+	go srv.Serve(q)
+	
+	s.mu.Lock()
+	old,oldq := s.srv,s.queue
+	s.cfg,s.srv,s.queue = cfg,srv,q
+	s.mu.Unlock()
+	
+	if old!=nil { s.drain(old,oldq) }
+}
+func (s *server) drain(srv *fasthttp.Server,q *connQueue) {
+	q.Close()
+	s.draining.Add(1)
+	go func(){
+		defer s.draining.Done()
+		srv.Shutdown()
+	}()
+}
+func (s *server) current() (*config,*connQueue) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	return s.cfg,s.queue
+}
+
+func (s *server) accept(ln net.Listener) {
+	for {
+		c,err := ln.Accept()
+		if err!=nil { return }
+		for {
+			_,q := s.current()
+			if q.push(c) { break }
+			
+			// The queue is closed and has not been replaced, we are shutting down.
+			if _,nq := s.current(); nq==q { c.Close(); break }
+		}
+	}
+}
+
+func (s *server) reload(pth string) {
+	cfg,err := loadConfig(pth)
+	if err!=nil {
+		log.Println("reload:",err)
+		return
+	}
+	old,_ := s.current()
+	if cfg.storeConfig!=old.storeConfig {
+		log.Println("reload: changes to the listen address, data directory or backend require a restart")
+		cfg.storeConfig = old.storeConfig
+	}
+	if !reflect.DeepEqual(cfg.S3,old.S3) {
+		log.Println("reload: changes to s3 require a restart")
+		cfg.S3 = old.S3
+	}
+	if !reflect.DeepEqual(cfg.Events,old.Events) {
+		log.Println("reload: changes to events require a restart")
+		cfg.Events = old.Events
+	}
+	if !reflect.DeepEqual(cfg.Replica,old.Replica) {
+		log.Println("reload: changes to replica require a restart")
+		cfg.Replica = old.Replica
+	}
+	if s.guard!=nil {
+		s.adm.SetReadOnly(cfg.ReadOnly)
+		s.auth.Update(cfg.Auth)
+	} else if cfg.ReadOnly || cfg.Auth!=nil {
+		log.Println("reload: read_only and auth are not supported by the base backend")
+	}
+	for _,rf := range s.logs {
+		if err := rf.Reopen(); err!=nil { log.Println("reload:",err) }
+	}
+	s.start(cfg)
+	log.Println("reloaded",pth)
+}
+
+func (s *server) shutdown() {
+	s.ln.Close()
+	if s.s3ln!=nil { s.s3ln.Close() }
+	
+	// End the event and follow streams, which would hold up the drain.
+	if s.events!=nil { s.events.Stop() }
+	if s.stop!=nil { close(s.stop) }
+	if s.replica!=nil { s.replica.Stop() }
+	s.mu.Lock()
+	cfg := s.cfg
+	s.drain(s.srv,s.queue)
+	s.mu.Unlock()
+	
+	// The timeout covers closing the store, which waits for the requests in flight.
+	expired := make(chan struct{})
+	t := time.AfterFunc(time.Duration(cfg.ShutdownTimeout),func(){ close(expired) })
+	defer t.Stop()
+	done := make(chan struct{})
+	go func(){
+		s.draining.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-expired:
+		log.Println("shutdown: timeout, closing the store with requests in flight")
+	}
+	if s.store!=nil {
+		closed := make(chan error,1)
+		go func(){ closed <- s.store.Close() }()
+		select {
+		case err := <-closed:
+			if err!=nil { log.Println("shutdown:",err) }
+		case <-expired:
+			log.Println("shutdown: timeout, exiting without closing the store")
+		}
+	}
+	if s.events!=nil { s.events.Close() }
+	for _,rf := range s.logs { rf.Close() }
+}
+
+func listen(addr string,l *tlsLoader) (ln net.Listener,err error) {
+	if ln,err = net.Listen("tcp",addr); err!=nil { return }
+	if l!=nil { ln = tls.NewListener(ln,l.serverConfig()) }
+	return
+}
+
+// Opens the listeners, which share the TLS settings.
+func (s *server) listen(cfg *config) (err error) {
+	var l *tlsLoader
+	if cfg.TLS.CertFile!="" {
+		if l,err = newTLSLoader(cfg.TLS); err!=nil { return }
+	}
+	if s.ln,err = listen(cfg.Listen,l); err!=nil { return }
+	if cfg.S3==nil || cfg.S3.Listen=="" { return }
+	if s.s3ln,err = listen(cfg.S3.Listen,l); err!=nil {
+		s.ln.Close()
+		return
+	}
+	s.s3port = s.s3ln.Addr().(*net.TCPAddr).Port
+	if s.s3port==s.ln.Addr().(*net.TCPAddr).Port {
+		s.ln.Close()
+		s.s3ln.Close()
+		return errors.New("s3: the listen address must use another port")
+	}
+	return
+}
+
+func cmdServe(args []string) int {
+	fl := flag.NewFlagSet("serve",flag.ExitOnError)
+	cpath := fl.String("config","hblobstore.json","the config file")
+	fl.Parse(args)
+	
+	cfg,err := loadConfig(*cpath)
+	if err!=nil {
+		fmt.Fprintln(os.Stderr,"serve:",err)
+		return 1
+	}
+	s := new(server)
+	if err = s.open(cfg); err!=nil {
+		fmt.Fprintln(os.Stderr,"serve:",err)
+		return 1
+	}
+	if err = s.listen(cfg); err!=nil {
+		fmt.Fprintln(os.Stderr,"serve:",err)
+		if s.store!=nil { s.store.Close() }
+		if s.events!=nil { s.events.Close() }
+		return 1
+	}
+	s.start(cfg)
+	if s.replica!=nil { s.replica.Start() }
+	go s.accept(s.ln)
+	log.Println("listening on",s.ln.Addr())
+	if s.s3ln!=nil {
+		go s.accept(s.s3ln)
+		log.Println("serving S3 on",s.s3ln.Addr())
+	}
+	
+	sig := make(chan os.Signal,1)
+	signal.Notify(sig,syscall.SIGHUP,syscall.SIGTERM,os.Interrupt)
+	for v := range sig {
+		if v==syscall.SIGHUP {
+			s.reload(*cpath)
+			continue
+		}
+		log.Println("shutting down")
+		s.shutdown()
+		return 0
+	}
+	return 0
+}
+
+///
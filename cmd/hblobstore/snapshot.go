@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/client"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func cmdSnapshot(args []string) int {
+	c := newCliFlags("snapshot")
+	list := c.Bool("list",false,"list the snapshots")
+	del := c.Bool("delete",false,"delete the named snapshot")
+	if !c.parse(args,1,2) { return 2 }
+	svc,err := c.open(c.Arg(0))
+	if err!=nil { return c.fail(err) }
+	defer closeStore(svc)
+	cl,remote := svc.(*client.Client)
+	name := c.Arg(1)
+	
+	switch {
+	case *list:
+		var infos []single.SnapshotInfo
+		if remote {
+			infos,err = cl.Snapshots()
+		} else {
+			infos,err = files.Snapshots(svc)
+		}
+		if err!=nil { return c.fail(err) }
+		if c.asJson {
+			if infos==nil { infos = []single.SnapshotInfo{} }
+			c.print(infos,"")
+			return 0
+		}
+		for _,info := range infos {
+			fmt.Printf("%s\t%s\t%d objects\t%d bytes\n",info.Name,info.Created.Format(time.RFC3339),info.Objects,info.Bytes)
+		}
+		return 0
+	case *del:
+		if name=="" { return c.fail(errors.New("-delete requires a name")) }
+		if remote { return c.result(cl.DeleteSnapshot(name)) }
+		return c.result(files.DeleteSnapshot(svc,name))
+	}
+	var info single.SnapshotInfo
+	if remote {
+		info,err = cl.CreateSnapshot(name)
+	} else {
+		info,err = files.CreateSnapshot(svc,name)
+	}
+	if err!=nil { return c.fail(err) }
+	c.print(info,info.Name)
+	return 0
+}
+
+///
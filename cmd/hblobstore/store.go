@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"flag"
+	"time"
+	"errors"
+	"strings"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/client"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+// Flags, that are shared by the client commands.
+type cliFlags struct{
+	*flag.FlagSet
+	name      string
+	asJson    bool
+	versioned bool
+	config    string
+	timeout   time.Duration
+}
+func newCliFlags(name string) *cliFlags {
+	c := &cliFlags{FlagSet:flag.NewFlagSet(name,flag.ExitOnError),name:name}
+	c.BoolVar(&c.asJson,"json",false,"print machine-readable output")
+	c.BoolVar(&c.versioned,"versioned",false,"open local data directories as versioned store")
+	c.StringVar(&c.config,"config","","open local data directories with the store options of this server config file")
+	c.DurationVar(&c.timeout,"timeout",time.Minute,"timeout of HTTP requests")
+	return c
+}
+
+// Parses args and checks the number of positional arguments. A max below 0
+// means no limit.
+func (c *cliFlags) parse(args []string,min,max int) bool {
+	c.Parse(args)
+	if c.NArg()<min || (max>=0 && c.NArg()>max) {
+		fmt.Fprintln(os.Stderr,"Usage: hblobstore "+commands[c.name].usage)
+		c.PrintDefaults()
+		return false
+	}
+	return true
+}
+
+/*
+Opens a store. URLs, like "http://localhost:8080", refer to a server, every
+other spec to a local data directory, which must not be in use by a server.
+Local data directories are opened with the store options of the -config file,
+like versioning, the intent log, checksums and the default retention, thus
+they are maintained like the server does.
+
+The credentials for servers are taken from the environment variables
+HBLOBSTORE_TOKEN, or HBLOBSTORE_KEY_ID and HBLOBSTORE_SECRET. For https,
+HBLOBSTORE_CA_FILE names a PEM file of root CAs, HBLOBSTORE_CERT_FILE and
+HBLOBSTORE_KEY_FILE a client certificate.
+*/
+func (c *cliFlags) open(spec string) (svc single.ObjectSvc,err error) {
+	if strings.HasPrefix(spec,"http://") || strings.HasPrefix(spec,"https://") {
+		tc,err := clientTLS()
+		if err!=nil { return nil,err }
+		return client.New(spec,client.Options{
+			Timeout: c.timeout,
+			Retries: 3,
+			TLSConfig: tc,
+			Token: os.Getenv("HBLOBSTORE_TOKEN"),
+			KeyID: os.Getenv("HBLOBSTORE_KEY_ID"),
+			Secret: os.Getenv("HBLOBSTORE_SECRET"),
+		})
+	}
+	st,err := os.Stat(spec)
+	if err!=nil { return }
+	if !st.IsDir() { return nil,errors.New(spec+": not a directory") }
+	var opts files.Options
+	if c.config!="" {
+		cfg,err := loadConfig(c.config)
+		if err!=nil { return nil,err }
+		opts = cfg.fileOptions()
+	}
+	opts.Versioning = opts.Versioning || c.versioned
+	return files.ServeFileOpts(spec,opts)
+}
+// Returns the TLS settings from the environment, nil for the defaults.
+func clientTLS() (tc *tls.Config,err error) {
+	ca,cert,key := os.Getenv("HBLOBSTORE_CA_FILE"),os.Getenv("HBLOBSTORE_CERT_FILE"),os.Getenv("HBLOBSTORE_KEY_FILE")
+	if ca=="" && cert=="" { return }
+	tc = new(tls.Config)
+	if ca!="" {
+		pem,err := os.ReadFile(ca)
+		if err!=nil { return nil,err }
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) { return nil,errors.New(ca+": no certificates") }
+	}
+	if cert!="" {
+		pair,err := tls.LoadX509KeyPair(cert,key)
+		if err!=nil { return nil,err }
+		tc.Certificates = []tls.Certificate{pair}
+	}
+	return
+}
+
+func closeStore(svc single.ObjectSvc) {
+	if cl,ok := svc.(io.Closer); ok { cl.Close() }
+}
+
+func (c *cliFlags) fail(err error) int {
+	fmt.Fprintf(os.Stderr,"hblobstore %s: %v\n",c.name,err)
+	return 1
+}
+
+// Prints v as JSON, or text otherwise.
+func (c *cliFlags) print(v interface{},text string) {
+	if c.asJson {
+		json.NewEncoder(os.Stdout).Encode(v)
+	} else if text!="" {
+		fmt.Println(text)
+	}
+}
+
+// Reads the file named by the optional argument, or stdin.
+func readInput(args []string) ([]byte,error) {
+	if len(args)==0 || args[0]=="-" { return io.ReadAll(os.Stdin) }
+	return os.ReadFile(args[0])
+}
+
+// Opens the file named by the optional argument, or stdin.
+func openInput(args []string) (io.ReadCloser,error) {
+	if len(args)==0 || args[0]=="-" { return io.NopCloser(os.Stdin),nil }
+	return os.Open(args[0])
+}
+
+func readTo(svc single.ObjectSvc,objectId []byte,pos single.ByteRange,w io.Writer) error {
+	return single.ReadTo(svc,objectId,pos,w)
+}
+
+///
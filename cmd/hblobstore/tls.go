@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"os"
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// The tls section of the config file. TLS is enabled, if CertFile is set.
+type tlsConfig struct{
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	
+	// Verifies client certificates against the CAs in this PEM file.
+	ClientCAFile string `json:"client_ca_file"`
+	
+	// Rejects connections without a valid client certificate.
+	RequireClientCert bool `json:"require_client_cert"`
+}
+
+// Files are checked for changes at most this often.
+const tlsCheckInterval = 5*time.Second
+
+/*
+Serves the tls.Config of tlsConfig. The files are reloaded, when they changed,
+thus renewed certificates are picked up without a restart.
+*/
+type tlsLoader struct{
+	cfg tlsConfig
+	
+	mu    sync.Mutex
+	cur   *tls.Config
+	mtime [3]time.Time
+	next  time.Time
+}
+
+func newTLSLoader(cfg tlsConfig) (*tlsLoader,error) {
+	if cfg.KeyFile=="" { return nil,errors.New("tls: key_file is required") }
+	if cfg.RequireClientCert && cfg.ClientCAFile=="" { return nil,errors.New("tls: require_client_cert needs client_ca_file") }
+	l := &tlsLoader{cfg:cfg}
+	if err := l.load(); err!=nil { return nil,err }
+	return l,nil
+}
+
+func (l *tlsLoader) files() []string {
+	return []string{l.cfg.CertFile,l.cfg.KeyFile,l.cfg.ClientCAFile}
+}
+
+func (l *tlsLoader) load() error {
+	cert,err := tls.LoadX509KeyPair(l.cfg.CertFile,l.cfg.KeyFile)
+	if err!=nil { return err }
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion: tls.VersionTLS12,
+	}
+	if l.cfg.ClientCAFile!="" {
+		pem,err := os.ReadFile(l.cfg.ClientCAFile)
+		if err!=nil { return err }
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) { return errors.New("tls: no certificates in "+l.cfg.ClientCAFile) }
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if l.cfg.RequireClientCert { cfg.ClientAuth = tls.RequireAndVerifyClientCert }
+	}
+	l.cur = cfg
+	l.mtime = l.stat()
+	return nil
+}
+
+func (l *tlsLoader) stat() (mt [3]time.Time) {
+	for i,f := range l.files() {
+		if f=="" { continue }
+		if st,err := os.Stat(f); err==nil { mt[i] = st.ModTime() }
+	}
+	return
+}
+
+// Returns the current tls.Config, reloading the files if they changed.
+// On errors, the last good config is kept.
+func (l *tlsLoader) config() *tls.Config {
+	l.mu.Lock(); defer l.mu.Unlock()
+	now := time.Now()
+	if now.Before(l.next) { return l.cur }
+	l.next = now.Add(tlsCheckInterval)
+	if l.stat()!=l.mtime {
+		if err := l.load(); err!=nil {
+			// Keep the last good config, until the files change again.
+			log.Println("tls: reload:",err)
+			l.mtime = l.stat()
+		} else {
+			log.Println("tls: reloaded certificates")
+		}
+	}
+	return l.cur
+}
+
+// A tls.Config, that delegates every handshake to the loader.
+func (l *tlsLoader) serverConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config,error) { return l.config(),nil },
+	}
+}
+
+///
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package main
+
+import (
+	"os"
+	"time"
+	"testing"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"encoding/pem"
+	"crypto/tls"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/files"
+	nhapi "github.com/byte-mug/hblobstore/single/nethttp-api"
+)
+
+// A certificate and its key, signed by parent, or self-signed.
+type testCert struct{
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+func newCert(t *testing.T,cn string,serial int64,parent *testCert) *testCert {
+	t.Helper()
+	key,err := ecdsa.GenerateKey(elliptic.P256(),rand.Reader)
+	if err!=nil { t.Fatal(err) }
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject: pkix.Name{CommonName:cn},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		DNSNames: []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,x509.ExtKeyUsageClientAuth},
+	}
+	signer,signKey := tmpl,key
+	if parent==nil {
+		tmpl.IsCA,tmpl.BasicConstraintsValid = true,true
+		tmpl.KeyUsage = x509.KeyUsageCertSign|x509.KeyUsageDigitalSignature
+	} else {
+		signer,signKey = parent.cert,parent.key
+	}
+	der,err := x509.CreateCertificate(rand.Reader,tmpl,signer,&key.PublicKey,signKey)
+	if err!=nil { t.Fatal(err) }
+	c := &testCert{key:key}
+	if c.cert,err = x509.ParseCertificate(der); err!=nil { t.Fatal(err) }
+	c.tls = tls.Certificate{Certificate:[][]byte{der},PrivateKey:key,Leaf:c.cert}
+	return c
+}
+
+// Writes the certificate and key as PEM files, with a modification time of mtime.
+func (c *testCert) write(t *testing.T,certFile,keyFile string,mtime time.Time) {
+	t.Helper()
+	kd,err := x509.MarshalECPrivateKey(c.key)
+	if err!=nil { t.Fatal(err) }
+	for f,b := range map[string]*pem.Block{certFile:{Type:"CERTIFICATE",Bytes:c.cert.Raw},keyFile:{Type:"EC PRIVATE KEY",Bytes:kd}} {
+		if f=="" { continue }
+		if err = os.WriteFile(f,pem.EncodeToMemory(b),0600); err!=nil { t.Fatal(err) }
+		if err = os.Chtimes(f,mtime,mtime); err!=nil { t.Fatal(err) }
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := tlsConfig{CertFile:filepath.Join(dir,"cert.pem"),KeyFile:filepath.Join(dir,"key.pem")}
+	mtime := time.Now().Add(-time.Hour)
+	newCert(t,"one",1,nil).write(t,cfg.CertFile,cfg.KeyFile,mtime)
+	l,err := newTLSLoader(cfg)
+	if err!=nil { t.Fatal(err) }
+	serial := func() int64 {
+		l.mu.Lock(); l.next = time.Time{}; l.mu.Unlock()
+		return l.config().Certificates[0].Leaf.SerialNumber.Int64()
+	}
+	if s := serial(); s!=1 { t.Fatalf("serial = %d",s) }
+	
+	newCert(t,"two",2,nil).write(t,cfg.CertFile,cfg.KeyFile,mtime.Add(time.Minute))
+	if s := serial(); s!=2 { t.Fatalf("serial after the renewal = %d",s) }
+	
+	// A broken certificate keeps the last good one.
+	if err = os.WriteFile(cfg.CertFile,[]byte("broken"),0600); err!=nil { t.Fatal(err) }
+	if s := serial(); s!=2 { t.Fatalf("serial after a broken renewal = %d",s) }
+	newCert(t,"three",3,nil).write(t,cfg.CertFile,cfg.KeyFile,mtime.Add(2*time.Minute))
+	if s := serial(); s!=3 { t.Fatalf("serial after the repair = %d",s) }
+}
+
+// Client certificates authenticate as their subject, or the principal it's mapped to.
+func TestClientCerts(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t,"ca",1,nil)
+	cfg := tlsConfig{CertFile:filepath.Join(dir,"cert.pem"),KeyFile:filepath.Join(dir,"key.pem"),ClientCAFile:filepath.Join(dir,"ca.pem")}
+	newCert(t,"localhost",2,ca).write(t,cfg.CertFile,cfg.KeyFile,time.Now())
+	ca.write(t,cfg.ClientCAFile,"",time.Now())
+	l,err := newTLSLoader(cfg)
+	if err!=nil { t.Fatal(err) }
+	
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	defer svc.(interface{ Close() error }).Close()
+	svc.PutObj([]byte("a"),[]byte("hello"))
+	a := auth.New(&auth.Config{
+		ClientCerts: true,
+		CertSubjects: map[string]string{"CN=backup":"ops"},
+		Policy: auth.Policy{"ops":{{Perms:auth.PermRead}},"CN=reader":{{Perms:auth.PermRead}}},
+	})
+	hs := httptest.NewUnstartedServer(nhapi.NewAuth(svc,a))
+	hs.TLS = l.serverConfig()
+	hs.StartTLS()
+	defer hs.Close()
+	
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _,c := range []struct{
+		cert *testCert
+		code int
+	}{
+		{newCert(t,"backup",3,ca),200},
+		{newCert(t,"reader",4,ca),200},
+		{newCert(t,"other",5,ca),403},
+		{newCert(t,"backup",6,nil),0}, // Not signed by the CA.
+		{nil,401},
+	}{
+		tc := &tls.Config{RootCAs:roots,ServerName:"localhost"}
+		if c.cert!=nil {
+			// Sent, even if the CA isn't one of the server's.
+			cert := &c.cert.tls
+			tc.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate,error) { return cert,nil }
+		}
+		hc := &http.Client{Transport:&http.Transport{TLSClientConfig:tc}}
+		resp,err := hc.Get(hs.URL+"/o/a")
+		code := 0
+		if err==nil {
+			code = resp.StatusCode
+			resp.Body.Close()
+		}
+		if code!=c.code {
+			name := "no certificate"
+			if c.cert!=nil { name = c.cert.cert.Subject.String() }
+			t.Errorf("%s: %d, %v",name,code,err)
+		}
+		hc.CloseIdleConnections()
+	}
+}
+
+///
@@ -0,0 +1,166 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+/*
+The state of a single/files store, for the health, readiness and admin
+endpoints of the frontends.
+*/
+package admin
+
+import (
+	"sync"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/guard"
+	"github.com/byte-mug/hblobstore/single/replica"
+)
+
+// Reasons, why a store isn't ready.
+const (
+	NotReadyReadOnly    = "read_only"
+	NotReadyNotWritable = "not_writable"
+	NotReadyDiskFull    = "disk_full"
+)
+
+type Admin struct{
+	g       *guard.Guard
+	minFree uint64
+	
+	// The replica, if the store is one.
+	r *replica.Replica
+	
+	// The configured read only mode, that replicas assume once they are promoted.
+	mu sync.Mutex
+	ro bool
+}
+
+// Creates an Admin of the store behind g. The disk is considered full, once
+// minFree or less bytes are available. The read only mode of g is the
+// configured one, see SetReadOnly.
+func New(g *guard.Guard,minFree uint64) *Admin {
+	return &Admin{g:g,minFree:minFree,ro:g.ReadOnly()}
+}
+
+// Makes the store a replica, which is read only, until it is promoted.
+func (a *Admin) SetReplica(r *replica.Replica) {
+	a.r = r
+	a.g.SetReadOnly(true)
+}
+
+// Whether the store is a replica, that isn't promoted.
+func (a *Admin) Replicating() bool { return a.r!=nil && !a.r.Promoted() }
+
+// Returns the reasons, why the store isn't ready, or nil. Replicas are ready,
+// while they are read only, since they serve reads.
+func (a *Admin) Ready() (reasons []string) {
+	if a.g.ReadOnly() && !a.Replicating() { reasons = append(reasons,NotReadyReadOnly) }
+	if files.ProbeOf(a.g)!=nil { reasons = append(reasons,NotReadyNotWritable) }
+	if d,err := files.DiskOf(a.g); err==nil && d.Avail<=a.minFree { reasons = append(reasons,NotReadyDiskFull) }
+	return
+}
+
+type Status struct{
+	ReadOnly    bool       `json:"read_only"`
+	OpenFiles   int        `json:"open_files"`
+	Deleting    int        `json:"deleting"`
+	PooledPaths int        `json:"pooled_paths"`
+	Disk        files.Disk `json:"disk"`
+	NotReady    []string   `json:"not_ready,omitempty"`
+}
+
+func (a *Admin) Status() (st Status,err error) {
+	var s files.Stats
+	if s,err = files.StatsOf(a.g); err!=nil { return }
+	st.ReadOnly = a.g.ReadOnly()
+	st.OpenFiles,st.Deleting,st.PooledPaths = s.OpenFiles,s.Deleting,s.PooledPaths
+	st.Disk,_ = files.DiskOf(a.g)
+	st.NotReady = a.Ready()
+	return
+}
+
+// The files, that are open, and that are being deleted.
+type Handles struct{
+	Open     []string `json:"open"`
+	Deleting []string `json:"deleting"`
+}
+
+func (a *Admin) Handles() (h Handles,err error) {
+	h.Open,h.Deleting,err = files.HandlesOf(a.g)
+	if h.Open==nil { h.Open = []string{} }
+	if h.Deleting==nil { h.Deleting = []string{} }
+	return
+}
+
+// Sets the configured read only mode, and toggles the guard. Replicas remain
+// read only, until they are promoted.
+func (a *Admin) SetReadOnly(ro bool) {
+	a.mu.Lock(); defer a.mu.Unlock()
+	a.ro = ro
+	a.g.SetReadOnly(ro || a.Replicating())
+}
+
+// Returns the state of the replica, or single.ENotFound, if the store isn't one.
+func (a *Admin) Replication() (st single.ReplicationStatus,err error) {
+	if a.r==nil { return st,single.ENotFound }
+	st = a.r.Status()
+	st.ReadOnly = a.g.ReadOnly()
+	return
+}
+
+/*
+Stops the replica, see replica.Replica.Promote. The store becomes writable,
+unless it's configured to be read only. The status reports the mode, that is
+in effect.
+*/
+func (a *Admin) Promote() (st single.ReplicationStatus,err error) {
+	if a.r==nil { return st,single.ENotFound }
+	a.mu.Lock()
+	if !a.r.Promoted() {
+		if err = a.r.Promote(); err==nil { a.g.SetReadOnly(a.ro) }
+	}
+	a.mu.Unlock()
+	if err!=nil { return }
+	return a.Replication()
+}
+
+// Creates a snapshot, see files.CreateSnapshot.
+func (a *Admin) CreateSnapshot(name string) (single.SnapshotInfo,error) {
+	return files.CreateSnapshot(a.g,name)
+}
+func (a *Admin) Snapshots() (infos []single.SnapshotInfo,err error) {
+	if infos,err = files.Snapshots(a.g); infos==nil { infos = []single.SnapshotInfo{} }
+	return
+}
+func (a *Admin) DeleteSnapshot(name string) error {
+	return files.DeleteSnapshot(a.g,name)
+}
+
+// Opens a snapshot as read-only store.
+func (a *Admin) OpenSnapshot(name string) (single.ObjectSvc,error) {
+	return files.OpenSnapshot(a.g,name)
+}
+
+///
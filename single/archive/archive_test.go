@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func testStore(t *testing.T) single.ObjectSvc {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	return svc
+}
+
+func readAll(t *testing.T,svc single.ObjectSvc,name string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := single.ReadTo(svc,[]byte(name),single.ByteRange{},&buf); err!=nil { t.Fatalf("read %s: %v",name,err) }
+	return buf.String()
+}
+
+// Exports a store with a small and a multipart object.
+func export(t *testing.T) []byte {
+	t.Helper()
+	svc := testStore(t)
+	svc.PutObj([]byte("a"),[]byte("archived"))
+	svc.PutObj([]byte("big"),[]byte("0123456789"))
+	var buf bytes.Buffer
+	if err := Export(svc,&buf,ExportOptions{}); err!=nil { t.Fatal(err) }
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	arc := export(t)
+	imp := func(svc single.ObjectSvc,arc []byte,c Conflict) (ImportStats,error) {
+		return Import(svc,bytes.NewReader(arc),ImportOptions{Conflict:c,PartSize:4})
+	}
+	
+	dst := testStore(t)
+	if st,err := imp(dst,arc,ConflictFail); err!=nil || st.Objects!=2 || st.Bytes!=18 { t.Fatalf("import: %+v, %v",st,err) }
+	if s := readAll(t,dst,"big"); s!="0123456789" { t.Fatalf("big = %q",s) }
+	
+	dst.PutObj([]byte("c"),[]byte("live"))
+	replace := func(data string) {
+		if _,err := single.AsReplaceSvc(dst).ReplaceObj([]byte("a"),bytes.NewReader([]byte(data))); err!=nil { t.Fatal(err) }
+	}
+	replace("live")
+	_,err := imp(dst,arc,ConflictFail)
+	var oe *ObjectError
+	if !errors.As(err,&oe) || oe.Object!="a" || oe.Err!=single.EExist { t.Fatalf("fail: %v",err) }
+	
+	if st,err := imp(dst,arc,ConflictSkip); err!=nil || st.Skipped!=2 || st.Objects!=0 { t.Fatalf("skip: %+v, %v",st,err) }
+	if s := readAll(t,dst,"a"); s!="live" { t.Fatalf("skipped a = %q",s) }
+	
+	if st,err := imp(dst,arc,ConflictOverwrite); err!=nil || st.Objects!=2 { t.Fatalf("overwrite: %+v, %v",st,err) }
+	if s := readAll(t,dst,"a"); s!="archived" { t.Fatalf("overwritten a = %q",s) }
+	if s := readAll(t,dst,"c"); s!="live" { t.Fatalf("c = %q",s) }
+}
+
+// An overwrite, whose content doesn't match the checksum, leaves the existing object.
+func TestImportChecksum(t *testing.T) {
+	arc := export(t)
+	i := bytes.Index(arc,[]byte("archived"))
+	copy(arc[i:],"tampered")
+	
+	dst := testStore(t)
+	dst.PutObj([]byte("a"),[]byte("live"))
+	_,err := Import(dst,bytes.NewReader(arc),ImportOptions{Conflict:ConflictOverwrite})
+	var oe *ObjectError
+	if !errors.As(err,&oe) || oe.Object!="a" { t.Fatalf("overwrite: %v",err) }
+	if s := readAll(t,dst,"a"); s!="live" { t.Fatalf("a = %q",s) }
+	
+	empty := testStore(t)
+	if _,err = Import(empty,bytes.NewReader(arc),ImportOptions{}); err==nil { t.Fatal("import succeeded") }
+	if _,err = empty.Info([]byte("a")); err!=single.ENotFound { t.Fatalf("a: %v",err) }
+}
+
+// Archives, that are cut at any block before the trailer of two empty blocks, are rejected.
+func TestImportTruncated(t *testing.T) {
+	arc := export(t)
+	for n := len(arc)-3*512; n>0; n -= 512 {
+		if _,err := Import(testStore(t),bytes.NewReader(arc[:n]),ImportOptions{}); err==nil { t.Fatalf("archive cut at %d of %d bytes imported",n,len(arc)) }
+	}
+}
+
+///
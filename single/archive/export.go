@@ -0,0 +1,239 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Exports stores into tar archives, and imports them into any store.
+
+Every object is a regular file, that is named like the object, in ascending
+order. With versions, an object has an entry for every version, oldest first,
+and delete markers are empty entries. The metadata is kept in PAX records:
+
+	HBLOBSTORE.sha256        the SHA-256 of the content, hex encoded
+	HBLOBSTORE.version       the version, if the store is versioned
+	HBLOBSTORE.deleted       "1" for delete markers
+	HBLOBSTORE.retain_until  the retention, on the last entry of an object
+	HBLOBSTORE.legal_hold    "1" for a legal hold, likewise
+
+The archive starts with a global header, and ends with a directory entry "./",
+that tell import, that the archive is complete.
+*/
+package archive
+
+import (
+	"io"
+	"os"
+	"hash"
+	"time"
+	"bytes"
+	"strconv"
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// The keys of the PAX records.
+const (
+	KeySHA256      = "HBLOBSTORE.sha256"
+	KeyVersion     = "HBLOBSTORE.version"
+	KeyDeleted     = "HBLOBSTORE.deleted"
+	KeyRetainUntil = "HBLOBSTORE.retain_until"
+	KeyLegalHold   = "HBLOBSTORE.legal_hold"
+	
+	// Of the global header at the start, and the entry at the end, which
+	// holds the number of objects.
+	KeyFormat = "HBLOBSTORE.format"
+	KeyEnd    = "HBLOBSTORE.end"
+)
+
+// Objects up to this length are held in memory, larger ones in a temporary file.
+const spoolMemory = 4<<20
+
+type ExportOptions struct{
+	// Only exports the objects with this prefix.
+	Prefix string
+	
+	// Exports every version of a versioned store, not just the latest one.
+	Versions bool
+}
+
+type exporter struct{
+	svc single.ObjectSvc
+	vs  single.VersionSvc
+	rs  single.RetentionSvc
+	ss  single.StatSvc
+	tw  *tar.Writer
+	sp  spool
+}
+
+/*
+Writes the objects of the store to w. The store must be a ListSvc. Objects,
+that are deleted during the export, are left out.
+*/
+func Export(svc single.ObjectSvc,w io.Writer,opts ExportOptions) (err error) {
+	ls := single.AsListSvc(svc)
+	if ls==nil { return single.EOpNotSupp }
+	e := &exporter{
+		svc: svc,
+		vs: single.AsVersionSvc(svc),
+		rs: single.AsRetentionSvc(svc),
+		ss: single.AsStatSvc(svc),
+		tw: tar.NewWriter(w),
+	}
+	defer e.sp.reset()
+	err = e.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeXGlobalHeader,
+		Name: "pax_global_header",
+		PAXRecords: map[string]string{KeyFormat:"1"},
+		Format: tar.FormatPAX,
+	})
+	if err!=nil { return }
+	
+	var after []byte
+	n := 0
+	for {
+		names,err := ls.ListObjs([]byte(opts.Prefix),after,1000)
+		if err!=nil { return err }
+		if len(names)==0 { break }
+		for _,name := range names {
+			if err = e.object(name,opts.Versions); err!=nil { return err }
+		}
+		n += len(names)
+		after = names[len(names)-1]
+	}
+	err = e.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name: "./",
+		Mode: 0755,
+		ModTime: time.Now(),
+		PAXRecords: map[string]string{KeyEnd:strconv.Itoa(n)},
+		Format: tar.FormatPAX,
+	})
+	if err!=nil { return }
+	return e.tw.Close()
+}
+
+func (e *exporter) object(name []byte,versions bool) (err error) {
+	vers := []single.VersionInfo{{}}
+	if versions && e.vs!=nil {
+		if vers,err = e.vs.ListVersions(name); err!=nil { return skipGone(err) }
+	}
+	var ret single.Retention
+	if e.rs!=nil {
+		if ret,err = e.rs.GetRetention(name); err!=nil { return skipGone(err) }
+	}
+	mtime := time.Now()
+	if e.ss!=nil {
+		st,err := e.ss.Stat(name)
+		if err!=nil { return skipGone(err) }
+		mtime = st.ModTime
+	}
+	for i,vi := range vers {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name: string(name),
+			Mode: 0644,
+			ModTime: mtime,
+			PAXRecords: make(map[string]string),
+			Format: tar.FormatPAX,
+		}
+		if vi.Version!=0 { hdr.PAXRecords[KeyVersion] = vi.Version.String() }
+		if i==len(vers)-1 {
+			if ret.Until!=0 { hdr.PAXRecords[KeyRetainUntil] = strconv.FormatInt(ret.Until,10) }
+			if ret.LegalHold { hdr.PAXRecords[KeyLegalHold] = "1" }
+		}
+		if vi.Deleted {
+			hdr.PAXRecords[KeyDeleted] = "1"
+			if err = e.tw.WriteHeader(hdr); err!=nil { return }
+			continue
+		}
+		
+		// The content is read first, since the header holds it's checksum.
+		e.sp.reset()
+		if vi.Version==0 {
+			err = single.ReadTo(e.svc,name,single.ByteRange{},&e.sp)
+		} else {
+			err = single.ReadVersionTo(e.vs,name,vi.Version,single.ByteRange{},&e.sp)
+		}
+		if err!=nil {
+			// A single version may be removed meanwhile, as well.
+			if err = skipGone(err); err!=nil { return }
+			continue
+		}
+		hdr.Size = e.sp.n
+		hdr.PAXRecords[KeySHA256] = hex.EncodeToString(e.sp.h.Sum(nil))
+		if err = e.tw.WriteHeader(hdr); err!=nil { return }
+		r,err := e.sp.reader()
+		if err!=nil { return err }
+		if _,err = io.Copy(e.tw,r); err!=nil { return err }
+	}
+	return
+}
+
+func skipGone(err error) error {
+	if single.BoilDownError(err)==single.ENotFound { return nil }
+	return err
+}
+
+// Collects the content of an object and it's checksum.
+type spool struct{
+	buf bytes.Buffer
+	f   *os.File
+	n   int64
+	h   hash.Hash
+}
+func (s *spool) reset() {
+	s.buf.Reset()
+	if s.f!=nil {
+		s.f.Close()
+		os.Remove(s.f.Name())
+		s.f = nil
+	}
+	s.n = 0
+	if s.h==nil { s.h = sha256.New() }
+	s.h.Reset()
+}
+func (s *spool) Write(p []byte) (n int,err error) {
+	if s.f==nil && s.buf.Len()+len(p)>spoolMemory {
+		if s.f,err = os.CreateTemp("","hblobstore-export-*"); err!=nil { return }
+		if _,err = s.f.Write(s.buf.Bytes()); err!=nil { return }
+		s.buf.Reset()
+	}
+	if s.f!=nil {
+		n,err = s.f.Write(p)
+	} else {
+		n,_ = s.buf.Write(p)
+	}
+	s.h.Write(p[:n])
+	s.n += int64(n)
+	return
+}
+func (s *spool) reader() (io.Reader,error) {
+	if s.f==nil { return bytes.NewReader(s.buf.Bytes()),nil }
+	_,err := s.f.Seek(0,io.SeekStart)
+	return s.f,err
+}
+
+///
@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package archive
+
+import (
+	"io"
+	"hash"
+	"bytes"
+	"errors"
+	"strconv"
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// What Import does with objects, that already exist in the store.
+type Conflict int
+const (
+	ConflictFail Conflict = iota
+	ConflictSkip
+	ConflictOverwrite
+)
+
+// Parses "fail", "skip" or "overwrite".
+func ParseConflict(s string) (Conflict,error) {
+	switch s {
+	case "fail": return ConflictFail,nil
+	case "skip": return ConflictSkip,nil
+	case "overwrite": return ConflictOverwrite,nil
+	}
+	return 0,errors.New("archive: unknown conflict mode "+strconv.Quote(s))
+}
+
+// The default of ImportOptions.PartSize.
+const DefaultPartSize = 16<<20
+
+type ImportOptions struct{
+	Conflict Conflict
+	
+	// Objects larger than PartSize are uploaded in parts of this size, if
+	// the store supports multipart uploads.
+	PartSize int
+}
+
+type ImportStats struct{
+	// The imported objects, and the written entries, including delete markers.
+	Objects int   `json:"objects"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	
+	// The objects, that were skipped, since they existed.
+	Skipped int `json:"skipped"`
+	
+	// The objects, whose retention was dropped, since the store doesn't support it.
+	Unretained int `json:"unretained,omitempty"`
+}
+
+// An error of Import, caused by an object.
+type ObjectError struct{
+	Object string
+	Err    error
+}
+func (e *ObjectError) Error() string { return "archive: "+e.Object+": "+e.Err.Error() }
+
+type importer struct{
+	svc single.ObjectSvc
+	vs  single.VersionSvc
+	rs  single.RetentionSvc
+	us  single.UploadSvc
+	rps single.ReplaceSvc
+	st  ImportStats
+	
+	partSize int
+	
+	// Set, if the current object exists, and has to be replaced.
+	exists bool
+}
+
+/*
+Writes the objects of an archive to the store, in the order of the archive.
+Plain tar archives can be imported, as well, their regular files become
+objects. Archives of Export are rejected, if they are truncated, but the
+objects before are imported nonetheless.
+*/
+func Import(svc single.ObjectSvc,r io.Reader,opts ImportOptions) (ImportStats,error) {
+	im := &importer{
+		svc: svc,
+		vs: single.AsVersionSvc(svc),
+		rs: single.AsRetentionSvc(svc),
+		us: single.AsUploadSvc(svc),
+		rps: single.AsReplaceSvc(svc),
+		partSize: opts.PartSize,
+	}
+	if im.partSize<=0 { im.partSize = DefaultPartSize }
+	tr := tar.NewReader(r)
+	var cur string
+	var skip,ours,ended bool
+	for {
+		hdr,err := tr.Next()
+		if err==io.EOF { break }
+		if err!=nil { return im.st,err }
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			if hdr.PAXRecords[KeyFormat]!="" { ours = true }
+			continue
+		case tar.TypeDir:
+			if hdr.PAXRecords[KeyEnd]!="" { ended = true }
+			continue
+		case tar.TypeReg:
+		default:
+			continue
+		}
+		if hdr.Name!=cur {
+			cur = hdr.Name
+			if skip,err = im.begin(cur,opts.Conflict); err!=nil { return im.st,&ObjectError{cur,err} }
+		}
+		if skip { continue }
+		if err = im.entry(hdr,tr); err!=nil { return im.st,&ObjectError{cur,err} }
+	}
+	if ours && !ended { return im.st,errors.New("archive: truncated") }
+	return im.st,nil
+}
+
+// Checks the object, before it's first entry is written.
+func (im *importer) begin(name string,c Conflict) (skip bool,err error) {
+	if !proto.ValidObject(name) { return false,single.EInvalid }
+	_,err = im.svc.Info([]byte(name))
+	im.exists = err==nil
+	switch single.BoilDownError(err) {
+	case single.ENotFound:
+	case nil:
+		switch c {
+		case ConflictSkip:
+			im.st.Skipped++
+			return true,nil
+		case ConflictOverwrite:
+		default: return false,single.EExist
+		}
+	default: return
+	}
+	im.st.Objects++
+	return false,nil
+}
+
+func (im *importer) entry(hdr *tar.Header,r io.Reader) (err error) {
+	name := []byte(hdr.Name)
+	if hdr.PAXRecords[KeyDeleted]=="1" {
+		if err = im.svc.DeleteObj(name); single.BoilDownError(err)==single.ENotFound { err = nil }
+	} else if im.exists && im.vs==nil && im.rps!=nil {
+		// The existing object stays, unless the content is complete and matches the checksum.
+		_,err = im.rps.ReplaceObj(name,&sumReader{r:r,h:sha256.New(),hdr:hdr})
+	} else if hdr.Size>int64(im.partSize) && im.us!=nil {
+		err = im.upload(name,hdr,r)
+	} else {
+		var data []byte
+		if data,err = io.ReadAll(r); err!=nil { return }
+		if err = checkSum(hdr,sha256.Sum256(data)); err!=nil { return }
+		if im.vs!=nil {
+			_,err = im.vs.PutVersion(name,data)
+		} else if err = im.clear(name); err==nil {
+			err = im.svc.PutObj(name,data)
+		}
+	}
+	if err!=nil { return }
+	im.exists = true
+	im.st.Entries++
+	im.st.Bytes += hdr.Size
+	return im.retention(name,hdr)
+}
+
+// Deletes the object, before it is replaced, if the store can't replace it atomically.
+func (im *importer) clear(name []byte) (err error) {
+	if im.vs!=nil || !im.exists { return }
+	if err = im.svc.DeleteObj(name); single.BoilDownError(err)==single.ENotFound { err = nil }
+	return
+}
+
+// Uploads the content in parts, so that it is only published, if the checksum matches.
+func (im *importer) upload(name []byte,hdr *tar.Header,r io.Reader) (err error) {
+	id,err := im.us.CreateUpload(name)
+	if err!=nil { return }
+	h := sha256.New()
+	buf := make([]byte,im.partSize)
+	var total int64
+	for part := 1; total<hdr.Size; part++ {
+		var n int
+		// Only the last part is short, unless the entry is truncated.
+		n,err = io.ReadFull(r,buf)
+		if err==io.EOF || err==io.ErrUnexpectedEOF {
+			err = nil
+			if total+int64(n)!=hdr.Size { err = io.ErrUnexpectedEOF }
+		}
+		if err!=nil { break }
+		h.Write(buf[:n])
+		total += int64(n)
+		if err = im.us.UploadPart(name,id,part,buf[:n]); err!=nil { break }
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	if err==nil { err = checkSum(hdr,sum) }
+	if err==nil { err = im.clear(name) }
+	if err==nil { _,err = im.us.CompleteUpload(name,id,nil) }
+	if err!=nil { im.us.AbortUpload(name,id) }
+	return
+}
+
+// Checks the content against the checksum of hdr, before it reports the end of it.
+type sumReader struct{
+	r   io.Reader
+	h   hash.Hash
+	hdr *tar.Header
+}
+func (s *sumReader) Read(p []byte) (n int,err error) {
+	n,err = s.r.Read(p)
+	s.h.Write(p[:n])
+	if err==io.EOF {
+		var sum [sha256.Size]byte
+		s.h.Sum(sum[:0])
+		if e := checkSum(s.hdr,sum); e!=nil { err = e }
+	}
+	return
+}
+
+func checkSum(hdr *tar.Header,sum [sha256.Size]byte) error {
+	want,ok := hdr.PAXRecords[KeySHA256]
+	if !ok { return nil }
+	if exp,err := hex.DecodeString(want); err!=nil || !bytes.Equal(exp,sum[:]) { return errors.New("checksum mismatch") }
+	return nil
+}
+
+func (im *importer) retention(name []byte,hdr *tar.Header) (err error) {
+	var ret single.Retention
+	if s,ok := hdr.PAXRecords[KeyRetainUntil]; ok {
+		if ret.Until,err = strconv.ParseInt(s,10,64); err!=nil { return single.EInvalid }
+	}
+	ret.LegalHold = hdr.PAXRecords[KeyLegalHold]=="1"
+	if ret==(single.Retention{}) { return }
+	if im.rs==nil {
+		im.st.Unretained++
+		return
+	}
+	return im.rs.SetRetention(name,ret)
+}
+
+///
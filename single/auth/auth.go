@@ -0,0 +1,331 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Authentication and authorization for the HTTP frontends.
+
+Requests are authenticated by static bearer tokens, by HMAC signatures
+(see sign.go) or by verified TLS client certificates. The resulting principal is authorized by a Policy, that grants
+permissions per object name prefix. Requests without credentials act as the
+anonymous principal "".
+*/
+package auth
+
+import (
+	"strings"
+	"sync/atomic"
+	"crypto/x509"
+	"crypto/sha256"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// Permissions, that can be granted.
+type Perm uint
+const (
+	PermRead Perm = 1<<iota
+	PermWrite
+	PermDelete
+	
+	// Setting the retention and legal hold of objects.
+	PermRetention
+)
+
+const permLetters = "rwdl"
+
+// Perms are written as letters, like "rw".
+func (p Perm) MarshalText() ([]byte,error) {
+	b := make([]byte,0,len(permLetters))
+	for i := range permLetters {
+		if p&(1<<uint(i))!=0 { b = append(b,permLetters[i]) }
+	}
+	return b,nil
+}
+func (p *Perm) UnmarshalText(b []byte) error {
+	*p = 0
+	for _,c := range b {
+		i := strings.IndexByte(permLetters,c)
+		if i<0 { return &PermError{c} }
+		*p |= 1<<uint(i)
+	}
+	return nil
+}
+
+type PermError struct{
+	Letter byte
+}
+func (e *PermError) Error() string {
+	return "auth: unknown permission "+string(e.Letter)+", expected one of "+permLetters
+}
+
+// Returns the permission, that is needed for op.
+func PermOf(op proto.Op) Perm {
+	switch op {
+	case proto.OpPut,proto.OpAppend: return PermWrite
+	case proto.OpUpload,proto.OpUploadPart,proto.OpAbortUpload: return PermWrite
+	case proto.OpDelete: return PermDelete
+	case proto.OpSetRetention: return PermRetention
+	}
+	return PermRead
+}
+
+type Grant struct{
+	Prefix string `json:"prefix"`
+	Perms  Perm   `json:"perms"`
+}
+
+// Maps principals to their grants. The grants of "*" apply to everyone,
+// including the anonymous principal "".
+type Policy map[string][]Grant
+
+func allowed(grants []Grant,name string,p Perm) bool {
+	for _,g := range grants {
+		if g.Perms&p==p && strings.HasPrefix(name,g.Prefix) { return true }
+	}
+	return false
+}
+
+// Reports whether principal may perform p on the object name. For listings,
+// name is the requested prefix.
+func (pol Policy) Allowed(principal,name string,p Perm) bool {
+	return allowed(pol[principal],name,p) || allowed(pol["*"],name,p)
+}
+
+// A protocol-neutral view of an HTTP request.
+type Request interface{
+	Method() string
+	
+	// The decoded path.
+	Path() string
+	RawQuery() string
+	Header(name string) string
+}
+
+// Implemented by Requests, that came in over TLS.
+type CertRequest interface{
+	Request
+	
+	// The verified chains of the client certificate, if any.
+	VerifiedChains() [][]*x509.Certificate
+}
+
+// The user value, under which the frontends store the principal of an
+// authenticated request, for the logs.
+const PrincipalKey = "principal"
+
+// The result of the authentication.
+type Identity struct{
+	Principal string
+	
+	// The hex encoded SHA-256 of the request body, that the frontend must
+	// verify using CheckPayload, if not empty.
+	Payload string
+	
+	// Set for aws-chunked bodies, that must be decoded by the frontend.
+	Stream *Stream
+}
+
+// Verifies the body against id.Payload.
+func (id *Identity) CheckPayload(body []byte) error {
+	if id.Payload=="" { return nil }
+	sum := sha256.Sum256(body)
+	if hexEqual(id.Payload,sum[:]) { return nil }
+	return single.EUnauthorized
+}
+
+/*
+Authenticates requests. If the request carries no credentials of its kind,
+Authenticate returns ok==false. Otherwise, it returns the identity, or an error
+if the credentials are invalid.
+*/
+type Authenticator interface{
+	Authenticate(r Request) (id Identity,ok bool,err error)
+}
+
+// Bearer tokens, "Authorization: Bearer <token>".
+type Tokens struct{
+	m map[[sha256.Size]byte]string
+}
+
+// Creates Tokens from a token to principal map.
+func NewTokens(tokens map[string]string) *Tokens {
+	t := &Tokens{m:make(map[[sha256.Size]byte]string,len(tokens))}
+	for tok,principal := range tokens {
+		t.m[sha256.Sum256([]byte(tok))] = principal
+	}
+	return t
+}
+func (t *Tokens) Authenticate(r Request) (id Identity,ok bool,err error) {
+	tok := r.Header("Authorization")
+	if !strings.HasPrefix(tok,"Bearer ") { return }
+	
+	// The map is keyed by hashes, so the lookup leaks no timing of the token.
+	principal,found := t.m[sha256.Sum256([]byte(tok[7:]))]
+	if !found { return id,true,single.EUnauthorized }
+	return Identity{Principal:principal},true,nil
+}
+
+/*
+Authenticates requests with a verified TLS client certificate. The principal
+is the certificate's subject, like "CN=backup,O=Example", unless it's mapped
+to another principal.
+*/
+type Certs struct{
+	Subjects map[string]string
+}
+func (c *Certs) Authenticate(r Request) (id Identity,ok bool,err error) {
+	cr,isCR := r.(CertRequest)
+	if !isCR { return }
+	chains := cr.VerifiedChains()
+	if len(chains)==0 || len(chains[0])==0 { return }
+	
+	subject := chains[0][0].Subject.String()
+	if principal,found := c.Subjects[subject]; found { subject = principal }
+	return Identity{Principal:subject},true,nil
+}
+
+// The auth section of a config file.
+type Config struct{
+	// Maps tokens to principals.
+	Tokens map[string]string `json:"tokens"`
+	
+	// HMAC keys by key id.
+	Keys map[string]Key `json:"keys"`
+	
+	// Authenticate by TLS client certificates, see Certs.
+	ClientCerts  bool              `json:"client_certs"`
+	CertSubjects map[string]string `json:"cert_subjects"`
+	
+	Policy Policy `json:"policy"`
+	
+	// The principals, that may use the admin API.
+	Admins []string `json:"admins"`
+}
+
+type state struct{
+	authn  []Authenticator
+	policy Policy
+	admins []string
+}
+
+/*
+Checks requests against the current authenticators and policy, that can be
+replaced at any time. A disabled Auth, and a nil *Auth, allow everything.
+*/
+type Auth struct{
+	st atomic.Value
+}
+
+// Creates an Auth. If cfg is nil, it's disabled.
+func New(cfg *Config) *Auth {
+	a := new(Auth)
+	a.Update(cfg)
+	return a
+}
+
+// Replaces the configuration. If cfg is nil, a is disabled.
+func (a *Auth) Update(cfg *Config) {
+	if cfg==nil {
+		a.st.Store((*state)(nil))
+		return
+	}
+	var authn []Authenticator
+	if len(cfg.Tokens)!=0 { authn = append(authn,NewTokens(cfg.Tokens)) }
+	if len(cfg.Keys)!=0 { authn = append(authn,NewKeys(cfg.Keys)) }
+	if cfg.ClientCerts { authn = append(authn,&Certs{cfg.CertSubjects}) }
+	a.set(cfg.Policy,cfg.Admins,authn)
+}
+
+// Like Update, for custom authenticators. They are tried in order.
+func (a *Auth) Set(policy Policy,authn ...Authenticator) {
+	a.set(policy,nil,authn)
+}
+func (a *Auth) set(policy Policy,admins []string,authn []Authenticator) {
+	if policy==nil { policy = Policy{} }
+	a.st.Store(&state{authn,policy,admins})
+}
+
+// Reports whether a checks requests.
+func (a *Auth) Enabled() bool {
+	return a.state()!=nil
+}
+
+func (a *Auth) state() *state {
+	if a==nil { return nil }
+	st,_ := a.st.Load().(*state)
+	return st
+}
+
+/*
+Authenticates r and authorizes op on name. Fails with single.EUnauthorized,
+if the credentials are invalid, or if the anonymous principal lacks the
+permission, and with single.EForbidden, if an authenticated principal lacks it.
+*/
+func (a *Auth) Check(r Request,op proto.Op,name string) (id Identity,err error) {
+	if id,err = a.Authenticate(r); err!=nil { return }
+	err = a.Authorize(&id,op,name)
+	return
+}
+
+// The first half of Check, for frontends, that authorize several operations.
+func (a *Auth) Authenticate(r Request) (id Identity,err error) {
+	st := a.state()
+	if st==nil { return }
+	
+	var ok bool
+	for _,au := range st.authn {
+		if id,ok,err = au.Authenticate(r); ok { break }
+	}
+	if err!=nil { return }
+	
+	// Unknown schemes must not be downgraded to anonymous access.
+	if !ok && r.Header("Authorization")!="" { return id,single.EUnauthorized }
+	return
+}
+
+// The second half of Check.
+func (a *Auth) Authorize(id *Identity,op proto.Op,name string) error {
+	st := a.state()
+	if st==nil || st.policy.Allowed(id.Principal,name,PermOf(op)) { return nil }
+	if id.Principal=="" { return single.EUnauthorized }
+	return single.EForbidden
+}
+
+/*
+Authenticates r as admin. Unlike Check, a disabled Auth denies everything, since
+the admin API controls the whole store.
+*/
+func (a *Auth) Admin(r Request) (id Identity,err error) {
+	st := a.state()
+	if st==nil { return id,single.EUnauthorized }
+	if id,err = a.Authenticate(r); err!=nil { return }
+	for _,p := range st.admins {
+		if p!="" && p==id.Principal { return }
+	}
+	if id.Principal=="" { return id,single.EUnauthorized }
+	return id,single.EForbidden
+}
+
+///
@@ -0,0 +1,48 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package auth
+
+import (
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+func TestPermText(t *testing.T) {
+	var p Perm
+	if err := p.UnmarshalText([]byte("rl")); err!=nil { t.Fatal(err) }
+	if p!=PermRead|PermRetention { t.Fatalf("perms = %b",p) }
+	if b,_ := p.MarshalText(); string(b)!="rl" { t.Fatalf("text = %q",b) }
+	if err := p.UnmarshalText([]byte("x")); err==nil { t.Fatal("accepted x") }
+}
+
+// Writers must not be able to release retentions and legal holds.
+func TestPermOfRetention(t *testing.T) {
+	if PermOf(proto.OpSetRetention)!=PermRetention { t.Fatal("SetRetention doesn't need PermRetention") }
+	pol := Policy{"app":{{Prefix:"",Perms:PermRead|PermWrite|PermDelete}}}
+	if pol.Allowed("app","a",PermOf(proto.OpSetRetention)) { t.Fatal("writer may set retention") }
+}
+
+///
@@ -0,0 +1,236 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package auth
+
+import (
+	"time"
+	"strconv"
+	"strings"
+	"net/url"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+HMAC signed requests, in the style of S3 SigV4:
+
+	Authorization: HBS1-HMAC-SHA256 Credential=<key id>,Expires=<unix time>,Signature=<hex>
+	X-Content-Sha256: <hex SHA-256 of the body, or UNSIGNED-PAYLOAD>
+
+The signature is the HMAC-SHA256 of StringToSign, keyed with the secret.
+*/
+const Scheme = "HBS1-HMAC-SHA256"
+
+// The X-Content-Sha256 value, that exempts the body from the signature.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Signatures must not expire further in the future.
+const MaxExpiry = 7*24*time.Hour
+
+// The request headers, that are covered by the signature.
+var SignedHeaders = []string{
+	"X-Content-Sha256",
+	"X-Offset",
+	"X-Length",
+	"X-Retain-Until",
+	"X-Legal-Hold",
+}
+
+// Returns the hex encoded SHA-256 of body, for the X-Content-Sha256 header.
+func PayloadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func hexEqual(h string,raw []byte) bool {
+	b,err := hex.DecodeString(h)
+	return err==nil && hmac.Equal(b,raw)
+}
+
+/*
+Returns the canonical form of the request:
+
+	Scheme, method, path, sorted query, expires and "name:value" for each of
+	SignedHeaders, separated by newlines.
+*/
+func StringToSign(r Request,expires int64) (string,error) {
+	q,err := url.ParseQuery(r.RawQuery())
+	if err!=nil { return "",err }
+	var b strings.Builder
+	b.WriteString(Scheme+"\n")
+	b.WriteString(r.Method()+"\n")
+	b.WriteString(r.Path()+"\n")
+	b.WriteString(q.Encode()+"\n")
+	b.WriteString(strconv.FormatInt(expires,10)+"\n")
+	for _,h := range SignedHeaders {
+		b.WriteString(strings.ToLower(h)+":"+strings.TrimSpace(r.Header(h))+"\n")
+	}
+	return b.String(),nil
+}
+
+func mac(secret,sts string) []byte {
+	return macB([]byte(secret),sts)
+}
+func macB(key []byte,sts string) []byte {
+	m := hmac.New(sha256.New,key)
+	m.Write([]byte(sts))
+	return m.Sum(nil)
+}
+
+/*
+Returns the Authorization header of r. The X-Content-Sha256 header must
+already be set.
+*/
+func Sign(r Request,keyID,secret string,expires time.Time) (string,error) {
+	exp := expires.Unix()
+	sts,err := StringToSign(r,exp)
+	if err!=nil { return "",err }
+	return Scheme+" Credential="+keyID+",Expires="+strconv.FormatInt(exp,10)+
+		",Signature="+hex.EncodeToString(mac(secret,sts)),nil
+}
+
+// A HMAC key. The principal defaults to the key id.
+type Key struct{
+	Secret    string `json:"secret"`
+	Principal string `json:"principal"`
+}
+
+// Authenticates HMAC signed requests.
+type Keys struct{
+	m map[string]Key
+}
+
+func NewKeys(keys map[string]Key) *Keys {
+	k := &Keys{m:make(map[string]Key,len(keys))}
+	for id,key := range keys {
+		if key.Principal=="" { key.Principal = id }
+		k.m[id] = key
+	}
+	return k
+}
+
+func parseAuthorization(v string) (keyID string,expires int64,sig string,ok bool) {
+	if !strings.HasPrefix(v,Scheme+" ") { return }
+	for _,f := range strings.Split(v[len(Scheme)+1:],",") {
+		name,val,_ := strings.Cut(strings.TrimSpace(f),"=")
+		switch name {
+		case "Credential": keyID = val
+		case "Expires": expires,_ = strconv.ParseInt(val,10,64)
+		case "Signature": sig = val
+		}
+	}
+	return keyID,expires,sig,true
+}
+
+/*
+Pre-signed URLs carry the credential in the query:
+
+	?X-Credential=<key id>&X-Expires=<unix time>&X-Signature=<hex>
+
+The signature is the HMAC-SHA256 of PresignString. It covers the method, the
+path and the other query arguments, but not the headers or the body.
+*/
+const PresignScheme = "HBS1-PRESIGN"
+
+/*
+Returns the canonical form of a pre-signed request:
+
+	PresignScheme, method, path and the sorted query without X-Signature,
+	separated by newlines.
+*/
+func PresignString(method,path string,q url.Values) string {
+	sig := q["X-Signature"]
+	delete(q,"X-Signature")
+	s := PresignScheme+"\n"+method+"\n"+path+"\n"+q.Encode()+"\n"
+	if sig!=nil { q["X-Signature"] = sig }
+	return s
+}
+
+/*
+Pre-signs a URL, like "http://host/o/object?version=...", for method. Anyone
+holding the result can perform this single request until expires.
+*/
+func Presign(method,rawurl,keyID,secret string,expires time.Time) (string,error) {
+	u,err := url.Parse(rawurl)
+	if err!=nil { return "",err }
+	q := u.Query()
+	q.Set("X-Credential",keyID)
+	q.Set("X-Expires",strconv.FormatInt(expires.Unix(),10))
+	q.Set("X-Signature",hex.EncodeToString(mac(secret,PresignString(method,u.Path,q))))
+	u.RawQuery = q.Encode()
+	return u.String(),nil
+}
+
+// Checks the expiry and returns the key.
+func (k *Keys) key(keyID string,expires int64) (key Key,ok bool) {
+	now := time.Now()
+	if expires<=now.Unix() || expires>now.Add(MaxExpiry).Unix() { return }
+	key,ok = k.m[keyID]
+	return
+}
+
+func (k *Keys) presigned(r Request) (id Identity,ok bool,err error) {
+	q,e := url.ParseQuery(r.RawQuery())
+	if e!=nil { return }
+	if q.Get("X-Amz-Signature")!="" { return k.queryV4(r,q) }
+	if q.Get("X-Signature")=="" { return }
+	ok,err = true,single.EUnauthorized
+	
+	expires,_ := strconv.ParseInt(q.Get("X-Expires"),10,64)
+	key,found := k.key(q.Get("X-Credential"),expires)
+	if !found { return }
+	if !hexEqual(q.Get("X-Signature"),mac(key.Secret,PresignString(r.Method(),r.Path(),q))) { return }
+	return Identity{Principal:key.Principal},true,nil
+}
+
+/*
+Authenticates signed requests and pre-signed URLs, including those of SigV4.
+Each of the keys is accepted, thus keys can be rotated by adding a new key,
+switching the signers to it, and removing the old one once its signatures
+expired.
+*/
+func (k *Keys) Authenticate(r Request) (id Identity,ok bool,err error) {
+	v := r.Header("Authorization")
+	if strings.HasPrefix(v,SigV4+" ") { return k.authV4(r,v) }
+	keyID,expires,sig,ok := parseAuthorization(v)
+	if !ok { return k.presigned(r) }
+	err = single.EUnauthorized
+	
+	key,found := k.key(keyID,expires)
+	if !found { return }
+	payload := r.Header("X-Content-Sha256")
+	if payload=="" { return }
+	sts,e := StringToSign(r,expires)
+	if e!=nil || !hexEqual(sig,mac(key.Secret,sts)) { return }
+	
+	id.Principal = key.Principal
+	if payload!=UnsignedPayload { id.Payload = payload }
+	return id,true,nil
+}
+
+///
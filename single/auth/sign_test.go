@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package auth
+
+import (
+	"time"
+	"strconv"
+	"strings"
+	"testing"
+	"net/url"
+	"net/http"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// A Request for the tests.
+type testReq struct{
+	method,path,query string
+	h map[string]string
+}
+func (r *testReq) Method() string { return r.method }
+func (r *testReq) Path() string { return r.path }
+func (r *testReq) RawQuery() string { return r.query }
+func (r *testReq) Header(name string) string { return r.h[http.CanonicalHeaderKey(name)] }
+
+func newReq(method,path,query string,h ...string) *testReq {
+	r := &testReq{method:method,path:path,query:query,h:map[string]string{}}
+	for i := 0; i+1<len(h); i += 2 { r.h[http.CanonicalHeaderKey(h[i])] = h[i+1] }
+	return r
+}
+
+// A known vector, that pins the string to sign.
+func TestSignVector(t *testing.T) {
+	r := newReq("PUT","/o/a","b=2&a=1","X-Content-Sha256",UnsignedPayload,"X-Offset","5")
+	v,err := Sign(r,"k1","secret",time.Unix(1700000000,0))
+	if err!=nil { t.Fatal(err) }
+	const want = Scheme+" Credential=k1,Expires=1700000000,Signature=c7d7e22edd31fbb5f0fc4e88da031f52c59bb704d776b1c32bc42676ba67a55a"
+	if v!=want { t.Fatalf("signature\n%s\nwant\n%s",v,want) }
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := NewKeys(map[string]Key{"k1":{Secret:"secret"},"k2":{Secret:"other",Principal:"app"}})
+	exp := time.Now().Add(time.Hour)
+	signed := func(keyID,secret string,expires time.Time,h ...string) *testReq {
+		r := newReq("PUT","/o/a","b=2&a=1",h...)
+		v,err := Sign(r,keyID,secret,expires)
+		if err!=nil { t.Fatal(err) }
+		r.h["Authorization"] = v
+		return r
+	}
+	
+	for _,c := range []struct{
+		name      string
+		r         *testReq
+		principal string
+		payload   string
+		err       error
+	}{
+		{"unsigned",signed("k1","secret",exp,"X-Content-Sha256",UnsignedPayload),"k1","",nil},
+		{"payload",signed("k2","other",exp,"X-Content-Sha256",PayloadHash([]byte("x"))),"app",PayloadHash([]byte("x")),nil},
+		{"wrong key id",signed("k3","secret",exp,"X-Content-Sha256",UnsignedPayload),"","",single.EUnauthorized},
+		{"wrong secret",signed("k2","secret",exp,"X-Content-Sha256",UnsignedPayload),"","",single.EUnauthorized},
+		{"expired",signed("k1","secret",time.Now().Add(-time.Second),"X-Content-Sha256",UnsignedPayload),"","",single.EUnauthorized},
+		{"too far in the future",signed("k1","secret",time.Now().Add(MaxExpiry+time.Hour),"X-Content-Sha256",UnsignedPayload),"","",single.EUnauthorized},
+		{"no payload hash",signed("k1","secret",exp),"","",single.EUnauthorized},
+	}{
+		id,ok,err := keys.Authenticate(c.r)
+		if !ok || err!=c.err || id.Principal!=c.principal || id.Payload!=c.payload {
+			t.Errorf("%s: %+v, %v, %v",c.name,id,ok,err)
+		}
+	}
+	
+	// The signature covers the signed headers, the query and the expiry.
+	for _,tamper := range []func(r *testReq){
+		func(r *testReq) { r.h["X-Offset"] = "1" },
+		func(r *testReq) { r.query = "a=1" },
+		func(r *testReq) { r.method = "DELETE" },
+		func(r *testReq) {
+			ts := "Expires="+strconv.FormatInt(exp.Unix(),10)
+			r.h["Authorization"] = strings.Replace(r.h["Authorization"],ts,"Expires="+strconv.FormatInt(exp.Unix()-1,10),1)
+		},
+	}{
+		r := signed("k1","secret",exp,"X-Content-Sha256",UnsignedPayload)
+		tamper(r)
+		if _,ok,err := keys.Authenticate(r); !ok || err!=single.EUnauthorized { t.Errorf("tampered request: %v, %v",ok,err) }
+	}
+	
+	// Unsigned requests are left to the other authenticators.
+	if _,ok,err := keys.Authenticate(newReq("GET","/o/a","")); ok || err!=nil { t.Fatalf("unsigned request: %v, %v",ok,err) }
+}
+
+func TestPresignVector(t *testing.T) {
+	u,err := Presign("GET","http://host/o/a?version=3","k1","secret",time.Unix(1700000000,0))
+	if err!=nil { t.Fatal(err) }
+	const want = "http://host/o/a?X-Credential=k1&X-Expires=1700000000&X-Signature=1d89ceea3d383e64b7adc05eb63f32c9ed1826bf91e99c01c2d4bcf650142896&version=3"
+	if u!=want { t.Fatalf("url\n%s\nwant\n%s",u,want) }
+}
+
+// Returns the request of a pre-signed URL.
+func presignedReq(t *testing.T,method,keyID,secret string,expires time.Time) *testReq {
+	t.Helper()
+	raw,err := Presign(method,"http://host/o/a?version=3",keyID,secret,expires)
+	if err!=nil { t.Fatal(err) }
+	u,err := url.Parse(raw)
+	if err!=nil { t.Fatal(err) }
+	return newReq(method,u.Path,u.RawQuery)
+}
+
+func TestPresigned(t *testing.T) {
+	keys := NewKeys(map[string]Key{"k1":{Secret:"secret"}})
+	exp := time.Now().Add(time.Hour)
+	
+	for _,c := range []struct{
+		name string
+		r    *testReq
+		err  error
+	}{
+		{"valid",presignedReq(t,"GET","k1","secret",exp),nil},
+		{"wrong key id",presignedReq(t,"GET","k2","secret",exp),single.EUnauthorized},
+		{"wrong secret",presignedReq(t,"GET","k1","other",exp),single.EUnauthorized},
+		{"expired",presignedReq(t,"GET","k1","secret",time.Now().Add(-time.Second)),single.EUnauthorized},
+		{"too far in the future",presignedReq(t,"GET","k1","secret",time.Now().Add(MaxExpiry+time.Hour)),single.EUnauthorized},
+	}{
+		id,ok,err := keys.Authenticate(c.r)
+		if !ok || err!=c.err || (err==nil && id.Principal!="k1") { t.Errorf("%s: %+v, %v, %v",c.name,id,ok,err) }
+	}
+	
+	// The signature covers the method, the path and the query.
+	for _,tamper := range []func(r *testReq){
+		func(r *testReq) { r.method = "DELETE" },
+		func(r *testReq) { r.path = "/o/b" },
+		func(r *testReq) { r.query = strings.Replace(r.query,"version=3","version=4",1) },
+		func(r *testReq) { r.query += "&offset=1" },
+	}{
+		r := presignedReq(t,"GET","k1","secret",exp)
+		tamper(r)
+		if _,ok,err := keys.Authenticate(r); !ok || err!=single.EUnauthorized { t.Errorf("tampered URL %s?%s: %v, %v",r.path,r.query,ok,err) }
+	}
+}
+
+// During a rotation both keys are accepted, afterwards only the new one.
+func TestKeyRotation(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	old := presignedReq(t,"GET","old","s1",exp)
+	cur := presignedReq(t,"GET","new","s2",exp)
+	
+	both := NewKeys(map[string]Key{"old":{Secret:"s1",Principal:"app"},"new":{Secret:"s2",Principal:"app"}})
+	for _,r := range []*testReq{old,cur} {
+		if id,ok,err := both.Authenticate(r); !ok || err!=nil || id.Principal!="app" { t.Fatalf("during the rotation: %+v, %v, %v",id,ok,err) }
+	}
+	rotated := NewKeys(map[string]Key{"new":{Secret:"s2",Principal:"app"}})
+	if _,_,err := rotated.Authenticate(old); err!=single.EUnauthorized { t.Fatalf("removed key: %v",err) }
+	if _,_,err := rotated.Authenticate(cur); err!=nil { t.Fatalf("new key: %v",err) }
+}
+
+///
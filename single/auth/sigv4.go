@@ -0,0 +1,255 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package auth
+
+import (
+	"time"
+	"sort"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"net/url"
+	"crypto/sha256"
+	"encoding/hex"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+AWS Signature Version 4, as sent by S3 clients. The HMAC keys double as AWS
+access keys, the region of the credential scope is not checked.
+*/
+const SigV4 = "AWS4-HMAC-SHA256"
+
+const (
+	amzDate = "20060102T150405Z"
+	
+	// Requests must be signed this close to the server's clock.
+	maxSkew = 15*time.Minute
+	
+	emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// The server's clock, that the tests set to the date of the AWS test vectors.
+var clock = time.Now
+
+// Escapes s, as required by SigV4. Slashes are kept in paths.
+func awsEscape(s string,path bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i<len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A'<=c && c<='Z', 'a'<=c && c<='z', '0'<=c && c<='9', c=='-', c=='_', c=='.', c=='~', c=='/' && path:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// Returns the sorted, escaped query, leaving out the argument skip.
+func canonicalQuery(raw,skip string) (string,error) {
+	type kv struct{ k,v string }
+	var args []kv
+	for _,p := range strings.Split(raw,"&") {
+		if p=="" { continue }
+		k,v,_ := strings.Cut(p,"=")
+		k,err := url.QueryUnescape(k)
+		if err!=nil { return "",err }
+		if v,err = url.QueryUnescape(v); err!=nil { return "",err }
+		if k==skip { continue }
+		args = append(args,kv{awsEscape(k,false),awsEscape(v,false)})
+	}
+	sort.Slice(args,func(i,j int) bool {
+		if args[i].k!=args[j].k { return args[i].k<args[j].k }
+		return args[i].v<args[j].v
+	})
+	parts := make([]string,len(args))
+	for i,a := range args { parts[i] = a.k+"="+a.v }
+	return strings.Join(parts,"&"),nil
+}
+
+func sigV4Key(secret,date,region,service string) []byte {
+	k := mac("AWS4"+secret,date)
+	k = macB(k,region)
+	k = macB(k,service)
+	return macB(k,"aws4_request")
+}
+
+func sha256Hex(s []byte) string {
+	sum := sha256.Sum256(s)
+	return hex.EncodeToString(sum[:])
+}
+
+// A parsed SigV4 credential.
+type sigV4 struct{
+	keyID   string
+	date    string // YYYYMMDD
+	scope   string
+	region  string
+	service string
+	signed  []string
+	sig     string
+}
+
+func (s *sigV4) parseCredential(cred string) bool {
+	parts := strings.Split(cred,"/")
+	if len(parts)!=5 || parts[4]!="aws4_request" { return false }
+	s.keyID,s.date,s.region,s.service = parts[0],parts[1],parts[2],parts[3]
+	s.scope = strings.Join(parts[1:],"/")
+	return true
+}
+
+func (s *sigV4) stringToSign(r Request,stamp,query,payload string) string {
+	var b strings.Builder
+	b.WriteString(r.Method()+"\n")
+	b.WriteString(awsEscape(r.Path(),true)+"\n")
+	b.WriteString(query+"\n")
+	for _,h := range s.signed {
+		b.WriteString(h+":"+strings.Join(strings.Fields(r.Header(h))," ")+"\n")
+	}
+	b.WriteString("\n"+strings.Join(s.signed,";")+"\n"+payload)
+	return SigV4+"\n"+stamp+"\n"+s.scope+"\n"+sha256Hex([]byte(b.String()))
+}
+
+/*
+Verifies the signature and returns the signing key. Requests are valid within
+maxSkew of their date, pre-signed URLs from their date until it expires.
+*/
+func (k *Keys) verifyV4(s *sigV4,r Request,stamp,query,payload string,expires int64) (key Key,skey []byte,ok bool) {
+	if key,ok = k.m[s.keyID]; !ok { return }
+	ok = false
+	t,err := time.Parse(amzDate,stamp)
+	if err!=nil || t.Format("20060102")!=s.date { return }
+	now := clock()
+	if t.After(now.Add(maxSkew)) { return }
+	if expires==0 && t.Before(now.Add(-maxSkew)) { return }
+	if expires>0 && !t.Add(time.Duration(expires)*time.Second).After(now) { return }
+	
+	hasHost := false
+	for _,h := range s.signed { if h=="host" { hasHost = true } }
+	if !hasHost { return }
+	
+	skey = sigV4Key(key.Secret,s.date,s.region,s.service)
+	ok = hexEqual(s.sig,macB(skey,s.stringToSign(r,stamp,query,payload)))
+	return
+}
+
+// Authenticates a request with an "Authorization: AWS4-HMAC-SHA256 ..." header.
+func (k *Keys) authV4(r Request,v string) (id Identity,ok bool,err error) {
+	ok,err = true,single.EUnauthorized
+	var s sigV4
+	for _,f := range strings.Split(v[len(SigV4)+1:],",") {
+		name,val,_ := strings.Cut(strings.TrimSpace(f),"=")
+		switch name {
+		case "Credential": if !s.parseCredential(val) { return }
+		case "SignedHeaders": s.signed = strings.Split(val,";")
+		case "Signature": s.sig = val
+		}
+	}
+	payload := r.Header("X-Amz-Content-Sha256")
+	if payload=="" { payload = UnsignedPayload }
+	query,e := canonicalQuery(r.RawQuery(),"")
+	if e!=nil { return }
+	stamp := r.Header("X-Amz-Date")
+	key,skey,valid := k.verifyV4(&s,r,stamp,query,payload,0)
+	if !valid { return }
+	
+	id.Principal = key.Principal
+	switch {
+	case strings.HasPrefix(payload,"STREAMING-AWS4-HMAC-SHA256-PAYLOAD"):
+		id.Stream = &Stream{key:skey,stamp:stamp,scope:s.scope,prev:s.sig}
+	case strings.HasPrefix(payload,"STREAMING-"):
+		id.Stream = &Stream{}
+	case payload!=UnsignedPayload:
+		id.Payload = payload
+	}
+	return id,true,nil
+}
+
+// Authenticates a SigV4 pre-signed URL.
+func (k *Keys) queryV4(r Request,q url.Values) (id Identity,ok bool,err error) {
+	ok,err = true,single.EUnauthorized
+	var s sigV4
+	if q.Get("X-Amz-Algorithm")!=SigV4 || !s.parseCredential(q.Get("X-Amz-Credential")) { return }
+	s.signed = strings.Split(q.Get("X-Amz-SignedHeaders"),";")
+	s.sig = q.Get("X-Amz-Signature")
+	expires,e := strconv.ParseInt(q.Get("X-Amz-Expires"),10,64)
+	if e!=nil || expires<=0 || expires>int64(MaxExpiry/time.Second) { return }
+	query,e := canonicalQuery(r.RawQuery(),"X-Amz-Signature")
+	if e!=nil { return }
+	key,_,valid := k.verifyV4(&s,r,q.Get("X-Amz-Date"),query,UnsignedPayload,expires)
+	if !valid { return }
+	return Identity{Principal:key.Principal},true,nil
+}
+
+/*
+Decodes an aws-chunked request body, as announced by a STREAMING-* payload
+hash. The chunk signatures are verified, if the request was signed so.
+*/
+type Stream struct{
+	key   []byte
+	stamp string
+	scope string
+	prev  string
+}
+
+var errChunk = errors.New("auth: malformed aws-chunked body")
+
+func (s *Stream) Decode(body []byte) (data []byte,err error) {
+	data = make([]byte,0,len(body))
+	for {
+		i := bytes.Index(body,[]byte("\r\n"))
+		if i<0 { return nil,errChunk }
+		head := string(body[:i])
+		body = body[i+2:]
+		
+		sz,params,_ := strings.Cut(head,";")
+		n,err := strconv.ParseInt(sz,16,64)
+		if err!=nil || n<0 || n>int64(len(body)) { return nil,errChunk }
+		chunk := body[:n]
+		body = body[n:]
+		
+		if s.key!=nil {
+			sig := strings.TrimPrefix(params,"chunk-signature=")
+			sts := SigV4+"-PAYLOAD\n"+s.stamp+"\n"+s.scope+"\n"+s.prev+"\n"+emptyHash+"\n"+sha256Hex(chunk)
+			if !hexEqual(sig,macB(s.key,sts)) { return nil,single.EUnauthorized }
+			s.prev = sig
+		}
+		
+		// The trailers after the last chunk are ignored.
+		if n==0 { return data,nil }
+		data = append(data,chunk...)
+		if !bytes.HasPrefix(body,[]byte("\r\n")) { return nil,errChunk }
+		body = body[2:]
+	}
+}
+
+///
@@ -0,0 +1,347 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Incremental backups of stores into another store, and their restore.
+
+Every backup is a generation, with a manifest, that records every object with
+it's length, version and SHA-256. Of the objects, that were backed up before,
+only the appended data is copied, the rest refers to the parts, that earlier
+generations copied. An object of a versioned store counts as appended, if it
+has the same version, since versions are only appended to. An object of a store
+without versions counts as appended, if it didn't shrink and the bytes, that
+were backed up, are unchanged: If the store keeps checksums, see
+single.ChecksumSvc, the appended data must extend the recorded CRC-32 to the
+current one, and an object of the same length and modification time is
+unchanged. Otherwise, the backed up bytes are read again, and their SHA-256 is
+compared.
+
+The target holds the manifests "gen-{generation}" and the parts
+"chunk-{generation}-{hash}". It must be a ListSvc, and may hold other objects.
+*/
+package backup
+
+import (
+	"io"
+	"fmt"
+	"hash"
+	"time"
+	"errors"
+	"strconv"
+	"encoding"
+	"hash/crc32"
+	"encoding/hex"
+	"crypto/sha256"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// The objects of the target are written in pieces of this size, by default.
+const DefaultChunkSize = 4<<20
+
+// A range of an object, that was copied by a generation.
+type Part struct{
+	Generation int   `json:"gen"`
+	Offset     int64 `json:"offset"`
+	Length     int64 `json:"length"`
+}
+
+type Entry struct{
+	Object  string         `json:"object"`
+	Version single.Version `json:"version,omitempty"`
+	Length  int64          `json:"length"`
+	SHA256  string         `json:"sha256"`
+	
+	// The CRC-32 of the store and the modification time in nanoseconds, if known.
+	CRC32   string `json:"crc32,omitempty"`
+	ModTime int64  `json:"mtime,omitempty"`
+	
+	// The state of the checksum, to continue it with the appended data.
+	State []byte `json:"state"`
+	
+	Parts []Part `json:"parts"`
+}
+
+type GenerationInfo struct{
+	Generation int       `json:"generation"`
+	Parent     int       `json:"parent,omitempty"`
+	Prefix     string    `json:"prefix,omitempty"`
+	Created    time.Time `json:"created"`
+	
+	// Recorded at the end of the backup.
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	Copied  int64 `json:"copied"`
+}
+
+type BackupOptions struct{
+	// Only backs up the objects with this prefix.
+	Prefix string
+	
+	// Copies every object, rather than the changes since the last generation with the same prefix.
+	Full bool
+	
+	// Reads the backed up bytes of the objects again, rather than trusting the
+	// checksums and modification times of the store.
+	Verify bool
+	
+	ChunkSize int
+}
+
+type BackupStats struct{
+	Generation int `json:"generation"`
+	
+	// The objects, that were copied entirely, appended to, or unchanged.
+	New       int `json:"new"`
+	Appended  int `json:"appended"`
+	Unchanged int `json:"unchanged"`
+	
+	// The objects of the previous generation, that are gone.
+	Deleted int `json:"deleted"`
+	
+	Copied int64 `json:"copied"`
+}
+
+// An error of Backup or Restore, caused by an object.
+type ObjectError struct{
+	Object string
+	Err    error
+}
+func (e *ObjectError) Error() string { return "backup: "+e.Object+": "+e.Err.Error() }
+
+type backuper struct{
+	svc    single.ObjectSvc
+	vs     single.VersionSvc
+	ss     single.StatSvc
+	cs     single.ChecksumSvc
+	verify bool
+	target single.ObjectSvc
+	gen    int
+	size   int
+	st     BackupStats
+}
+
+/*
+Backs up the objects of svc, which must be a ListSvc, as a new generation of
+target. Objects, that are deleted during the backup, are left out. If the
+backup fails, the generation remains incomplete and is ignored.
+*/
+func Backup(svc,target single.ObjectSvc,opts BackupOptions) (st BackupStats,err error) {
+	ls := single.AsListSvc(svc)
+	if ls==nil { return st,single.EOpNotSupp }
+	infos,last,err := generations(target)
+	if err!=nil { return }
+	b := &backuper{
+		svc: svc,
+		vs: single.AsVersionSvc(svc),
+		ss: single.AsStatSvc(svc),
+		cs: single.AsChecksumSvc(svc),
+		verify: opts.Verify,
+		target: target,
+		gen: last+1,
+		size: opts.ChunkSize,
+	}
+	if b.size<=0 { b.size = DefaultChunkSize }
+	b.st.Generation = b.gen
+	info := GenerationInfo{Generation:b.gen,Prefix:opts.Prefix,Created:time.Now().UTC()}
+	for i := len(infos)-1; i>=0 && !opts.Full; i-- {
+		if infos[i].Prefix!=opts.Prefix { continue }
+		info.Parent = infos[i].Generation
+		break
+	}
+	
+	// The manifest is created first, so that the generation is taken.
+	mw := &objWriter{svc:target,name:manifestName(b.gen),size:b.size}
+	if err = mw.writeLine(&line{Begin:&info}); err==nil { err = mw.flush() }
+	if err!=nil { return b.st,err }
+	
+	var prev *manifestReader
+	var pe *Entry
+	if info.Parent!=0 {
+		prev = openManifest(target,info.Parent)
+		defer prev.Close()
+		if pe,err = prev.next(); err!=nil { return b.st,err }
+	}
+	var after []byte
+	for {
+		names,err := ls.ListObjs([]byte(opts.Prefix),after,1000)
+		if err!=nil { return b.st,err }
+		if len(names)==0 { break }
+		for _,name := range names {
+			// Both are in ascending order.
+			for pe!=nil && pe.Object<string(name) {
+				b.st.Deleted++
+				if pe,err = prev.next(); err!=nil { return b.st,err }
+			}
+			var match *Entry
+			if pe!=nil && pe.Object==string(name) {
+				match = pe
+				if pe,err = prev.next(); err!=nil { return b.st,err }
+			}
+			e,err := b.object(name,match)
+			if err!=nil {
+				if single.BoilDownError(err)==single.ENotFound { continue }
+				return b.st,&ObjectError{string(name),err}
+			}
+			if err = mw.writeLine(&line{Entry:&e}); err!=nil { return b.st,err }
+			info.Objects++
+			info.Bytes += e.Length
+		}
+		after = names[len(names)-1]
+	}
+	for pe!=nil {
+		b.st.Deleted++
+		if pe,err = prev.next(); err!=nil { return b.st,err }
+	}
+	info.Copied = b.st.Copied
+	if err = mw.writeLine(&line{End:&info}); err==nil { err = mw.flush() }
+	return b.st,err
+}
+
+// Sets the latest version and the length of e, and, if known, the CRC-32 and the modification time.
+func (b *backuper) stat(name []byte,e *Entry) (err error) {
+	if b.vs!=nil {
+		var vers []single.VersionInfo
+		if vers,err = b.vs.ListVersions(name); err!=nil { return }
+		if len(vers)==0 || vers[len(vers)-1].Deleted { return single.ENotFound }
+		e.Version = vers[len(vers)-1].Version
+		e.Length,err = b.vs.InfoVersion(name,e.Version)
+		return
+	}
+	
+	// The modification time comes first, so that an append in between changes it.
+	if b.ss!=nil {
+		var st single.ObjectStat
+		if st,err = b.ss.Stat(name); err!=nil { return }
+		e.Length,e.ModTime = st.Length,st.ModTime.UnixNano()
+	}
+	switch {
+	case b.cs!=nil:
+		lng,crc,ok,err := b.cs.Checksum(name)
+		if err!=nil { return err }
+		e.Length = lng
+		if ok { e.CRC32 = fmt.Sprintf("%08x",crc) }
+	case b.ss==nil:
+		e.Length,err = b.svc.Info(name)
+	}
+	return
+}
+
+// Reads exactly the range, or fails with io.ErrUnexpectedEOF.
+func (b *backuper) read(name []byte,ver single.Version,off,lng int64,w io.Writer) (err error) {
+	if lng==0 { return }
+	cw := &countWriter{w:w}
+	if ver==0 {
+		err = single.ReadTo(b.svc,name,single.ByteRange{off,lng},cw)
+	} else {
+		err = single.ReadVersionTo(b.vs,name,ver,single.ByteRange{off,lng},cw)
+	}
+	if err==nil && cw.n!=lng { err = io.ErrUnexpectedEOF }
+	return
+}
+
+/*
+Whether the object e only got appended to, since prev, see the package
+comment. If so, h holds the checksum of the first prev.Length bytes. If check
+is set, the appended data must extend the CRC-32 of prev to the one of e,
+which the caller verifies while copying it.
+*/
+func (b *backuper) appended(name []byte,prev,e *Entry,h hash.Hash) (ok,check bool) {
+	if e.Length<prev.Length || e.Version!=prev.Version { return }
+	restore := func() bool { return h.(encoding.BinaryUnmarshaler).UnmarshalBinary(prev.State)==nil }
+	switch {
+	case b.vs!=nil:
+		return restore(),false
+	case b.verify:
+	case prev.CRC32!="" && e.CRC32!="":
+		if e.Length==prev.Length { return e.CRC32==prev.CRC32 && restore(),false }
+		return restore(),true
+	case prev.ModTime!=0 && e.ModTime==prev.ModTime && e.Length==prev.Length:
+		return restore(),false
+	}
+	if b.read(name,0,0,prev.Length,h)!=nil { return }
+	return hex.EncodeToString(h.Sum(nil))==prev.SHA256,false
+}
+
+// Computes a CRC-32, that is continued from it's initial value.
+type crcWriter uint32
+func (c *crcWriter) Write(p []byte) (int,error) {
+	*c = crcWriter(crc32.Update(uint32(*c),crc32.IEEETable,p))
+	return len(p),nil
+}
+func parseCRC(s string) crcWriter {
+	v,_ := strconv.ParseUint(s,16,32)
+	return crcWriter(v)
+}
+
+func (b *backuper) object(name []byte,prev *Entry) (e Entry,err error) {
+	e.Object = string(name)
+	if err = b.stat(name,&e); err!=nil { return }
+	h := sha256.New()
+	var off int64
+	var cont,check bool
+	if prev!=nil { cont,check = b.appended(name,prev,&e,h) }
+	if cont {
+		off = prev.Length
+		e.Parts = append(e.Parts,prev.Parts...)
+	} else {
+		h.Reset()
+	}
+	if e.Length>off {
+		cw := &objWriter{svc:b.target,name:chunkName(b.gen,e.Object),size:b.size}
+		var c crcWriter
+		w := io.MultiWriter(cw,h)
+		if check {
+			c = parseCRC(prev.CRC32)
+			w = io.MultiWriter(cw,h,&c)
+		}
+		if err = b.read(name,e.Version,off,e.Length-off,w); err==nil { err = cw.flush() }
+		if err!=nil { return }
+		if check && c!=parseCRC(e.CRC32) {
+			// The backed up bytes changed, thus the object is copied entirely.
+			if err = b.target.DeleteObj(cw.name); err!=nil { return }
+			return b.object(name,nil)
+		}
+		e.Parts = append(e.Parts,Part{Generation:b.gen,Offset:off,Length:e.Length-off})
+		b.st.Copied += e.Length-off
+	}
+	if e.State,err = h.(encoding.BinaryMarshaler).MarshalBinary(); err!=nil { return }
+	e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	if e.Parts==nil { e.Parts = []Part{} }
+	switch {
+	case !cont: b.st.New++
+	case e.Length==off: b.st.Unchanged++
+	default: b.st.Appended++
+	}
+	return
+}
+
+func hashName(object string) []byte {
+	h := sha256.Sum256([]byte(object))
+	return h[:]
+}
+
+var errChecksum = errors.New("checksum mismatch")
+
+///
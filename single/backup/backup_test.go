@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/archive"
+)
+
+func testStore(t *testing.T,opts files.Options) single.ObjectSvc {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),opts)
+	if err!=nil { t.Fatal(err) }
+	return svc
+}
+
+func readAll(t *testing.T,svc single.ObjectSvc,name string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := single.ReadTo(svc,[]byte(name),single.ByteRange{},&buf); err!=nil { t.Fatalf("read %s: %v",name,err) }
+	return buf.String()
+}
+
+func replace(t *testing.T,svc single.ObjectSvc,name,data string) {
+	t.Helper()
+	if _,err := single.AsReplaceSvc(svc).ReplaceObj([]byte(name),strings.NewReader(data)); err!=nil { t.Fatal(err) }
+}
+
+func backup(t *testing.T,svc,target single.ObjectSvc) BackupStats {
+	t.Helper()
+	st,err := Backup(svc,target,BackupOptions{ChunkSize:1000})
+	if err!=nil { t.Fatal(err) }
+	return st
+}
+
+func TestBackupIncremental(t *testing.T) {
+	svc,target := testStore(t,files.Options{}),testStore(t,files.Options{})
+	big := strings.Repeat("x",10000)
+	svc.PutObj([]byte("a"),[]byte("hello"))
+	svc.PutObj([]byte("b"),[]byte(big))
+	svc.PutObj([]byte("c"),[]byte("gone"))
+	if st := backup(t,svc,target); st.New!=3 || st.Copied!=10009 { t.Fatalf("first generation: %+v",st) }
+	
+	svc.Append([]byte("a"),[]byte(" world"))
+	svc.DeleteObj([]byte("c"))
+	if st := backup(t,svc,target); st.Appended!=1 || st.Unchanged!=1 || st.Deleted!=1 || st.Copied!=6 { t.Fatalf("second generation: %+v",st) }
+	
+	// A change before the end, that was backed up, is detected, however long the object is.
+	replace(t,svc,"b","y"+big)
+	if st := backup(t,svc,target); st.New!=1 || st.Unchanged!=1 || st.Copied!=10001 { t.Fatalf("third generation: %+v",st) }
+	
+	dst := testStore(t,files.Options{})
+	st,err := Restore(target,dst,RestoreOptions{})
+	if err!=nil { t.Fatal(err) }
+	if st.Generation!=3 || st.Objects!=2 { t.Fatalf("restore: %+v",st) }
+	if s := readAll(t,dst,"a"); s!="hello world" { t.Fatalf("a = %q",s) }
+	if s := readAll(t,dst,"b"); s!="y"+big { t.Fatalf("b has %d bytes",len(s)) }
+	
+	st,err = Restore(target,dst,RestoreOptions{Generation:1,Prefix:"c"})
+	if err!=nil || st.Objects!=1 { t.Fatalf("restore of generation 1: %+v, %v",st,err) }
+	if s := readAll(t,dst,"c"); s!="gone" { t.Fatalf("c = %q",s) }
+}
+
+// Counts the bytes read from a files store.
+type readCounter struct{
+	single.ObjectSvc
+	n int64
+}
+func (r *readCounter) Unwrap() single.ObjectSvc { return r.ObjectSvc }
+func (r *readCounter) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) error {
+	cw := &countWriter{w:sink}
+	err := single.ReadTo(r.ObjectSvc,objectId,pos,cw)
+	r.n += cw.n
+	return err
+}
+func (r *readCounter) ListObjs(prefix, after []byte, limit int) ([][]byte,error) {
+	return single.AsListSvc(r.ObjectSvc).ListObjs(prefix,after,limit)
+}
+func (r *readCounter) Stat(objectId []byte) (single.ObjectStat,error) {
+	return single.AsStatSvc(r.ObjectSvc).Stat(objectId)
+}
+func (r *readCounter) Checksum(objectId []byte) (int64,uint32,bool,error) {
+	return single.AsChecksumSvc(r.ObjectSvc).Checksum(objectId)
+}
+
+// With checksums, only the appended data is read.
+func TestBackupChecksums(t *testing.T) {
+	svc,target := &readCounter{ObjectSvc:testStore(t,files.Options{Checksums:true})},testStore(t,files.Options{})
+	big := strings.Repeat("x",10000)
+	svc.PutObj([]byte("a"),[]byte(big))
+	svc.PutObj([]byte("b"),[]byte(big))
+	svc.PutObj([]byte("c"),[]byte("same"))
+	backup(t,svc,target)
+	
+	svc.n = 0
+	svc.Append([]byte("a"),[]byte("appended"))
+	if st := backup(t,svc,target); st.Appended!=1 || st.Unchanged!=2 || st.Copied!=8 || svc.n!=8 { t.Fatalf("second generation: %+v, %d bytes read",st,svc.n) }
+	
+	// A change of the backed up bytes, that looks like an append.
+	svc.n = 0
+	replace(t,svc.ObjectSvc,"b","y"+big)
+	if st := backup(t,svc,target); st.New!=1 || st.Unchanged!=2 || st.Copied!=10001 { t.Fatalf("third generation: %+v",st) }
+	if st,err := Backup(svc,target,BackupOptions{ChunkSize:1000,Verify:true}); err!=nil || st.Unchanged!=3 || svc.n!=1+10001+10008+10001+4 {
+		t.Fatalf("verified generation: %+v, %v, %d bytes read",st,err,svc.n)
+	}
+	
+	dst := testStore(t,files.Options{})
+	if _,err := Restore(target,dst,RestoreOptions{}); err!=nil { t.Fatal(err) }
+	if s := readAll(t,dst,"b"); s!="y"+big { t.Fatalf("b has %d bytes",len(s)) }
+	if s := readAll(t,dst,"a"); s!=big+"appended" { t.Fatalf("a has %d bytes",len(s)) }
+}
+
+func TestRestoreConflict(t *testing.T) {
+	svc,target := testStore(t,files.Options{}),testStore(t,files.Options{})
+	svc.PutObj([]byte("a"),[]byte("backed up"))
+	backup(t,svc,target)
+	
+	dst := testStore(t,files.Options{})
+	dst.PutObj([]byte("a"),[]byte("live"))
+	_,err := Restore(target,dst,RestoreOptions{})
+	var oe *ObjectError
+	if !errors.As(err,&oe) || oe.Err!=single.EExist { t.Fatalf("conflict: %v",err) }
+	st,err := Restore(target,dst,RestoreOptions{Conflict:archive.ConflictSkip})
+	if err!=nil || st.Skipped!=1 { t.Fatalf("skip: %+v, %v",st,err) }
+	if _,err = Restore(target,dst,RestoreOptions{Conflict:archive.ConflictOverwrite}); err!=nil { t.Fatal(err) }
+	if s := readAll(t,dst,"a"); s!="backed up" { t.Fatalf("a = %q",s) }
+}
+
+// A restore, that fails the verification, leaves the existing object.
+func TestRestoreChecksum(t *testing.T) {
+	svc,target := testStore(t,files.Options{}),testStore(t,files.Options{})
+	svc.PutObj([]byte("a"),[]byte("backed up"))
+	backup(t,svc,target)
+	replace(t,target,string(chunkName(1,"a")),"corrupted")
+	
+	dst := testStore(t,files.Options{})
+	dst.PutObj([]byte("a"),[]byte("live"))
+	_,err := Restore(target,dst,RestoreOptions{Conflict:archive.ConflictOverwrite})
+	var oe *ObjectError
+	if !errors.As(err,&oe) || oe.Err!=errChecksum { t.Fatalf("restore: %v",err) }
+	if s := readAll(t,dst,"a"); s!="live" { t.Fatalf("a = %q",s) }
+	
+	// Nor does a new object appear.
+	empty := testStore(t,files.Options{})
+	if _,err = Restore(target,empty,RestoreOptions{}); err==nil { t.Fatal("restore succeeded") }
+	if _,err = empty.Info([]byte("a")); err!=single.ENotFound { t.Fatalf("a: %v",err) }
+}
+
+///
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package backup
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bytes"
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"encoding/json"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+const (
+	manifestPrefix = "gen-"
+	chunkPrefix    = "chunk-"
+)
+
+// The manifest of a generation is named "gen-{generation}".
+func manifestName(gen int) []byte {
+	return []byte(fmt.Sprintf("%s%08d",manifestPrefix,gen))
+}
+
+// The part of an object, that was copied by a generation, is named "chunk-{generation}-{hash}".
+func chunkName(gen int,object string) []byte {
+	return []byte(fmt.Sprintf("%s%08d-%x",chunkPrefix,gen,hashName(object)))
+}
+
+var ErrIncomplete = errors.New("backup: incomplete generation")
+
+// A line of a manifest, the first one has Begin, the last one End, and the others are entries.
+type line struct{
+	*Entry
+	Begin *GenerationInfo `json:"begin,omitempty"`
+	End   *GenerationInfo `json:"end,omitempty"`
+}
+
+// Buffers the data, and writes it to the object in pieces of size bytes.
+type objWriter struct{
+	svc     single.ObjectSvc
+	name    []byte
+	size    int
+	buf     bytes.Buffer
+	created bool
+}
+func (w *objWriter) Write(p []byte) (n int,err error) {
+	for len(p)>0 {
+		k := w.size-w.buf.Len()
+		if k>len(p) { k = len(p) }
+		w.buf.Write(p[:k])
+		p = p[k:]
+		n += k
+		if w.buf.Len()>=w.size {
+			if err = w.flush(); err!=nil { return }
+		}
+	}
+	return
+}
+func (w *objWriter) writeLine(l *line) error {
+	data,err := json.Marshal(l)
+	if err!=nil { return err }
+	_,err = w.Write(append(data,'\n'))
+	return err
+}
+
+// Creates the object, if it doesn't exist yet, even if it is empty.
+func (w *objWriter) flush() (err error) {
+	if w.created && w.buf.Len()==0 { return }
+	if w.created {
+		_,err = w.svc.Append(w.name,w.buf.Bytes())
+	} else {
+		err = w.svc.PutObj(w.name,w.buf.Bytes())
+	}
+	if err!=nil { return }
+	w.created = true
+	w.buf.Reset()
+	return
+}
+
+// Counts the bytes, that are written to w.
+type countWriter struct{
+	w io.Writer
+	n int64
+}
+func (c *countWriter) Write(p []byte) (n int,err error) {
+	n,err = c.w.Write(p)
+	c.n += int64(n)
+	return
+}
+
+// Reads the entries of a manifest, in the order of the objects.
+type manifestReader struct{
+	pr    *io.PipeReader
+	sc    *bufio.Scanner
+	begin *GenerationInfo
+	end   *GenerationInfo
+}
+func openManifest(target single.ObjectSvc,gen int) *manifestReader {
+	pr,pw := io.Pipe()
+	go func() { pw.CloseWithError(single.ReadTo(target,manifestName(gen),single.ByteRange{},pw)) }()
+	sc := bufio.NewScanner(pr)
+	sc.Buffer(make([]byte,64<<10),16<<20)
+	return &manifestReader{pr:pr,sc:sc}
+}
+
+// Returns nil at the end of the manifest, or ErrIncomplete, if the end is missing.
+func (r *manifestReader) next() (*Entry,error) {
+	for r.end==nil && r.sc.Scan() {
+		var l line
+		if json.Unmarshal(r.sc.Bytes(),&l)!=nil { return nil,single.EDiskFailure }
+		switch {
+		case l.Begin!=nil: r.begin = l.Begin
+		case l.End!=nil: r.end = l.End
+		case l.Entry!=nil: return l.Entry,nil
+		}
+	}
+	if r.end!=nil { return nil,nil }
+	if err := r.sc.Err(); err!=nil { return nil,err }
+	return nil,ErrIncomplete
+}
+func (r *manifestReader) Close() error {
+	return r.pr.Close()
+}
+
+/*
+Lists the complete generations of the target, oldest first, and returns the
+highest generation number, that is used, including incomplete ones.
+*/
+func generations(target single.ObjectSvc) (infos []GenerationInfo,last int,err error) {
+	ls := single.AsListSvc(target)
+	if ls==nil { return nil,0,single.EOpNotSupp }
+	var after []byte
+	for {
+		names,err := ls.ListObjs([]byte(manifestPrefix),after,1000)
+		if err!=nil { return nil,0,err }
+		if len(names)==0 { break }
+		for _,name := range names {
+			gen,err := strconv.Atoi(strings.TrimPrefix(string(name),manifestPrefix))
+			if err!=nil || gen<=0 { continue }
+			if gen>last { last = gen }
+			info,err := readEnd(target,name)
+			if err!=nil {
+				if single.BoilDownError(err)==single.ENotFound || err==ErrIncomplete { continue }
+				return nil,0,err
+			}
+			infos = append(infos,info)
+		}
+		after = names[len(names)-1]
+	}
+	sort.Slice(infos,func(i,j int) bool { return infos[i].Generation<infos[j].Generation })
+	return
+}
+
+// Reads the last line of a manifest.
+func readEnd(target single.ObjectSvc,name []byte) (info GenerationInfo,err error) {
+	lng,err := target.Info(name)
+	if err!=nil { return }
+	off := lng-64<<10
+	if off<0 { off = 0 }
+	var buf bytes.Buffer
+	if err = single.ReadTo(target,name,single.ByteRange{off,lng-off},&buf); err!=nil { return }
+	data := bytes.TrimRight(buf.Bytes(),"\n")
+	data = data[bytes.LastIndexByte(data,'\n')+1:]
+	var l line
+	if json.Unmarshal(data,&l)!=nil || l.End==nil { return info,ErrIncomplete }
+	return *l.End,nil
+}
+
+// Lists the complete generations of the target, oldest first.
+func Generations(target single.ObjectSvc) ([]GenerationInfo,error) {
+	infos,_,err := generations(target)
+	return infos,err
+}
+
+///
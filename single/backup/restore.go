@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package backup
+
+import (
+	"io"
+	"strings"
+	"encoding/hex"
+	"crypto/sha256"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/archive"
+)
+
+type RestoreOptions struct{
+	// The generation to restore, the latest one, if 0.
+	Generation int
+	
+	// Only restores the objects with this prefix.
+	Prefix string
+	
+	// What to do with objects, that exist in the store.
+	Conflict archive.Conflict
+	
+	ChunkSize int
+}
+
+type RestoreStats struct{
+	Generation int   `json:"generation"`
+	Objects    int   `json:"objects"`
+	Bytes      int64 `json:"bytes"`
+	Skipped    int   `json:"skipped"`
+}
+
+/*
+Writes the objects of a generation of target to svc, and verifies their
+checksums. If svc can replace objects atomically, see single.ReplaceSvc, an
+object only becomes visible, once it is verified, and replaces the existing one
+with ConflictOverwrite. Otherwise, objects, that fail the verification, are
+written nonetheless, and ConflictOverwrite requires a versioned store, as the
+existing objects would have to be deleted first.
+*/
+func Restore(target,svc single.ObjectSvc,opts RestoreOptions) (st RestoreStats,err error) {
+	infos,_,err := generations(target)
+	if err!=nil { return }
+	if len(infos)==0 { return st,single.ENotFound }
+	st.Generation = infos[len(infos)-1].Generation
+	if opts.Generation!=0 {
+		st.Generation = 0
+		for _,info := range infos {
+			if info.Generation==opts.Generation { st.Generation = info.Generation }
+		}
+		if st.Generation==0 { return st,single.ENotFound }
+	}
+	size := opts.ChunkSize
+	if size<=0 { size = DefaultChunkSize }
+	versioned := single.AsVersionSvc(svc)!=nil
+	rs := single.AsReplaceSvc(svc)
+	
+	r := openManifest(target,st.Generation)
+	defer r.Close()
+	for {
+		e,err := r.next()
+		if err!=nil { return st,err }
+		if e==nil { break }
+		if !strings.HasPrefix(e.Object,opts.Prefix) { continue }
+		name := []byte(e.Object)
+		_,err = svc.Info(name)
+		switch single.BoilDownError(err) {
+		case single.ENotFound:
+			err = nil
+		case nil:
+			switch opts.Conflict {
+			case archive.ConflictSkip:
+				st.Skipped++
+				continue
+			case archive.ConflictOverwrite:
+				// Stores without versions only create objects.
+				if !versioned && rs==nil { err = single.EOpNotSupp }
+			default:
+				err = single.EExist
+			}
+		}
+		if err==nil { err = restore(target,svc,rs,e,size) }
+		if err!=nil { return st,&ObjectError{e.Object,err} }
+		st.Objects++
+		st.Bytes += e.Length
+	}
+	return
+}
+
+// Writes the object. With rs, the parts are streamed into ReplaceObj, which fails, unless they are verified.
+func restore(target,svc single.ObjectSvc,rs single.ReplaceSvc,e *Entry,size int) (err error) {
+	if rs!=nil {
+		pr,pw := io.Pipe()
+		go func() { pw.CloseWithError(copyParts(target,e,pw)) }()
+		_,err = rs.ReplaceObj([]byte(e.Object),pr)
+		pr.Close()
+		return
+	}
+	w := &objWriter{svc:svc,name:[]byte(e.Object),size:size}
+	err = copyParts(target,e,w)
+	if err==nil || err==errChecksum {
+		if ferr := w.flush(); ferr!=nil { err = ferr }
+	}
+	return
+}
+
+// Copies the parts of e to w, and verifies the checksum.
+func copyParts(target single.ObjectSvc,e *Entry,w io.Writer) (err error) {
+	h := sha256.New()
+	for _,p := range e.Parts {
+		if p.Length==0 { continue }
+		cw := &countWriter{w:io.MultiWriter(w,h)}
+		if err = single.ReadTo(target,chunkName(p.Generation,e.Object),single.ByteRange{0,p.Length},cw); err!=nil { return }
+		if cw.n!=p.Length { return io.ErrUnexpectedEOF }
+	}
+	if hex.EncodeToString(h.Sum(nil))!=e.SHA256 { return errChecksum }
+	return
+}
+
+///
@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Executes batches of operations, see proto.BatchOp, on an object store, for
+the batch endpoint of the frontends and for local stores.
+*/
+package batch
+
+import (
+	"sync"
+	"bytes"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// The number of operations, that are executed at the same time, by default.
+const DefaultWorkers = 16
+
+// Maps BatchOp.Op to the operation, that is authorized.
+var ops = map[string]proto.Op{
+	"info"  : proto.OpInfo,
+	"read"  : proto.OpRead,
+	"put"   : proto.OpPut,
+	"append": proto.OpAppend,
+	"delete": proto.OpDelete,
+}
+
+type Batch struct{
+	svc single.ObjectSvc
+	vs  single.VersionSvc
+	
+	// Shared by all batches, to bound the concurrency of the store.
+	slots chan struct{}
+}
+
+// Creates a Batch, that executes up to workers operations at the same time.
+func New(svc single.ObjectSvc,workers int) *Batch {
+	if workers<=0 { workers = DefaultWorkers }
+	return &Batch{svc:svc,vs:single.AsVersionSvc(svc),slots:make(chan struct{},workers)}
+}
+
+/*
+Executes the operations concurrently and returns their results in the same
+order. Operations on the same object are executed one after another, in order.
+authorize is called for every valid operation, before it is executed, and may
+be nil.
+*/
+func (b *Batch) Run(bops []proto.BatchOp,authorize func(op proto.Op,object string) error) []proto.BatchResult {
+	var groups [][]int
+	byObj := make(map[string]int)
+	for i := range bops {
+		g,ok := byObj[bops[i].Object]
+		if !ok {
+			g = len(groups)
+			byObj[bops[i].Object] = g
+			groups = append(groups,nil)
+		}
+		groups[g] = append(groups[g],i)
+	}
+	
+	res := make([]proto.BatchResult,len(bops))
+	next := make(chan []int)
+	n := cap(b.slots)
+	if n>len(groups) { n = len(groups) }
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i<n; i++ {
+		go func() {
+			defer wg.Done()
+			for g := range next {
+				b.slots <- struct{}{}
+				for _,j := range g { res[j] = b.run(&bops[j],authorize) }
+				<- b.slots
+			}
+		}()
+	}
+	for _,g := range groups { next <- g }
+	close(next)
+	wg.Wait()
+	return res
+}
+
+func (b *Batch) run(bop *proto.BatchOp,authorize func(op proto.Op,object string) error) (r proto.BatchResult) {
+	op,ok := ops[bop.Op]
+	var err error
+	switch {
+	case !ok || !proto.ValidObject(bop.Object): err = single.EInvalid
+	case authorize!=nil: err = authorize(op,bop.Object)
+	}
+	if err==nil { err = b.exec(op,bop,&r) }
+	isR := op==proto.OpInfo || op==proto.OpRead
+	if err==nil {
+		r.Status = 200
+		if !isR { r.Status = 201 }
+		return
+	}
+	if isR && single.BoilDownError(err)==single.EBeingDeleted { err = single.ENotFound }
+	r = proto.BatchResult{Error:proto.ClassOf(err)}
+	r.Status,_,_ = proto.StatusOf(err,isR)
+	return
+}
+
+func (b *Batch) exec(op proto.Op,bop *proto.BatchOp,r *proto.BatchResult) (err error) {
+	obj := []byte(bop.Object)
+	if bop.Version!=0 && b.vs==nil { return single.EOpNotSupp }
+	switch op {
+	case proto.OpInfo:
+		r.Length,err = b.info(obj,bop.Version)
+	case proto.OpRead:
+		r.Data,err = b.read(obj,bop)
+	case proto.OpPut:
+		switch {
+		case bop.Version!=0: err = single.EInvalid
+		case b.vs!=nil: r.Version,err = b.vs.PutVersion(obj,bop.Data)
+		default: err = b.svc.PutObj(obj,bop.Data)
+		}
+	case proto.OpAppend:
+		if bop.Version!=0 { return single.EInvalid }
+		var rang single.ByteRange
+		rang,err = b.svc.Append(obj,bop.Data)
+		r.Offset,r.Length = rang[0],rang[1]
+	case proto.OpDelete:
+		if bop.Version==0 {
+			err = b.svc.DeleteObj(obj)
+		} else {
+			err = b.vs.DeleteVersion(obj,bop.Version)
+		}
+	}
+	return
+}
+
+func (b *Batch) info(obj []byte,ver single.Version) (int64,error) {
+	if ver==0 { return b.svc.Info(obj) }
+	return b.vs.InfoVersion(obj,ver)
+}
+
+// Reads the range into memory. Ranges beyond MaxBatchRead are EInvalid.
+func (b *Batch) read(obj []byte,bop *proto.BatchOp) (data []byte,err error) {
+	if bop.Offset<0 || bop.Length<0 { return nil,single.EInvalid }
+	sz,err := b.info(obj,bop.Version)
+	if err!=nil { return }
+	n := sz-bop.Offset
+	if bop.Length!=0 && bop.Length<n { n = bop.Length }
+	if n>proto.MaxBatchRead { return nil,single.EInvalid }
+	if n<=0 { return []byte{},nil }
+	
+	// The object may grow meanwhile, thus the length is fixed.
+	buf := bytes.NewBuffer(make([]byte,0,n))
+	rang := single.ByteRange{bop.Offset,n}
+	if bop.Version==0 {
+		err = single.ReadTo(b.svc,obj,rang,buf)
+	} else {
+		err = single.ReadVersionTo(b.vs,obj,bop.Version,rang,buf)
+	}
+	return buf.Bytes(),err
+}
+
+///
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package batch
+
+import (
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+func testBatch(t *testing.T,opts files.Options) *Batch {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),opts)
+	if err!=nil { t.Fatal(err) }
+	return New(svc,4)
+}
+
+func TestBatchRun(t *testing.T) {
+	b := testBatch(t,files.Options{})
+	res := b.Run([]proto.BatchOp{
+		{Op:"put",Object:"a",Data:[]byte("hello")},
+		{Op:"append",Object:"a",Data:[]byte(" world")},
+		{Op:"info",Object:"a"},
+		{Op:"read",Object:"a",Offset:6,Length:3},
+		{Op:"read",Object:"a",Offset:6},
+		{Op:"info",Object:"missing"},
+		{Op:"put",Object:"a",Data:[]byte("again")},
+		{Op:"frobnicate",Object:"a"},
+		{Op:"delete",Object:"b"},
+	},nil)
+	want := []struct{
+		status int
+		err    error
+	}{{201,nil},{201,nil},{200,nil},{200,nil},{200,nil},{404,single.ENotFound},{409,single.EExist},{400,single.EInvalid},{404,single.ENotFound}}
+	for i,w := range want {
+		if res[i].Status!=w.status || res[i].Err()!=w.err { t.Fatalf("result %d = %+v",i,res[i]) }
+	}
+	if res[1].Offset!=5 || res[1].Length!=6 { t.Fatalf("append = %+v",res[1]) }
+	if res[2].Length!=11 { t.Fatalf("info = %+v",res[2]) }
+	if string(res[3].Data)!="wor" || string(res[4].Data)!="world" { t.Fatalf("reads = %q, %q",res[3].Data,res[4].Data) }
+}
+
+// Operations on the same object are executed in order.
+func TestBatchOrder(t *testing.T) {
+	b := testBatch(t,files.Options{})
+	var bops []proto.BatchOp
+	for i := 0; i<100; i++ {
+		bops = append(bops,proto.BatchOp{Op:"append",Object:"a",Data:[]byte("x")},proto.BatchOp{Op:"append",Object:"b",Data:[]byte("yy")})
+	}
+	res := b.Run(bops,nil)
+	for i,r := range res {
+		want := int64(i/2)
+		if i%2==1 { want *= 2 }
+		if r.Status!=201 || r.Offset!=want { t.Fatalf("result %d = %+v",i,r) }
+	}
+}
+
+func TestBatchAuthorize(t *testing.T) {
+	b := testBatch(t,files.Options{})
+	res := b.Run([]proto.BatchOp{
+		{Op:"put",Object:"pub-a"},
+		{Op:"put",Object:"priv-a"},
+		{Op:"read",Object:"priv-a"},
+	},func(op proto.Op,object string) error {
+		if object=="priv-a" { return single.EForbidden }
+		return nil
+	})
+	if res[0].Status!=201 || res[1].Err()!=single.EForbidden || res[2].Err()!=single.EForbidden { t.Fatalf("results = %+v",res) }
+}
+
+func TestBatchLimits(t *testing.T) {
+	b := testBatch(t,files.Options{})
+	big := make([]byte,proto.MaxBatchRead+1)
+	res := b.Run([]proto.BatchOp{
+		{Op:"put",Object:"a",Data:big},
+		{Op:"read",Object:"a"},
+		{Op:"read",Object:"a",Offset:1},
+		{Op:"read",Object:"a",Offset:-1},
+		{Op:"info",Object:"a",Version:1},
+	},nil)
+	if res[1].Err()!=single.EInvalid || res[2].Status!=200 || len(res[2].Data)!=proto.MaxBatchRead { t.Fatalf("reads = %+v, %d bytes",res[1],len(res[2].Data)) }
+	if res[3].Err()!=single.EInvalid || res[4].Err()!=single.EOpNotSupp { t.Fatalf("results = %+v, %+v",res[3],res[4]) }
+}
+
+// Versioned stores return the created version, and address versions.
+func TestBatchVersions(t *testing.T) {
+	b := testBatch(t,files.Options{Versioning:true})
+	res := b.Run([]proto.BatchOp{{Op:"put",Object:"a",Data:[]byte("one")},{Op:"put",Object:"a",Data:[]byte("two")}},nil)
+	if res[0].Version==0 || res[1].Version<=res[0].Version { t.Fatalf("results = %+v",res) }
+	res = b.Run([]proto.BatchOp{
+		{Op:"read",Object:"a",Version:res[0].Version},
+		{Op:"delete",Object:"a",Version:res[1].Version},
+		{Op:"read",Object:"a"},
+	},nil)
+	if string(res[0].Data)!="one" || res[1].Status!=201 || string(res[2].Data)!="one" { t.Fatalf("results = %+v",res) }
+}
+
+///
@@ -0,0 +1,547 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Implements single.ObjectSvc on top of the HTTP protocol of single/fasthttp-api.
+*/
+package client
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"errors"
+	"strconv"
+	"net/url"
+	"crypto/tls"
+	"encoding/json"
+	
+	"unsafe"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/events"
+	"github.com/byte-mug/hblobstore/single/proto"
+	"github.com/byte-mug/hblobstore/util/bconv"
+)
+
+// Returned for responses, that don't map to any error of package single.
+type StatusError struct{
+	Status int
+	Body   string
+}
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s",e.Status,e.Body)
+}
+
+// Translates an error response into the errors of package single.
+func translate(resp *fasthttp.Response) error {
+	err := proto.ErrorOf(resp.StatusCode(),func(name string) string {
+		return string(resp.Header.Peek(name))
+	})
+	if err==nil { err = &StatusError{resp.StatusCode(),string(resp.Body())} }
+	return err
+}
+
+type Options struct{
+	// Timeout of a single attempt, zero means no timeout.
+	Timeout time.Duration
+	
+	// How often idempotent requests are retried on network errors and
+	// 502, 503 and 504 responses.
+	Retries int
+	
+	// The delay before the first retry, doubled for each further one.
+	Backoff time.Duration
+	
+	// The maximum number of pooled connections, zero means the fasthttp default.
+	MaxConns int
+	
+	// For https, like custom root CAs or a client certificate.
+	TLSConfig *tls.Config
+	
+	// Sent as bearer token, if not empty.
+	Token string
+	
+	// If KeyID is not empty, requests are signed with the HMAC key instead.
+	KeyID  string
+	Secret string
+}
+
+type Client struct{
+	hc   *fasthttp.HostClient
+	base string
+	
+	// Like hc, but streams the responses, for Follow.
+	fc *fasthttp.HostClient
+
+	opts Options
+}
+
+// Creates a client for the server at addr, like "http://localhost:8080".
+func New(addr string,opts Options) (*Client,error) {
+	u,err := url.Parse(addr)
+	if err!=nil { return nil,err }
+	if u.Scheme!="http" && u.Scheme!="https" { return nil,errors.New("client: unsupported scheme "+u.Scheme) }
+	if opts.Backoff<=0 { opts.Backoff = 100*time.Millisecond }
+	hc := &fasthttp.HostClient{
+		Addr: fasthttp.AddMissingPort(u.Host,u.Scheme=="https"),
+		IsTLS: u.Scheme=="https",
+		TLSConfig: opts.TLSConfig,
+		MaxConns: opts.MaxConns,
+	}
+	return &Client{
+		hc: hc,
+		fc: &fasthttp.HostClient{
+			Addr: hc.Addr,
+			IsTLS: hc.IsTLS,
+			TLSConfig: hc.TLSConfig,
+			MaxConns: hc.MaxConns,
+			StreamResponseBody: true,
+		},
+		base: u.Scheme+"://"+u.Host,
+		opts: opts,
+	},nil
+}
+
+func (c *Client) uri(prefix string,objectId []byte,ver single.Version) string {
+	s := c.base+prefix+url.PathEscape(string(objectId))
+	if ver!=0 { s += "?version="+ver.String() }
+	return s
+}
+
+func retryable(err error,resp *fasthttp.Response) bool {
+	if err!=nil { return true }
+	switch resp.StatusCode() {
+	case fasthttp.StatusBadGateway,fasthttp.StatusServiceUnavailable,fasthttp.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// How long signatures are valid, covering all retries.
+const signExpiry = 15*time.Minute
+
+// Exposes a *fasthttp.Request as auth.Request.
+type authReq struct{
+	req *fasthttp.Request
+}
+func (r authReq) Method() string { return string(r.req.Header.Method()) }
+func (r authReq) Path() string { return string(r.req.URI().Path()) }
+func (r authReq) RawQuery() string { return string(r.req.URI().QueryString()) }
+func (r authReq) Header(name string) string { return string(r.req.Header.Peek(name)) }
+
+// Adds the credentials to req.
+func (c *Client) authorize(req *fasthttp.Request) error {
+	switch {
+	case c.opts.KeyID!="":
+		req.Header.Set("X-Content-Sha256",auth.PayloadHash(req.Body()))
+		v,err := auth.Sign(authReq{req},c.opts.KeyID,c.opts.Secret,time.Now().Add(signExpiry))
+		if err!=nil { return err }
+		req.Header.Set("Authorization",v)
+	case c.opts.Token!="":
+		req.Header.Set("Authorization","Bearer "+c.opts.Token)
+	}
+	return nil
+}
+
+// Performs req. Idempotent requests are retried.
+func (c *Client) do(req *fasthttp.Request,resp *fasthttp.Response,idempotent bool) (err error) {
+	if err = c.authorize(req); err!=nil { return }
+	delay := c.opts.Backoff
+	for i := 0; ; i++ {
+		if c.opts.Timeout>0 {
+			err = c.hc.DoTimeout(req,resp,c.opts.Timeout)
+		} else {
+			err = c.hc.Do(req,resp)
+		}
+		if !idempotent || i>=c.opts.Retries || !retryable(err,resp) { return }
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Performs a request and translates error responses. The caller must release resp.
+func (c *Client) request(method,uri string,body []byte,idempotent bool) (resp *fasthttp.Response,err error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	if body!=nil { req.SetBodyRaw(body) }
+	resp = fasthttp.AcquireResponse()
+	if err = c.do(req,resp,idempotent); err!=nil {
+		fasthttp.ReleaseResponse(resp)
+		return nil,err
+	}
+	if resp.StatusCode()>=300 {
+		err = translate(resp)
+		fasthttp.ReleaseResponse(resp)
+		return nil,err
+	}
+	return
+}
+
+func (c *Client) PutObj(objectId []byte,data []byte) (err error) {
+	_,err = c.PutVersion(objectId,data)
+	return
+}
+
+// Like PutObj. Returns the new version, if the server is versioned, 0 otherwise.
+func (c *Client) PutVersion(objectId []byte,data []byte) (ver single.Version,err error) {
+	resp,err := c.request("PUT",c.uri("/o/",objectId,0),data,false)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	if xv := resp.Header.Peek("X-Version"); len(xv)!=0 { ver,err = single.ParseVersion(xv) }
+	return
+}
+func (c *Client) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	resp,err := c.request("POST",c.uri("/o/",objectId,0),data,false)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	pos[0],_ = bconv.ParseUint64(resp.Header.Peek("X-Offset"))
+	pos[1],_ = bconv.ParseUint64(resp.Header.Peek("X-Length"))
+	return
+}
+func (c *Client) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return c.ReadVersionTo(objectId,0,pos,ops.GetBodyBuffer(dst))
+}
+func (c *Client) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	return c.ReadVersionTo(objectId,0,pos,sink)
+}
+func (c *Client) ReadVersion(objectId []byte,ver single.Version,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return c.ReadVersionTo(objectId,ver,pos,ops.GetBodyBuffer(dst))
+}
+func (c *Client) ReadVersionTo(objectId []byte,ver single.Version,pos single.ByteRange,sink single.Sink) (err error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(c.uri("/o/",objectId,ver))
+	if pos[0]>0 { req.Header.AddBytesV("X-Offset",bconv.AppendUint64(make([]byte,0,10),pos[0])) }
+	if lng,ok := pos.Length64(); ok { req.Header.AddBytesV("X-Length",bconv.AppendUint64(make([]byte,0,10),lng)) }
+	
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err = c.do(req,resp,true); err!=nil { return }
+	if resp.StatusCode()>=300 { return translate(resp) }
+	return resp.BodyWriteTo(sink)
+}
+func (c *Client) DeleteObj(objectId []byte) (err error) {
+	return c.DeleteVersion(objectId,0)
+}
+func (c *Client) Info(objectId []byte) (lng int64,err error) {
+	return c.InfoVersion(objectId,0)
+}
+func (c *Client) InfoVersion(objectId []byte,ver single.Version) (lng int64,err error) {
+	resp,err := c.request("OPTIONS",c.uri("/o/",objectId,ver),nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	return bconv.ParseUint64(resp.Header.Peek("X-Length"))
+}
+
+// Lists the versions of an object. Fails with single.EOpNotSupp, if the
+// server isn't versioned.
+func (c *Client) ListVersions(objectId []byte) (vers []single.VersionInfo,err error) {
+	resp,err := c.request("GET",c.uri("/v/",objectId,0),nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	var raw []struct{
+		Version string `json:"version"`
+		Length  int64  `json:"length"`
+		Deleted bool   `json:"deleted"`
+	}
+	if err = json.Unmarshal(resp.Body(),&raw); err!=nil { return }
+	vers = make([]single.VersionInfo,len(raw))
+	for i,r := range raw {
+		if vers[i].Version,err = single.ParseVersion([]byte(r.Version)); err!=nil { return nil,err }
+		vers[i].Length,vers[i].Deleted = r.Length,r.Deleted
+	}
+	return
+}
+
+// Deletes a version of an object, or the object itself, if ver is 0.
+func (c *Client) DeleteVersion(objectId []byte,ver single.Version) (err error) {
+	resp,err := c.request("DELETE",c.uri("/o/",objectId,ver),nil,false)
+	if err==nil { fasthttp.ReleaseResponse(resp) }
+	return
+}
+
+func (c *Client) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	if len(prefix)!=0 { args.SetBytesV("prefix",prefix) }
+	if len(after)!=0 { args.SetBytesV("after",after) }
+	if limit>0 { args.SetUint("limit",limit) }
+	resp,err := c.request("GET",c.base+"/l?"+args.String(),nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	var strs []string
+	if err = json.Unmarshal(resp.Body(),&strs); err!=nil { return }
+	names = make([][]byte,len(strs))
+	for i,s := range strs { names[i] = []byte(s) }
+	return
+}
+
+func (c *Client) GetRetention(objectId []byte) (ret single.Retention,err error) {
+	resp,err := c.request("OPTIONS",c.uri("/r/",objectId,0),nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	ret.Until,_ = bconv.ParseUint64(resp.Header.Peek("X-Retain-Until"))
+	ret.LegalHold = string(resp.Header.Peek("X-Legal-Hold"))=="1"
+	return
+}
+func (c *Client) SetRetention(objectId []byte,ret single.Retention) (err error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI(c.uri("/r/",objectId,0))
+	req.Header.AddBytesV("X-Retain-Until",bconv.AppendUint64(make([]byte,0,10),ret.Until))
+	if ret.LegalHold { req.Header.Add("X-Legal-Hold","1") }
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err = c.do(req,resp,true); err!=nil { return }
+	if resp.StatusCode()>=300 { return translate(resp) }
+	return
+}
+
+func (c *Client) uploadUri(objectId []byte,uploadId string) string {
+	return c.uri("/u/",objectId,0)+"?upload="+url.QueryEscape(uploadId)
+}
+
+func (c *Client) CreateUpload(objectId []byte) (uploadId string,err error) {
+	resp,err := c.request("POST",c.uri("/u/",objectId,0),nil,false)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	return string(resp.Header.Peek("X-Upload-Id")),nil
+}
+
+// Uploads a part. Since parts can be re-uploaded, the request is retried.
+func (c *Client) UploadPart(objectId []byte,uploadId string,part int,data []byte) (err error) {
+	resp,err := c.request("PUT",c.uploadUri(objectId,uploadId)+"&part="+strconv.Itoa(part),data,true)
+	if err==nil { fasthttp.ReleaseResponse(resp) }
+	return
+}
+func (c *Client) ListParts(objectId []byte,uploadId string) (parts []single.PartInfo,err error) {
+	resp,err := c.request("GET",c.uploadUri(objectId,uploadId),nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	err = json.Unmarshal(resp.Body(),&parts)
+	return
+}
+func (c *Client) CompleteUpload(objectId []byte,uploadId string,parts []int) (ver single.Version,err error) {
+	var body []byte
+	if parts!=nil { body,_ = json.Marshal(parts) }
+	resp,err := c.request("POST",c.uploadUri(objectId,uploadId),body,false)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	if xv := resp.Header.Peek("X-Version"); len(xv)!=0 { ver,err = single.ParseVersion(xv) }
+	return
+}
+func (c *Client) AbortUpload(objectId []byte,uploadId string) (err error) {
+	resp,err := c.request("DELETE",c.uploadUri(objectId,uploadId),nil,false)
+	if err==nil { fasthttp.ReleaseResponse(resp) }
+	return
+}
+
+/*
+Returns a pre-signed URL, that allows anyone to perform method on the object
+(or a version of it) for ttl. The client must have a HMAC key.
+*/
+func (c *Client) Presign(method string,objectId []byte,ver single.Version,ttl time.Duration) (string,error) {
+	if c.opts.KeyID=="" { return "",errors.New("client: pre-signing requires KeyID and Secret") }
+	return auth.Presign(method,c.uri("/o/",objectId,ver),c.opts.KeyID,c.opts.Secret,time.Now().Add(ttl))
+}
+
+// The duration of the requests of Follow.
+const followWindow = 10*time.Second
+
+/*
+Executes the operations with a single request and returns their results, see
+proto.BatchOp. Batches without appends are retried.
+*/
+func (c *Client) Batch(ops []proto.BatchOp) (res []proto.BatchResult,err error) {
+	idempotent := true
+	for i := range ops {
+		if ops[i].Op=="append" { idempotent = false }
+	}
+	body,err := json.Marshal(ops)
+	if err!=nil { return }
+	resp,err := c.request("POST",c.base+"/b",body,idempotent)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	if err = json.Unmarshal(resp.Body(),&res); err==nil && len(res)!=len(ops) { err = errors.New("client: batch result mismatch") }
+	return
+}
+
+// Writes the archive of the objects with the prefix to w, see single/archive.
+func (c *Client) Export(w io.Writer,prefix string,versions bool) (err error) {
+	uri := c.base+"/export?prefix="+url.QueryEscape(prefix)
+	if versions { uri += "&versions=1" }
+	return c.export(w,uri)
+}
+
+// Like Export, but exports a snapshot, using the admin API.
+func (c *Client) ExportSnapshot(w io.Writer,name,prefix string) (err error) {
+	return c.export(w,c.base+"/admin/snapshots/export?name="+url.QueryEscape(name)+"&prefix="+url.QueryEscape(prefix))
+}
+
+func (c *Client) export(w io.Writer,uri string) (err error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(uri)
+	if err = c.authorize(req); err!=nil { return }
+	
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err = c.fc.Do(req,resp); err!=nil { return }
+	if resp.StatusCode()>=300 { return translate(resp) }
+	if body := resp.BodyStream(); body!=nil {
+		_,err = io.Copy(w,body)
+	} else {
+		_,err = w.Write(resp.Body())
+	}
+	return
+}
+
+// Creates a snapshot, using the admin API. An empty name is chosen by the server.
+func (c *Client) CreateSnapshot(name string) (info single.SnapshotInfo,err error) {
+	resp,err := c.request("POST",c.base+"/admin/snapshots?name="+url.QueryEscape(name),nil,false)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	err = json.Unmarshal(resp.Body(),&info)
+	return
+}
+func (c *Client) Snapshots() (infos []single.SnapshotInfo,err error) {
+	resp,err := c.request("GET",c.base+"/admin/snapshots",nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	err = json.Unmarshal(resp.Body(),&infos)
+	return
+}
+func (c *Client) DeleteSnapshot(name string) (err error) {
+	resp,err := c.request("DELETE",c.base+"/admin/snapshots?name="+url.QueryEscape(name),nil,true)
+	if err==nil { fasthttp.ReleaseResponse(resp) }
+	return
+}
+
+// Returns the state of a replica, using the admin API.
+func (c *Client) Replication() (st single.ReplicationStatus,err error) {
+	resp,err := c.request("GET",c.base+"/admin/replication",nil,true)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	err = json.Unmarshal(resp.Body(),&st)
+	return
+}
+
+// Promotes a replica to a primary, using the admin API.
+func (c *Client) Promote() (st single.ReplicationStatus,err error) {
+	resp,err := c.request("POST",c.base+"/admin/promote",nil,false)
+	if err!=nil { return }
+	defer fasthttp.ReleaseResponse(resp)
+	err = json.Unmarshal(resp.Body(),&st)
+	return
+}
+
+/*
+Returns the events after cursor, waiting up to wait for them, see GET /events.
+next is the cursor to continue from, and last the last event of the server.
+*/
+func (c *Client) Events(cursor uint64,wait time.Duration) (evs []events.Event,next,last uint64,err error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(c.base+"/events?after="+strconv.FormatUint(cursor,10)+"&wait="+strconv.Itoa(int(wait/time.Second)))
+	if err = c.authorize(req); err!=nil { return }
+	
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if c.opts.Timeout>0 {
+		// The timeout applies on top of the wait.
+		err = c.hc.DoTimeout(req,resp,c.opts.Timeout+wait)
+	} else {
+		err = c.hc.Do(req,resp)
+	}
+	if err!=nil { return }
+	if resp.StatusCode()>=300 { return nil,0,0,translate(resp) }
+	var page struct{
+		Cursor uint64         `json:"cursor"`
+		Events []events.Event `json:"events"`
+	}
+	if err = json.Unmarshal(resp.Body(),&page); err!=nil { return }
+	last,_ = strconv.ParseUint(string(resp.Header.Peek("X-Last-Event")),10,64)
+	return page.Events,page.Cursor,last,nil
+}
+
+/*
+Follows the object with requests, that follow it for followWindow each. done is
+checked between them.
+*/
+func (c *Client) Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error) {
+	for {
+		select {
+		case <-done: return nil
+		default:
+		}
+		var n int64
+		n,err = c.followWindow(objectId,off,fn)
+		off += n
+		if err!=nil { return }
+	}
+}
+func (c *Client) followWindow(objectId []byte,off int64,fn func(data []byte) error) (n int64,err error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(c.uri("/o/",objectId,0)+"?follow="+strconv.Itoa(int(followWindow/time.Second)))
+	if off>0 { req.Header.AddBytesV("X-Offset",bconv.AppendUint64(make([]byte,0,10),off)) }
+	if err = c.authorize(req); err!=nil { return }
+	
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err = c.fc.Do(req,resp); err!=nil { return }
+	if resp.StatusCode()>=300 { return 0,translate(resp) }
+	body := resp.BodyStream()
+	if body==nil {
+		// Small responses aren't streamed.
+		data := resp.Body()
+		if len(data)!=0 { err = fn(data) }
+		return int64(len(data)),err
+	}
+	buf := make([]byte,64<<10)
+	for {
+		m,rerr := body.Read(buf)
+		if m>0 {
+			n += int64(m)
+			if err = fn(buf[:m]); err!=nil { return }
+		}
+		if rerr==io.EOF { return }
+		if rerr!=nil { return n,rerr }
+	}
+}
+
+// Closes idle connections.
+func (c *Client) Close() error {
+	c.hc.CloseIdleConnections()
+	c.fc.CloseIdleConnections()
+	return nil
+}
+
+///
@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package client_test
+
+import (
+	"io"
+	"net"
+	"bytes"
+	"testing"
+	"time"
+	
+	"github.com/valyala/fasthttp"
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/client"
+	sfhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+)
+
+var testAuth = &auth.Config{
+	Keys: map[string]auth.Key{"k1":{Secret:"secret",Principal:"app"}},
+	Policy: auth.Policy{"app":{{Prefix:"",Perms:auth.PermRead|auth.PermWrite|auth.PermDelete|auth.PermRetention}}},
+}
+
+// Serves a versioned store, that requires signed requests, on a loopback port.
+// Returns the address.
+func testServer(t *testing.T) string {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{Versioning:true})
+	if err!=nil { t.Fatal(err) }
+	router := fhr.New()
+	sfhapi.RegisterObjectSvcAuth(svc,router,auth.New(testAuth))
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	go fasthttp.Serve(ln,router.Handler)
+	t.Cleanup(func() {
+		ln.Close()
+		if c,ok := svc.(io.Closer); ok { c.Close() }
+	})
+	return "http://"+ln.Addr().String()
+}
+
+func testClient(t *testing.T,addr string,opts client.Options) *client.Client {
+	t.Helper()
+	opts.Timeout = 10*time.Second
+	c,err := client.New(addr,opts)
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func read(c *client.Client,name string,ver single.Version,pos single.ByteRange) (string,error) {
+	var buf bytes.Buffer
+	err := c.ReadVersionTo([]byte(name),ver,pos,&buf)
+	return buf.String(),err
+}
+
+func TestRoundTrip(t *testing.T) {
+	addr := testServer(t)
+	c := testClient(t,addr,client.Options{KeyID:"k1",Secret:"secret"})
+	name := []byte("a")
+	
+	v1,err := c.PutVersion(name,[]byte("hello"))
+	if err!=nil || v1==0 { t.Fatalf("put: %v, %v",v1,err) }
+	if pos,err := c.Append(name,[]byte(" world")); err!=nil || pos!=(single.ByteRange{5,6}) { t.Fatalf("append: %v, %v",pos,err) }
+	if s,err := read(c,"a",0,single.ByteRange{6,5}); err!=nil || s!="world" { t.Fatalf("range: %q, %v",s,err) }
+	if lng,err := c.Info(name); err!=nil || lng!=11 { t.Fatalf("info: %d, %v",lng,err) }
+	
+	v2,err := c.PutVersion(name,[]byte("two"))
+	if err!=nil || v2<=v1 { t.Fatalf("second put: %v, %v",v2,err) }
+	vers,err := c.ListVersions(name)
+	if err!=nil || len(vers)!=2 || vers[0].Version!=v1 || vers[1].Version!=v2 { t.Fatalf("versions: %+v, %v",vers,err) }
+	if s,err := read(c,"a",v1,single.ByteRange{}); err!=nil || s!="hello world" { t.Fatalf("v1: %q, %v",s,err) }
+	
+	if err = c.PutObj([]byte("b"),[]byte("b")); err!=nil { t.Fatal(err) }
+	if names,err := c.ListObjs(nil,[]byte("a"),10); err!=nil || len(names)!=1 || string(names[0])!="b" { t.Fatalf("list: %q, %v",names,err) }
+	
+	// Errors map to those of package single.
+	if err = c.DeleteObj(name); err!=nil { t.Fatal(err) }
+	if _,err = c.Info(name); err!=single.ENotFound { t.Fatalf("info of a deleted object: %v",err) }
+	if _,err = read(c,"missing",0,single.ByteRange{}); err!=single.ENotFound { t.Fatalf("read of a missing object: %v",err) }
+	
+	until := time.Now().Add(time.Hour).Unix()
+	if err = c.SetRetention([]byte("b"),single.Retention{Until:until}); err!=nil { t.Fatal(err) }
+	if ret,err := c.GetRetention([]byte("b")); err!=nil || ret.Until!=until { t.Fatalf("retention: %+v, %v",ret,err) }
+	if err = c.DeleteObj([]byte("b")); err!=single.ERetained { t.Fatalf("delete of a retained object: %v",err) }
+	
+	id,err := c.CreateUpload([]byte("u"))
+	if err!=nil { t.Fatal(err) }
+	for i,s := range []string{"aa","bb"} {
+		if err = c.UploadPart([]byte("u"),id,i+1,[]byte(s)); err!=nil { t.Fatal(err) }
+	}
+	if parts,err := c.ListParts([]byte("u"),id); err!=nil || len(parts)!=2 { t.Fatalf("parts: %+v, %v",parts,err) }
+	if _,err = c.CompleteUpload([]byte("u"),id,nil); err!=nil { t.Fatal(err) }
+	if s,err := read(c,"u",0,single.ByteRange{}); err!=nil || s!="aabb" { t.Fatalf("u: %q, %v",s,err) }
+	
+	// A pre-signed URL works without credentials.
+	u,err := c.Presign("GET",[]byte("u"),0,time.Minute)
+	if err!=nil { t.Fatal(err) }
+	code,body,err := fasthttp.Get(nil,u)
+	if err!=nil || code!=200 || string(body)!="aabb" { t.Fatalf("pre-signed: %d %q, %v",code,body,err) }
+}
+
+// Large reads are sent from the file, see single.FileSink.
+func TestRoundTripSendFile(t *testing.T) {
+	c := testClient(t,testServer(t),client.Options{KeyID:"k1",Secret:"secret"})
+	data := make([]byte,1<<20)
+	for i := range data { data[i] = byte(i*7) }
+	if err := c.PutObj([]byte("big"),data); err!=nil { t.Fatal(err) }
+	for _,pos := range []single.ByteRange{{},{1000,300000},{1<<19,0},{1<<20-10,100}} {
+		s,err := read(c,"big",0,pos)
+		end := len(data)
+		if lng,ok := pos.Length64(); ok && pos[0]+lng<int64(end) { end = int(pos[0]+lng) }
+		if err!=nil || s!=string(data[pos[0]:end]) { t.Fatalf("%v: %d bytes, %v",pos,len(s),err) }
+	}
+}
+
+func TestRoundTripUnauthorized(t *testing.T) {
+	addr := testServer(t)
+	for _,opts := range []client.Options{{},{KeyID:"k1",Secret:"wrong"},{KeyID:"k2",Secret:"secret"}} {
+		c := testClient(t,addr,opts)
+		if _,err := c.Info([]byte("a")); err!=single.EUnauthorized { t.Errorf("%+v: %v",opts,err) }
+	}
+}
+
+///
@@ -0,0 +1,326 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+/*
+Change events of the object service. A Store emits an event for every
+successful mutation into a Log, a persistent journal of events with increasing
+sequence numbers, from which webhooks and event streams read, starting after a
+cursor.
+
+The Log is a directory of segments, named after the sequence number of their
+first event in hex, like "0000000000000001.log", with an event per line as JSON.
+Only the newest segments are kept. Every event is synced, before it becomes
+visible, thus the cursor of a webhook never points beyond the events, that
+survive a crash.
+*/
+package events
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"bufio"
+	"errors"
+	"strings"
+	"strconv"
+	"path/filepath"
+	"encoding/json"
+)
+
+// Event types.
+const (
+	Created  = "created"
+	Appended = "appended"
+	Deleted  = "deleted"
+	
+	// An incomplete multipart upload of the object expired and was removed.
+	Expired = "expired"
+)
+
+type Event struct{
+	Seq     uint64 `json:"seq"`
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	Object  string `json:"object"`
+	Version string `json:"version,omitempty"`
+	
+	// The written byte range, offset and length, if known.
+	Range []int64 `json:"range,omitempty"`
+	
+	// The upload id of expired uploads.
+	Upload string `json:"upload,omitempty"`
+}
+
+const segExt = ".log"
+
+// Events, that are kept in memory for the followers.
+const ringSize = 4096
+
+// The number of object locks, see Store.
+const lockStripes = 256
+
+type Options struct{
+	// Events per segment, default 10000.
+	SegmentSize int
+	
+	// Segments to keep, default 10.
+	KeepSegments int
+}
+
+type Log struct{
+	dir  string
+	opts Options
+	
+	mu    sync.Mutex
+	f     *os.File
+	count int    // Events in f.
+	next  uint64 // The sequence number of the next event.
+	ring  []Event
+	
+	// Closed and replaced by every event.
+	wake chan struct{}
+	
+	done   chan struct{}
+	stop   sync.Once
+	closed bool
+	hooks  sync.WaitGroup
+	
+	// Serialize the mutations of an object with their events, see Store.
+	locks [lockStripes]sync.Mutex
+}
+
+func segName(first uint64) string { return fmt.Sprintf("%016x",first)+segExt }
+
+// Returns the first sequence numbers of the segments in dir, ascending.
+func segments(dir string) (segs []uint64,err error) {
+	ents,err := os.ReadDir(dir)
+	if err!=nil { return }
+	for _,ent := range ents {
+		name := ent.Name()
+		if !strings.HasSuffix(name,segExt) { continue }
+		first,perr := strconv.ParseUint(strings.TrimSuffix(name,segExt),16,64)
+		if perr!=nil { continue }
+		segs = append(segs,first)
+	}
+	sort.Slice(segs,func(i,j int) bool { return segs[i]<segs[j] })
+	return
+}
+
+// Opens or creates the Log in dir.
+func Open(dir string,opts Options) (l *Log,err error) {
+	if opts.SegmentSize<=0 { opts.SegmentSize = 10000 }
+	if opts.KeepSegments<=0 { opts.KeepSegments = 10 }
+	if err = os.MkdirAll(dir,0777); err!=nil { return }
+	l = &Log{dir:dir,opts:opts,next:1,wake:make(chan struct{}),done:make(chan struct{})}
+	segs,err := segments(dir)
+	if err!=nil { return nil,err }
+	if len(segs)==0 {
+		err = l.openSegment(1)
+	} else {
+		err = l.recover(segs[len(segs)-1])
+	}
+	if err!=nil { return nil,err }
+	return
+}
+
+func (l *Log) openSegment(first uint64) (err error) {
+	if l.f,err = os.OpenFile(filepath.Join(l.dir,segName(first)),os.O_WRONLY|os.O_CREATE|os.O_APPEND,0666); err!=nil { return }
+	l.count = 0
+	return syncDir(l.dir)
+}
+
+// Syncs the directory, thus a new segment survives a crash.
+func syncDir(dir string) error {
+	d,err := os.Open(dir)
+	if err!=nil { return err }
+	defer d.Close()
+	return d.Sync()
+}
+
+// Opens the last segment, dropping a torn last line.
+func (l *Log) recover(first uint64) (err error) {
+	f,err := os.OpenFile(filepath.Join(l.dir,segName(first)),os.O_RDWR,0666)
+	if err!=nil { return }
+	var good int64
+	l.next = first
+	rd := bufio.NewReader(f)
+	for {
+		line,rerr := rd.ReadBytes('\n')
+		var e Event
+		if rerr!=nil || json.Unmarshal(line,&e)!=nil { break }
+		good += int64(len(line))
+		l.count++
+		l.next = e.Seq+1
+	}
+	if err = f.Truncate(good); err==nil { _,err = f.Seek(good,io.SeekStart) }
+	if err!=nil {
+		f.Close()
+		return
+	}
+	l.f = f
+	return
+}
+
+// Starts a new segment and removes the oldest ones. The caller holds l.mu.
+func (l *Log) rotate() (err error) {
+	l.f.Close()
+	if err = l.openSegment(l.next); err!=nil { return }
+	segs,_ := segments(l.dir)
+	for len(segs)>l.opts.KeepSegments {
+		os.Remove(filepath.Join(l.dir,segName(segs[0])))
+		segs = segs[1:]
+	}
+	return
+}
+
+var ErrClosed = errors.New("events: the log is closed")
+
+/*
+Assigns the sequence number and time of e, and appends it to the Log. If the
+event can't be written, it is dropped, and neither published nor numbered.
+*/
+func (l *Log) Emit(e Event) (err error) {
+	l.mu.Lock(); defer l.mu.Unlock()
+	if l.closed { return ErrClosed }
+	e.Seq = l.next
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if l.count>=l.opts.SegmentSize {
+		if err = l.rotate(); err!=nil { return }
+	}
+	st,err := l.f.Stat()
+	if err!=nil { return }
+	data,_ := json.Marshal(e)
+	if _,err = l.f.Write(append(data,'\n')); err==nil { err = l.f.Sync() }
+	if err!=nil {
+		// A torn line would hide the following events.
+		l.f.Truncate(st.Size())
+		return
+	}
+	l.count++
+	l.next++
+	
+	if len(l.ring)==ringSize { l.ring = append(l.ring[:0],l.ring[1:]...) }
+	l.ring = append(l.ring,e)
+	close(l.wake)
+	l.wake = make(chan struct{})
+	return
+}
+
+// The sequence number of the last event, 0 if there is none.
+func (l *Log) Last() uint64 {
+	l.mu.Lock(); defer l.mu.Unlock()
+	return l.next-1
+}
+
+// Returns a channel, that is closed, once there are events after cursor.
+func (l *Log) Wait(cursor uint64) <-chan struct{} {
+	l.mu.Lock(); defer l.mu.Unlock()
+	if l.next-1>cursor { return closedChan }
+	return l.wake
+}
+
+var closedChan = make(chan struct{})
+func init() { close(closedChan) }
+
+// Closed by Stop.
+func (l *Log) Done() <-chan struct{} { return l.done }
+
+// Stops the webhooks and the followers, that select on Done. Events are still
+// recorded, until the Log is closed.
+func (l *Log) Stop() {
+	l.stop.Do(func(){ close(l.done) })
+	l.hooks.Wait()
+}
+
+func match(e *Event,prefix string) bool { return strings.HasPrefix(e.Object,prefix) }
+
+/*
+Returns up to max events after cursor, whose object names start with prefix.
+next is the cursor to continue from, which can be larger than the last event
+returned, if events didn't match.
+
+Events, that were removed with their segment, are skipped.
+*/
+func (l *Log) Read(cursor uint64,prefix string,max int) (evs []Event,next uint64,err error) {
+	l.mu.Lock()
+	next = cursor
+	if len(l.ring)!=0 && l.ring[0].Seq<=cursor+1 {
+		for i := range l.ring {
+			e := &l.ring[i]
+			if e.Seq<=cursor { continue }
+			if len(evs)==max { break }
+			if match(e,prefix) { evs = append(evs,*e) }
+			next = e.Seq
+		}
+		l.mu.Unlock()
+		return
+	}
+	last := l.next-1
+	l.mu.Unlock()
+	if last<=cursor { return }
+	return l.readSegments(cursor,last,prefix,max)
+}
+
+func (l *Log) readSegments(cursor,last uint64,prefix string,max int) (evs []Event,next uint64,err error) {
+	next = cursor
+	segs,err := segments(l.dir)
+	if err!=nil { return }
+	
+	// Skip the segments, that end before the cursor.
+	i := sort.Search(len(segs),func(i int) bool { return segs[i]>cursor+1 })
+	if i>0 { i-- }
+	for ; i<len(segs); i++ {
+		f,oerr := os.Open(filepath.Join(l.dir,segName(segs[i])))
+		if oerr!=nil { continue } // Removed in between.
+		rd := bufio.NewReader(f)
+		for {
+			line,rerr := rd.ReadBytes('\n')
+			var e Event
+			if rerr!=nil || json.Unmarshal(line,&e)!=nil { break }
+			if e.Seq<=cursor { continue }
+			if len(evs)==max || e.Seq>last { f.Close(); return }
+			if match(&e,prefix) { evs = append(evs,e) }
+			next = e.Seq
+		}
+		f.Close()
+	}
+	return
+}
+
+// Stops the Log, see Stop, and closes it.
+func (l *Log) Close() (err error) {
+	l.Stop()
+	l.mu.Lock(); defer l.mu.Unlock()
+	if l.closed { return }
+	l.closed = true
+	close(l.wake)
+	l.wake = closedChan
+	return l.f.Close()
+}
+
+///
@@ -0,0 +1,237 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package events
+
+import (
+	"os"
+	"sync"
+	"time"
+	"strconv"
+	"testing"
+	"net/http"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"io"
+	"net/http/httptest"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func testLog(t *testing.T,dir string) *Log {
+	t.Helper()
+	l,err := Open(dir,Options{SegmentSize:3})
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func emitN(l *Log,n int) {
+	for i := 0; i<n; i++ { l.Emit(Event{Type:Created,Object:"o"+strconv.Itoa(i)}) }
+}
+
+// Events are read from the segments, once the ring doesn't cover the cursor.
+func TestLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	l,err := Open(dir,Options{SegmentSize:3})
+	if err!=nil { t.Fatal(err) }
+	emitN(l,7)
+	l.Close()
+	
+	// A torn last line is dropped.
+	segs,_ := segments(dir)
+	f,err := os.OpenFile(filepath.Join(dir,segName(segs[len(segs)-1])),os.O_WRONLY|os.O_APPEND,0)
+	if err!=nil { t.Fatal(err) }
+	f.Write([]byte(`{"seq":8,"ty`))
+	f.Close()
+	
+	l = testLog(t,dir)
+	if last := l.Last(); last!=7 { t.Fatalf("last = %d",last) }
+	l.Emit(Event{Type:Deleted,Object:"o0"})
+	evs,next,err := l.Read(2,"",100)
+	if err!=nil { t.Fatal(err) }
+	if len(evs)!=6 || next!=8 || evs[0].Seq!=3 || evs[5].Type!=Deleted { t.Fatalf("events = %+v, next %d",evs,next) }
+	
+	evs,next,err = l.Read(0,"o1",100)
+	if err!=nil { t.Fatal(err) }
+	if len(evs)!=1 || evs[0].Object!="o1" || next!=8 { t.Fatalf("events with prefix = %+v, next %d",evs,next) }
+}
+
+// An event, that can't be written, is neither numbered nor published, and fails the mutation.
+func TestEmitFailed(t *testing.T) {
+	l := testLog(t,t.TempDir())
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	s := Wrap(svc,l)
+	emitN(l,2)
+	
+	l.mu.Lock()
+	f := l.f
+	f.Close()
+	l.mu.Unlock()
+	if err = s.PutObj([]byte("a"),[]byte("data")); err==nil { t.Fatal("the mutation succeeded") }
+	if last := l.Last(); last!=2 { t.Fatalf("last = %d",last) }
+	select {
+	case <-l.Wait(2): t.Fatal("the event was published")
+	default:
+	}
+	
+	// The next segment takes the sequence number.
+	l.mu.Lock()
+	l.count = l.opts.SegmentSize
+	l.mu.Unlock()
+	if err = l.Emit(Event{Type:Deleted,Object:"a"}); err!=nil { t.Fatal(err) }
+	evs,_,err := l.Read(2,"",100)
+	if err!=nil || len(evs)!=1 || evs[0].Seq!=3 || evs[0].Type!=Deleted { t.Fatalf("events = %+v, %v",evs,err) }
+}
+
+// The events of an object are in the order of it's mutations.
+func TestStoreOrder(t *testing.T) {
+	l := testLog(t,t.TempDir())
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	s := Wrap(svc,l)
+	defer s.Close()
+	var wg sync.WaitGroup
+	for i := 0; i<8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j<20; j++ { s.Append([]byte("a"),[]byte("xy")) }
+		}()
+	}
+	wg.Wait()
+	evs,_,err := l.Read(0,"",1000)
+	if err!=nil { t.Fatal(err) }
+	if len(evs)!=160 { t.Fatalf("%d events",len(evs)) }
+	for i,e := range evs {
+		if e.Type!=Appended || e.Range[0]!=int64(2*i) { t.Fatalf("event %d = %+v",i,e) }
+	}
+}
+
+func TestStoreEvents(t *testing.T) {
+	l := testLog(t,t.TempDir())
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	s := Wrap(svc,l)
+	defer s.Close()
+	s.PutObj([]byte("a"),[]byte("abc"))
+	s.Append([]byte("a"),[]byte("de"))
+	if err = s.PutObj([]byte("a"),nil); err!=single.EExist { t.Fatalf("put of an existing object: %v",err) }
+	s.DeleteObj([]byte("a"))
+	evs,_,err := l.Read(0,"",100)
+	if err!=nil { t.Fatal(err) }
+	var types []string
+	for _,e := range evs { types = append(types,e.Type) }
+	if len(evs)!=3 || types[0]!=Created || types[1]!=Appended || types[2]!=Deleted || evs[1].Range[0]!=3 || evs[1].Range[1]!=2 {
+		t.Fatalf("events = %+v",evs)
+	}
+}
+
+// Records the webhook requests. The first fail requests are answered with 500.
+type receiver struct{
+	mu   sync.Mutex
+	fail int
+	evs  []Event
+	sigs []string
+	got  chan struct{}
+}
+func (rc *receiver) ServeHTTP(w http.ResponseWriter,r *http.Request) {
+	body,_ := io.ReadAll(r.Body)
+	rc.mu.Lock(); defer rc.mu.Unlock()
+	if rc.fail>0 {
+		rc.fail--
+		w.WriteHeader(500)
+		return
+	}
+	var evs []Event
+	json.Unmarshal(body,&evs)
+	rc.evs = append(rc.evs,evs...)
+	mac := hmac.New(sha256.New,[]byte("secret"))
+	mac.Write(body)
+	if r.Header.Get("X-Hblobstore-Signature")!="sha256="+hex.EncodeToString(mac.Sum(nil)) { rc.sigs = append(rc.sigs,"bad") }
+	select {
+	case rc.got <- struct{}{}:
+	default:
+	}
+}
+
+// Waits, until the receiver has n events.
+func (rc *receiver) wait(t *testing.T,n int) []Event {
+	t.Helper()
+	deadline := time.After(10*time.Second)
+	for {
+		rc.mu.Lock()
+		evs := append([]Event(nil),rc.evs...)
+		rc.mu.Unlock()
+		if len(evs)>=n { return evs }
+		select {
+		case <-rc.got:
+		case <-deadline: t.Fatalf("%d of %d events delivered",len(evs),n)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	rc := &receiver{fail:1,got:make(chan struct{},1)}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	
+	dir := t.TempDir()
+	l,err := Open(dir,Options{})
+	if err!=nil { t.Fatal(err) }
+	l.Emit(Event{Type:Created,Object:"before"})
+	hook := Webhook{URL:srv.URL,Secret:"secret",Prefix:"app/"}
+	l.Deliver([]Webhook{hook})
+	
+	// A new webhook starts with the events from now on, and gets those with the prefix only.
+	l.Emit(Event{Type:Created,Object:"app/a"})
+	l.Emit(Event{Type:Created,Object:"other"})
+	l.Emit(Event{Type:Appended,Object:"app/a",Range:[]int64{0,5}})
+	evs := rc.wait(t,2)
+	if len(evs)!=2 || evs[0].Object!="app/a" || evs[1].Type!=Appended || evs[1].Seq!=4 { t.Fatalf("events = %+v",evs) }
+	if len(rc.sigs)!=0 { t.Fatal("bad signature") }
+	
+	// Delivery resumes after the persisted cursor.
+	for i := 0; ; i++ {
+		cursor,_ := loadCursor(l.cursorPath(&hook))
+		if cursor==4 { break }
+		if i==1000 { t.Fatalf("cursor = %d",cursor) }
+		time.Sleep(10*time.Millisecond)
+	}
+	l.Close()
+	l,err = Open(dir,Options{})
+	if err!=nil { t.Fatal(err) }
+	defer l.Close()
+	l.Emit(Event{Type:Deleted,Object:"app/a"})
+	l.Deliver([]Webhook{hook})
+	evs = rc.wait(t,3)
+	if len(evs)!=3 || evs[2].Type!=Deleted || evs[2].Seq!=5 { t.Fatalf("events after reopening = %+v",evs) }
+}
+
+///
@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package events
+
+import (
+	"io"
+	"hash/fnv"
+	
+	"unsafe"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+Emits an event into a Log for every successful mutation of a store. There are
+no events for multipart uploads, until they are complete, and for changes of
+the retention.
+
+Each mutation holds the lock of it's object, shared by all Stores of the Log,
+until the event is emitted, thus the events of an object are in the order of
+it's mutations. ReplaceObj and CompleteUpload, that stream their content, only
+take it to emit their event, which consumers handle by copying the whole
+object, regardless of the order. The event is synced, before the mutation
+returns, but a crash in between loses it. If the event can't be written, the
+mutation fails with the error, although it was performed.
+*/
+type Store struct{
+	svc single.ObjectSvc
+	l   *Log
+}
+
+func Wrap(svc single.ObjectSvc,l *Log) *Store {
+	return &Store{svc:svc,l:l}
+}
+
+func (s *Store) Unwrap() single.ObjectSvc { return s.svc }
+
+// Locks the object. Returns the unlock function.
+func (s *Store) lock(objectId []byte) func() {
+	h := fnv.New32a()
+	h.Write(objectId)
+	m := &s.l.locks[h.Sum32()%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
+
+func (s *Store) emit(typ string,objectId []byte,ver single.Version,rang []int64) error {
+	e := Event{Type:typ,Object:string(objectId),Range:rang}
+	if ver!=0 { e.Version = ver.String() }
+	return s.l.Emit(e)
+}
+
+func (s *Store) PutObj(objectId []byte,data []byte) (err error) {
+	defer s.lock(objectId)()
+	if err = s.svc.PutObj(objectId,data); err==nil { err = s.emit(Created,objectId,0,[]int64{0,int64(len(data))}) }
+	return
+}
+func (s *Store) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	defer s.lock(objectId)()
+	if pos,err = s.svc.Append(objectId,data); err==nil { err = s.emit(Appended,objectId,0,[]int64{pos[0],pos[1]}) }
+	return
+}
+func (s *Store) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return s.svc.ReadObj(objectId,pos,ops,dst)
+}
+func (s *Store) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	return single.ReadTo(s.svc,objectId,pos,sink)
+}
+func (s *Store) DeleteObj(objectId []byte) (err error) {
+	defer s.lock(objectId)()
+	if err = s.svc.DeleteObj(objectId); err==nil { err = s.emit(Deleted,objectId,0,nil) }
+	return
+}
+func (s *Store) Info(objectId []byte) (lng int64,err error) {
+	return s.svc.Info(objectId)
+}
+
+func (s *Store) PutVersion(objectId []byte,data []byte) (ver single.Version,err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	defer s.lock(objectId)()
+	if ver,err = vs.PutVersion(objectId,data); err==nil { err = s.emit(Created,objectId,ver,[]int64{0,int64(len(data))}) }
+	return
+}
+func (s *Store) ReplaceObj(objectId []byte,r io.Reader) (ver single.Version,err error) {
+	rs,ok := s.svc.(single.ReplaceSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	if ver,err = rs.ReplaceObj(objectId,r); err==nil {
+		unlock := s.lock(objectId)
+		err = s.emit(Created,objectId,ver,nil)
+		unlock()
+	}
+	return
+}
+func (s *Store) ReadVersion(objectId []byte,ver single.Version,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	return vs.ReadVersion(objectId,ver,pos,ops,dst)
+}
+func (s *Store) ReadVersionTo(objectId []byte,ver single.Version,pos single.ByteRange,sink single.Sink) (err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	return single.ReadVersionTo(vs,objectId,ver,pos,sink)
+}
+func (s *Store) InfoVersion(objectId []byte,ver single.Version) (lng int64,err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	return vs.InfoVersion(objectId,ver)
+}
+func (s *Store) ListVersions(objectId []byte) (vers []single.VersionInfo,err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	return vs.ListVersions(objectId)
+}
+func (s *Store) DeleteVersion(objectId []byte,ver single.Version) (err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.lock(objectId)()
+	if err = vs.DeleteVersion(objectId,ver); err==nil { err = s.emit(Deleted,objectId,ver,nil) }
+	return
+}
+
+func (s *Store) GetRetention(objectId []byte) (ret single.Retention,err error) {
+	rs,ok := s.svc.(single.RetentionSvc)
+	if !ok { return ret,single.EOpNotSupp }
+	return rs.GetRetention(objectId)
+}
+func (s *Store) SetRetention(objectId []byte,ret single.Retention) (err error) {
+	rs,ok := s.svc.(single.RetentionSvc)
+	if !ok { return single.EOpNotSupp }
+	return rs.SetRetention(objectId,ret)
+}
+
+func (s *Store) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	ls,ok := s.svc.(single.ListSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	return ls.ListObjs(prefix,after,limit)
+}
+
+func (s *Store) Stat(objectId []byte) (st single.ObjectStat,err error) {
+	ss,ok := s.svc.(single.StatSvc)
+	if !ok { return st,single.EOpNotSupp }
+	return ss.Stat(objectId)
+}
+func (s *Store) Checksum(objectId []byte) (lng int64,crc uint32,ok bool,err error) {
+	cs,isCs := s.svc.(single.ChecksumSvc)
+	if !isCs { return 0,0,false,single.EOpNotSupp }
+	return cs.Checksum(objectId)
+}
+
+func (s *Store) CreateUpload(objectId []byte) (uploadId string,err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return "",single.EOpNotSupp }
+	return us.CreateUpload(objectId)
+}
+func (s *Store) UploadPart(objectId []byte,uploadId string,part int,data []byte) (err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return single.EOpNotSupp }
+	return us.UploadPart(objectId,uploadId,part,data)
+}
+func (s *Store) ListParts(objectId []byte,uploadId string) (parts []single.PartInfo,err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	return us.ListParts(objectId,uploadId)
+}
+func (s *Store) CompleteUpload(objectId []byte,uploadId string,parts []int) (ver single.Version,err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	if ver,err = us.CompleteUpload(objectId,uploadId,parts); err==nil {
+		unlock := s.lock(objectId)
+		err = s.emit(Created,objectId,ver,nil)
+		unlock()
+	}
+	return
+}
+func (s *Store) AbortUpload(objectId []byte,uploadId string) (err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return single.EOpNotSupp }
+	return us.AbortUpload(objectId,uploadId)
+}
+
+func (s *Store) Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error) {
+	fs,ok := s.svc.(single.FollowSvc)
+	if !ok { return single.EOpNotSupp }
+	return fs.Follow(objectId,off,done,fn)
+}
+
+// Closes the wrapped store, if it is an io.Closer. The Log is closed separately.
+func (s *Store) Close() error {
+	if c,ok := s.svc.(io.Closer); ok { return c.Close() }
+	return nil
+}
+
+///
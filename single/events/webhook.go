@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package events
+
+import (
+	"os"
+	"log"
+	"time"
+	"bytes"
+	"context"
+	"strconv"
+	"net/http"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+// Events per request.
+const hookBatch = 100
+
+type Webhook struct{
+	URL string `json:"url"`
+	
+	// If set, requests carry the header X-Hblobstore-Signature, the hex
+	// encoded HMAC-SHA256 of the body, prefixed by "sha256=".
+	Secret string `json:"secret"`
+	
+	// Only events of objects with this prefix are delivered.
+	Prefix string `json:"prefix"`
+}
+
+// The cursor file of a webhook.
+func (l *Log) cursorPath(h *Webhook) string {
+	sum := sha256.Sum256([]byte(h.URL+"\x00"+h.Prefix))
+	return filepath.Join(l.dir,"hook-"+hex.EncodeToString(sum[:8])+".cursor")
+}
+
+func loadCursor(pth string) (cursor uint64,ok bool) {
+	data,err := os.ReadFile(pth)
+	if err!=nil { return }
+	cursor,err = strconv.ParseUint(string(bytes.TrimSpace(data)),10,64)
+	return cursor,err==nil
+}
+func storeCursor(pth string,cursor uint64) error {
+	tmp := pth+".tmp"
+	if err := os.WriteFile(tmp,[]byte(strconv.FormatUint(cursor,10)+"\n"),0666); err!=nil { return err }
+	return os.Rename(tmp,pth)
+}
+
+/*
+Delivers the events to the webhooks, until the Log is closed. Events are
+POSTed as JSON array, in order, and retried with increasing delays until the
+endpoint answers with a 2xx status. The progress of every webhook is
+persisted, so delivery resumes after a restart. A new webhook starts with the
+events, that are emitted from now on.
+*/
+func (l *Log) Deliver(hooks []Webhook) {
+	for i := range hooks {
+		pth := l.cursorPath(&hooks[i])
+		cursor,ok := loadCursor(pth)
+		if !ok {
+			cursor = l.Last()
+			storeCursor(pth,cursor)
+		}
+		l.hooks.Add(1)
+		go l.deliver(hooks[i],pth,cursor)
+	}
+}
+
+func (l *Log) deliver(h Webhook,pth string,cursor uint64) {
+	defer l.hooks.Done()
+	ctx,cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func(){
+		<-l.done
+		cancel()
+	}()
+	
+	client := &http.Client{Timeout:30*time.Second}
+	delay := time.Second
+	for {
+		select {
+		case <-l.done: return
+		default:
+		}
+		evs,next,err := l.Read(cursor,h.Prefix,hookBatch)
+		if err==nil && len(evs)!=0 { err = post(ctx,client,&h,evs) }
+		if err!=nil {
+			log.Println("events:",h.URL+":",err)
+			select {
+			case <-l.done: return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay>5*time.Minute { delay = 5*time.Minute }
+			continue
+		}
+		delay = time.Second
+		if next!=cursor {
+			cursor = next
+			if err = storeCursor(pth,cursor); err!=nil { log.Println("events:",err) }
+		}
+		if len(evs)!=0 { continue }
+		select {
+		case <-l.done: return
+		case <-l.Wait(cursor):
+		}
+	}
+}
+
+type statusError int
+func (e statusError) Error() string { return "status "+strconv.Itoa(int(e)) }
+
+func post(ctx context.Context,client *http.Client,h *Webhook,evs []Event) error {
+	body,_ := json.Marshal(evs)
+	req,err := http.NewRequestWithContext(ctx,"POST",h.URL,bytes.NewReader(body))
+	if err!=nil { return err }
+	req.Header.Set("Content-Type","application/json")
+	if h.Secret!="" {
+		mac := hmac.New(sha256.New,[]byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Hblobstore-Signature","sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp,err := client.Do(req)
+	if err!=nil { return err }
+	resp.Body.Close()
+	if resp.StatusCode<200 || resp.StatusCode>=300 { return statusError(resp.StatusCode) }
+	return nil
+}
+
+///
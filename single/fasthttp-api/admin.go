@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package fhapi
+
+import (
+	"strings"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/admin"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/metrics"
+	
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+)
+
+type apiAdmin struct{
+	adm *admin.Admin
+	a   *auth.Auth
+}
+
+func (h *apiAdmin) healthz(ctx *fasthttp.RequestCtx) {
+	ctx.SetBodyString("ok\n")
+}
+func (h *apiAdmin) readyz(ctx *fasthttp.RequestCtx) {
+	reasons := h.adm.Ready()
+	if len(reasons)==0 {
+		ctx.SetBodyString("ok\n")
+		return
+	}
+	ctx.SetBodyString(strings.Join(reasons,"\n")+"\n")
+	ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+}
+
+// Requires an admin, see auth.Auth.Admin.
+func (h *apiAdmin) checked(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id,err := h.a.Admin(authReq{ctx})
+		if err==nil { err = id.CheckPayload(ctx.Request.Body()) }
+		if err!=nil {
+			setError(err,ctx,false)
+			return
+		}
+		ctx.SetUserValue(auth.PrincipalKey,id.Principal)
+		handler(ctx)
+	}
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx,v interface{},err error) {
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	data,_ := json.Marshal(v)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+func (h *apiAdmin) status(ctx *fasthttp.RequestCtx) {
+	st,err := h.adm.Status()
+	writeJSON(ctx,st,err)
+}
+func (h *apiAdmin) handles(ctx *fasthttp.RequestCtx) {
+	hs,err := h.adm.Handles()
+	writeJSON(ctx,hs,err)
+}
+
+// Expects the body true or false.
+func (h *apiAdmin) putReadOnly(ctx *fasthttp.RequestCtx) {
+	var ro bool
+	if json.Unmarshal(ctx.Request.Body(),&ro)!=nil {
+		setError(single.EInvalid,ctx,false)
+		return
+	}
+	h.adm.SetReadOnly(ro)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (h *apiAdmin) getSnapshots(ctx *fasthttp.RequestCtx) {
+	infos,err := h.adm.Snapshots()
+	writeJSON(ctx,infos,err)
+}
+
+// Creates a snapshot, named by the query argument "name", if any.
+func (h *apiAdmin) postSnapshot(ctx *fasthttp.RequestCtx) {
+	info,err := h.adm.CreateSnapshot(string(ctx.QueryArgs().Peek("name")))
+	if err!=nil {
+		setError(err,ctx,false)
+		return
+	}
+	writeJSON(ctx,info,nil)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+}
+func (h *apiAdmin) deleteSnapshot(ctx *fasthttp.RequestCtx) {
+	if err := h.adm.DeleteSnapshot(string(ctx.QueryArgs().Peek("name"))); err!=nil {
+		setError(err,ctx,false)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// Streams the snapshot "name" as tar archive, like "GET /export".
+func (h *apiAdmin) exportSnapshot(ctx *fasthttp.RequestCtx) {
+	svc,err := h.adm.OpenSnapshot(string(ctx.QueryArgs().Peek("name")))
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	(&apiExport{svc:svc}).getExport(ctx)
+}
+
+func (h *apiAdmin) replication(ctx *fasthttp.RequestCtx) {
+	st,err := h.adm.Replication()
+	writeJSON(ctx,st,err)
+}
+func (h *apiAdmin) promote(ctx *fasthttp.RequestCtx) {
+	st,err := h.adm.Promote()
+	writeJSON(ctx,st,err)
+}
+
+/*
+Registers "/healthz", "/readyz" and the admin API under "/admin/". The health
+and readiness checks are public, the admin API requires a principal, that is
+listed as admin in a.
+*/
+func RegisterAdmin(adm *admin.Admin, router *fhr.Router, a *auth.Auth) {
+	h := &apiAdmin{adm,a}
+	router.Handle("GET","/healthz",h.healthz)
+	router.Handle("GET","/readyz",h.readyz)
+	router.Handle("GET","/admin/status",h.checked(h.status))
+	router.Handle("GET","/admin/handles",h.checked(h.handles))
+	router.Handle("PUT","/admin/read_only",h.checked(h.putReadOnly))
+	router.Handle("GET","/admin/snapshots",h.checked(h.getSnapshots))
+	router.Handle("POST","/admin/snapshots",h.checked(h.postSnapshot))
+	router.Handle("DELETE","/admin/snapshots",h.checked(h.deleteSnapshot))
+	router.Handle("GET","/admin/snapshots/export",h.checked(h.exportSnapshot))
+	router.Handle("GET","/admin/replication",h.checked(h.replication))
+	router.Handle("POST","/admin/promote",h.checked(h.promote))
+}
+
+/*
+Registers "GET /metrics". With authentication, it requires an admin, like the
+admin API, since the metrics reveal the activity of the whole store. Without,
+it is public, thus it can be scraped locally.
+*/
+func RegisterMetrics(m *metrics.Metrics, router *fhr.Router, a *auth.Auth) {
+	h := &apiAdmin{a:a}
+	checked := h.checked(m.Serve)
+	router.Handle("GET","/metrics",func(ctx *fasthttp.RequestCtx) {
+		if a.Enabled() {
+			checked(ctx)
+		} else {
+			m.Serve(ctx)
+		}
+	})
+}
+
+///
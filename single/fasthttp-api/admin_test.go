@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package fhapi_test
+
+import (
+	"io"
+	"testing"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/admin"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/guard"
+	"github.com/byte-mug/hblobstore/single/metrics"
+	sfhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+)
+
+var testAuth = &auth.Config{
+	Tokens: map[string]string{"ops-token":"ops","app-token":"app"},
+	Policy: auth.Policy{"app":{{Prefix:"",Perms:auth.PermRead|auth.PermWrite}}},
+	Admins: []string{"ops"},
+}
+
+// Serves the object, admin and metrics API of a guarded store. A store with
+// less than minFree available bytes is full.
+func testRouter(t *testing.T,a *auth.Auth,minFree uint64) fasthttp.RequestHandler {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		if c,ok := svc.(io.Closer); ok { c.Close() }
+	})
+	g := guard.New(svc)
+	router := fhr.New()
+	sfhapi.RegisterObjectSvcAuth(g,router,a)
+	sfhapi.RegisterAdmin(admin.New(g,minFree),router,a)
+	sfhapi.RegisterMetrics(metrics.New(),router,a)
+	return router.Handler
+}
+
+// Performs a request with the bearer token tok, if any, and returns the status and body.
+func do(h fasthttp.RequestHandler,method,uri,tok,body string) (int,string) {
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBodyString(body)
+	if tok!="" { ctx.Request.Header.Set("Authorization","Bearer "+tok) }
+	h(ctx)
+	return ctx.Response.StatusCode(),string(ctx.Response.Body())
+}
+
+func TestReadyz(t *testing.T) {
+	h := testRouter(t,auth.New(testAuth),0)
+	for _,path := range []string{"/healthz","/readyz"} {
+		if code,body := do(h,"GET",path,"",""); code!=200 || body!="ok\n" { t.Fatalf("%s: %d %q",path,code,body) }
+	}
+	if code,_ := do(h,"PUT","/o/a","app-token","x"); code>=300 { t.Fatalf("put: %d",code) }
+	
+	if code,body := do(h,"PUT","/admin/read_only","ops-token","true"); code!=204 { t.Fatalf("read_only: %d %s",code,body) }
+	if code,body := do(h,"GET","/readyz","",""); code!=503 || body!=admin.NotReadyReadOnly+"\n" { t.Fatalf("readyz of a read only store: %d %q",code,body) }
+	if code,_ := do(h,"GET","/healthz","",""); code!=200 { t.Fatalf("healthz of a read only store: %d",code) }
+	if code,_ := do(h,"PUT","/o/b","app-token","y"); code<400 { t.Fatalf("put to a read only store: %d",code) }
+	if code,body := do(h,"GET","/o/a","app-token",""); code!=200 || body!="x" { t.Fatalf("read of a read only store: %d %q",code,body) }
+	
+	code,body := do(h,"GET","/admin/status","ops-token","")
+	var st admin.Status
+	if code!=200 || json.Unmarshal([]byte(body),&st)!=nil { t.Fatalf("status: %d %s",code,body) }
+	if !st.ReadOnly || len(st.NotReady)!=1 || st.NotReady[0]!=admin.NotReadyReadOnly { t.Fatalf("status: %+v",st) }
+	
+	if code,_ := do(h,"PUT","/admin/read_only","ops-token","yes"); code!=400 { t.Fatalf("read_only with a bad body: %d",code) }
+	if code,_ := do(h,"PUT","/admin/read_only","ops-token","false"); code!=204 { t.Fatalf("read_only: %d",code) }
+	if code,body := do(h,"GET","/readyz","",""); code!=200 || body!="ok\n" { t.Fatalf("readyz: %d %q",code,body) }
+	if code,_ := do(h,"PUT","/o/b","app-token","y"); code>=300 { t.Fatalf("put: %d",code) }
+	
+	// No disk has that much space.
+	h = testRouter(t,auth.New(testAuth),1<<62)
+	if code,body := do(h,"GET","/readyz","",""); code!=503 || body!=admin.NotReadyDiskFull+"\n" { t.Fatalf("readyz of a full disk: %d %q",code,body) }
+}
+
+func TestAdminAuth(t *testing.T) {
+	h := testRouter(t,auth.New(testAuth),0)
+	reqs := []struct{
+		method,path,body string
+	}{
+		{"GET","/admin/status",""},
+		{"GET","/admin/handles",""},
+		{"PUT","/admin/read_only","false"},
+		{"GET","/admin/snapshots",""},
+		{"GET","/admin/replication",""},
+		{"POST","/admin/promote",""},
+		{"GET","/metrics",""},
+	}
+	for _,r := range reqs {
+		for tok,want := range map[string]int{"":401,"bad-token":401,"app-token":403} {
+			if code,body := do(h,r.method,r.path,tok,r.body); code!=want { t.Errorf("%s %s with %q: %d %s, want %d",r.method,r.path,tok,code,body,want) }
+		}
+		code,body := do(h,r.method,r.path,"ops-token",r.body)
+		switch r.path {
+		case "/admin/replication","/admin/promote":
+			// The store isn't a replica.
+			if code!=404 { t.Errorf("%s %s: %d %s",r.method,r.path,code,body) }
+		default:
+			if code>=300 { t.Errorf("%s %s: %d %s",r.method,r.path,code,body) }
+		}
+	}
+	
+	// Without authentication, the admin API is disabled, while the metrics are public.
+	h = testRouter(t,nil,0)
+	for _,r := range reqs {
+		code,body := do(h,r.method,r.path,"",r.body)
+		if r.path=="/metrics" {
+			if code!=200 { t.Errorf("metrics without authentication: %d %s",code,body) }
+		} else if code!=401 {
+			t.Errorf("%s %s without authentication: %d %s",r.method,r.path,code,body)
+		}
+	}
+	if code,_ := do(h,"GET","/readyz","",""); code!=200 { t.Errorf("readyz without authentication: %d",code) }
+}
+
+///
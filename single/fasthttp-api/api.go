@@ -21,34 +21,66 @@ SOFTWARE.
 */
 
 
+
 package fhapi
 
 import (
-	"unsafe"
 	"io"
+	"os"
+	"bufio"
 	
 	"github.com/valyala/fasthttp"
 	"github.com/byte-mug/hblobstore/single"
 )
 
-func fchCast(p unsafe.Pointer) *fasthttp.RequestCtx {
-	return (*fasthttp.RequestCtx)(p)
-}
-func asPtr(p *fasthttp.RequestCtx) unsafe.Pointer {
-	return (unsafe.Pointer)(p)
+/*
+Wraps the *fasthttp.RequestCtx as single.Sink. It is a single pointer, so
+boxing it into an interface does not allocate.
+*/
+type sink struct{
+	*fasthttp.RequestCtx
 }
 
-func fhcSetBody(p unsafe.Pointer,data []byte) {
-	fchCast(p).SetBody(data)
-}
-func fhcGetBodyBuffer(p unsafe.Pointer) io.Writer {
-	return fchCast(p)
+// Returns ctx as single.Sink, that uses sendfile(2) for large reads.
+func Sink(ctx *fasthttp.RequestCtx) single.Sink {
+	return sink{ctx}
 }
 
-var ops = single.RdOps{
-	SetBody: fhcSetBody,
-	GetBodyBuffer: fhcGetBodyBuffer,
+// Streams the file range after the handler returned, see fileStream.
+func (s sink) SendFile(f *os.File,off,n int64,done func()) error {
+	if _,err := f.Seek(off,io.SeekStart); err!=nil {
+		f.Close()
+		done()
+		return err
+	}
+	s.SetBodyStream(&fileStream{io.LimitedReader{R:f,N:n},f,done},int(n))
+	return nil
 }
 
+/*
+A body stream, that is closed by fasthttp, once the response has been
+written.
+
+Fasthttp only uses sendfile(2) for plain *os.File or *io.LimitedReader
+streams, which it never closes, so we write the body on our own.
+*/
+type fileStream struct{
+	io.LimitedReader
+	f    *os.File
+	done func()
+}
+func (s *fileStream) SupportsBodyWriteTo() bool { return true }
+func (s *fileStream) WriteTo(w io.Writer) (int64,error) {
+	// The buffer must be empty to trigger sendfile in bufio.Writer.ReadFrom.
+	if bw,ok := w.(*bufio.Writer); ok {
+		if err := bw.Flush(); err!=nil { return 0,err }
+	}
+	return io.Copy(w,&s.LimitedReader)
+}
+func (s *fileStream) Close() error {
+	err := s.f.Close()
+	if s.done!=nil { s.done(); s.done = nil }
+	return err
+}
 
 ///
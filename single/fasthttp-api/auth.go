@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package fhapi
+
+import (
+	"crypto/x509"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// Exposes a *fasthttp.RequestCtx as auth.Request.
+type authReq struct{
+	ctx *fasthttp.RequestCtx
+}
+func (r authReq) Method() string { return string(r.ctx.Method()) }
+func (r authReq) Path() string { return string(r.ctx.Path()) }
+func (r authReq) RawQuery() string { return string(r.ctx.URI().QueryString()) }
+func (r authReq) Header(name string) string { return string(r.ctx.Request.Header.Peek(name)) }
+func (r authReq) VerifiedChains() [][]*x509.Certificate {
+	st := r.ctx.TLSConnectionState()
+	if st==nil { return nil }
+	return st.VerifiedChains
+}
+
+// Wraps handler, so that requests are checked by a first.
+func checked(a *auth.Auth,op proto.Op,handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if a==nil { return handler }
+	return func(ctx *fasthttp.RequestCtx) {
+		var id auth.Identity
+		var err error
+		switch op {
+		case proto.OpList:
+			id,err = a.Check(authReq{ctx},op,string(ctx.QueryArgs().Peek("prefix")))
+		case proto.OpBatch:
+			// The operations are authorized by the handler.
+			id,err = a.Authenticate(authReq{ctx})
+		default:
+			id,err = a.Check(authReq{ctx},op,string(ctx.UserValue("object").([]byte)))
+		}
+		if err==nil { err = id.CheckPayload(ctx.Request.Body()) }
+		if err!=nil {
+			setError(err,ctx,false)
+			return
+		}
+		if id.Principal!="" { ctx.SetUserValue(auth.PrincipalKey,id.Principal) }
+		handler(ctx)
+	}
+}
+
+///
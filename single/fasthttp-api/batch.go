@@ -0,0 +1,57 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package fhapi
+
+import (
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// Executes the batch in the body, see proto.BatchOp, authorizing every operation.
+func(h *apiOL) postBatch(ctx *fasthttp.RequestCtx) {
+	var bops []proto.BatchOp
+	if json.Unmarshal(ctx.Request.Body(),&bops)!=nil || len(bops)>proto.MaxBatch {
+		setError(single.EInvalid,ctx,false)
+		return
+	}
+	var authorize func(op proto.Op,object string) error
+	if h.a!=nil {
+		id := &auth.Identity{}
+		id.Principal,_ = ctx.UserValue(auth.PrincipalKey).(string)
+		authorize = func(op proto.Op,object string) error { return h.a.Authorize(id,op,object) }
+	}
+	res := h.b.Run(bops,authorize)
+	if res==nil { res = []proto.BatchResult{} }
+	data,_ := json.Marshal(res)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+///
@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package fhapi
+
+import (
+	"bufio"
+	"bytes"
+	"time"
+	"strconv"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/events"
+	"github.com/byte-mug/hblobstore/single/proto"
+	
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+)
+
+// Limits of the event endpoint.
+const (
+	eventBatch   = 1000
+	maxPollWait  = 60*time.Second
+	streamLength = 5*time.Minute
+	keepAlive    = 15*time.Second
+)
+
+type apiEvents struct{
+	l *events.Log
+}
+
+type eventPage struct{
+	Cursor uint64         `json:"cursor"`
+	Events []events.Event `json:"events"`
+}
+
+// The cursor is taken from the query argument "after", or the header Last-Event-ID.
+func eventCursor(ctx *fasthttp.RequestCtx) (cursor uint64,err error) {
+	arg := ctx.QueryArgs().Peek("after")
+	if arg==nil { arg = ctx.Request.Header.Peek("Last-Event-ID") }
+	if len(arg)==0 { return }
+	if cursor,err = strconv.ParseUint(string(arg),10,64); err!=nil { err = single.EInvalid }
+	return
+}
+
+func (h *apiEvents) getEvents(ctx *fasthttp.RequestCtx) {
+	cursor,err := eventCursor(ctx)
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	prefix := string(ctx.QueryArgs().Peek("prefix"))
+	if bytes.Contains(ctx.Request.Header.Peek("Accept"),[]byte("text/event-stream")) {
+		h.stream(ctx,cursor,prefix)
+		return
+	}
+	
+	// Long-poll, waiting up to "wait" seconds for events.
+	wait,_ := strconv.ParseUint(string(ctx.QueryArgs().Peek("wait")),10,64)
+	timeout := time.Duration(wait)*time.Second
+	if timeout>maxPollWait { timeout = maxPollWait }
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	page := eventPage{Cursor:cursor}
+	for {
+		page.Events,page.Cursor,err = h.l.Read(page.Cursor,prefix,eventBatch)
+		if err!=nil || len(page.Events)!=0 || !h.wait(page.Cursor,deadline.C) { break }
+	}
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	if page.Events==nil { page.Events = []events.Event{} }
+	data,_ := json.Marshal(page)
+	ctx.Response.Header.Set("X-Last-Event",strconv.FormatUint(h.l.Last(),10))
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// Waits for events after cursor. Returns false on timeout, or if the Log is closed.
+func (h *apiEvents) wait(cursor uint64,timeout <-chan time.Time) bool {
+	select {
+	case <-h.l.Done(): return false
+	case <-timeout: return false
+	case <-h.l.Wait(cursor):
+		select {
+		case <-h.l.Done(): return false
+		default: return true
+		}
+	}
+}
+
+/*
+Streams the events as server-sent events, with the sequence number as id.
+The stream ends after streamLength, or the write_timeout of the server, and
+clients reconnect with Last-Event-ID.
+*/
+func (h *apiEvents) stream(ctx *fasthttp.RequestCtx,cursor uint64,prefix string) {
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control","no-cache")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		end := time.NewTimer(streamLength)
+		defer end.Stop()
+		for {
+			evs,next,err := h.l.Read(cursor,prefix,eventBatch)
+			if err!=nil { return }
+			cursor = next
+			for i := range evs {
+				data,_ := json.Marshal(&evs[i])
+				w.WriteString("id: "+strconv.FormatUint(evs[i].Seq,10)+"\nevent: "+evs[i].Type+"\ndata: ")
+				w.Write(data)
+				w.WriteString("\n\n")
+			}
+			if w.Flush()!=nil { return }
+			if len(evs)!=0 { continue }
+			select {
+			case <-h.l.Done(): return
+			case <-end.C: return
+			case <-time.After(keepAlive):
+				w.WriteString(": keep-alive\n\n")
+			case <-h.l.Wait(cursor):
+			}
+		}
+	})
+}
+
+/*
+Registers "GET /events", that returns the events after a cursor. Requests need
+the permission to list the query argument "prefix", which filters the events.
+*/
+func RegisterEvents(l *events.Log, router *fhr.Router, a *auth.Auth) {
+	h := &apiEvents{l}
+	router.Handle("GET","/events",checked(a,proto.OpList,h.getEvents))
+}
+
+///
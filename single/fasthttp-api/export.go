@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package fhapi
+
+import (
+	"bufio"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/archive"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/proto"
+	
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+)
+
+type apiExport struct{
+	svc single.ObjectSvc
+	a   *auth.Auth
+}
+
+// Streams the archive. The query argument "versions=1" includes all versions.
+func (h *apiExport) getExport(ctx *fasthttp.RequestCtx) {
+	opts := archive.ExportOptions{
+		Prefix: string(ctx.QueryArgs().Peek("prefix")),
+		Versions: string(ctx.QueryArgs().Peek("versions"))=="1",
+	}
+	if h.a!=nil {
+		id := &auth.Identity{}
+		id.Principal,_ = ctx.UserValue(auth.PrincipalKey).(string)
+		if err := h.a.Authorize(id,proto.OpRead,opts.Prefix); err!=nil {
+			setError(err,ctx,true)
+			return
+		}
+	}
+	if single.AsListSvc(h.svc)==nil {
+		setError(single.EOpNotSupp,ctx,true)
+		return
+	}
+	ctx.SetContentType("application/x-tar")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Errors truncate the archive, which import detects.
+		archive.Export(h.svc,w,opts)
+	})
+}
+
+/*
+Registers "GET /export", that streams the objects with the query argument
+"prefix" as tar archive, see single/archive. Requests need the permissions to
+list and read the prefix.
+*/
+func RegisterExport(svc single.ObjectSvc, router *fhr.Router, a *auth.Auth) {
+	h := &apiExport{svc,a}
+	router.Handle("GET","/export",checked(a,proto.OpList,h.getExport))
+}
+
+///
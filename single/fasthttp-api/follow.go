@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package fhapi
+
+import (
+	"sync"
+	"bufio"
+	"time"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+	"github.com/byte-mug/hblobstore/util/bconv"
+)
+
+// Streams the object from X-Offset, following appends, see proto.MaxFollow, or until h.stop is closed.
+func(h *apiOL) followObject(ctx *fasthttp.RequestCtx,arg []byte) {
+	d,err := proto.ParseFollow(string(arg))
+	switch {
+	case err!=nil:
+	case h.fs==nil: err = single.EOpNotSupp
+	case len(ctx.QueryArgs().Peek("version"))!=0: err = single.EInvalid
+	}
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	obj := append([]byte(nil),ctx.UserValue("object").([]byte)...)
+	off,_ := bconv.ParseUint64(ctx.Request.Header.Peek("X-Offset"))
+	if off<0 { off = 0 }
+	
+	// Fail early, while the status can be set.
+	if _,err = h.Info(obj); err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	ctx.Response.Header.AddBytesV("X-Offset",bconv.AppendUint64(make([]byte,0,10),off))
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		done := make(chan struct{})
+		var once sync.Once
+		end := func(){ once.Do(func(){ close(done) }) }
+		t := time.AfterFunc(d,end)
+		defer t.Stop()
+		defer end()
+		go func() {
+			select {
+			case <-h.stop: end()
+			case <-done:
+			}
+		}()
+		
+		// The stream just ends, once the object is deleted.
+		h.fs.Follow(obj,off,done,func(data []byte) (err error) {
+			if _,err = w.Write(data); err!=nil { return }
+			return w.Flush()
+		})
+	})
+}
+
+///
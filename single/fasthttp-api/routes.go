@@ -24,51 +24,75 @@ SOFTWARE.
 package fhapi
 
 import (
+	"encoding/json"
+	
 	"github.com/valyala/fasthttp"
 	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/batch"
+	"github.com/byte-mug/hblobstore/single/proto"
 	
 	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
 	"github.com/byte-mug/hblobstore/util/bconv"
 )
 
 func setError(err error,ctx *fasthttp.RequestCtx, isR bool) {
-	ctx.SetBodyString(err.Error())
-	switch single.BoilDownError(err) {
-	case nil:
-		ctx.Response.Header.Add("X-Error","nil_error")
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-	case single.EOpNotSupp:
-		ctx.SetStatusCode(fasthttp.StatusNotImplemented)
-	case single.EIsReadOnly:
-		ctx.Response.Header.Add("X-Reason","read_only")
-		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
-	case single.EServerAccessDenied:
-		ctx.Response.Header.Add("X-Error","access_denied")
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-	case single.EDiskFailure:
-		ctx.Response.Header.Add("X-Error","disk_failure")
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-	case single.EBeingDeleted:
-		if isR {
-			ctx.SetStatusCode(fasthttp.StatusNotFound)
-		} else {
-			ctx.Response.Header.Add("X-Error","being_deleted")
-			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-		}
-	case single.ENotFound:
-		ctx.SetStatusCode(fasthttp.StatusNotFound)
-	case single.EExist:
-		ctx.SetStatusCode(fasthttp.StatusConflict)
-	}
-	ctx.Response.Header.Add("X-Error","unknown_error")
-	ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	if err!=nil { ctx.SetBodyString(err.Error()) }
+	code,header,value := proto.StatusOf(err,isR)
+	if header!="" { ctx.Response.Header.Add(header,value) }
+	ctx.SetStatusCode(code)
 }
 
 type apiOL struct{
 	single.ObjectSvc
+	
+	// Non-nil, if the store is versioned.
+	vs single.VersionSvc
+	
+	// Non-nil, if the store supports retention.
+	rs single.RetentionSvc
+	
+	// Non-nil, if the store can be listed.
+	ls single.ListSvc
+	
+	// Non-nil, if the store supports multipart uploads.
+	us single.UploadSvc
+	
+	// Non-nil, if the store can follow objects.
+	fs single.FollowSvc
+	
+	// Ends the follow streams, once closed.
+	stop <-chan struct{}
+	
+	b *batch.Batch
+	a *auth.Auth
+}
+
+// Parses the optional "version" query argument.
+func queryVersion(ctx *fasthttp.RequestCtx) (ver single.Version,err error) {
+	if arg := ctx.QueryArgs().Peek("version"); len(arg)!=0 {
+		if ver,err = single.ParseVersion(arg); err!=nil { err = single.ENotFound }
+	}
+	return
+}
+
+func(h *apiOL) info(ctx *fasthttp.RequestCtx) (sz int64,err error) {
+	var ver single.Version
+	if ver,err = queryVersion(ctx); err!=nil { return }
+	if ver==0 { return h.Info(ctx.UserValue("object").([]byte)) }
+	if h.vs==nil { return 0,single.EOpNotSupp }
+	return h.vs.InfoVersion(ctx.UserValue("object").([]byte),ver)
+}
+func(h *apiOL) readObj(ctx *fasthttp.RequestCtx,rang single.ByteRange) (err error) {
+	var ver single.Version
+	if ver,err = queryVersion(ctx); err!=nil { return }
+	if ver==0 { return single.ReadTo(h.ObjectSvc,ctx.UserValue("object").([]byte),rang,sink{ctx}) }
+	if h.vs==nil { return single.EOpNotSupp }
+	return single.ReadVersionTo(h.vs,ctx.UserValue("object").([]byte),ver,rang,sink{ctx})
 }
+
 func(h *apiOL) headObject(ctx *fasthttp.RequestCtx) {
-	sz,err := h.Info(ctx.UserValue("object").([]byte))
+	sz,err := h.info(ctx)
 	if err!=nil {
 		setError(err,ctx,true)
 	} else {
@@ -78,12 +102,16 @@ func(h *apiOL) headObject(ctx *fasthttp.RequestCtx) {
 }
 
 func(h *apiOL) getObject(ctx *fasthttp.RequestCtx) {
+	if arg := ctx.QueryArgs().Peek("follow"); arg!=nil {
+		h.followObject(ctx,arg)
+		return
+	}
 	var rang single.ByteRange
 	rang[0],_ = bconv.ParseUint64(ctx.Request.Header.Peek("X-Offset"))
 	rang[1],_ = bconv.ParseUint64(ctx.Request.Header.Peek("X-Length"))
 	//
 	
-	err := h.ReadObj(ctx.UserValue("object").([]byte),rang,&ops,asPtr(ctx))
+	err := h.readObj(ctx,rang)
 	if err!=nil {
 		setError(err,ctx,true)
 	} else {
@@ -91,10 +119,17 @@ func(h *apiOL) getObject(ctx *fasthttp.RequestCtx) {
 	}
 }
 func(h *apiOL) putObject(ctx *fasthttp.RequestCtx) {
-	err := h.PutObj(ctx.UserValue("object").([]byte),ctx.Request.Body())
+	var ver single.Version
+	var err error
+	if h.vs!=nil {
+		ver,err = h.vs.PutVersion(ctx.UserValue("object").([]byte),ctx.Request.Body())
+	} else {
+		err = h.PutObj(ctx.UserValue("object").([]byte),ctx.Request.Body())
+	}
 	if err!=nil {
 		setError(err,ctx,false)
 	} else {
+		if ver!=0 { ctx.Response.Header.AddBytesV("X-Version",ver.AppendTo(make([]byte,0,16))) }
 		ctx.SetBodyString("")
 		ctx.SetStatusCode(fasthttp.StatusCreated)
 	}
@@ -112,7 +147,16 @@ func(h *apiOL) postObject(ctx *fasthttp.RequestCtx) {
 }
 
 func(h *apiOL) deleteObject(ctx *fasthttp.RequestCtx) {
-	err := h.DeleteObj(ctx.UserValue("object").([]byte))
+	ver,err := queryVersion(ctx)
+	switch {
+	case err!=nil:
+	case ver==0:
+		err = h.DeleteObj(ctx.UserValue("object").([]byte))
+	case h.vs==nil:
+		err = single.EOpNotSupp
+	default:
+		err = h.vs.DeleteVersion(ctx.UserValue("object").([]byte),ver)
+	}
 	if err!=nil {
 		setError(err,ctx,false)
 	} else {
@@ -120,13 +164,120 @@ func(h *apiOL) deleteObject(ctx *fasthttp.RequestCtx) {
 	}
 }
 
+func(h *apiOL) listVersions(ctx *fasthttp.RequestCtx) {
+	if h.vs==nil {
+		setError(single.EOpNotSupp,ctx,true)
+		return
+	}
+	vers,err := h.vs.ListVersions(ctx.UserValue("object").([]byte))
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	if vers==nil { vers = []single.VersionInfo{} }
+	data,_ := json.Marshal(vers)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+func(h *apiOL) headRetention(ctx *fasthttp.RequestCtx) {
+	if h.rs==nil {
+		setError(single.EOpNotSupp,ctx,true)
+		return
+	}
+	ret,err := h.rs.GetRetention(ctx.UserValue("object").([]byte))
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	ctx.Response.Header.AddBytesV("X-Retain-Until",bconv.AppendUint64(make([]byte,0,10),ret.Until))
+	if ret.LegalHold {
+		ctx.Response.Header.Add("X-Legal-Hold","1")
+	} else {
+		ctx.Response.Header.Add("X-Legal-Hold","0")
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+func(h *apiOL) putRetention(ctx *fasthttp.RequestCtx) {
+	if h.rs==nil {
+		setError(single.EOpNotSupp,ctx,false)
+		return
+	}
+	var ret single.Retention
+	ret.Until,_ = bconv.ParseUint64(ctx.Request.Header.Peek("X-Retain-Until"))
+	if ret.Until<0 { ret.Until = 0 }
+	ret.LegalHold = string(ctx.Request.Header.Peek("X-Legal-Hold"))=="1"
+	err := h.rs.SetRetention(ctx.UserValue("object").([]byte),ret)
+	if err!=nil {
+		setError(err,ctx,false)
+	} else {
+		ctx.SetBodyString("")
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}
+}
+
+// Lists the objects as JSON array of names. The query arguments "prefix",
+// "after" and "limit" are passed to ListObjs.
+func(h *apiOL) listObjects(ctx *fasthttp.RequestCtx) {
+	if h.ls==nil {
+		setError(single.EOpNotSupp,ctx,true)
+		return
+	}
+	args := ctx.QueryArgs()
+	limit,err := bconv.ParseUint64(args.Peek("limit"))
+	if err!=nil || limit<=0 || limit>1000 { limit = 1000 }
+	names,err := h.ls.ListObjs(args.Peek("prefix"),args.Peek("after"),int(limit))
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	strs := make([]string,len(names))
+	for i,name := range names { strs[i] = string(name) }
+	data,_ := json.Marshal(strs)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
 func RegisterObjectSvc(ol single.ObjectSvc, router *fhr.Router) {
-	h := &apiOL{ol}
-	router.Handle("OPTIONS","/o/:object",h.headObject  )
-	router.Handle("GET"    ,"/o/:object",h.getObject   )
-	router.Handle("PUT"    ,"/o/:object",h.putObject   )
-	router.Handle("POST"   ,"/o/:object",h.postObject  )
-	router.Handle("DELETE" ,"/o/:object",h.deleteObject)
+	RegisterObjectSvcAuth(ol,router,nil)
+}
+
+// Like RegisterObjectSvc, but requests are checked by a, if not nil.
+func RegisterObjectSvcAuth(ol single.ObjectSvc, router *fhr.Router, a *auth.Auth) {
+	RegisterObjectSvcStop(ol,router,a,nil)
+}
+
+// Like RegisterObjectSvcAuth, but the follow streams end, once stop is closed, like on shutdown.
+func RegisterObjectSvcStop(ol single.ObjectSvc, router *fhr.Router, a *auth.Auth, stop <-chan struct{}) {
+	h := &apiOL{ObjectSvc:ol,stop:stop}
+	h.vs = single.AsVersionSvc(ol)
+	h.rs = single.AsRetentionSvc(ol)
+	h.ls = single.AsListSvc(ol)
+	h.us = single.AsUploadSvc(ol)
+	h.fs = single.AsFollowSvc(ol)
+	h.b = batch.New(ol,batch.DefaultWorkers)
+	h.a = a
+	handlers := [...]fasthttp.RequestHandler{
+		proto.OpInfo        : h.headObject,
+		proto.OpRead        : h.getObject,
+		proto.OpPut         : h.putObject,
+		proto.OpAppend      : h.postObject,
+		proto.OpDelete      : h.deleteObject,
+		proto.OpListVersions: h.listVersions,
+		proto.OpGetRetention: h.headRetention,
+		proto.OpSetRetention: h.putRetention,
+		proto.OpList        : h.listObjects,
+		proto.OpUpload      : h.postUpload,
+		proto.OpUploadPart  : h.putPart,
+		proto.OpListParts   : h.listParts,
+		proto.OpAbortUpload : h.abortUpload,
+		proto.OpBatch       : h.postBatch,
+	}
+	for _,r := range proto.Routes {
+		router.Handle(r.Method,r.Path,checked(a,r.Op,handlers[r.Op]))
+	}
 }
 
 ///
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package fhapi
+
+import (
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/util/bconv"
+)
+
+// Initiates an upload, returning it's id as X-Upload-Id. With the query
+// argument "upload", the upload is completed instead. The body optionally
+// holds a JSON array of the part numbers to assemble.
+func(h *apiOL) postUpload(ctx *fasthttp.RequestCtx) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,ctx,false)
+		return
+	}
+	obj := ctx.UserValue("object").([]byte)
+	uploadId := string(ctx.QueryArgs().Peek("upload"))
+	if uploadId=="" {
+		id,err := h.us.CreateUpload(obj)
+		if err!=nil {
+			setError(err,ctx,false)
+			return
+		}
+		ctx.Response.Header.Add("X-Upload-Id",id)
+		ctx.SetBodyString("")
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		return
+	}
+	var parts []int
+	if body := ctx.Request.Body(); len(body)!=0 && json.Unmarshal(body,&parts)!=nil {
+		setError(single.EInvalid,ctx,false)
+		return
+	}
+	ver,err := h.us.CompleteUpload(obj,uploadId,parts)
+	if err!=nil {
+		setError(err,ctx,false)
+		return
+	}
+	if ver!=0 { ctx.Response.Header.AddBytesV("X-Version",ver.AppendTo(make([]byte,0,16))) }
+	ctx.SetBodyString("")
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+}
+
+// Uploads the part given by the query arguments "upload" and "part".
+func(h *apiOL) putPart(ctx *fasthttp.RequestCtx) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,ctx,false)
+		return
+	}
+	args := ctx.QueryArgs()
+	part,err := bconv.ParseUint64(args.Peek("part"))
+	if err!=nil {
+		setError(single.EInvalid,ctx,false)
+		return
+	}
+	err = h.us.UploadPart(ctx.UserValue("object").([]byte),string(args.Peek("upload")),int(part),ctx.Request.Body())
+	if err!=nil {
+		setError(err,ctx,false)
+	} else {
+		ctx.SetBodyString("")
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+	}
+}
+
+func(h *apiOL) listParts(ctx *fasthttp.RequestCtx) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,ctx,true)
+		return
+	}
+	parts,err := h.us.ListParts(ctx.UserValue("object").([]byte),string(ctx.QueryArgs().Peek("upload")))
+	if err!=nil {
+		setError(err,ctx,true)
+		return
+	}
+	if parts==nil { parts = []single.PartInfo{} }
+	data,_ := json.Marshal(parts)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+func(h *apiOL) abortUpload(ctx *fasthttp.RequestCtx) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,ctx,false)
+		return
+	}
+	err := h.us.AbortUpload(ctx.UserValue("object").([]byte),string(ctx.QueryArgs().Peek("upload")))
+	if err!=nil {
+		setError(err,ctx,false)
+	} else {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}
+}
+
+///
@@ -27,7 +27,9 @@ import (
 	"sync"
 	"os"
 	"io"
+	"sort"
 	"path/filepath"
+	"time"
 	
 	"unsafe"
 	
@@ -53,25 +55,123 @@ type singleFile struct {
 	
 	// Append-Mutex
 	am sync.Mutex
+	
+	// Intent log, if any, and the file's path.
+	wl  *wal
+	pth string
+	
+	// Running checksum, see loadSum. sumdirty is set, until it's written by flushSum.
+	sum      uint32
+	sumok    bool
+	sums     bool
+	sumdirty bool
+	
+	// Set, once the file was replaced, see replace.go.
+	replaced bool
+}
+
+/*
+Writes buf at the end of the file. Must be called with s.am held.
+
+With an intent log, the data is synced, and the first write syncs the directory
+as well, thus a new file's entry is durable.
+*/
+func (s *singleFile) write(op byte,buf []byte) (w int,err error) {
+	if s.wl==nil { return s.f.WriteAt(buf,s.l) }
+	
+	var seq uint64
+	if seq,err = s.wl.intent(op,s.pth,s.l,buf); err!=nil { return }
+	crashPoint("intent",s.pth)
+	w,err = s.f.WriteAt(buf,s.l)
+	if err==nil { err = s.f.Sync() }
+	if err==nil && s.l==0 { err = syncDir(filepath.Dir(s.pth)) }
+	crashPoint("written",s.pth)
+	
+	// Leave the intent pending, unless the torn write could be undone.
+	if err!=nil && s.f.Truncate(s.l)!=nil { return }
+	s.wl.done(seq)
+	return
 }
 func (s *singleFile) Append(buf []byte) (pos single.ByteRange,err error) {
+	return s.appendFirst(buf,nil)
+}
+// Like Append, but calls first before writing to an empty file.
+func (s *singleFile) appendFirst(buf []byte,first func() error) (pos single.ByteRange,err error) {
+	return s.appendIf(buf,func() error {
+		if s.l==0 && first!=nil { return first() }
+		return nil
+	})
+}
+// Like Append, but calls check with s.am held before writing, and fails with it's error.
+func (s *singleFile) appendIf(buf []byte,check func() error) (pos single.ByteRange,err error) {
 	var w int
 	s.am.Lock(); defer s.am.Unlock()
-	w,err = s.f.WriteAt(buf,s.l)
+	if s.replaced { return pos,errReplaced }
+	if err = check(); err!=nil { return }
+	w,err = s.write(walAppend,buf)
 	pos[0] = s.l
 	pos[1] = int64(w)
-	if err==nil { s.l += int64(w) }
+	if err==nil { s.l += int64(w); s.updateSum(buf) }
 	return
 }
 func (s *singleFile) CreateContent(buf []byte) (err error) {
 	var w int
 	s.am.Lock(); defer s.am.Unlock()
-	if s.l!=0 { return single.EExist } // We lost the race!
-	w,err = s.f.WriteAt(buf,0)
-	if err==nil { s.l += int64(w) }
+	if s.l!=0 || s.replaced { return single.EExist } // We lost the race!
+	w,err = s.write(walCreate,buf)
+	if err==nil { s.l += int64(w); s.updateSum(buf) }
 	return
 }
 
+func (s *singleFile) copyTo(pos single.ByteRange, w io.Writer) (err error) {
+	off := pos.Begin64()
+	
+	lng,ok := pos.Length64()
+	if !ok { lng = s.length() }
+	
+	_,err = io.Copy(
+		w,
+		io.NewSectionReader(s.f,off,lng),
+	)
+	err = translate(err)
+	return
+}
+
+// Ranges below this size are copied, as opening a file is not worth it.
+const sendFileMin = 64<<10
+
+/*
+Reads the range into sink. If the sink is a single.FileSink, the file is
+handed over, and s remains borrowed until the sink is done with it.
+The caller must not call s.Done(), if handed is true.
+*/
+func (s *singleFile) readTo(pos single.ByteRange, sink single.Sink) (handed bool,err error) {
+	fsk,ok := sink.(single.FileSink)
+	if !ok { return false,s.copyTo(pos,sink) }
+	
+	off := pos.Begin64()
+	lng,ok := pos.Length64()
+	if l := s.length(); !ok || off+lng>l { lng = l-off }
+	if lng<sendFileMin { return false,s.copyTo(pos,sink) }
+	
+	// Open a private descriptor, as sendfile(2) uses the file offset.
+	f,err := os.Open(s.pth)
+	if err!=nil { return false,translate(err) }
+	return true,translate(fsk.SendFile(f,off,lng,s.Done))
+}
+
+func (s *singleFile) stat() (st single.ObjectStat,err error) {
+	fi,err := s.f.Stat()
+	if err!=nil { return st,translate(err) }
+	return single.ObjectStat{Length:s.length(),ModTime:fi.ModTime()},nil
+}
+
+// Returns the length of the content, which Appends change under s.am.
+func (s *singleFile) length() int64 {
+	s.am.Lock(); defer s.am.Unlock()
+	return s.l
+}
+
 func makSF(f *os.File) (*singleFile,error) {
 	s,err := f.Stat()
 	if err!=nil { return nil,err }
@@ -98,6 +198,36 @@ type multiFiles struct {
 	
 	// String-Pool
 	sp conc.Strpool
+	
+	// Retention cache and -lock, see retention.go.
+	rc sync.Map
+	rl sync.RWMutex
+	
+	// Default retention of new objects, except those, for which noret returns true.
+	defret time.Duration
+	noret  func(name []byte) bool
+	
+	// Intent log, if enabled.
+	wl *wal
+	
+	// Maintain checksums.
+	sums bool
+	
+	// Channels of the followers by object name, see follow.go.
+	wakes sync.Map
+	
+	// Held shared by mutations, and exclusively by snapshots, see snapshot.go.
+	ql sync.RWMutex
+	
+	// Expiry of multipart uploads, the time of the last sweep, the claimed
+	// upload ids and the callback for expired uploads.
+	uplexp     time.Duration
+	uplsweep   int64
+	uplclaims  sync.Map
+	uplexpired func(objectId []byte,uploadId string)
+	
+	// The object names, see list.go.
+	ix nameIndex
 }
 func (fs *multiFiles) path(name []byte) (pth string,alloced bool) {
 	if s,ok := fs.sp.Load(name); ok { return s,false }
@@ -125,6 +255,8 @@ func (fs *multiFiles) borrowFile(raw []byte,path istring,create int) (*singleFil
 		if err!=nil { return nil,translate(err) }
 		sf,err := makSF(f)
 		if err!=nil { f.Close(); return nil,translate(err) }
+		sf.wl,sf.pth = fs.wl,path.(string)
+		if fs.sums { sf.loadSum() }
 		
 		// Insert a new k-v-pair, and on conflict, return the existing value.
 		inst,ok = fs.fm.LoadOrStore(path,sf)
@@ -168,56 +300,239 @@ func (fs *multiFiles) deleteFile(path istring) (found bool,err error) {
 	if _,done := fs.fme.LoadOrStore(path,single.EBeingDeleted); done { return }
 	defer fs.fme.Delete(path)
 	found = fs.clearFile(path)
+	done,err := fs.logIntent(walDelete,path.(string),0,0)
+	if err!=nil { return }
+	defer done()
+	crashPoint("intent",path.(string))
 	err = translate(os.Remove(path.(string)))
+	if err==nil { os.Remove(path.(string)+sumExt) }
+	if err==nil && fs.wl!=nil { err = translate(syncDir(filepath.Dir(path.(string)))) }
+	crashPoint("written",path.(string))
+	return
+}
+
+/*
+Records an intent for a mutation of the file pth, that isn't written through a
+*singleFile, like the creation of a version. lng and crc describe the content,
+that the file will have. The returned function records the completion.
+*/
+func (fs *multiFiles) logIntent(op byte,pth string,lng int64,crc uint32) (done func(),err error) {
+	if fs.wl==nil { return func(){},nil }
+	seq,err := fs.wl.intentSum(op,pth,0,lng,crc)
+	if err!=nil { return nil,err }
+	return func(){ fs.wl.done(seq) },nil
+}
+// Like deleteFile, but moves the file to dst.
+func (fs *multiFiles) moveFile(path istring,dst string) (err error) {
+	if _,done := fs.fme.LoadOrStore(path,single.EBeingDeleted); done { return single.EBeingDeleted }
+	defer fs.fme.Delete(path)
+	fs.clearFile(path)
+	err = translate(os.Rename(path.(string),dst))
+	if err==nil { os.Remove(path.(string)+sumExt) }
 	return
 }
 func (fs *multiFiles) PutObj(objectId []byte,data []byte) (err error) {
+	fs.ql.RLock(); defer fs.ql.RUnlock()
+	fs.rl.RLock(); defer fs.rl.RUnlock()
 	var sf *singleFile
 	if sf,err = fs.hlBorrowFile(objectId,os.O_CREATE|os.O_EXCL); err!=nil { return }
 	defer sf.Done()
-	return sf.CreateContent(data)
+	fs.ix.add(objectId)
+	if err = fs.initRetention(objectId); err!=nil { return }
+	if err = sf.CreateContent(data); err!=nil { return }
+	fs.wake(objectId)
+	return
 }
 func (fs *multiFiles) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	fs.ql.RLock(); defer fs.ql.RUnlock()
+	fs.rl.RLock(); defer fs.rl.RUnlock()
+	if err = fs.checkRetained(objectId); err!=nil { return }
 	var sf *singleFile
-	if sf,err = fs.hlBorrowFile(objectId,os.O_CREATE); err!=nil { return }
-	defer sf.Done()
-	return sf.Append(data)
+	for {
+		if sf,err = fs.hlBorrowFile(objectId,os.O_CREATE); err!=nil { return }
+		fs.ix.add(objectId)
+		
+		// The first Append creates the object.
+		pos,err = sf.appendFirst(data,func() error { return fs.initRetention(objectId) })
+		sf.Done()
+		
+		// If the object was replaced meanwhile, append to the new file.
+		if err!=errReplaced { break }
+	}
+	if err!=nil { return }
+	fs.wake(objectId)
+	return
 }
 
 func (fs *multiFiles) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
 	var sf *singleFile
 	if sf,err = fs.hlBorrowFile(objectId,0); err!=nil { return }
 	defer sf.Done()
-	
-	off := pos.Begin64()
-	
-	lng,ok := pos.Length64()
-	if !ok { lng = sf.l }
-	
-	_,err = io.Copy(
-		ops.GetBodyBuffer(dst),
-		io.NewSectionReader(sf.f,off,lng),
-	)
-	err = translate(err)
+	return sf.copyTo(pos,ops.GetBodyBuffer(dst))
+}
+func (fs *multiFiles) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	var sf *singleFile
+	var handed bool
+	if sf,err = fs.hlBorrowFile(objectId,0); err!=nil { return }
+	if handed,err = sf.readTo(pos,sink); !handed { sf.Done() }
 	return
 }
+func (fs *multiFiles) Stat(objectId []byte) (st single.ObjectStat,err error) {
+	var sf *singleFile
+	if sf,err = fs.hlBorrowFile(objectId,0); err!=nil { return }
+	defer sf.Done()
+	return sf.stat()
+}
 
 func (fs *multiFiles) DeleteObj(objectId []byte) (err error) {
+	fs.ql.RLock(); defer fs.ql.RUnlock()
+	fs.rl.RLock(); defer fs.rl.RUnlock()
+	if err = fs.checkRetained(objectId); err!=nil { return }
 	path,_ := fs.path(objectId)
 	_,err = fs.deleteFile(path)
 	fs.sp.Delete(objectId)
+	if err==nil {
+		fs.ix.remove(objectId,path)
+		fs.clearRetention(objectId)
+	}
+	fs.wake(objectId)
 	return
 }
 func (fs *multiFiles) Info(objectId []byte) (lng int64,err error) {
 	var sf *singleFile
 	if sf,err = fs.hlBorrowFile(objectId,0); err!=nil { return }
 	defer sf.Done()
-	lng = sf.l
+	lng = sf.length()
+	return
+}
+
+// Closes all open files, waiting for their users. The store must not be used afterwards.
+func (fs *multiFiles) Close() (err error) {
+	fs.fm.Range(func(path,inst interface{}) bool {
+		if fs.clearFile(path) { inst.(*singleFile).flushSum() }
+		return true
+	})
+	if fs.wl!=nil { err = fs.wl.Close() }
+	return
+}
+
+// Returns the *multiFiles behind a store, unwrapping middlewares, or nil.
+func filesOf(svc single.ObjectSvc) *multiFiles {
+	switch v := single.Innermost(svc).(type) {
+	case *multiFiles: return v
+	case *versionFiles: return v.multiFiles
+	}
+	return nil
+}
+
+// Runtime statistics of a store, see StatsOf.
+type Stats struct{
+	// Files, that are open, see borrowFile.
+	OpenFiles int
+	
+	// Files, that are being deleted.
+	Deleting int
+	
+	// Object paths in the string pool.
+	PooledPaths int
+}
+
+// Returns the statistics of a store, as returned by ServeFile or ServeFileOpts.
+func StatsOf(svc single.ObjectSvc) (st Stats,err error) {
+	fs := filesOf(svc)
+	if fs==nil { return st,single.EOpNotSupp }
+	fs.fm.Range(func(_,_ interface{}) bool { st.OpenFiles++; return true })
+	fs.fme.Range(func(_,_ interface{}) bool { st.Deleting++; return true })
+	st.PooledPaths = fs.sp.Len()
+	return
+}
+
+// Returns the files, that are open and that are being deleted, relative to the data directory.
+func HandlesOf(svc single.ObjectSvc) (open,deleting []string,err error) {
+	fs := filesOf(svc)
+	if fs==nil { return nil,nil,single.EOpNotSupp }
+	rel := func(dst *[]string) func(k,_ interface{}) bool {
+		return func(k,_ interface{}) bool {
+			if r,rerr := filepath.Rel(fs.dir,k.(string)); rerr==nil { *dst = append(*dst,r) }
+			return true
+		}
+	}
+	fs.fm.Range(rel(&open))
+	fs.fme.Range(rel(&deleting))
+	sort.Strings(open)
+	sort.Strings(deleting)
 	return
 }
 
+// Checks, whether a file can be created and written in the data directory.
+func ProbeOf(svc single.ObjectSvc) (err error) {
+	fs := filesOf(svc)
+	if fs==nil { return single.EOpNotSupp }
+	f,err := os.CreateTemp(fs.dir,"probe-*.tmp")
+	if err!=nil { return translate(err) }
+	defer os.Remove(f.Name())
+	_,err = f.Write([]byte("probe"))
+	if err==nil { err = f.Sync() }
+	if e := f.Close(); err==nil { err = e }
+	return translate(err)
+}
+
+// The usage of the file system, that holds the data directory, in bytes.
+type Disk struct{
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+	
+	// Available to unprivileged users, like Free minus the reserved blocks.
+	Avail uint64 `json:"avail"`
+}
+
+// Returns the usage of the file system, that holds the store.
+func DiskOf(svc single.ObjectSvc) (d Disk,err error) {
+	fs := filesOf(svc)
+	if fs==nil { return d,single.EOpNotSupp }
+	return diskUsage(fs.dir)
+}
+
 func ServeFile(dir string) single.ObjectSvc {
 	return &multiFiles{dir:dir}
 }
 
+// Options for ServeFileOpts.
+type Options struct{
+	// Keep older versions of objects. The returned store implements single.VersionSvc.
+	Versioning bool
+	
+	// If positive, new objects are retained for this duration.
+	DefaultRetention time.Duration
+	
+	// If set, the objects, for which it returns true, are exempt from the DefaultRetention.
+	NoRetention func(objectId []byte) bool
+	
+	// Record mutations in an intent log, that is replayed on startup (see Recover).
+	WAL bool
+	
+	// If positive, the intent log is checkpointed in this interval.
+	CheckpointInterval time.Duration
+	
+	// Maintain a checksum of each object, that is verified by Fsck and Scrub.
+	Checksums bool
+	
+	// Multipart uploads, that weren't modified for this duration, are
+	// removed. Defaults to a day.
+	UploadExpiry time.Duration
+	
+	// If set, called for every upload, that expired and was removed.
+	UploadExpired func(objectId []byte,uploadId string)
+}
+
+func ServeFileOpts(dir string,opts Options) (svc single.ObjectSvc,err error) {
+	fs := &multiFiles{dir:dir,defret:opts.DefaultRetention,noret:opts.NoRetention,sums:opts.Checksums,uplexp:opts.UploadExpiry,uplexpired:opts.UploadExpired}
+	if opts.WAL {
+		if fs.wl,err = openWal(dir); err!=nil { return }
+		if opts.CheckpointInterval>0 { go fs.wl.checkpoints(opts.CheckpointInterval,fs.flushSums) }
+	}
+	if opts.Versioning { return &versionFiles{multiFiles:fs},nil }
+	return fs,nil
+}
+
 ///
@@ -0,0 +1,112 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package files
+
+import (
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// The size of the reads of Follow.
+const followChunk = 256<<10
+
+/*
+Followers wait on a channel per object name, that is closed and removed by the
+next mutation of the object. Followers register before they look at the
+object, thus they can't miss a wake up.
+*/
+func (fs *multiFiles) wakeChan(name []byte) chan struct{} {
+	v,_ := fs.wakes.LoadOrStore(string(name),make(chan struct{}))
+	return v.(chan struct{})
+}
+func (fs *multiFiles) wake(name []byte) {
+	if v,ok := fs.wakes.LoadAndDelete(string(name)); ok { close(v.(chan struct{})) }
+}
+
+// Reads up to len(buf) bytes at off, but not beyond the end of the file.
+func (s *singleFile) readAt(buf []byte,off int64) (n int,err error) {
+	lng := s.length()-off
+	if lng<=0 { return }
+	if lng<int64(len(buf)) { buf = buf[:lng] }
+	n,err = s.f.ReadAt(buf,off)
+	return n,translate(err)
+}
+
+/*
+The loop of Follow. borrow returns the file of the object, which must be the
+same on every call, as another one means, that the object was replaced.
+*/
+func (fs *multiFiles) follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error,borrow func() (*singleFile,error)) (err error) {
+	var first *singleFile
+	var buf []byte
+	for {
+		ch := fs.wakeChan(objectId)
+		var sf *singleFile
+		if sf,err = borrow(); err!=nil {
+			if err==single.EBeingDeleted { err = single.ENotFound }
+			return
+		}
+		if first==nil { first = sf }
+		if sf!=first {
+			sf.Done()
+			return single.ENotFound
+		}
+		if buf==nil { buf = make([]byte,followChunk) }
+		var n int
+		n,err = sf.readAt(buf,off)
+		sf.Done()
+		if err!=nil { return }
+		if n>0 {
+			off += int64(n)
+			if err = fn(buf[:n]); err!=nil { return }
+			continue
+		}
+		select {
+		case <-ch:
+		case <-done: return nil
+		}
+	}
+}
+
+func (fs *multiFiles) Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error) {
+	return fs.follow(objectId,off,done,fn,func() (*singleFile,error) {
+		return fs.hlBorrowFile(objectId,0)
+	})
+}
+
+// Follows the latest version. A new version, or a delete marker, ends it.
+func (vf *versionFiles) Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error) {
+	dir := vf.vdir(objectId)
+	var ver single.Version
+	return vf.follow(objectId,off,done,fn,func() (*singleFile,error) {
+		vi,err := latestVersion(dir)
+		if err!=nil { return nil,err }
+		if vi.Deleted || (ver!=0 && vi.Version!=ver) { return nil,single.ENotFound }
+		ver = vi.Version
+		return vf.borrowFile(objectId,vfile(dir,ver,verData),0)
+	})
+}
+
+///
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"sync"
+	"time"
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// Follows the object in the background, collecting the data.
+type follower struct{
+	mu   sync.Mutex
+	data []byte
+	done chan struct{}
+	err  chan error
+}
+func follow(svc single.ObjectSvc,name string,off int64) *follower {
+	f := &follower{done:make(chan struct{}),err:make(chan error,1)}
+	go func() {
+		f.err <- single.AsFollowSvc(svc).Follow([]byte(name),off,f.done,func(data []byte) error {
+			f.mu.Lock(); defer f.mu.Unlock()
+			f.data = append(f.data,data...)
+			return nil
+		})
+	}()
+	return f
+}
+
+// Waits, until the follower got want.
+func (f *follower) wait(t *testing.T,want string) {
+	t.Helper()
+	for i := 0; i<1000; i++ {
+		f.mu.Lock()
+		got := string(f.data)
+		f.mu.Unlock()
+		if got==want { return }
+		time.Sleep(5*time.Millisecond)
+	}
+	t.Fatalf("followed %q, want %q",string(f.data),want)
+}
+
+// Waits for the end of Follow.
+func (f *follower) end(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-f.err: return err
+	case <-time.After(5*time.Second): t.Fatal("follow didn't end")
+	}
+	return nil
+}
+
+func TestFollow(t *testing.T) {
+	svc := testStore(t,Options{})
+	svc.PutObj([]byte("a"),[]byte("abc"))
+	f := follow(svc,"a",1)
+	f.wait(t,"bc")
+	svc.Append([]byte("a"),[]byte("de"))
+	f.wait(t,"bcde")
+	svc.Append([]byte("a"),[]byte("f"))
+	f.wait(t,"bcdef")
+	close(f.done)
+	if err := f.end(t); err!=nil { t.Fatal(err) }
+}
+
+// Deleting or replacing the object ends the follower.
+func TestFollowEnds(t *testing.T) {
+	svc := testStore(t,Options{})
+	svc.PutObj([]byte("a"),[]byte("abc"))
+	f := follow(svc,"a",0)
+	f.wait(t,"abc")
+	svc.DeleteObj([]byte("a"))
+	if err := f.end(t); err!=single.ENotFound { t.Fatalf("deleted: %v",err) }
+	
+	svc.PutObj([]byte("a"),[]byte("abc"))
+	f = follow(svc,"a",0)
+	f.wait(t,"abc")
+	replace(t,svc,"a","new")
+	if err := f.end(t); err!=single.ENotFound { t.Fatalf("replaced: %v",err) }
+	
+	if err := follow(svc,"missing",0).end(t); err!=single.ENotFound { t.Fatalf("missing: %v",err) }
+}
+
+// A new version ends the follower of the latest one.
+func TestFollowVersioned(t *testing.T) {
+	svc := testStore(t,Options{Versioning:true})
+	vs := single.AsVersionSvc(svc)
+	vs.PutVersion([]byte("a"),[]byte("abc"))
+	f := follow(svc,"a",0)
+	f.wait(t,"abc")
+	svc.Append([]byte("a"),[]byte("d"))
+	f.wait(t,"abcd")
+	vs.PutVersion([]byte("a"),[]byte("new"))
+	if err := f.end(t); err!=single.ENotFound { t.Fatalf("new version: %v",err) }
+}
+
+///
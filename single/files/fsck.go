@@ -0,0 +1,312 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"io"
+	"time"
+	"strings"
+	"hash/crc32"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// Objects are moved into this directory, when quarantined.
+const quarantineDir = "quarantine"
+
+// Kinds of problems, found by Fsck and Scrub.
+const (
+	PTemp          = "temp"           // A stray temporary file.
+	PUndecodable   = "undecodable"    // A file, that is not part of the naming scheme.
+	PEmpty         = "empty"          // A zero-length object without checksum, likely a failed PutObj.
+	PCorrupt       = "corrupt"        // The content doesn't match it's checksum.
+	PStaleChecksum = "stale_checksum" // The checksum is longer than the content, or doesn't match the prefix, that it covers.
+	POrphan        = "orphan"         // A sidecar file without object.
+	PIntentLog     = "intent_log"     // The intent log has not been replayed.
+	PIOError       = "io_error"       // The file could not be read.
+)
+
+type FsckOptions struct{
+	// Remove temporary files and orphaned sidecars, and replay the intent log.
+	Repair bool
+	
+	// Move corrupt and empty objects into the directory "quarantine".
+	Quarantine bool
+	
+	// Limits the rate at which content is read, in bytes per second.
+	Rate int64
+	
+	// Temporary, empty and orphaned files younger than this are ignored.
+	// Scrub defaults to one hour, since such files might be in use.
+	MinAge time.Duration
+}
+
+type Problem struct{
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+type Report struct{
+	Objects  int64     `json:"objects"`
+	Bytes    int64     `json:"bytes"`
+	Verified int64     `json:"verified"`
+	Problems []Problem `json:"problems"`
+}
+
+type throttle struct{
+	rate  int64
+	start time.Time
+	n     int64
+}
+func (t *throttle) wait(n int) {
+	if t.rate<=0 { return }
+	if t.n==0 { t.start = time.Now() }
+	t.n += int64(n)
+	if d := time.Duration(t.n*int64(time.Second)/t.rate)-time.Since(t.start); d>0 { time.Sleep(d) }
+}
+
+type throttledReader struct{
+	r io.Reader
+	t *throttle
+}
+func (r throttledReader) Read(p []byte) (n int,err error) {
+	if len(p)>1<<20 { p = p[:1<<20] }
+	n,err = r.r.Read(p)
+	r.t.wait(n)
+	return
+}
+
+type checker struct{
+	dir  string
+	opts FsckOptions
+	rep  Report
+	thr  throttle
+	now  time.Time
+	
+	// The running store, nil if offline.
+	fs *multiFiles
+}
+
+func (c *checker) problem(pth,kind,detail,action string) {
+	rel,err := filepath.Rel(c.dir,pth)
+	if err!=nil { rel = pth }
+	c.rep.Problems = append(c.rep.Problems,Problem{Path:rel,Kind:kind,Detail:detail,Action:action})
+}
+func (c *checker) old(st os.FileInfo) bool {
+	return c.now.Sub(st.ModTime())>=c.opts.MinAge
+}
+func (c *checker) exists(pth string) bool {
+	_,err := os.Lstat(pth)
+	return err==nil
+}
+
+func (c *checker) remove(pth string) string {
+	if !c.opts.Repair { return "" }
+	if err := os.Remove(pth); err!=nil { return "remove failed: "+err.Error() }
+	return "removed"
+}
+func (c *checker) quarantine(pth string) string {
+	if !c.opts.Quarantine { return "" }
+	rel,_ := filepath.Rel(c.dir,pth)
+	qdir := filepath.Join(c.dir,quarantineDir)
+	dst := filepath.Join(qdir,strings.Replace(rel,string(filepath.Separator),"_",-1))
+	if err := os.MkdirAll(qdir,0777); err!=nil { return "quarantine failed: "+err.Error() }
+	
+	var err error
+	if c.fs!=nil {
+		err = c.fs.moveFile(pth,dst)
+	} else if err = os.Rename(pth,dst); err==nil {
+		os.Remove(pth+sumExt)
+	}
+	if err!=nil { return "quarantine failed: "+err.Error() }
+	return "quarantined"
+}
+
+// Returns the checksum of the first lng bytes of f.
+func (c *checker) crc(f io.ReaderAt,lng int64) (uint32,error) {
+	h := crc32.NewIEEE()
+	_,err := io.Copy(h,throttledReader{io.NewSectionReader(f,0,lng),&c.thr})
+	return h.Sum32(),err
+}
+
+func (c *checker) checkObject(pth string,st os.FileInfo) {
+	c.rep.Objects++
+	c.rep.Bytes += st.Size()
+	
+	var lng int64
+	var sum uint32
+	var err error
+	var f io.ReaderAt
+	var fst os.FileInfo
+	var open bool
+	if c.fs!=nil { _,open = c.fs.fm.Load(pth) }
+	if open {
+		// The store has the file open already, so it's checksum is at hand.
+		var sf *singleFile
+		if sf,err = c.fs.borrowFile(nil,pth,0); err!=nil { return } // Deleted in the meantime.
+		defer sf.Done()
+		var ok bool
+		if lng,sum,ok = sf.snapSum(); !ok { err = os.ErrNotExist }
+		f = sf.f
+	} else {
+		// Otherwise, the file is opened directly, rather than borrowed, as the
+		// store keeps borrowed files open.
+		fl,ferr := os.Open(pth)
+		if os.IsNotExist(ferr) && c.fs!=nil { return }
+		if ferr!=nil { c.problem(pth,PIOError,ferr.Error(),""); return }
+		defer fl.Close()
+		if fst,err = fl.Stat(); err!=nil { c.problem(pth,PIOError,err.Error(),""); return }
+		lng,sum,err = readSum(pth)
+		f = fl
+	}
+	if os.IsNotExist(err) {
+		if st.Size()==0 && c.old(st) { c.problem(pth,PEmpty,"",c.quarantine(pth)) }
+		return
+	}
+	
+	// The sidecar is written lazily, thus it may cover a prefix of the content only.
+	if err!=nil || (fst!=nil && lng>fst.Size()) {
+		c.problem(pth+sumExt,PStaleChecksum,"",c.remove(pth+sumExt))
+		return
+	}
+	act,err := c.crc(f,lng)
+	if err!=nil { c.problem(pth,PIOError,err.Error(),""); return }
+	if act!=sum && fst!=nil && c.fs!=nil && !c.same(pth,fst) { return } // Replaced in the meantime.
+	
+	// A prefix, that doesn't match, may be left by a crash during a replacement.
+	if act!=sum && fst!=nil && lng<fst.Size() {
+		c.problem(pth+sumExt,PStaleChecksum,"",c.remove(pth+sumExt))
+		return
+	}
+	c.rep.Verified++
+	if act!=sum { c.problem(pth,PCorrupt,"checksum mismatch",c.quarantine(pth)) }
+}
+
+// Whether pth still refers to the file st.
+func (c *checker) same(pth string,st os.FileInfo) bool {
+	cur,err := os.Stat(pth)
+	return err==nil && os.SameFile(cur,st)
+}
+
+func (c *checker) checkFile(pth string,st os.FileInfo,orphan bool) {
+	switch {
+	case strings.HasSuffix(pth,".tmp"):
+		if c.old(st) { c.problem(pth,PTemp,"",c.remove(pth)) }
+	case orphan:
+		if c.old(st) { c.problem(pth,POrphan,"",c.remove(pth)) }
+	}
+}
+
+func (c *checker) checkVersions(dir string) {
+	ents,err := os.ReadDir(dir)
+	if err!=nil { c.problem(dir,PIOError,err.Error(),""); return }
+	for _,ent := range ents {
+		name := ent.Name()
+		pth := filepath.Join(dir,name)
+		st,err := ent.Info()
+		if err!=nil { continue }
+		ext := filepath.Ext(name)
+		_,verr := single.ParseVersion([]byte(strings.TrimSuffix(name,ext)))
+		switch {
+		case ext==".tmp":
+			c.checkFile(pth,st,false)
+		case ext==sumExt:
+			c.checkFile(pth,st,!c.exists(strings.TrimSuffix(pth,sumExt)))
+		case verr==nil && ext==verData && st.Mode().IsRegular():
+			c.checkObject(pth,st)
+		case verr==nil && ext==verMark:
+		default:
+			c.problem(pth,PUndecodable,"",c.quarantine(pth))
+		}
+	}
+}
+
+func (c *checker) run() {
+	if c.fs==nil {
+		recs,err := readWal(filepath.Join(c.dir,walName))
+		if err!=nil {
+			c.problem(filepath.Join(c.dir,walName),PIOError,err.Error(),"")
+		} else if len(recs)>0 {
+			act := ""
+			if c.opts.Repair {
+				act = "replayed"
+				if err = Recover(c.dir); err!=nil { act = "replay failed: "+err.Error() }
+			}
+			c.problem(filepath.Join(c.dir,walName),PIntentLog,"",act)
+		}
+	}
+	
+	ents,err := os.ReadDir(c.dir)
+	if err!=nil { c.problem(c.dir,PIOError,err.Error(),""); return }
+	for _,ent := range ents {
+		name := ent.Name()
+		pth := filepath.Join(c.dir,name)
+		st,err := ent.Info()
+		if err!=nil { continue }
+		switch {
+		case name==walName,name==quarantineDir,name==uplDir,name==snapDir:
+		case strings.HasSuffix(name,".tmp"):
+			c.checkFile(pth,st,false)
+		case strings.HasPrefix(name,"obj-") && strings.HasSuffix(name,".bin"+sumExt):
+			c.checkFile(pth,st,!c.exists(strings.TrimSuffix(pth,sumExt)))
+		case strings.HasPrefix(name,"obj-") && strings.HasSuffix(name,".bin") && len(name)>8 && st.Mode().IsRegular():
+			c.checkObject(pth,st)
+		case strings.HasPrefix(name,"ret-") && len(name)>4:
+			obj := name[4:]
+			c.checkFile(pth,st,!c.exists(filepath.Join(c.dir,"obj-"+obj+".bin")) && !c.exists(filepath.Join(c.dir,"ver-"+obj)))
+		case strings.HasPrefix(name,"ver-") && len(name)>4 && st.IsDir():
+			c.checkVersions(pth)
+		default:
+			c.problem(pth,PUndecodable,"",c.quarantine(pth))
+		}
+	}
+}
+
+// Checks the store in dir, which must not be in use.
+func Fsck(dir string,opts FsckOptions) (rep *Report,err error) {
+	if _,err = os.Stat(dir); err!=nil { return nil,translate(err) }
+	c := &checker{dir:dir,opts:opts,now:time.Now()}
+	c.thr.rate = opts.Rate
+	c.run()
+	return &c.rep,nil
+}
+
+// Checks a running store, as returned by ServeFile or ServeFileOpts.
+// Objects, that are deleted or replaced while being verified, are skipped.
+func Scrub(svc single.ObjectSvc,opts FsckOptions) (rep *Report,err error) {
+	fs := filesOf(svc)
+	if fs==nil { return nil,single.EOpNotSupp }
+	if opts.MinAge==0 { opts.MinAge = time.Hour }
+	c := &checker{dir:fs.dir,opts:opts,now:time.Now(),fs:fs}
+	c.thr.rate = opts.Rate
+	c.run()
+	return &c.rep,nil
+}
+
+///
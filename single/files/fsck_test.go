@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"fmt"
+	"testing"
+	"hash/crc32"
+	"path/filepath"
+)
+
+// Creates n objects with checksums in a new store, and closes it.
+func fsckSetup(t *testing.T,n int) string {
+	t.Helper()
+	dir := t.TempDir()
+	svc,err := ServeFileOpts(dir,Options{Checksums:true})
+	if err!=nil { t.Fatal(err) }
+	defer closeSvc(svc)
+	for i := 0; i<n; i++ {
+		name := []byte(fmt.Sprint("obj",i))
+		if err = svc.PutObj(name,[]byte("hello")); err!=nil { t.Fatal(err) }
+		if _,err = svc.Append(name,[]byte(" world")); err!=nil { t.Fatal(err) }
+	}
+	return dir
+}
+
+func problemKinds(rep *Report) (kinds []string) {
+	for _,p := range rep.Problems { kinds = append(kinds,p.Kind+" "+p.Path) }
+	return
+}
+
+func TestFsckHealthy(t *testing.T) {
+	dir := fsckSetup(t,3)
+	rep,err := Fsck(dir,FsckOptions{})
+	if err!=nil { t.Fatal(err) }
+	if rep.Objects!=3 || rep.Verified!=3 || len(rep.Problems)!=0 { t.Fatalf("report = %+v",rep) }
+}
+
+func TestFsckCorrupt(t *testing.T) {
+	dir := fsckSetup(t,2)
+	pth := filepath.Join(dir,"obj-obj1.bin")
+	if err := os.WriteFile(pth,[]byte("HELLO world"),0666); err!=nil { t.Fatal(err) }
+	rep,err := Fsck(dir,FsckOptions{Quarantine:true})
+	if err!=nil { t.Fatal(err) }
+	if len(rep.Problems)!=1 || rep.Problems[0].Kind!=PCorrupt || rep.Problems[0].Action!="quarantined" { t.Fatalf("problems = %q",problemKinds(rep)) }
+	if _,err = os.Stat(pth); !os.IsNotExist(err) { t.Fatal("corrupt object wasn't moved") }
+	if _,err = os.Stat(pth+sumExt); !os.IsNotExist(err) { t.Fatal("checksum of quarantined object remains") }
+}
+
+func TestFsckStaleAndTemp(t *testing.T) {
+	dir := fsckSetup(t,1)
+	pth := filepath.Join(dir,"obj-obj0.bin")
+	if err := os.WriteFile(pth+sumExt,[]byte("5 0\n"),0666); err!=nil { t.Fatal(err) }
+	if err := os.WriteFile(filepath.Join(dir,"x.tmp"),nil,0666); err!=nil { t.Fatal(err) }
+	rep,err := Fsck(dir,FsckOptions{Repair:true})
+	if err!=nil { t.Fatal(err) }
+	if len(rep.Problems)!=2 { t.Fatalf("problems = %q",problemKinds(rep)) }
+	for _,p := range rep.Problems {
+		if (p.Kind!=PStaleChecksum && p.Kind!=PTemp) || p.Action!="removed" { t.Fatalf("problems = %q",problemKinds(rep)) }
+	}
+}
+
+// Scrubbing must not leave the objects open.
+func TestScrubClosesFiles(t *testing.T) {
+	dir := fsckSetup(t,20)
+	svc,err := ServeFileOpts(dir,Options{Checksums:true})
+	if err!=nil { t.Fatal(err) }
+	defer closeSvc(svc)
+	if _,err = svc.Append([]byte("obj0"),[]byte("!")); err!=nil { t.Fatal(err) }
+	rep,err := Scrub(svc,FsckOptions{})
+	if err!=nil { t.Fatal(err) }
+	if rep.Verified!=20 || len(rep.Problems)!=0 { t.Fatalf("report = %+v",rep) }
+	st,err := StatsOf(svc)
+	if err!=nil { t.Fatal(err) }
+	if st.OpenFiles!=1 { t.Fatalf("%d open files after scrub, want 1",st.OpenFiles) }
+}
+
+func TestScrubCorrupt(t *testing.T) {
+	dir := fsckSetup(t,1)
+	svc,err := ServeFileOpts(dir,Options{Checksums:true})
+	if err!=nil { t.Fatal(err) }
+	defer closeSvc(svc)
+	if err = os.WriteFile(filepath.Join(dir,"obj-obj0.bin"),[]byte("HELLO world"),0666); err!=nil { t.Fatal(err) }
+	rep,err := Scrub(svc,FsckOptions{})
+	if err!=nil { t.Fatal(err) }
+	if len(rep.Problems)!=1 || rep.Problems[0].Kind!=PCorrupt { t.Fatalf("problems = %q",problemKinds(rep)) }
+}
+
+// Appends update the sidecar lazily, a sidecar, that covers a prefix, is verified nonetheless.
+func TestSumLazy(t *testing.T) {
+	dir := fsckSetup(t,1)
+	pth := filepath.Join(dir,"obj-obj0.bin")
+	svc,err := ServeFileOpts(dir,Options{Checksums:true})
+	if err!=nil { t.Fatal(err) }
+	if _,err = svc.Append([]byte("obj0"),[]byte("!")); err!=nil { t.Fatal(err) }
+	if lng,_,err := readSum(pth); err!=nil || lng!=11 { t.Fatalf("sidecar written by Append: %d, %v",lng,err) }
+	
+	// As after a crash.
+	rep,err := Fsck(dir,FsckOptions{Repair:true})
+	if err!=nil { t.Fatal(err) }
+	if rep.Verified!=1 || len(rep.Problems)!=0 { t.Fatalf("report = %+v",rep) }
+	
+	closeSvc(svc)
+	if lng,sum,err := readSum(pth); err!=nil || lng!=12 || sum!=crc32.ChecksumIEEE([]byte("hello world!")) { t.Fatalf("sidecar after Close: %d %x, %v",lng,sum,err) }
+}
+
+// A torn write of the sidecar leaves the old one.
+func TestWriteSumAtomic(t *testing.T) {
+	pth := filepath.Join(t.TempDir(),"obj-a.bin")
+	if err := writeSum(pth,5,0x1234); err!=nil { t.Fatal(err) }
+	if err := os.WriteFile(pth+sumExt+verTemp,[]byte("1"),0666); err!=nil { t.Fatal(err) } // Left by a crash.
+	if err := writeSum(pth,6,0x5678); err!=nil { t.Fatal(err) }
+	lng,sum,err := readSum(pth)
+	if err!=nil || lng!=6 || sum!=0x5678 { t.Fatalf("sum = %d %x %v",lng,sum,err) }
+}
+
+///
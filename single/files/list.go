@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"strings"
+	"path/filepath"
+)
+
+/*
+The object names are kept in a sorted index, thus a page of a listing costs
+a binary search, rather than reading and sorting the whole directory. The
+index is built by the first listing, and maintained by the mutations
+afterwards: They add the names of the files, that they create, and remove
+the names of the files, that they delete. Files, that are removed otherwise,
+like quarantined objects, are filtered out by the listing.
+*/
+type nameIndex struct{
+	mu    sync.Mutex
+	built bool
+	names []string
+}
+
+// Adds name, once the file was created.
+func (ix *nameIndex) add(name []byte) {
+	ix.mu.Lock(); defer ix.mu.Unlock()
+	if !ix.built { return }
+	i := sort.SearchStrings(ix.names,string(name))
+	if i<len(ix.names) && ix.names[i]==string(name) { return }
+	ix.names = append(ix.names,"")
+	copy(ix.names[i+1:],ix.names[i:])
+	ix.names[i] = string(name)
+}
+
+// Removes name, once the file pth was deleted. If pth was recreated meanwhile, name is kept.
+func (ix *nameIndex) remove(name []byte,pth string) {
+	ix.mu.Lock(); defer ix.mu.Unlock()
+	if _,err := os.Lstat(pth); err==nil { return }
+	i := sort.SearchStrings(ix.names,string(name))
+	if i<len(ix.names) && ix.names[i]==string(name) { ix.names = append(ix.names[:i],ix.names[i+1:]...) }
+}
+
+// Builds the index from the directory entries named {pfx}{name}{sfx}. Must be called with ix.mu held.
+func (ix *nameIndex) build(dir,pfx,sfx string) (err error) {
+	ents,err := os.ReadDir(dir)
+	if err!=nil { return translate(err) }
+	names := make([]string,0,len(ents))
+	for _,ent := range ents {
+		n := ent.Name()
+		if len(n)<=len(pfx)+len(sfx) || !strings.HasPrefix(n,pfx) || !strings.HasSuffix(n,sfx) { continue }
+		names = append(names,n[len(pfx):len(n)-len(sfx)])
+	}
+	
+	// The suffix might change the order.
+	sort.Strings(names)
+	ix.names,ix.built = names,true
+	return
+}
+
+// The index is read in batches of this size, so that keep runs without the lock.
+const listBatch = 1000
+
+// Lists the object names from the directory entries named {pfx}{name}{sfx}, for which keep returns true.
+func (fs *multiFiles) listNames(pfx,sfx string,prefix,after []byte,limit int,keep func(name string) bool) (names [][]byte,err error) {
+	ix := &fs.ix
+	cur := string(after)
+	if cur<string(prefix) { cur = string(prefix) }
+	incl := cur!=string(after) // The prefix itself might be a name.
+	for limit<=0 || len(names)<limit {
+		ix.mu.Lock()
+		if !ix.built {
+			if err = ix.build(fs.dir,pfx,sfx); err!=nil { ix.mu.Unlock(); return }
+		}
+		i := sort.SearchStrings(ix.names,cur)
+		if !incl && i<len(ix.names) && ix.names[i]==cur { i++ }
+		batch := make([]string,0,listBatch)
+		for ; i<len(ix.names) && len(batch)<listBatch && strings.HasPrefix(ix.names[i],string(prefix)); i++ {
+			batch = append(batch,ix.names[i])
+		}
+		ix.mu.Unlock()
+		
+		for _,name := range batch {
+			if limit>0 && len(names)>=limit { break }
+			if keep(name) { names = append(names,[]byte(name)) }
+		}
+		if len(batch)<listBatch { break }
+		cur,incl = batch[len(batch)-1],false
+	}
+	return
+}
+
+func (fs *multiFiles) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	return fs.listNames("obj-",".bin",prefix,after,limit,func(name string) bool {
+		st,err := os.Lstat(filepath.Join(fs.dir,"obj-"+name+".bin"))
+		return err==nil && st.Mode().IsRegular()
+	})
+}
+
+// Lists the objects, whose latest version isn't a delete marker. Only the
+// versions of the listed objects are read.
+func (vf *versionFiles) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	return vf.listNames("ver-","",prefix,after,limit,func(name string) bool {
+		vi,err := latestVersion(vf.vdir([]byte(name)))
+		return err==nil && !vi.Deleted
+	})
+}
+
+///
@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"fmt"
+	"testing"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// Lists all objects with the prefix, limit at a time.
+func listAll(t *testing.T,svc single.ObjectSvc,prefix string,limit int) (names []string) {
+	t.Helper()
+	var after []byte
+	for {
+		page,err := single.AsListSvc(svc).ListObjs([]byte(prefix),after,limit)
+		if err!=nil { t.Fatal(err) }
+		if len(page)==0 { return }
+		if len(page)>limit { t.Fatalf("page of %d names, limit %d",len(page),limit) }
+		for _,name := range page { names = append(names,string(name)) }
+		after = page[len(page)-1]
+	}
+}
+
+func TestListPages(t *testing.T) {
+	for _,versioning := range []bool{false,true} {
+		svc := testStore(t,Options{Versioning:versioning})
+		n := 2*listBatch+10
+		for i := 0; i<n; i++ {
+			if err := svc.PutObj([]byte(fmt.Sprintf("o%05d",i)),nil); err!=nil { t.Fatal(err) }
+		}
+		if err := svc.PutObj([]byte("p"),nil); err!=nil { t.Fatal(err) }
+		names := listAll(t,svc,"o",333)
+		if len(names)!=n { t.Fatalf("versioning=%v: %d names, want %d",versioning,len(names),n) }
+		for i,name := range names {
+			if name!=fmt.Sprintf("o%05d",i) { t.Fatalf("versioning=%v: names[%d] = %q",versioning,i,name) }
+		}
+		
+		// The index follows the mutations.
+		if err := svc.DeleteObj([]byte("o00005")); err!=nil { t.Fatal(err) }
+		if _,err := svc.Append([]byte("o00005x"),[]byte("a")); err!=nil { t.Fatal(err) }
+		names = listAll(t,svc,"o0000",2)
+		if fmt.Sprint(names)!="[o00000 o00001 o00002 o00003 o00004 o00005x o00006 o00007 o00008 o00009]" { t.Fatalf("versioning=%v: %q",versioning,names) }
+		if err := svc.PutObj([]byte("o00005"),nil); err!=nil { t.Fatal(err) }
+		if names = listAll(t,svc,"o00005",10); len(names)!=2 { t.Fatalf("versioning=%v: recreated: %q",versioning,names) }
+	}
+}
+
+// Files, that are removed behind the store's back, are not listed.
+func TestListRemovedFile(t *testing.T) {
+	svc := testStore(t,Options{})
+	for _,name := range []string{"a","b","c"} {
+		if err := svc.PutObj([]byte(name),nil); err!=nil { t.Fatal(err) }
+	}
+	listAll(t,svc,"",10)
+	if err := os.Remove(filepath.Join(filesOf(svc).dir,"obj-b.bin")); err!=nil { t.Fatal(err) }
+	if names := listAll(t,svc,"",10); fmt.Sprint(names)!="[a c]" { t.Fatalf("names = %q",names) }
+}
+
+///
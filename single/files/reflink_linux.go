@@ -0,0 +1,50 @@
+// +build linux
+
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE of linux/fs.h.
+const ficlone = 0x40049409
+
+// Creates dst as a clone of src, that shares it's extents, if the file system supports reflinks.
+func reflink(src,dst string) (err error) {
+	in,err := os.Open(src)
+	if err!=nil { return }
+	defer in.Close()
+	out,err := os.OpenFile(dst,os.O_WRONLY|os.O_CREATE|os.O_EXCL,0666)
+	if err!=nil { return }
+	if _,_,errno := syscall.Syscall(syscall.SYS_IOCTL,out.Fd(),ficlone,in.Fd()); errno!=0 { err = errno }
+	if e := out.Close(); err==nil { err = e }
+	if err!=nil { os.Remove(dst) }
+	return
+}
+
+///
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"io"
+	"errors"
+	"hash/crc32"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+Objects are replaced by writing the new content into a temporary file, that
+is renamed over the object file. The rename is recorded in the intent log,
+but not replayed, since renaming is atomic.
+
+The handles of the old file, that the store has cached, are evicted: Readers,
+that borrowed them, finish reading the old content, while Appends, that wait
+for them, are retried with the new file.
+*/
+
+// Returned by singleFile.Append, if the file was replaced meanwhile.
+var errReplaced = errors.New("replaced")
+
+// Writes r into a new temporary file in dir. Returns it's path, length and crc32.
+func writeTemp(dir string,r io.Reader) (pth string,lng int64,sum uint32,err error) {
+	f,err := os.CreateTemp(dir,"replace-*"+verTemp)
+	if err!=nil { return "",0,0,translate(err) }
+	h := crc32.NewIEEE()
+	lng,err = io.Copy(io.MultiWriter(f,h),r)
+	if err==nil { err = f.Sync() }
+	if e := f.Close(); err==nil { err = e }
+	if err!=nil {
+		os.Remove(f.Name())
+		return "",0,0,translate(err)
+	}
+	return f.Name(),lng,h.Sum32(),nil
+}
+
+// Evicts the cached handle sf of the file pth. Must be called with sf.am held.
+func (fs *multiFiles) evict(pth string,sf *singleFile) {
+	sf.replaced = true
+	fs.fml.Lock()
+	if inst,ok := fs.fm.Load(pth); ok && inst==sf { fs.fm.Delete(pth) }
+	fs.fml.Unlock()
+	go func() {
+		sf.Wait()
+		sf.f.Close()
+	}()
+}
+
+// Whether sf is a handle of the file pth.
+func (sf *singleFile) isFile(pth string) bool {
+	a,err := sf.f.Stat()
+	if err!=nil { return false }
+	b,err := os.Stat(pth)
+	return err==nil && os.SameFile(a,b)
+}
+
+func (fs *multiFiles) ReplaceObj(objectId []byte,r io.Reader) (ver single.Version,err error) {
+	// The content is written before locking, as it might take a while.
+	tmp,lng,sum,err := writeTemp(fs.dir,r)
+	if err!=nil { return }
+	defer os.Remove(tmp)
+	
+	fs.ql.RLock(); defer fs.ql.RUnlock()
+	fs.rl.RLock(); defer fs.rl.RUnlock()
+	if err = fs.checkRetained(objectId); err!=nil { return }
+	path,_ := fs.path(objectId)
+	if _,ok := fs.fme.Load(path); ok { return 0,single.EBeingDeleted }
+	if err = fs.initRetention(objectId); err!=nil { return }
+	done,err := fs.logIntent(walReplace,path,lng,sum)
+	if err!=nil { return }
+	defer done()
+	
+	// Appends to the old file must not touch the checksum of the new one.
+	var old *singleFile
+	if inst,ok := fs.fm.Load(path); ok {
+		old = inst.(*singleFile)
+		old.am.Lock()
+	}
+	if fs.sums { writeSum(path,lng,sum) }
+	if err = os.Rename(tmp,path); err==nil { err = syncDir(fs.dir) }
+	if err!=nil && fs.sums {
+		os.Remove(path+sumExt)
+		if old!=nil && old.sumok { writeSum(path,old.l,old.sum) }
+	}
+	if err==nil && old!=nil { fs.evict(path,old) }
+	if old!=nil { old.am.Unlock() }
+	if err!=nil { return 0,translate(err) }
+	
+	// A handle of the old file, that was opened before the rename, but cached afterwards.
+	fs.fmwg.Wait()
+	if inst,ok := fs.fm.Load(path); ok && inst!=old {
+		sf := inst.(*singleFile)
+		sf.am.Lock()
+		if !sf.isFile(path) { fs.evict(path,sf) }
+		sf.am.Unlock()
+	}
+	fs.ix.add(objectId)
+	fs.wake(objectId)
+	return
+}
+
+// Writes a new version from r.
+func (vf *versionFiles) ReplaceObj(objectId []byte,r io.Reader) (ver single.Version,err error) {
+	dir := vf.vdir(objectId)
+	if err = os.MkdirAll(dir,0777); err!=nil { return 0,translate(err) }
+	tmp,lng,sum,err := writeTemp(dir,r)
+	if err!=nil { return }
+	defer os.Remove(tmp)
+	
+	vf.ql.RLock(); defer vf.ql.RUnlock()
+	vf.rl.RLock(); defer vf.rl.RUnlock()
+	if err = vf.checkRetained(objectId); err!=nil { return }
+	vf.ix.add(objectId)
+	ver,first := vf.alloc(dir)
+	if first {
+		if err = vf.initRetention(objectId); err!=nil { return }
+	}
+	pth := vfile(dir,ver,verData)
+	done,err := vf.logIntent(walCreate,pth,lng,sum)
+	if err!=nil { return }
+	if err = os.Rename(tmp,pth); err==nil { err = syncDir(dir) }
+	done()
+	if err!=nil { return 0,translate(err) }
+	if vf.sums { writeSum(pth,lng,sum) }
+	vf.wake(objectId)
+	return
+}
+
+///
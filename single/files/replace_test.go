@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"path/filepath"
+	"time"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+func replace(t *testing.T,svc single.ObjectSvc,name,data string) single.Version {
+	t.Helper()
+	ver,err := single.AsReplaceSvc(svc).ReplaceObj([]byte(name),strings.NewReader(data))
+	if err!=nil { t.Fatalf("replace %s: %v",name,err) }
+	return ver
+}
+
+// Appends after a replacement go to the new file, and keep it's checksum.
+func TestReplaceObj(t *testing.T) {
+	dir := t.TempDir()
+	svc,err := ServeFileOpts(dir,Options{Checksums:true,WAL:true})
+	if err!=nil { t.Fatal(err) }
+	if err = svc.PutObj([]byte("a"),[]byte("one")); err!=nil { t.Fatal(err) }
+	if _,err = svc.Append([]byte("a"),[]byte("+")); err!=nil { t.Fatal(err) }
+	if s := readAll(t,svc,"a"); s!="one+" { t.Fatalf("a = %q",s) }
+	
+	replace(t,svc,"a","two")
+	if s := readAll(t,svc,"a"); s!="two" { t.Fatalf("replaced a = %q",s) }
+	if _,err = svc.Append([]byte("a"),[]byte("!")); err!=nil { t.Fatal(err) }
+	if s := readAll(t,svc,"a"); s!="two!" { t.Fatalf("appended a = %q",s) }
+	
+	// Replacing creates missing objects.
+	replace(t,svc,"b","new")
+	if s := readAll(t,svc,"b"); s!="new" { t.Fatalf("b = %q",s) }
+	closeSvc(svc)
+	
+	rep,err := Fsck(dir,FsckOptions{})
+	if err!=nil { t.Fatal(err) }
+	if len(rep.Problems)!=0 { t.Fatalf("problems = %q",problemKinds(rep)) }
+}
+
+type failReader struct{ n int }
+func (r *failReader) Read(p []byte) (int,error) {
+	if r.n==0 { return 0,errors.New("broken") }
+	r.n--
+	p[0] = 'x'
+	return 1,nil
+}
+
+// A failed replacement leaves the old object and no temporary file.
+func TestReplaceObjFailed(t *testing.T) {
+	dir := t.TempDir()
+	svc,err := ServeFileOpts(dir,Options{})
+	if err!=nil { t.Fatal(err) }
+	defer closeSvc(svc)
+	if err = svc.PutObj([]byte("a"),[]byte("old")); err!=nil { t.Fatal(err) }
+	if _,err = single.AsReplaceSvc(svc).ReplaceObj([]byte("a"),&failReader{n:10}); err==nil { t.Fatal("replace succeeded") }
+	if s := readAll(t,svc,"a"); s!="old" { t.Fatalf("a = %q",s) }
+	if tmps,_ := filepath.Glob(filepath.Join(dir,"*"+verTemp)); len(tmps)!=0 { t.Fatalf("temporary files %q",tmps) }
+}
+
+// Blocks the first Write until proceed is closed.
+type gateWriter struct{
+	started,proceed chan struct{}
+	buf             []byte
+}
+func (w *gateWriter) Write(p []byte) (int,error) {
+	if len(w.buf)==0 {
+		close(w.started)
+		<-w.proceed
+	}
+	w.buf = append(w.buf,p...)
+	return len(p),nil
+}
+
+// Readers of the old file finish reading the old content.
+func TestReplaceObjReader(t *testing.T) {
+	svc := testStore(t,Options{})
+	old := strings.Repeat("o",1<<20)
+	if err := svc.PutObj([]byte("a"),[]byte(old)); err!=nil { t.Fatal(err) }
+	w := &gateWriter{started:make(chan struct{}),proceed:make(chan struct{})}
+	res := make(chan error)
+	go func() { res <- single.ReadTo(svc,[]byte("a"),single.ByteRange{},w) }()
+	<-w.started
+	replace(t,svc,"a","new")
+	close(w.proceed)
+	if err := <-res; err!=nil { t.Fatal(err) }
+	if string(w.buf)!=old { t.Fatalf("reader got %d bytes",len(w.buf)) }
+	if s := readAll(t,svc,"a"); s!="new" { t.Fatalf("a = %q",s) }
+}
+
+func TestReplaceObjVersioned(t *testing.T) {
+	svc := testStore(t,Options{Versioning:true})
+	vs := single.AsVersionSvc(svc)
+	v1,err := vs.PutVersion([]byte("a"),[]byte("one"))
+	if err!=nil { t.Fatal(err) }
+	v2 := replace(t,svc,"a","two")
+	if v2<=v1 { t.Fatalf("versions not increasing: %v, %v",v1,v2) }
+	if s := readAll(t,svc,"a"); s!="two" { t.Fatalf("latest = %q",s) }
+	if s := readVersion(t,vs,"a",v1); s!="one" { t.Fatalf("v1 = %q",s) }
+}
+
+func TestReplaceObjRetained(t *testing.T) {
+	svc := testStore(t,Options{DefaultRetention:time.Hour})
+	if err := svc.PutObj([]byte("a"),[]byte("old")); err!=nil { t.Fatal(err) }
+	_,err := single.AsReplaceSvc(svc).ReplaceObj([]byte("a"),strings.NewReader("new"))
+	if err!=single.ERetained { t.Fatalf("err = %v",err) }
+	if s := readAll(t,svc,"a"); s!="old" { t.Fatalf("a = %q",s) }
+}
+
+///
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+The retention of an object is stored in a sidecar file "ret-{name}", that
+contains the retention date in unix seconds and the legal hold flag.
+An absent sidecar file means, that the object isn't retained.
+*/
+
+func (fs *multiFiles) rpath(name []byte) string {
+	return filepath.Join(fs.dir,"ret-"+string(name))
+}
+func (fs *multiFiles) retention(name []byte) (ret single.Retention,err error) {
+	if inst,ok := fs.rc.Load(string(name)); ok { return inst.(single.Retention),nil }
+	data,err := os.ReadFile(fs.rpath(name))
+	if os.IsNotExist(err) {
+		err = nil
+	} else if err!=nil {
+		return ret,translate(err)
+	} else {
+		var hold int
+		if _,err = fmt.Sscan(string(data),&ret.Until,&hold); err!=nil { return ret,single.EDiskFailure }
+		ret.LegalHold = hold!=0
+	}
+	fs.rc.Store(string(name),ret)
+	return
+}
+func (fs *multiFiles) storeRetention(name []byte,ret single.Retention) (err error) {
+	hold := 0
+	if ret.LegalHold { hold = 1 }
+	pth := fs.rpath(name)
+	os.Remove(pth+".tmp")
+	err = writeFileAtomic(pth+".tmp",pth,[]byte(fmt.Sprintln(ret.Until,hold)))
+	if err==nil { fs.rc.Store(string(name),ret) } else { fs.rc.Delete(string(name)) }
+	return
+}
+
+/*
+Mutations hold fs.rl shared from checkRetained until they are done, while
+setRetention holds it exclusively. Thus, a retention or legal hold, that is
+set concurrently, either applies before the check, or after the mutation.
+*/
+
+// Returns ERetained, if the object must not be modified. Must be called with fs.rl held.
+func (fs *multiFiles) checkRetained(name []byte) error {
+	ret,err := fs.retention(name)
+	if err!=nil { return err }
+	if ret.Active(time.Now()) { return single.ERetained }
+	return nil
+}
+
+/*
+Applies the default retention to an object, that is about to be created. It is
+called before the content is written, thus the content is never visible
+without retention. A longer retention, that is in place already, is kept.
+Must be called with fs.rl held.
+*/
+func (fs *multiFiles) initRetention(name []byte) (err error) {
+	if fs.defret<=0 || (fs.noret!=nil && fs.noret(name)) { return }
+	until := time.Now().Add(fs.defret).Unix()
+	if cur,err := fs.retention(name); err==nil && cur.Until>=until { return nil }
+	return fs.storeRetention(name,single.Retention{Until:until})
+}
+
+// Removes the retention of a deleted object. Must be called with fs.rl held.
+func (fs *multiFiles) clearRetention(name []byte) {
+	os.Remove(fs.rpath(name))
+	fs.rc.Delete(string(name))
+}
+
+func (fs *multiFiles) setRetention(name []byte,ret single.Retention) (err error) {
+	fs.rl.Lock(); defer fs.rl.Unlock()
+	var cur single.Retention
+	if cur,err = fs.retention(name); err!=nil { return }
+	if ret.Until<cur.Until { return single.ERetained }
+	return fs.storeRetention(name,ret)
+}
+
+func (fs *multiFiles) GetRetention(objectId []byte) (ret single.Retention,err error) {
+	if _,err = fs.Info(objectId); err!=nil { return }
+	return fs.retention(objectId)
+}
+func (fs *multiFiles) SetRetention(objectId []byte,ret single.Retention) (err error) {
+	if _,err = fs.Info(objectId); err!=nil { return }
+	return fs.setRetention(objectId,ret)
+}
+
+// A versioned object is retained as a whole, including it's older versions.
+func (vf *versionFiles) GetRetention(objectId []byte) (ret single.Retention,err error) {
+	if _,err = latestVersion(vf.vdir(objectId)); err!=nil { return }
+	return vf.retention(objectId)
+}
+func (vf *versionFiles) SetRetention(objectId []byte,ret single.Retention) (err error) {
+	if _,err = latestVersion(vf.vdir(objectId)); err!=nil { return }
+	return vf.setRetention(objectId,ret)
+}
+
+///
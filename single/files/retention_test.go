@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"time"
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+func TestRetentionDefault(t *testing.T) {
+	for _,versioning := range []bool{false,true} {
+		svc := testStore(t,Options{Versioning:versioning,DefaultRetention:time.Hour})
+		rs := single.AsRetentionSvc(svc)
+		if err := svc.PutObj([]byte("a"),[]byte("data")); err!=nil { t.Fatal(err) }
+		ret,err := rs.GetRetention([]byte("a"))
+		if err!=nil || !ret.Active(time.Now()) { t.Fatalf("versioning=%v: retention = %+v %v",versioning,ret,err) }
+		if err = svc.DeleteObj([]byte("a")); err!=single.ERetained { t.Fatalf("versioning=%v: delete: %v",versioning,err) }
+		if _,err = svc.Append([]byte("a"),[]byte("x")); err!=single.ERetained { t.Fatalf("versioning=%v: append: %v",versioning,err) }
+		
+		// An object, that is created by Append, is retained as well.
+		if _,err = svc.Append([]byte("b"),[]byte("x")); err!=nil { t.Fatal(err) }
+		if err = svc.DeleteObj([]byte("b")); err!=single.ERetained { t.Fatalf("versioning=%v: delete appended: %v",versioning,err) }
+	}
+}
+
+func TestRetentionSidecarFirst(t *testing.T) {
+	svc := testStore(t,Options{DefaultRetention:time.Hour})
+	fs := filesOf(svc)
+	
+	// If the sidecar can't be written, the content isn't written either.
+	if err := os.Mkdir(fs.rpath([]byte("a")),0777); err!=nil { t.Fatal(err) }
+	if err := os.WriteFile(fs.rpath([]byte("a"))+"/x",nil,0666); err!=nil { t.Fatal(err) }
+	if err := svc.PutObj([]byte("a"),[]byte("data")); err==nil { t.Fatal("PutObj succeeded without sidecar") }
+	if lng,err := svc.Info([]byte("a")); err==nil && lng!=0 { t.Fatalf("content written without retention: %d bytes",lng) }
+}
+
+func TestRetentionExtendOnly(t *testing.T) {
+	svc := testStore(t,Options{})
+	rs := single.AsRetentionSvc(svc)
+	name := []byte("a")
+	if err := svc.PutObj(name,[]byte("data")); err!=nil { t.Fatal(err) }
+	until := time.Now().Add(time.Hour).Unix()
+	if err := rs.SetRetention(name,single.Retention{Until:until}); err!=nil { t.Fatal(err) }
+	if err := rs.SetRetention(name,single.Retention{Until:until-1}); err!=single.ERetained { t.Fatalf("shortened: %v",err) }
+	if err := rs.SetRetention(name,single.Retention{Until:until+1}); err!=nil { t.Fatalf("extend: %v",err) }
+	if err := svc.DeleteObj(name); err!=single.ERetained { t.Fatalf("delete: %v",err) }
+}
+
+func TestRetentionLegalHold(t *testing.T) {
+	svc := testStore(t,Options{})
+	rs := single.AsRetentionSvc(svc)
+	name := []byte("a")
+	if err := svc.PutObj(name,[]byte("data")); err!=nil { t.Fatal(err) }
+	if err := rs.SetRetention(name,single.Retention{LegalHold:true}); err!=nil { t.Fatal(err) }
+	if _,err := svc.Append(name,[]byte("x")); err!=single.ERetained { t.Fatalf("append: %v",err) }
+	if err := svc.DeleteObj(name); err!=single.ERetained { t.Fatalf("delete: %v",err) }
+	if err := rs.SetRetention(name,single.Retention{}); err!=nil { t.Fatal(err) }
+	if err := svc.DeleteObj(name); err!=nil { t.Fatalf("delete after release: %v",err) }
+	if _,err := rs.GetRetention(name); err!=single.ENotFound { t.Fatalf("retention of deleted object: %v",err) }
+}
+
+// A hold, that is set while appends are running, applies to every append, that starts afterwards.
+func TestRetentionConcurrentHold(t *testing.T) {
+	svc := testStore(t,Options{})
+	rs := single.AsRetentionSvc(svc)
+	name := []byte("a")
+	if err := svc.PutObj(name,nil); err!=nil { t.Fatal(err) }
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop: return
+			default: svc.Append(name,[]byte("x"))
+			}
+		}
+	}()
+	time.Sleep(10*time.Millisecond)
+	if err := rs.SetRetention(name,single.Retention{LegalHold:true}); err!=nil { t.Fatal(err) }
+	lng,_ := svc.Info(name)
+	time.Sleep(10*time.Millisecond)
+	close(stop)
+	<-done
+	if now,_ := svc.Info(name); now!=lng { t.Fatalf("appended under legal hold: %d -> %d",lng,now) }
+}
+
+///
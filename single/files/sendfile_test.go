@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"io"
+	"os"
+	"bytes"
+	"testing"
+	"time"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// A FileSink, that keeps the handed file, until release is called.
+type fileSink struct{
+	bytes.Buffer
+	handed bool
+	off,n  int64
+	f      *os.File
+	done   func()
+}
+func (s *fileSink) SendFile(f *os.File,off,n int64,done func()) error {
+	s.handed,s.off,s.n,s.f,s.done = true,off,n,f,done
+	_,err := io.Copy(&s.Buffer,io.NewSectionReader(f,off,n))
+	return err
+}
+func (s *fileSink) release() {
+	s.f.Close()
+	s.done()
+}
+
+func TestSendFile(t *testing.T) {
+	svc := testStore(t,Options{})
+	data := make([]byte,3*sendFileMin)
+	for i := range data { data[i] = byte(i*7) }
+	if err := svc.PutObj([]byte("a"),data); err!=nil { t.Fatal(err) }
+	
+	for _,c := range []struct{
+		pos    single.ByteRange
+		handed bool
+		off,n  int64
+	}{
+		{single.ByteRange{},true,0,int64(len(data))},
+		{single.ByteRange{100,sendFileMin},true,100,sendFileMin},
+		{single.ByteRange{sendFileMin,0},true,sendFileMin,2*sendFileMin},
+		
+		// Ranges beyond the end are cut, small ones are copied.
+		{single.ByteRange{2*sendFileMin-10,5*sendFileMin},true,2*sendFileMin-10,sendFileMin+10},
+		{single.ByteRange{10,100},false,10,100},
+		{single.ByteRange{int64(len(data))-100,0},false,int64(len(data))-100,100},
+	}{
+		var s fileSink
+		if err := single.ReadTo(svc,[]byte("a"),c.pos,&s); err!=nil { t.Fatalf("%v: %v",c.pos,err) }
+		if s.handed!=c.handed || (s.handed && (s.off!=c.off || s.n!=c.n)) { t.Fatalf("%v: handed %v at %d+%d",c.pos,s.handed,s.off,s.n) }
+		if !bytes.Equal(s.Bytes(),data[c.off:c.off+c.n]) { t.Fatalf("%v: read %d bytes, that don't match",c.pos,s.Len()) }
+		if s.handed { s.release() }
+	}
+}
+
+// The file stays borrowed, until the sink is done, thus it isn't deleted meanwhile.
+func TestSendFileBorrowed(t *testing.T) {
+	svc := testStore(t,Options{})
+	if err := svc.PutObj([]byte("a"),make([]byte,sendFileMin)); err!=nil { t.Fatal(err) }
+	var s fileSink
+	if err := single.ReadTo(svc,[]byte("a"),single.ByteRange{},&s); err!=nil || !s.handed { t.Fatalf("read: %v, handed %v",err,s.handed) }
+	
+	deleted := make(chan error,1)
+	go func() { deleted <- svc.DeleteObj([]byte("a")) }()
+	select {
+	case err := <-deleted: t.Fatalf("deleted while sending: %v",err)
+	case <-time.After(50*time.Millisecond):
+	}
+	s.release()
+	if err := <-deleted; err!=nil { t.Fatal(err) }
+}
+
+///
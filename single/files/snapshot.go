@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+	"bytes"
+	"strconv"
+	"strings"
+	"encoding/json"
+	"path/filepath"
+	
+	"unsafe"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+A snapshot preserves the objects of a store, as they were at a point in time.
+
+Each snapshot is a directory "snapshots/{name}", with a file for every object,
+and a manifest, that records the objects and their lengths. The files are
+cloned, where the file system supports reflinks, and hard-linked otherwise.
+Objects are only appended to, thus a hard link preserves the content up to the
+recorded length, which caps the reads.
+*/
+const (
+	snapDir      = "snapshots"
+	snapManifest = "manifest.json"
+	snapTemp     = ".tmp"
+)
+
+type snapEntry struct{
+	Object  string         `json:"object"`
+	File    string         `json:"file"`
+	Version single.Version `json:"version,omitempty"`
+	Length  int64          `json:"length"`
+	ModTime time.Time      `json:"mod_time"`
+}
+
+type snapManifestData struct{
+	Info    single.SnapshotInfo `json:"info"`
+	Entries []snapEntry         `json:"entries"`
+}
+
+// Snapshot names consist of letters, digits, '-', '_' and '.', but don't start with '.'.
+func validSnapshot(name string) bool {
+	if name=="" || len(name)>128 || name[0]=='.' || strings.HasSuffix(name,snapTemp) { return false }
+	for i := 0; i<len(name); i++ {
+		c := name[i]
+		switch {
+		case c>='a' && c<='z',c>='A' && c<='Z',c>='0' && c<='9',c=='-',c=='_',c=='.':
+		default: return false
+		}
+	}
+	return true
+}
+
+// Returns the committed length and the modification time of an object file.
+func (fs *multiFiles) committed(pth string) (lng int64,mtime time.Time,err error) {
+	st,err := os.Stat(pth)
+	if err!=nil { return 0,mtime,translate(err) }
+	lng = st.Size()
+	if inst,ok := fs.fm.Load(pth); ok { lng = inst.(*singleFile).length() }
+	return lng,st.ModTime(),nil
+}
+
+// Links the objects into dir. Must be called with fs.ql held exclusively.
+func (fs *multiFiles) snapshot(dir string,versioned bool) (m snapManifestData,err error) {
+	ents,err := os.ReadDir(fs.dir)
+	if err!=nil { return m,translate(err) }
+	m.Info.Reflink = true
+	for _,ent := range ents {
+		n := ent.Name()
+		var e snapEntry
+		var pth string
+		switch {
+		case !versioned && strings.HasPrefix(n,"obj-") && strings.HasSuffix(n,".bin") && len(n)>8 && ent.Type().IsRegular():
+			e.Object = n[4:len(n)-4]
+			pth = filepath.Join(fs.dir,n)
+		case versioned && strings.HasPrefix(n,"ver-") && len(n)>4 && ent.IsDir():
+			vdir := filepath.Join(fs.dir,n)
+			vi,lerr := latestVersion(vdir)
+			if lerr!=nil || vi.Deleted { continue }
+			e.Object,e.Version = n[4:],vi.Version
+			pth = vfile(vdir,vi.Version,verData)
+		default:
+			continue
+		}
+		if e.Length,e.ModTime,err = fs.committed(pth); err!=nil {
+			if err==single.ENotFound { continue }
+			return
+		}
+		e.File = strconv.Itoa(len(m.Entries))+".bin"
+		dst := filepath.Join(dir,e.File)
+		if !m.Info.Reflink || reflink(pth,dst)!=nil {
+			m.Info.Reflink = false
+			if err = os.Link(pth,dst); err!=nil { return m,translate(err) }
+		}
+		m.Entries = append(m.Entries,e)
+		m.Info.Bytes += e.Length
+	}
+	m.Info.Objects = len(m.Entries)
+	if m.Info.Objects==0 { m.Info.Reflink = false }
+	
+	// The suffix of the file names might change the order.
+	sort.Slice(m.Entries,func(i,j int) bool { return m.Entries[i].Object<m.Entries[j].Object })
+	return
+}
+
+/*
+Creates a snapshot of the store, named name, or after the current time, if
+name is empty. The writers are blocked, while the objects are linked.
+*/
+func CreateSnapshot(svc single.ObjectSvc,name string) (info single.SnapshotInfo,err error) {
+	fs := filesOf(svc)
+	if fs==nil { return info,single.EOpNotSupp }
+	if name=="" { name = time.Now().UTC().Format("20060102T150405Z") }
+	if !validSnapshot(name) { return info,single.EInvalid }
+	_,versioned := single.Innermost(svc).(*versionFiles)
+	dir := filepath.Join(fs.dir,snapDir,name)
+	
+	fs.ql.Lock()
+	var m snapManifestData
+	if _,err = os.Stat(dir); err==nil {
+		err = single.EExist
+	} else if err = os.RemoveAll(dir+snapTemp); err==nil {
+		if err = os.MkdirAll(dir+snapTemp,0777); err==nil { m,err = fs.snapshot(dir+snapTemp,versioned) }
+	}
+	fs.ql.Unlock()
+	if err==nil {
+		m.Info.Name,m.Info.Created = name,time.Now().UTC()
+		data,_ := json.Marshal(&m)
+		if err = writeFileAtomic(filepath.Join(dir+snapTemp,snapManifest+snapTemp),filepath.Join(dir+snapTemp,snapManifest),data); err==nil {
+			err = translate(os.Rename(dir+snapTemp,dir))
+		}
+	}
+	if err!=nil {
+		if err!=single.EExist { os.RemoveAll(dir+snapTemp) }
+		return info,translate(err)
+	}
+	return m.Info,nil
+}
+
+func readManifest(dir string) (m snapManifestData,err error) {
+	data,err := os.ReadFile(filepath.Join(dir,snapManifest))
+	if err!=nil { return m,translate(err) }
+	if json.Unmarshal(data,&m)!=nil { return m,single.EDiskFailure }
+	return
+}
+
+// Lists the snapshots of the store, oldest first.
+func Snapshots(svc single.ObjectSvc) (infos []single.SnapshotInfo,err error) {
+	fs := filesOf(svc)
+	if fs==nil { return nil,single.EOpNotSupp }
+	ents,err := os.ReadDir(filepath.Join(fs.dir,snapDir))
+	if os.IsNotExist(err) { return nil,nil }
+	if err!=nil { return nil,translate(err) }
+	for _,ent := range ents {
+		if !ent.IsDir() || !validSnapshot(ent.Name()) { continue }
+		m,err := readManifest(filepath.Join(fs.dir,snapDir,ent.Name()))
+		if err!=nil { continue }
+		infos = append(infos,m.Info)
+	}
+	sort.Slice(infos,func(i,j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return
+}
+
+// Removes a snapshot. Readers of the snapshot may fail afterwards.
+func DeleteSnapshot(svc single.ObjectSvc,name string) (err error) {
+	fs := filesOf(svc)
+	if fs==nil { return single.EOpNotSupp }
+	if !validSnapshot(name) { return single.ENotFound }
+	dir := filepath.Join(fs.dir,snapDir,name)
+	if _,err = os.Stat(dir); err!=nil { return translate(err) }
+	return translate(os.RemoveAll(dir))
+}
+
+// A read-only store of the objects of a snapshot.
+type snapshotFiles struct{
+	dir     string
+	entries []snapEntry
+}
+
+/*
+Opens a snapshot as read-only store, that implements single.ListSvc and
+single.StatSvc, as well. Every object has the length, it had, when the snapshot
+was created.
+*/
+func OpenSnapshot(svc single.ObjectSvc,name string) (single.ObjectSvc,error) {
+	fs := filesOf(svc)
+	if fs==nil { return nil,single.EOpNotSupp }
+	if !validSnapshot(name) { return nil,single.ENotFound }
+	dir := filepath.Join(fs.dir,snapDir,name)
+	m,err := readManifest(dir)
+	if err!=nil { return nil,err }
+	return &snapshotFiles{dir:dir,entries:m.Entries},nil
+}
+
+func (s *snapshotFiles) find(objectId []byte) (i int,err error) {
+	i = sort.Search(len(s.entries),func(i int) bool { return s.entries[i].Object>=string(objectId) })
+	if i==len(s.entries) || s.entries[i].Object!=string(objectId) { return 0,single.ENotFound }
+	return
+}
+
+func (s *snapshotFiles) PutObj(objectId []byte,data []byte) (err error) {
+	return single.EIsReadOnly
+}
+func (s *snapshotFiles) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	return pos,single.EIsReadOnly
+}
+func (s *snapshotFiles) DeleteObj(objectId []byte) (err error) {
+	return single.EIsReadOnly
+}
+func (s *snapshotFiles) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return s.ReadObjTo(objectId,pos,ops.GetBodyBuffer(dst))
+}
+
+// Reads the range, capped at the recorded length.
+func (s *snapshotFiles) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	i,err := s.find(objectId)
+	if err!=nil { return }
+	off := pos.Begin64()
+	lng,ok := pos.Length64()
+	if !ok || off+lng>s.entries[i].Length { lng = s.entries[i].Length-off }
+	if lng<=0 { return }
+	f,err := os.Open(filepath.Join(s.dir,s.entries[i].File))
+	if err!=nil { return translate(err) }
+	if fsk,ok := sink.(single.FileSink); ok && lng>=sendFileMin {
+		return translate(fsk.SendFile(f,off,lng,func() {}))
+	}
+	defer f.Close()
+	_,err = io.Copy(sink,io.NewSectionReader(f,off,lng))
+	return translate(err)
+}
+func (s *snapshotFiles) Info(objectId []byte) (lng int64,err error) {
+	i,err := s.find(objectId)
+	if err!=nil { return }
+	return s.entries[i].Length,nil
+}
+func (s *snapshotFiles) Stat(objectId []byte) (st single.ObjectStat,err error) {
+	i,err := s.find(objectId)
+	if err!=nil { return }
+	return single.ObjectStat{Length:s.entries[i].Length,ModTime:s.entries[i].ModTime},nil
+}
+func (s *snapshotFiles) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	i := sort.Search(len(s.entries),func(i int) bool { return s.entries[i].Object>string(after) })
+	for ; i<len(s.entries) && (limit<=0 || len(names)<limit); i++ {
+		name := []byte(s.entries[i].Object)
+		if !bytes.HasPrefix(name,prefix) {
+			if bytes.Compare(name,prefix)>0 { break }
+			continue
+		}
+		names = append(names,name)
+	}
+	return
+}
+
+///
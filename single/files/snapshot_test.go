@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// The snapshot keeps the objects, as they were, when it was created.
+func TestSnapshot(t *testing.T) {
+	svc := testStore(t,Options{})
+	svc.PutObj([]byte("a"),[]byte("one"))
+	svc.PutObj([]byte("b"),[]byte("two"))
+	info,err := CreateSnapshot(svc,"s1")
+	if err!=nil { t.Fatal(err) }
+	if info.Name!="s1" || info.Objects!=2 || info.Bytes!=6 { t.Fatalf("info = %+v",info) }
+	
+	svc.Append([]byte("a"),[]byte("+more"))
+	svc.DeleteObj([]byte("b"))
+	svc.PutObj([]byte("c"),[]byte("three"))
+	
+	snap,err := OpenSnapshot(svc,"s1")
+	if err!=nil { t.Fatal(err) }
+	if s := readAll(t,snap,"a"); s!="one" { t.Fatalf("a = %q",s) }
+	if s := readAll(t,snap,"b"); s!="two" { t.Fatalf("b = %q",s) }
+	if _,err = snap.Info([]byte("c")); err!=single.ENotFound { t.Fatalf("c: %v",err) }
+	if names := listAll(t,snap,"",1); len(names)!=2 || names[0]!="a" || names[1]!="b" { t.Fatalf("names = %q",names) }
+	if err = snap.PutObj([]byte("d"),nil); err!=single.EIsReadOnly { t.Fatalf("put: %v",err) }
+	if s := readAll(t,svc,"a"); s!="one+more" { t.Fatalf("live a = %q",s) }
+	
+	if _,err = CreateSnapshot(svc,"s1"); err!=single.EExist { t.Fatalf("duplicate: %v",err) }
+	if _,err = CreateSnapshot(svc,".hidden"); err!=single.EInvalid { t.Fatalf("invalid name: %v",err) }
+	infos,err := Snapshots(svc)
+	if err!=nil || len(infos)!=1 || infos[0].Name!="s1" { t.Fatalf("snapshots = %+v, %v",infos,err) }
+	if err = DeleteSnapshot(svc,"s1"); err!=nil { t.Fatal(err) }
+	if _,err = OpenSnapshot(svc,"s1"); err!=single.ENotFound { t.Fatalf("deleted snapshot: %v",err) }
+}
+
+// Replacing an object doesn't change the snapshot.
+func TestSnapshotReplace(t *testing.T) {
+	svc := testStore(t,Options{})
+	svc.PutObj([]byte("a"),[]byte("old"))
+	if _,err := CreateSnapshot(svc,"s"); err!=nil { t.Fatal(err) }
+	replace(t,svc,"a","new")
+	snap,err := OpenSnapshot(svc,"s")
+	if err!=nil { t.Fatal(err) }
+	if s := readAll(t,snap,"a"); s!="old" { t.Fatalf("a = %q",s) }
+}
+
+// Versioned snapshots keep the latest versions, except delete markers.
+func TestSnapshotVersioned(t *testing.T) {
+	svc := testStore(t,Options{Versioning:true})
+	vs := single.AsVersionSvc(svc)
+	vs.PutVersion([]byte("a"),[]byte("one"))
+	vs.PutVersion([]byte("a"),[]byte("two"))
+	vs.PutVersion([]byte("b"),[]byte("gone"))
+	vs.DeleteObj([]byte("b"))
+	if _,err := CreateSnapshot(svc,"s"); err!=nil { t.Fatal(err) }
+	vs.PutVersion([]byte("a"),[]byte("three"))
+	snap,err := OpenSnapshot(svc,"s")
+	if err!=nil { t.Fatal(err) }
+	if s := readAll(t,snap,"a"); s!="two" { t.Fatalf("a = %q",s) }
+	if names := listAll(t,snap,"",10); len(names)!=1 { t.Fatalf("names = %q",names) }
+}
+
+///
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"fmt"
+	"hash/crc32"
+)
+
+/*
+If checksums are enabled, every object file {path} has a sidecar file
+"{path}.sum", that contains the length of the content and it's crc32.
+The crc32 is extended in memory on every Append, thus the content doesn't need
+to be re-read, and the sidecar is written, when the store is closed or the
+intent log is checkpointed.
+
+After a crash, the sidecar may cover a prefix of the content only. The store
+drops such a sidecar, Fsck and Scrub verify the prefix. Sidecars are replaced
+atomically, thus a crash leaves the old or the new one.
+*/
+const sumExt = ".sum"
+
+func readSum(pth string) (lng int64,sum uint32,err error) {
+	data,err := os.ReadFile(pth+sumExt)
+	if err!=nil { return }
+	_,err = fmt.Sscanf(string(data),"%d %x",&lng,&sum)
+	return
+}
+func writeSum(pth string,lng int64,sum uint32) error {
+	tmp := pth+sumExt+verTemp
+	os.Remove(tmp)
+	return writeFileAtomic(tmp,pth+sumExt,[]byte(fmt.Sprintf("%d %08x\n",lng,sum)))
+}
+
+// Loads the checksum of s. Must be called before s is shared.
+func (s *singleFile) loadSum() {
+	s.sums = true
+	lng,sum,err := readSum(s.pth)
+	switch {
+	case err==nil && lng==s.l:
+		s.sum,s.sumok = sum,true
+	case os.IsNotExist(err) && s.l==0:
+		s.sum,s.sumok = 0,true
+	case !os.IsNotExist(err):
+		os.Remove(s.pth+sumExt)
+	}
+}
+
+// Extends the checksum by the newly written data. Must be called with s.am held.
+func (s *singleFile) updateSum(data []byte) {
+	if !s.sums || !s.sumok { return }
+	s.sum = crc32.Update(s.sum,crc32.IEEETable,data)
+	s.sumdirty = true
+}
+
+// Writes the sidecar, if the checksum changed since. A failed write is retried the next time.
+func (s *singleFile) flushSum() {
+	s.am.Lock(); defer s.am.Unlock()
+	if !s.sumdirty || !s.sumok || s.replaced { return }
+	if writeSum(s.pth,s.l,s.sum)==nil { s.sumdirty = false }
+}
+
+// Writes the changed sidecars of the open files.
+func (fs *multiFiles) flushSums() {
+	if !fs.sums { return }
+	fs.fm.Range(func(_,inst interface{}) bool {
+		inst.(*singleFile).flushSum()
+		return true
+	})
+}
+
+// Returns the length and checksum of the content, if known.
+func (s *singleFile) snapSum() (lng int64,sum uint32,ok bool) {
+	s.am.Lock(); defer s.am.Unlock()
+	return s.l,s.sum,s.sumok
+}
+
+// Returns the length and the checksum of the object. ok is false without Options.Checksums.
+func (fs *multiFiles) Checksum(objectId []byte) (lng int64,crc uint32,ok bool,err error) {
+	var sf *singleFile
+	if sf,err = fs.hlBorrowFile(objectId,0); err!=nil { return }
+	defer sf.Done()
+	lng,crc,ok = sf.snapSum()
+	return
+}
+// Like multiFiles.Checksum, for the latest version.
+func (vf *versionFiles) Checksum(objectId []byte) (lng int64,crc uint32,ok bool,err error) {
+	var sf *singleFile
+	if sf,err = vf.borrowVersion(objectId,0); err!=nil { return }
+	defer sf.Done()
+	lng,crc,ok = sf.snapSum()
+	return
+}
+
+///
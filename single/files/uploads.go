@@ -0,0 +1,325 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sort"
+	"time"
+	"bytes"
+	"strconv"
+	"strings"
+	"hash/crc32"
+	"sync/atomic"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+Multipart uploads are kept in the directory "uploads". Each upload has it's
+own directory, named after the upload id, that contains the object name in
+the file "name" and the parts as "{part}.part". Parts are written to a
+temporary file and renamed into place, thus re-uploading a part replaces it
+atomically.
+
+CompleteUpload and AbortUpload claim an upload by renaming it's directory to
+"{id}.claimed", which makes concurrent UploadPart calls fail. The sweep skips
+the claimed uploads, unless they were left behind by a crash. The parts are
+concatenated into "object.tmp" with io.Copy, which uses copy_file_range(2),
+thus the data doesn't pass through user space, and filesystems with reflink
+support share the extents instead of copying them. A single part is used as
+is. The result is linked into place, which fails, if the object exists, like
+PutObj does. The link is recorded in the intent log, like every mutation.
+*/
+const (
+	uplDir     = "uploads"
+	uplName    = "name"
+	uplPart    = ".part"
+	uplObject  = "object.tmp"
+	uplClaimed = ".claimed"
+)
+
+// Uploads, that weren't modified for this duration, are removed by default.
+const defaultUploadExpiry = 24*time.Hour
+
+func partFile(dir string,part int) string {
+	return filepath.Join(dir,fmt.Sprintf("%05d%s",part,uplPart))
+}
+
+// Upload ids are 32 lower-case hex digits. Anything else could escape the directory.
+func validUploadId(id string) bool {
+	if len(id)!=32 { return false }
+	for i := 0; i<len(id); i++ {
+		if c := id[i]; (c<'0' || c>'9') && (c<'a' || c>'f') { return false }
+	}
+	return true
+}
+
+func (fs *multiFiles) udir(uploadId string) string {
+	return filepath.Join(fs.dir,uplDir,uploadId)
+}
+func (fs *multiFiles) uploadExpiry() time.Duration {
+	if fs.uplexp>0 { return fs.uplexp }
+	return defaultUploadExpiry
+}
+
+// Returns the directory of the upload, if it exists and belongs to objectId.
+func (fs *multiFiles) openUpload(objectId []byte,uploadId string) (dir string,err error) {
+	if !validUploadId(uploadId) { return "",single.ENotFound }
+	dir = fs.udir(uploadId)
+	name,err := os.ReadFile(filepath.Join(dir,uplName))
+	if err!=nil { return "",translate(err) }
+	if !bytes.Equal(name,objectId) { return "",single.ENotFound }
+	return
+}
+
+// Claims the upload, see above. The caller owns the returned directory, until it calls releaseUpload.
+func (fs *multiFiles) claimUpload(objectId []byte,uploadId string) (dir string,err error) {
+	if dir,err = fs.openUpload(objectId,uploadId); err!=nil { return }
+	fs.uplclaims.Store(uploadId,nil)
+	if err = os.Rename(dir,dir+uplClaimed); err!=nil {
+		fs.uplclaims.Delete(uploadId)
+		return "",translate(err)
+	}
+	return dir+uplClaimed,nil
+}
+func (fs *multiFiles) releaseUpload(uploadId string) {
+	fs.uplclaims.Delete(uploadId)
+}
+
+// Removes stale uploads, at most once per quarter of the expiry.
+func (fs *multiFiles) sweepUploads() {
+	exp := fs.uploadExpiry()
+	now := time.Now()
+	last := atomic.LoadInt64(&fs.uplsweep)
+	if now.Sub(time.Unix(0,last))<exp/4 || !atomic.CompareAndSwapInt64(&fs.uplsweep,last,now.UnixNano()) { return }
+	
+	ents,err := os.ReadDir(filepath.Join(fs.dir,uplDir))
+	if err!=nil { return }
+	for _,ent := range ents {
+		id := ent.Name()
+		claimed := strings.HasSuffix(id,uplClaimed)
+		if claimed {
+			if _,ok := fs.uplclaims.Load(strings.TrimSuffix(id,uplClaimed)); ok { continue }
+		}
+		st,err := ent.Info()
+		if err!=nil || now.Sub(st.ModTime())<exp { continue }
+		dir := filepath.Join(fs.dir,uplDir,id)
+		name,nerr := os.ReadFile(filepath.Join(dir,uplName))
+		if os.RemoveAll(dir)!=nil { continue }
+		
+		// Claimed uploads, that were left by a crash, might have been completed.
+		if !claimed && nerr==nil && fs.uplexpired!=nil { fs.uplexpired(name,id) }
+	}
+}
+
+func (fs *multiFiles) CreateUpload(objectId []byte) (uploadId string,err error) {
+	go fs.sweepUploads()
+	var id [16]byte
+	if _,err = rand.Read(id[:]); err!=nil { return }
+	uploadId = hex.EncodeToString(id[:])
+	if err = os.MkdirAll(filepath.Join(fs.dir,uplDir),0777); err!=nil { return "",translate(err) }
+	dir := fs.udir(uploadId)
+	if err = os.Mkdir(dir,0777); err!=nil { return "",translate(err) }
+	if err = writeFileAtomic(filepath.Join(dir,uplName+verTemp),filepath.Join(dir,uplName),objectId); err!=nil {
+		os.RemoveAll(dir)
+		return "",err
+	}
+	return
+}
+
+func (fs *multiFiles) UploadPart(objectId []byte,uploadId string,part int,data []byte) (err error) {
+	if part<1 || part>single.MaxParts { return single.EInvalid }
+	dir,err := fs.openUpload(objectId,uploadId)
+	if err!=nil { return }
+	
+	// Every attempt gets it's own temporary file, as a part might be retried concurrently.
+	f,err := os.CreateTemp(dir,"*"+verTemp)
+	if err!=nil { return translate(err) }
+	_,err = f.Write(data)
+	if err==nil { err = f.Sync() }
+	if e := f.Close(); err==nil { err = e }
+	if err==nil { err = os.Rename(f.Name(),partFile(dir,part)) }
+	if err!=nil { os.Remove(f.Name()) }
+	return translate(err)
+}
+
+func listParts(dir string) (parts []single.PartInfo,err error) {
+	ents,err := os.ReadDir(dir)
+	if err!=nil { return nil,translate(err) }
+	for _,ent := range ents {
+		name := ent.Name()
+		if !strings.HasSuffix(name,uplPart) { continue }
+		n,perr := strconv.Atoi(strings.TrimSuffix(name,uplPart))
+		st,serr := ent.Info()
+		if perr!=nil || serr!=nil || n<1 || n>single.MaxParts { continue }
+		parts = append(parts,single.PartInfo{Part:n,Length:st.Size()})
+	}
+	sort.Slice(parts,func(i,j int) bool { return parts[i].Part<parts[j].Part })
+	return
+}
+
+func (fs *multiFiles) ListParts(objectId []byte,uploadId string) (parts []single.PartInfo,err error) {
+	dir,err := fs.openUpload(objectId,uploadId)
+	if err!=nil { return }
+	return listParts(dir)
+}
+
+func (fs *multiFiles) AbortUpload(objectId []byte,uploadId string) (err error) {
+	dir,err := fs.claimUpload(objectId,uploadId)
+	if err!=nil { return }
+	defer fs.releaseUpload(uploadId)
+	return translate(os.RemoveAll(dir))
+}
+
+// Concatenates the parts within dir. Returns the resulting file, it's length and crc32, if checksums or the intent log are enabled.
+func (fs *multiFiles) assemble(dir string,parts []int) (pth string,lng int64,sum uint32,err error) {
+	if parts==nil {
+		var infos []single.PartInfo
+		if infos,err = listParts(dir); err!=nil { return }
+		for _,pi := range infos { parts = append(parts,pi.Part) }
+	}
+	if len(parts)==0 { err = single.EInvalid; return }
+	for i,n := range parts {
+		if n<1 || n>single.MaxParts || (i>0 && n<=parts[i-1]) { err = single.EInvalid; return }
+	}
+	
+	var f *os.File
+	if len(parts)==1 {
+		pth = partFile(dir,parts[0])
+		f,err = os.Open(pth)
+	} else {
+		pth = filepath.Join(dir,uplObject)
+		f,err = os.OpenFile(pth,os.O_RDWR|os.O_CREATE|os.O_TRUNC,0666)
+		for _,n := range parts {
+			if err!=nil { break }
+			var src *os.File
+			if src,err = os.Open(partFile(dir,n)); err!=nil { break }
+			_,err = io.Copy(f,src)
+			src.Close()
+		}
+		if err==nil { err = f.Sync() }
+	}
+	if os.IsNotExist(err) { err = single.EInvalid } // A part is missing.
+	if err!=nil {
+		if f!=nil { f.Close() }
+		return "",0,0,translate(err)
+	}
+	defer f.Close()
+	
+	var st os.FileInfo
+	if st,err = f.Stat(); err!=nil { return "",0,0,translate(err) }
+	lng = st.Size()
+	if fs.sums || fs.wl!=nil {
+		h := crc32.NewIEEE()
+		if _,err = io.Copy(h,io.NewSectionReader(f,0,lng)); err!=nil { return "",0,0,translate(err) }
+		sum = h.Sum32()
+	}
+	return
+}
+
+/*
+Claims and assembles the upload, and passes the result to publish. If anything
+fails, the upload is released again, thus CompleteUpload can be retried, and
+publish must undo it's changes.
+*/
+func (fs *multiFiles) complete(objectId []byte,uploadId string,parts []int,publish func(pth string,lng int64,sum uint32) error) (err error) {
+	dir,err := fs.claimUpload(objectId,uploadId)
+	if err!=nil { return }
+	defer fs.releaseUpload(uploadId)
+	defer func() {
+		if err!=nil {
+			os.Remove(filepath.Join(dir,uplObject))
+			os.Rename(dir,strings.TrimSuffix(dir,uplClaimed))
+		} else {
+			os.RemoveAll(dir)
+		}
+	}()
+	pth,lng,sum,err := fs.assemble(dir,parts)
+	if err!=nil { return }
+	return publish(pth,lng,sum)
+}
+
+func (fs *multiFiles) CompleteUpload(objectId []byte,uploadId string,parts []int) (ver single.Version,err error) {
+	// The parts are assembled without the locks, as it might take a while.
+	err = fs.complete(objectId,uploadId,parts,func(pth string,lng int64,sum uint32) (err error) {
+		fs.ql.RLock(); defer fs.ql.RUnlock()
+		fs.rl.RLock(); defer fs.rl.RUnlock()
+		path,_ := fs.path(objectId)
+		if _,ok := fs.fme.Load(path); ok { return single.EBeingDeleted }
+		if _,err = os.Lstat(path); err==nil { return single.EExist }
+		if err = fs.initRetention(objectId); err!=nil { return }
+		done,err := fs.logIntent(walCreate,path,lng,sum)
+		if err!=nil { return }
+		defer done()
+		if err = os.Link(pth,path); err!=nil { return translate(err) }
+		if err = syncDir(fs.dir); err!=nil {
+			os.Remove(path)
+			return translate(err)
+		}
+		fs.ix.add(objectId)
+		if fs.sums { writeSum(path,lng,sum) }
+		return
+	})
+	if err==nil { fs.wake(objectId) }
+	return
+}
+
+func (vf *versionFiles) CompleteUpload(objectId []byte,uploadId string,parts []int) (ver single.Version,err error) {
+	err = vf.complete(objectId,uploadId,parts,func(pth string,lng int64,sum uint32) (err error) {
+		vf.ql.RLock(); defer vf.ql.RUnlock()
+		vf.rl.RLock(); defer vf.rl.RUnlock()
+		if err = vf.checkRetained(objectId); err!=nil { return }
+		dir := vf.vdir(objectId)
+		if err = os.MkdirAll(dir,0777); err!=nil { return translate(err) }
+		vf.ix.add(objectId)
+		var first bool
+		ver,first = vf.alloc(dir)
+		if first {
+			if err = vf.initRetention(objectId); err!=nil { return }
+		}
+		vpth := vfile(dir,ver,verData)
+		done,err := vf.logIntent(walCreate,vpth,lng,sum)
+		if err!=nil { return }
+		defer done()
+		if err = os.Rename(pth,vpth); err!=nil { return translate(err) }
+		if err = syncDir(dir); err!=nil {
+			// The part might be moved, thus move it back.
+			os.Rename(vpth,pth)
+			return translate(err)
+		}
+		if vf.sums { writeSum(vpth,lng,sum) }
+		return
+	})
+	if err==nil { vf.wake(objectId) }
+	return
+}
+
+///
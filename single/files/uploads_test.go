@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"time"
+	"testing"
+	"sync/atomic"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// Creates an upload of name with the parts.
+func testUpload(t *testing.T,svc single.ObjectSvc,name string,parts ...string) string {
+	t.Helper()
+	us := single.AsUploadSvc(svc)
+	id,err := us.CreateUpload([]byte(name))
+	if err!=nil { t.Fatal(err) }
+	for i,p := range parts {
+		if err = us.UploadPart([]byte(name),id,i+1,[]byte(p)); err!=nil { t.Fatal(err) }
+	}
+	return id
+}
+
+func TestUploadComplete(t *testing.T) {
+	for _,versioning := range []bool{false,true} {
+		svc := testStore(t,Options{Versioning:versioning,Checksums:true})
+		us := single.AsUploadSvc(svc)
+		id := testUpload(t,svc,"a","one","two","three")
+		
+		// Parts can be replaced, and a subset can be completed.
+		if err := us.UploadPart([]byte("a"),id,2,[]byte("TWO")); err!=nil { t.Fatal(err) }
+		if _,err := us.CompleteUpload([]byte("a"),id,[]int{1,2}); err!=nil { t.Fatal(err) }
+		if s := readAll(t,svc,"a"); s!="oneTWO" { t.Fatalf("versioning=%v: a = %q",versioning,s) }
+		if _,err := us.ListParts([]byte("a"),id); err!=single.ENotFound { t.Fatalf("upload after completion: %v",err) }
+		
+		// A single part is used as is.
+		id = testUpload(t,svc,"b","single")
+		if _,err := us.CompleteUpload([]byte("b"),id,nil); err!=nil { t.Fatal(err) }
+		if s := readAll(t,svc,"b"); s!="single" { t.Fatalf("versioning=%v: b = %q",versioning,s) }
+	}
+}
+
+// A failed completion keeps the upload, thus it can be retried.
+func TestUploadRetry(t *testing.T) {
+	svc := testStore(t,Options{})
+	us := single.AsUploadSvc(svc)
+	id := testUpload(t,svc,"a","one","two")
+	if _,err := us.CompleteUpload([]byte("a"),id,[]int{1,3}); err!=single.EInvalid { t.Fatalf("missing part: %v",err) }
+	if err := svc.PutObj([]byte("a"),[]byte("x")); err!=nil { t.Fatal(err) }
+	if _,err := us.CompleteUpload([]byte("a"),id,nil); err!=single.EExist { t.Fatalf("existing object: %v",err) }
+	if s := readAll(t,svc,"a"); s!="x" { t.Fatalf("a = %q",s) }
+	
+	if err := svc.DeleteObj([]byte("a")); err!=nil { t.Fatal(err) }
+	if _,err := us.CompleteUpload([]byte("a"),id,nil); err!=nil { t.Fatal(err) }
+	if s := readAll(t,svc,"a"); s!="onetwo" { t.Fatalf("a = %q",s) }
+}
+
+func TestUploadAbort(t *testing.T) {
+	svc := testStore(t,Options{})
+	us := single.AsUploadSvc(svc)
+	id := testUpload(t,svc,"a","one")
+	if err := us.AbortUpload([]byte("b"),id); err!=single.ENotFound { t.Fatalf("abort of another object: %v",err) }
+	if err := us.AbortUpload([]byte("a"),id); err!=nil { t.Fatal(err) }
+	if err := us.UploadPart([]byte("a"),id,1,nil); err!=single.ENotFound { t.Fatalf("part after abort: %v",err) }
+	if _,err := svc.Info([]byte("a")); err!=single.ENotFound { t.Fatalf("object after abort: %v",err) }
+}
+
+// The sweep removes expired uploads, but not the claimed ones.
+func TestUploadSweep(t *testing.T) {
+	var names []string
+	svc := testStore(t,Options{UploadExpiry:time.Minute,UploadExpired:func(objectId []byte,uploadId string) {
+		names = append(names,string(objectId))
+	}})
+	fs := filesOf(svc)
+	old := time.Now().Add(-time.Hour)
+	expired := testUpload(t,svc,"a","one")
+	claimed := testUpload(t,svc,"b","two")
+	crashed := testUpload(t,svc,"c","three")
+	dir,err := fs.claimUpload([]byte("b"),claimed)
+	if err!=nil { t.Fatal(err) }
+	if err = os.Rename(fs.udir(crashed),fs.udir(crashed)+uplClaimed); err!=nil { t.Fatal(err) }
+	for _,d := range []string{fs.udir(expired),dir,fs.udir(crashed)+uplClaimed} {
+		if err = os.Chtimes(d,old,old); err!=nil { t.Fatal(err) }
+	}
+	
+	// Wait for the sweep of CreateUpload.
+	for atomic.LoadInt64(&fs.uplsweep)==0 { time.Sleep(time.Millisecond) }
+	atomic.StoreInt64(&fs.uplsweep,0)
+	fs.sweepUploads()
+	ents,err := os.ReadDir(filepath.Join(fs.dir,uplDir))
+	if err!=nil { t.Fatal(err) }
+	if len(ents)!=1 || ents[0].Name()!=claimed+uplClaimed { t.Fatalf("uploads after the sweep: %v",ents) }
+	if len(names)!=1 || names[0]!="a" { t.Fatalf("expired uploads %q",names) }
+	fs.releaseUpload(claimed)
+}
+
+// Completing an upload wakes the followers of the object.
+func TestUploadWakes(t *testing.T) {
+	svc := testStore(t,Options{})
+	fs := filesOf(svc)
+	id := testUpload(t,svc,"a","one")
+	ch := fs.wakeChan([]byte("a"))
+	if _,err := fs.CompleteUpload([]byte("a"),id,nil); err!=nil { t.Fatal(err) }
+	select {
+	case <-ch:
+	default: t.Fatal("not woken")
+	}
+}
+
+///
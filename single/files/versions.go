@@ -0,0 +1,301 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"sort"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"strings"
+	"hash/crc32"
+	"path/filepath"
+	
+	"unsafe"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+A versioned store keeps every version of an object in it's own directory,
+"ver-{name}". Each version is a file named after it's version id, with the
+extension ".bin" for content and ".del" for delete markers. Versions are
+written to a ".tmp" file first and renamed into place, once complete.
+
+Version ids are derived from the wall clock, but are strictly increasing.
+*/
+const (
+	verData = ".bin"
+	verMark = ".del"
+	verTemp = ".tmp"
+)
+
+func vfile(dir string,ver single.Version,ext string) string {
+	return filepath.Join(dir,ver.String()+ext)
+}
+
+// Lists all versions within dir in ascending order. The lengths are not filled in.
+func listVersions(dir string) (vers []single.VersionInfo,err error) {
+	ents,err := os.ReadDir(dir)
+	if err!=nil { return nil,translate(err) }
+	for _,ent := range ents {
+		name := ent.Name()
+		ext := filepath.Ext(name)
+		if ext!=verData && ext!=verMark { continue }
+		ver,perr := single.ParseVersion([]byte(strings.TrimSuffix(name,ext)))
+		if perr!=nil || ver==0 { continue }
+		vers = append(vers,single.VersionInfo{Version:ver,Deleted:ext==verMark})
+	}
+	sort.Slice(vers,func(i,j int) bool { return vers[i].Version<vers[j].Version })
+	return
+}
+func latestVersion(dir string) (vi single.VersionInfo,err error) {
+	var vers []single.VersionInfo
+	if vers,err = listVersions(dir); err!=nil { return }
+	if len(vers)==0 { err = single.ENotFound; return }
+	vi = vers[len(vers)-1]
+	return
+}
+
+// Writes data to tmp and renames it to path. The rename is synced as well.
+func writeFileAtomic(tmp,path string,data []byte) (err error) {
+	f,err := os.OpenFile(tmp,os.O_WRONLY|os.O_CREATE|os.O_EXCL,0666)
+	if err!=nil { return translate(err) }
+	_,err = f.Write(data)
+	if err==nil { err = f.Sync() }
+	if e := f.Close(); err==nil { err = e }
+	if err==nil { err = os.Rename(tmp,path) }
+	if err!=nil { os.Remove(tmp) }
+	if err==nil { err = syncDir(filepath.Dir(path)) }
+	return translate(err)
+}
+
+// Syncs the directory, thus the creation, renaming or removal of it's entries is durable.
+func syncDir(dir string) error {
+	d,err := os.Open(dir)
+	if err!=nil { return err }
+	err = d.Sync()
+	if e := d.Close(); err==nil { err = e }
+	return err
+}
+
+type versionFiles struct {
+	*multiFiles
+	
+	// The last allocated version id.
+	last uint64
+	
+	// Serializes the creation of first versions by Append.
+	vl sync.Mutex
+}
+func (vf *versionFiles) vdir(name []byte) string {
+	return filepath.Join(vf.dir,"ver-"+string(name))
+}
+// Allocates a new version id. first is true, if dir contains no versions yet.
+func (vf *versionFiles) alloc(dir string) (ver single.Version,first bool) {
+	min := uint64(time.Now().UnixNano())
+	
+	// Don't go backwards, if the clock did.
+	vi,err := latestVersion(dir)
+	if err==nil && uint64(vi.Version)>=min {
+		min = uint64(vi.Version)+1
+	}
+	first = err==single.ENotFound
+	for {
+		l := atomic.LoadUint64(&vf.last)
+		v := min
+		if v<=l { v = l+1 }
+		if atomic.CompareAndSwapUint64(&vf.last,l,v) { return single.Version(v),first }
+	}
+}
+func (vf *versionFiles) borrowVersion(objectId []byte,ver single.Version) (sf *singleFile,err error) {
+	dir := vf.vdir(objectId)
+	if ver==0 {
+		var vi single.VersionInfo
+		if vi,err = latestVersion(dir); err!=nil { return }
+		if vi.Deleted { return nil,single.ENotFound }
+		ver = vi.Version
+	}
+	return vf.borrowFile(objectId,vfile(dir,ver,verData),0)
+}
+
+func (vf *versionFiles) Stat(objectId []byte) (st single.ObjectStat,err error) {
+	var sf *singleFile
+	if sf,err = vf.borrowVersion(objectId,0); err!=nil { return }
+	defer sf.Done()
+	return sf.stat()
+}
+
+func (vf *versionFiles) PutVersion(objectId []byte,data []byte) (ver single.Version,err error) {
+	vf.ql.RLock(); defer vf.ql.RUnlock()
+	vf.rl.RLock(); defer vf.rl.RUnlock()
+	return vf.putVersion(objectId,data)
+}
+// Must be called with vf.rl held.
+func (vf *versionFiles) putVersion(objectId []byte,data []byte) (ver single.Version,err error) {
+	if err = vf.checkRetained(objectId); err!=nil { return }
+	dir := vf.vdir(objectId)
+	if err = os.MkdirAll(dir,0777); err!=nil { return 0,translate(err) }
+	vf.ix.add(objectId)
+	ver,first := vf.alloc(dir)
+	if first {
+		if err = vf.initRetention(objectId); err!=nil { return }
+	}
+	pth := vfile(dir,ver,verData)
+	crc := crc32.ChecksumIEEE(data)
+	done,err := vf.logIntent(walCreate,pth,int64(len(data)),crc)
+	if err!=nil { return }
+	crashPoint("intent",pth)
+	err = writeFileAtomic(vfile(dir,ver,verTemp),pth,data)
+	crashPoint("written",pth)
+	done()
+	if err!=nil { return }
+	if vf.sums { writeSum(pth,int64(len(data)),crc) }
+	vf.wake(objectId)
+	return
+}
+func (vf *versionFiles) PutObj(objectId []byte,data []byte) (err error) {
+	_,err = vf.PutVersion(objectId,data)
+	return
+}
+func (vf *versionFiles) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	vf.ql.RLock(); defer vf.ql.RUnlock()
+	vf.rl.RLock(); defer vf.rl.RUnlock()
+	if err = vf.checkRetained(objectId); err!=nil { return }
+	dir := vf.vdir(objectId)
+	var vi single.VersionInfo
+	for {
+		vf.vl.Lock()
+		if vi,err = latestVersion(dir); err!=nil || vi.Deleted { break }
+		vf.vl.Unlock()
+		
+		// If a version was put meanwhile, append to that one.
+		if pos,err = vf.appendLatest(objectId,dir,vi.Version,data); err!=errSuperseded { return }
+	}
+	defer vf.vl.Unlock()
+	if err!=nil && err!=single.ENotFound { return }
+	
+	// There is no live version, so the appended data becomes a new one.
+	if _,err = vf.putVersion(objectId,data); err!=nil { return }
+	pos[1] = int64(len(data))
+	return
+}
+
+// Returned by appendLatest, if the version isn't the latest one anymore.
+var errSuperseded = errors.New("superseded")
+
+// Appends to the version ver, if it's still the latest one, when the data is written.
+func (vf *versionFiles) appendLatest(objectId []byte,dir string,ver single.Version,data []byte) (pos single.ByteRange,err error) {
+	var sf *singleFile
+	if sf,err = vf.borrowFile(objectId,vfile(dir,ver,verData),0); err!=nil {
+		if err==single.ENotFound { err = errSuperseded } // Removed meanwhile.
+		return
+	}
+	defer sf.Done()
+	pos,err = sf.appendIf(data,func() error {
+		if vi,err := latestVersion(dir); err!=nil || vi.Version!=ver || vi.Deleted { return errSuperseded }
+		return nil
+	})
+	if err==nil { vf.wake(objectId) }
+	return
+}
+func (vf *versionFiles) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return vf.ReadVersionTo(objectId,0,pos,ops.GetBodyBuffer(dst))
+}
+func (vf *versionFiles) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	return vf.ReadVersionTo(objectId,0,pos,sink)
+}
+func (vf *versionFiles) ReadVersion(objectId []byte,ver single.Version,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return vf.ReadVersionTo(objectId,ver,pos,ops.GetBodyBuffer(dst))
+}
+func (vf *versionFiles) ReadVersionTo(objectId []byte,ver single.Version,pos single.ByteRange,sink single.Sink) (err error) {
+	var sf *singleFile
+	var handed bool
+	if sf,err = vf.borrowVersion(objectId,ver); err!=nil { return }
+	if handed,err = sf.readTo(pos,sink); !handed { sf.Done() }
+	return
+}
+func (vf *versionFiles) Info(objectId []byte) (lng int64,err error) {
+	return vf.InfoVersion(objectId,0)
+}
+func (vf *versionFiles) InfoVersion(objectId []byte,ver single.Version) (lng int64,err error) {
+	var sf *singleFile
+	if sf,err = vf.borrowVersion(objectId,ver); err!=nil { return }
+	defer sf.Done()
+	lng = sf.length()
+	return
+}
+
+// Writes a delete marker. The older versions are kept.
+func (vf *versionFiles) DeleteObj(objectId []byte) (err error) {
+	vf.ql.RLock(); defer vf.ql.RUnlock()
+	vf.rl.RLock(); defer vf.rl.RUnlock()
+	if err = vf.checkRetained(objectId); err!=nil { return }
+	dir := vf.vdir(objectId)
+	vi,err := latestVersion(dir)
+	if err!=nil { return }
+	if vi.Deleted { return single.ENotFound }
+	ver,_ := vf.alloc(dir)
+	pth := vfile(dir,ver,verMark)
+	done,err := vf.logIntent(walCreate,pth,0,0)
+	if err!=nil { return }
+	err = writeFileAtomic(vfile(dir,ver,verTemp),pth,nil)
+	done()
+	if err==nil { vf.wake(objectId) }
+	return
+}
+func (vf *versionFiles) ListVersions(objectId []byte) (vers []single.VersionInfo,err error) {
+	dir := vf.vdir(objectId)
+	if vers,err = listVersions(dir); err!=nil { return }
+	for i := range vers {
+		if vers[i].Deleted { continue }
+		if st,serr := os.Stat(vfile(dir,vers[i].Version,verData)); serr==nil { vers[i].Length = st.Size() }
+	}
+	return
+}
+
+// Removes a version or a delete marker permanently.
+//
+// The directory is not removed, even if it became empty, since that would
+// race with concurrent PutVersion calls.
+func (vf *versionFiles) DeleteVersion(objectId []byte,ver single.Version) (err error) {
+	vf.ql.RLock(); defer vf.ql.RUnlock()
+	vf.rl.RLock(); defer vf.rl.RUnlock()
+	if ver==0 { return single.ENotFound }
+	if err = vf.checkRetained(objectId); err!=nil { return }
+	dir := vf.vdir(objectId)
+	_,err = vf.deleteFile(vfile(dir,ver,verData))
+	if err==single.ENotFound { _,err = vf.deleteFile(vfile(dir,ver,verMark)) }
+	if err!=nil { return }
+	vf.wake(objectId)
+	
+	// The last version is gone, so is the object.
+	if _,lerr := latestVersion(dir); lerr==single.ENotFound { vf.clearRetention(objectId) }
+	return
+}
+
+///
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"bytes"
+	"testing"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+// Opens a store in a temporary directory, that is closed at the end of the test.
+func testStore(t *testing.T,opts Options) single.ObjectSvc {
+	t.Helper()
+	svc,err := ServeFileOpts(t.TempDir(),opts)
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() { closeSvc(svc) })
+	return svc
+}
+func closeSvc(svc single.ObjectSvc) {
+	if fs := filesOf(svc); fs!=nil { fs.Close() }
+}
+
+// Reads the whole object, or fails the test.
+func readAll(t *testing.T,svc single.ObjectSvc,name string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := single.ReadTo(svc,[]byte(name),single.ByteRange{},&buf); err!=nil { t.Fatalf("read %s: %v",name,err) }
+	return buf.String()
+}
+func readVersion(t *testing.T,vs single.VersionSvc,name string,ver single.Version) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := single.ReadVersionTo(vs,[]byte(name),ver,single.ByteRange{},&buf); err!=nil { t.Fatalf("read %s@%v: %v",name,ver,err) }
+	return buf.String()
+}
+
+func TestVersionsPutOverwrites(t *testing.T) {
+	vs := single.AsVersionSvc(testStore(t,Options{Versioning:true}))
+	name := []byte("a")
+	v1,err := vs.PutVersion(name,[]byte("one"))
+	if err!=nil { t.Fatal(err) }
+	v2,err := vs.PutVersion(name,[]byte("two"))
+	if err!=nil { t.Fatal(err) }
+	if v2<=v1 { t.Fatalf("versions not increasing: %v, %v",v1,v2) }
+	if s := readAll(t,vs,"a"); s!="two" { t.Fatalf("latest = %q",s) }
+	if s := readVersion(t,vs,"a",v1); s!="one" { t.Fatalf("v1 = %q",s) }
+	
+	vers,err := vs.ListVersions(name)
+	if err!=nil { t.Fatal(err) }
+	if len(vers)!=2 || vers[0].Version!=v1 || vers[1].Version!=v2 || vers[0].Length!=3 { t.Fatalf("versions = %+v",vers) }
+}
+
+func TestVersionsDeleteMarker(t *testing.T) {
+	vs := single.AsVersionSvc(testStore(t,Options{Versioning:true}))
+	name := []byte("a")
+	v1,err := vs.PutVersion(name,[]byte("one"))
+	if err!=nil { t.Fatal(err) }
+	if err = vs.DeleteObj(name); err!=nil { t.Fatal(err) }
+	if _,err = vs.Info(name); err!=single.ENotFound { t.Fatalf("Info after delete: %v",err) }
+	if err = vs.DeleteObj(name); err!=single.ENotFound { t.Fatalf("second delete: %v",err) }
+	if s := readVersion(t,vs,"a",v1); s!="one" { t.Fatalf("v1 = %q",s) }
+	
+	names,err := single.AsListSvc(vs).ListObjs(nil,nil,0)
+	if err!=nil { t.Fatal(err) }
+	if len(names)!=0 { t.Fatalf("deleted object listed: %q",names) }
+	
+	vers,err := vs.ListVersions(name)
+	if err!=nil { t.Fatal(err) }
+	if len(vers)!=2 || !vers[1].Deleted { t.Fatalf("versions = %+v",vers) }
+	
+	// Removing the marker brings the object back.
+	if err = vs.DeleteVersion(name,vers[1].Version); err!=nil { t.Fatal(err) }
+	if s := readAll(t,vs,"a"); s!="one" { t.Fatalf("after removing the marker = %q",s) }
+}
+
+func TestVersionsAppend(t *testing.T) {
+	vs := single.AsVersionSvc(testStore(t,Options{Versioning:true}))
+	name := []byte("a")
+	pos,err := vs.Append(name,[]byte("ab"))
+	if err!=nil || pos!=(single.ByteRange{0,2}) { t.Fatalf("first append: %v %v",pos,err) }
+	if pos,err = vs.Append(name,[]byte("cd")); err!=nil || pos!=(single.ByteRange{2,2}) { t.Fatalf("second append: %v %v",pos,err) }
+	if s := readAll(t,vs,"a"); s!="abcd" { t.Fatalf("content = %q",s) }
+	
+	// Appends extend the latest version, rather than creating new ones.
+	vers,err := vs.ListVersions(name)
+	if err!=nil || len(vers)!=1 { t.Fatalf("versions = %+v %v",vers,err) }
+}
+
+// An Append, that raced with a PutVersion, goes to the new version.
+func TestVersionsAppendSuperseded(t *testing.T) {
+	svc := testStore(t,Options{Versioning:true})
+	vf := svc.(*versionFiles)
+	name := []byte("a")
+	v1,_ := vf.PutVersion(name,[]byte("one"))
+	
+	// As if the PutVersion happened after Append looked up the latest version.
+	v2,_ := vf.PutVersion(name,[]byte("two"))
+	if _,err := vf.appendLatest(name,vf.vdir(name),v1,[]byte("!")); err!=errSuperseded { t.Fatalf("append to v1: %v",err) }
+	if s := readVersion(t,vf,"a",v1); s!="one" { t.Fatalf("v1 = %q",s) }
+	if _,err := vf.Append(name,[]byte("!")); err!=nil { t.Fatal(err) }
+	if s := readVersion(t,vf,"a",v2); s!="two!" { t.Fatalf("v2 = %q",s) }
+}
+
+func TestVersionsDeleteVersion(t *testing.T) {
+	vs := single.AsVersionSvc(testStore(t,Options{Versioning:true}))
+	name := []byte("a")
+	v1,_ := vs.PutVersion(name,[]byte("one"))
+	v2,_ := vs.PutVersion(name,[]byte("two"))
+	if err := vs.DeleteVersion(name,v2); err!=nil { t.Fatal(err) }
+	if s := readAll(t,vs,"a"); s!="one" { t.Fatalf("latest = %q",s) }
+	if err := vs.DeleteVersion(name,v2); err!=single.ENotFound { t.Fatalf("deleting twice: %v",err) }
+	if err := vs.DeleteVersion(name,v1); err!=nil { t.Fatal(err) }
+	if _,err := vs.Info(name); err!=single.ENotFound { t.Fatalf("Info: %v",err) }
+}
+
+///
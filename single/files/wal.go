@@ -0,0 +1,288 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"bytes"
+	"strings"
+	"strconv"
+	"hash/crc32"
+	"path/filepath"
+)
+
+/*
+The intent log records every mutation of an object file, before it is
+performed. An intent is followed by a done-record, once the mutation is
+finished. Intents without a done-record are replayed by Recover.
+
+Each record is a single line:
+
+	{op} {seq} {offset} {length} {crc32} {quoted path, relative to dir}
+	D {seq}
+
+The crc32 covers the data, that was to be written, thus Recover can tell
+complete writes from torn ones.
+
+Every mutation of an object file records an intent, including the creation
+of versions and the completion of multipart uploads. Done-records are not
+synced, except for deletions, since the next intent syncs them: A finished
+intent is only replayed, if no object was mutated afterwards, thus the file
+it refers to is still the one, that was written. Replaying a deletion, after
+the object was recreated, would remove the new object, so deletions sync
+their done-record.
+*/
+const walName = "intent.log"
+
+const (
+	walCreate  = 'C'
+	walAppend  = 'A'
+	walDelete  = 'R'
+	walReplace = 'P'
+	walDone    = 'D'
+)
+
+type walRec struct{
+	op  byte
+	seq uint64
+	off int64
+	lng int64
+	crc uint32
+	rel string
+}
+func (r walRec) appendTo(dst []byte) []byte {
+	dst = append(dst,r.op,' ')
+	dst = strconv.AppendUint(dst,r.seq,10)
+	if r.op!=walDone {
+		dst = append(dst,' ')
+		dst = strconv.AppendInt(dst,r.off,10)
+		dst = append(dst,' ')
+		dst = strconv.AppendInt(dst,r.lng,10)
+		dst = append(dst,' ')
+		dst = strconv.AppendUint(dst,uint64(r.crc),16)
+		dst = append(dst,' ')
+		dst = strconv.AppendQuote(dst,r.rel)
+	}
+	return append(dst,'\n')
+}
+func parseWalRec(line string) (r walRec,ok bool) {
+	f := strings.SplitN(line," ",6)
+	if len(f)<2 || len(f[0])!=1 { return }
+	r.op = f[0][0]
+	var err error
+	if r.seq,err = strconv.ParseUint(f[1],10,64); err!=nil { return }
+	if r.op==walDone { return r,len(f)==2 }
+	if len(f)!=6 { return }
+	if r.off,err = strconv.ParseInt(f[2],10,64); err!=nil { return }
+	if r.lng,err = strconv.ParseInt(f[3],10,64); err!=nil { return }
+	crc,err := strconv.ParseUint(f[4],16,32)
+	if err!=nil { return }
+	r.crc = uint32(crc)
+	if r.rel,err = strconv.Unquote(f[5]); err!=nil { return }
+	switch r.op {
+	case walCreate,walAppend,walDelete,walReplace: ok = true
+	}
+	return
+}
+
+// Returns the intents without a done-record, in log order.
+func readWal(pth string) (recs []walRec,err error) {
+	data,err := os.ReadFile(pth)
+	if os.IsNotExist(err) { return nil,nil }
+	if err!=nil { return nil,translate(err) }
+	
+	// An incomplete last line is a torn intent, whose mutation never started.
+	if i := bytes.LastIndexByte(data,'\n'); i>=0 { data = data[:i+1] } else { data = nil }
+	
+	pending := make(map[uint64]walRec)
+	for _,line := range strings.Split(string(data),"\n") {
+		r,ok := parseWalRec(line)
+		if !ok { continue }
+		if r.op==walDone {
+			delete(pending,r.seq)
+		} else {
+			pending[r.seq] = r
+		}
+	}
+	for _,r := range pending { recs = append(recs,r) }
+	sort.Slice(recs,func(i,j int) bool { return recs[i].seq<recs[j].seq })
+	return
+}
+
+// Returns true, if the range [off,off+lng) of the file matches crc.
+func walVerify(pth string,off,lng int64,crc uint32) bool {
+	f,err := os.Open(pth)
+	if err!=nil { return false }
+	defer f.Close()
+	h := crc32.NewIEEE()
+	n,err := io.Copy(h,io.NewSectionReader(f,off,lng))
+	return err==nil && n==lng && h.Sum32()==crc
+}
+func walTruncate(pth string,off int64) error {
+	st,err := os.Stat(pth)
+	if os.IsNotExist(err) { return nil }
+	if err!=nil { return err }
+	if st.Size()<=off { return nil }
+	return os.Truncate(pth,off)
+}
+func walRemove(pth string) error {
+	err := os.Remove(pth)
+	if os.IsNotExist(err) { return nil }
+	return err
+}
+
+/*
+Replays the intent log of the store in dir: Torn appends are truncated,
+incomplete PutObj calls are rolled back and interrupted deletions are
+finished. Replacements aren't replayed, since renaming is atomic. Afterwards,
+the intent log is removed.
+
+Recover must not be called on a store, that is in use.
+*/
+func Recover(dir string) (err error) {
+	pth := filepath.Join(dir,walName)
+	recs,err := readWal(pth)
+	if err!=nil { return }
+	for _,r := range recs {
+		obj := filepath.Join(dir,r.rel)
+		switch r.op {
+		case walAppend:
+			if !walVerify(obj,r.off,r.lng,r.crc) { err = walTruncate(obj,r.off) }
+		case walCreate:
+			if !walVerify(obj,r.off,r.lng,r.crc) { err = walRemove(obj) }
+		case walDelete:
+			err = walRemove(obj)
+		}
+		if err!=nil { return translate(err) }
+	}
+	return translate(walRemove(pth))
+}
+
+type wal struct{
+	dir string
+	
+	mu sync.Mutex
+	f  *os.File
+	seq uint64
+	pending map[uint64]walRec
+	
+	quit   chan struct{}
+	closed sync.Once
+}
+
+// Recovers the store in dir and starts a new intent log.
+func openWal(dir string) (w *wal,err error) {
+	if err = Recover(dir); err!=nil { return }
+	w = &wal{dir:dir,pending:make(map[uint64]walRec),quit:make(chan struct{})}
+	w.f,err = os.OpenFile(filepath.Join(dir,walName),os.O_WRONLY|os.O_CREATE|os.O_APPEND,0666)
+	if err!=nil { return nil,translate(err) }
+	return
+}
+func (w *wal) rel(pth string) string {
+	rel,err := filepath.Rel(w.dir,pth)
+	if err!=nil { return pth }
+	return rel
+}
+
+// Durably records an intent. The caller must call done(seq), once finished.
+func (w *wal) intent(op byte,pth string,off int64,data []byte) (seq uint64,err error) {
+	return w.intentSum(op,pth,off,int64(len(data)),crc32.ChecksumIEEE(data))
+}
+// Like intent, for data of length lng with the checksum crc.
+func (w *wal) intentSum(op byte,pth string,off,lng int64,crc uint32) (seq uint64,err error) {
+	r := walRec{op:op,off:off,lng:lng,crc:crc,rel:w.rel(pth)}
+	w.mu.Lock(); defer w.mu.Unlock()
+	w.seq++
+	r.seq = w.seq
+	if _,err = w.f.Write(r.appendTo(make([]byte,0,64+len(r.rel)))); err==nil { err = w.f.Sync() }
+	if err!=nil { return 0,translate(err) }
+	w.pending[r.seq] = r
+	return r.seq,nil
+}
+
+// Records the completion of an intent. Only the done-records of deletions are synced, see above.
+func (w *wal) done(seq uint64) {
+	w.mu.Lock(); defer w.mu.Unlock()
+	r := w.pending[seq]
+	delete(w.pending,seq)
+	if _,err := w.f.Write(walRec{op:walDone,seq:seq}.appendTo(make([]byte,0,24))); err==nil && r.op==walDelete { w.f.Sync() }
+}
+
+// Called at the crash points of mutations, with the path of the object file.
+// Tests use it to kill the process at these points, see wal_test.go.
+var crashHook func(point,pth string)
+
+func crashPoint(point,pth string) {
+	if crashHook!=nil { crashHook(point,pth) }
+}
+
+// Rewrites the intent log, dropping all finished intents.
+func (w *wal) Checkpoint() (err error) {
+	w.mu.Lock(); defer w.mu.Unlock()
+	pth := filepath.Join(w.dir,walName)
+	recs := make([]walRec,0,len(w.pending))
+	for _,r := range w.pending { recs = append(recs,r) }
+	sort.Slice(recs,func(i,j int) bool { return recs[i].seq<recs[j].seq })
+	var buf []byte
+	for _,r := range recs { buf = r.appendTo(buf) }
+	
+	os.Remove(pth+".tmp")
+	if err = writeFileAtomic(pth+".tmp",pth,buf); err!=nil { return }
+	f,err := os.OpenFile(pth,os.O_WRONLY|os.O_APPEND,0666)
+	if err!=nil { return translate(err) }
+	w.f.Close()
+	w.f = f
+	return
+}
+// Checkpoints the log every iv, calling before first.
+func (w *wal) checkpoints(iv time.Duration,before func()) {
+	t := time.NewTicker(iv)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			before()
+			w.Checkpoint()
+		case <-w.quit: return
+		}
+	}
+}
+
+// Stops the checkpoints, checkpoints the log a last time and closes it. Further calls return nil.
+func (w *wal) Close() (err error) {
+	w.closed.Do(func() {
+		close(w.quit)
+		err = w.Checkpoint()
+		w.mu.Lock(); defer w.mu.Unlock()
+		if cerr := w.f.Close(); err==nil { err = translate(cerr) }
+	})
+	return
+}
+
+///
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package files
+
+import (
+	"os"
+	"os/exec"
+	"time"
+	"testing"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+The crash tests run a mutation in a child process, that exits at a crash point,
+like a process, that is killed. With the torn flag, it writes half of the data
+into the object file first, like a write, that was cut short.
+
+The parent replays the intent log and checks the object.
+*/
+const (
+	crashEnvDir   = "HBS_CRASH_DIR"
+	crashEnvOp    = "HBS_CRASH_OP"
+	crashEnvPoint = "HBS_CRASH_POINT"
+	crashEnvTorn  = "HBS_CRASH_TORN"
+	
+	crashExit = 3
+)
+
+var crashData = []byte("0123456789")
+
+// Runs in the child process, see crash.
+func TestCrashChild(t *testing.T) {
+	dir := os.Getenv(crashEnvDir)
+	if dir=="" { t.Skip("only run by the crash tests") }
+	point,torn := os.Getenv(crashEnvPoint),os.Getenv(crashEnvTorn)!=""
+	svc,err := ServeFileOpts(dir,Options{WAL:true,Versioning:os.Getenv(crashEnvOp)=="version"})
+	if err!=nil { t.Fatal(err) }
+	crashHook = func(p,pth string) {
+		if p!=point { return }
+		if torn {
+			f,err := os.OpenFile(pth,os.O_WRONLY|os.O_CREATE|os.O_APPEND,0666)
+			if err==nil { f.Write(crashData[:len(crashData)/2]); f.Close() }
+		}
+		os.Exit(crashExit)
+	}
+	name := []byte("obj")
+	switch os.Getenv(crashEnvOp) {
+	case "put": err = svc.PutObj(name,crashData)
+	case "append": _,err = svc.Append(name,crashData)
+	case "delete": err = svc.DeleteObj(name)
+	case "version": _,err = single.AsVersionSvc(svc).PutVersion(name,crashData)
+	}
+	t.Fatalf("no crash at %s, err = %v",point,err)
+}
+
+// Runs op in a child process, that crashes at point, and replays the intent log.
+func crash(t *testing.T,dir,op,point string,torn bool) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0],"-test.run=^TestCrashChild$")
+	cmd.Env = append(os.Environ(),crashEnvDir+"="+dir,crashEnvOp+"="+op,crashEnvPoint+"="+point)
+	if torn { cmd.Env = append(cmd.Env,crashEnvTorn+"=1") }
+	out,err := cmd.CombinedOutput()
+	if ee,ok := err.(*exec.ExitError); !ok || ee.ExitCode()!=crashExit { t.Fatalf("child: %v\n%s",err,out) }
+	if err = Recover(dir); err!=nil { t.Fatal(err) }
+	if _,err = os.Stat(filepath.Join(dir,walName)); !os.IsNotExist(err) { t.Fatal("intent log remains after Recover") }
+}
+
+// Returns the content of the object in a fresh store, or ENotFound.
+func crashResult(t *testing.T,dir string,versioning bool) (string,error) {
+	t.Helper()
+	svc,err := ServeFileOpts(dir,Options{WAL:true,Versioning:versioning})
+	if err!=nil { t.Fatal(err) }
+	defer closeSvc(svc)
+	if _,err = svc.Info([]byte("obj")); err!=nil { return "",err }
+	return readAll(t,svc,"obj"),nil
+}
+
+func crashSetup(t *testing.T,content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if content=="" { return dir }
+	svc,err := ServeFileOpts(dir,Options{WAL:true})
+	if err!=nil { t.Fatal(err) }
+	defer closeSvc(svc)
+	if err = svc.PutObj([]byte("obj"),[]byte(content)); err!=nil { t.Fatal(err) }
+	return dir
+}
+
+func TestCrashAppend(t *testing.T) {
+	for _,c := range []struct{
+		point string
+		torn  bool
+		want  string
+	}{
+		{"intent",false,"head"},
+		{"intent",true,"head"},
+		{"written",false,"head"+string(crashData)},
+	} {
+		dir := crashSetup(t,"head")
+		crash(t,dir,"append",c.point,c.torn)
+		if s,err := crashResult(t,dir,false); err!=nil || s!=c.want { t.Fatalf("%s torn=%v: %q %v, want %q",c.point,c.torn,s,err,c.want) }
+	}
+}
+
+func TestCrashPut(t *testing.T) {
+	dir := crashSetup(t,"")
+	crash(t,dir,"put","intent",true)
+	if _,err := crashResult(t,dir,false); err!=single.ENotFound { t.Fatalf("torn put survived: %v",err) }
+	
+	dir = crashSetup(t,"")
+	crash(t,dir,"put","written",false)
+	if s,err := crashResult(t,dir,false); err!=nil || s!=string(crashData) { t.Fatalf("put: %q %v",s,err) }
+}
+
+func TestCrashDelete(t *testing.T) {
+	for _,point := range []string{"intent","written"} {
+		dir := crashSetup(t,"head")
+		crash(t,dir,"delete",point,false)
+		if _,err := crashResult(t,dir,false); err!=single.ENotFound { t.Fatalf("%s: deleted object survived: %v",point,err) }
+	}
+}
+
+func TestCrashVersion(t *testing.T) {
+	dir := t.TempDir()
+	crash(t,dir,"version","intent",true)
+	if _,err := crashResult(t,dir,true); err!=single.ENotFound { t.Fatalf("torn version survived: %v",err) }
+	
+	dir = t.TempDir()
+	crash(t,dir,"version","written",false)
+	if s,err := crashResult(t,dir,true); err!=nil || s!=string(crashData) { t.Fatalf("version: %q %v",s,err) }
+}
+
+// A deletion, that finished, is not replayed, even if the object was recreated.
+func TestWalDeleteNotReplayed(t *testing.T) {
+	dir := crashSetup(t,"old")
+	svc,err := ServeFileOpts(dir,Options{WAL:true})
+	if err!=nil { t.Fatal(err) }
+	if err = svc.DeleteObj([]byte("obj")); err!=nil { t.Fatal(err) }
+	
+	// Recreate the object behind the store's back, and drop the store without Close.
+	if err = os.WriteFile(filepath.Join(dir,"obj-obj.bin"),[]byte("new"),0666); err!=nil { t.Fatal(err) }
+	if err = Recover(dir); err!=nil { t.Fatal(err) }
+	if s,err := crashResult(t,dir,false); err!=nil || s!="new" { t.Fatalf("recreated object: %q %v",s,err) }
+}
+
+// Closing a store twice doesn't panic.
+func TestWalCloseTwice(t *testing.T) {
+	svc,err := ServeFileOpts(t.TempDir(),Options{WAL:true,CheckpointInterval:time.Hour})
+	if err!=nil { t.Fatal(err) }
+	if err = filesOf(svc).Close(); err!=nil { t.Fatal(err) }
+	if err = filesOf(svc).Close(); err!=nil { t.Fatalf("second Close: %v",err) }
+}
+
+///
@@ -0,0 +1,195 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Wraps a store, enforcing settings, that can be changed at runtime.
+*/
+package guard
+
+import (
+	"io"
+	"sync/atomic"
+	
+	"unsafe"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+type Guard struct{
+	svc single.ObjectSvc
+	ro  int32
+}
+
+func New(svc single.ObjectSvc) *Guard {
+	return &Guard{svc:svc}
+}
+
+// If enabled, all mutations fail with single.EIsReadOnly.
+func (g *Guard) SetReadOnly(ro bool) {
+	var v int32
+	if ro { v = 1 }
+	atomic.StoreInt32(&g.ro,v)
+}
+func (g *Guard) ReadOnly() bool { return atomic.LoadInt32(&g.ro)!=0 }
+
+func (g *Guard) check() error {
+	if g.ReadOnly() { return single.EIsReadOnly }
+	return nil
+}
+
+func (g *Guard) Unwrap() single.ObjectSvc { return g.svc }
+
+func (g *Guard) PutObj(objectId []byte,data []byte) (err error) {
+	if err = g.check(); err!=nil { return }
+	return g.svc.PutObj(objectId,data)
+}
+func (g *Guard) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	if err = g.check(); err!=nil { return }
+	return g.svc.Append(objectId,data)
+}
+func (g *Guard) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	return g.svc.ReadObj(objectId,pos,ops,dst)
+}
+func (g *Guard) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	return single.ReadTo(g.svc,objectId,pos,sink)
+}
+func (g *Guard) DeleteObj(objectId []byte) (err error) {
+	if err = g.check(); err!=nil { return }
+	return g.svc.DeleteObj(objectId)
+}
+func (g *Guard) Info(objectId []byte) (lng int64,err error) {
+	return g.svc.Info(objectId)
+}
+
+func (g *Guard) PutVersion(objectId []byte,data []byte) (ver single.Version,err error) {
+	vs,ok := g.svc.(single.VersionSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return vs.PutVersion(objectId,data)
+}
+func (g *Guard) ReplaceObj(objectId []byte,r io.Reader) (ver single.Version,err error) {
+	rs,ok := g.svc.(single.ReplaceSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return rs.ReplaceObj(objectId,r)
+}
+func (g *Guard) ReadVersion(objectId []byte,ver single.Version,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	vs,ok := g.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	return vs.ReadVersion(objectId,ver,pos,ops,dst)
+}
+func (g *Guard) ReadVersionTo(objectId []byte,ver single.Version,pos single.ByteRange,sink single.Sink) (err error) {
+	vs,ok := g.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	return single.ReadVersionTo(vs,objectId,ver,pos,sink)
+}
+func (g *Guard) InfoVersion(objectId []byte,ver single.Version) (lng int64,err error) {
+	vs,ok := g.svc.(single.VersionSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	return vs.InfoVersion(objectId,ver)
+}
+func (g *Guard) ListVersions(objectId []byte) (vers []single.VersionInfo,err error) {
+	vs,ok := g.svc.(single.VersionSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	return vs.ListVersions(objectId)
+}
+func (g *Guard) DeleteVersion(objectId []byte,ver single.Version) (err error) {
+	vs,ok := g.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return vs.DeleteVersion(objectId,ver)
+}
+
+func (g *Guard) GetRetention(objectId []byte) (ret single.Retention,err error) {
+	rs,ok := g.svc.(single.RetentionSvc)
+	if !ok { return ret,single.EOpNotSupp }
+	return rs.GetRetention(objectId)
+}
+func (g *Guard) SetRetention(objectId []byte,ret single.Retention) (err error) {
+	rs,ok := g.svc.(single.RetentionSvc)
+	if !ok { return single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return rs.SetRetention(objectId,ret)
+}
+
+func (g *Guard) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	ls,ok := g.svc.(single.ListSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	return ls.ListObjs(prefix,after,limit)
+}
+
+func (g *Guard) Stat(objectId []byte) (st single.ObjectStat,err error) {
+	ss,ok := g.svc.(single.StatSvc)
+	if !ok { return st,single.EOpNotSupp }
+	return ss.Stat(objectId)
+}
+func (g *Guard) Checksum(objectId []byte) (lng int64,crc uint32,ok bool,err error) {
+	cs,isCs := g.svc.(single.ChecksumSvc)
+	if !isCs { return 0,0,false,single.EOpNotSupp }
+	return cs.Checksum(objectId)
+}
+
+func (g *Guard) CreateUpload(objectId []byte) (uploadId string,err error) {
+	us,ok := g.svc.(single.UploadSvc)
+	if !ok { return "",single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return us.CreateUpload(objectId)
+}
+func (g *Guard) UploadPart(objectId []byte,uploadId string,part int,data []byte) (err error) {
+	us,ok := g.svc.(single.UploadSvc)
+	if !ok { return single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return us.UploadPart(objectId,uploadId,part,data)
+}
+func (g *Guard) ListParts(objectId []byte,uploadId string) (parts []single.PartInfo,err error) {
+	us,ok := g.svc.(single.UploadSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	return us.ListParts(objectId,uploadId)
+}
+func (g *Guard) CompleteUpload(objectId []byte,uploadId string,parts []int) (ver single.Version,err error) {
+	us,ok := g.svc.(single.UploadSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return us.CompleteUpload(objectId,uploadId,parts)
+}
+func (g *Guard) AbortUpload(objectId []byte,uploadId string) (err error) {
+	us,ok := g.svc.(single.UploadSvc)
+	if !ok { return single.EOpNotSupp }
+	if err = g.check(); err!=nil { return }
+	return us.AbortUpload(objectId,uploadId)
+}
+
+func (g *Guard) Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error) {
+	fs,ok := g.svc.(single.FollowSvc)
+	if !ok { return single.EOpNotSupp }
+	return fs.Follow(objectId,off,done,fn)
+}
+
+// Closes the wrapped store, if it is an io.Closer.
+func (g *Guard) Close() error {
+	if c,ok := g.svc.(io.Closer); ok { return c.Close() }
+	return nil
+}
+
+///
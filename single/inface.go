@@ -24,6 +24,7 @@ SOFTWARE.
 package single
 
 import (
+	"io"
 	"errors"
 	"unsafe"
 )
@@ -36,6 +37,18 @@ var (
 	ENotFound = errors.New("Not Found")
 	
 	EBeingDeleted = errors.New("Busy Being Deleted")
+	
+	// The object is under retention or legal hold.
+	ERetained = errors.New("Retained")
+	
+	// The request lacks valid credentials, or they were rejected.
+	EUnauthorized = errors.New("Unauthorized")
+	
+	// The credentials are valid, but don't grant the operation.
+	EForbidden = errors.New("Forbidden")
+	
+	// A malformed argument, like an invalid part number.
+	EInvalid = errors.New("Invalid Argument")
 )
 
 func BoilDownError(err error) error {
@@ -50,4 +63,181 @@ type ObjectSvc interface{
 	Info(objectId []byte) (lng int64,err error)
 }
 
+// Implemented by stores, that keep older versions of their objects.
+//
+// In a versioned store, PutObj overwrites existing objects and DeleteObj
+// writes a delete marker, each creating a new version. Version 0 refers to
+// the latest version.
+type VersionSvc interface{
+	ObjectSvc
+	PutVersion(objectId []byte,data []byte) (ver Version,err error)
+	ReadVersion(objectId []byte,ver Version,pos ByteRange, ops *RdOps, dst unsafe.Pointer) (err error)
+	InfoVersion(objectId []byte,ver Version) (lng int64,err error)
+	ListVersions(objectId []byte) (vers []VersionInfo,err error)
+	DeleteVersion(objectId []byte,ver Version) (err error)
+}
+
+// Implemented by stores, that support write-once retention.
+//
+// While an object's retention is active, every operation, that would modify
+// or remove it's content, fails with ERetained.
+type RetentionSvc interface{
+	ObjectSvc
+	GetRetention(objectId []byte) (ret Retention,err error)
+	
+	// Sets the object's retention. The retention date can only be extended,
+	// while the legal hold can be set and cleared freely.
+	SetRetention(objectId []byte,ret Retention) (err error)
+}
+
+// Implemented by stores, that can enumerate their objects.
+type ListSvc interface{
+	ObjectSvc
+	// Lists up to limit object names with the given prefix, that sort after
+	// the name after, in ascending order.
+	ListObjs(prefix, after []byte, limit int) (names [][]byte,err error)
+}
+
+// Implemented by stores, that know the modification time of their objects.
+type StatSvc interface{
+	ObjectSvc
+	Stat(objectId []byte) (st ObjectStat,err error)
+}
+
+/*
+Implemented by stores, that support multipart uploads.
+
+An upload collects numbered parts, that can be uploaded in any order and
+re-uploaded, until CompleteUpload assembles them into the object. The object
+only becomes visible once complete. Uploads, that are neither completed nor
+aborted, are removed eventually.
+*/
+type UploadSvc interface{
+	ObjectSvc
+	CreateUpload(objectId []byte) (uploadId string,err error)
+	
+	// Stores a part, replacing an earlier upload of it. part ranges from 1 to MaxParts.
+	UploadPart(objectId []byte,uploadId string,part int,data []byte) (err error)
+	ListParts(objectId []byte,uploadId string) (parts []PartInfo,err error)
+	
+	// Assembles the given parts, which must be ascending, or all parts, if
+	// parts is nil. The object is created like with PutObj, or PutVersion, if
+	// the store is versioned.
+	CompleteUpload(objectId []byte,uploadId string,parts []int) (ver Version,err error)
+	AbortUpload(objectId []byte,uploadId string) (err error)
+}
+
+/*
+Implemented by stores, that can follow objects, which are appended to.
+
+Follow passes the object from off to fn, and then the data, that is appended,
+as it arrives, until done is closed. It fails with ENotFound, once the object
+is deleted or replaced, and with the error of fn, if any. fn must not retain
+data.
+*/
+type FollowSvc interface{
+	ObjectSvc
+	Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error)
+}
+
+/*
+Implemented by stores, that can overwrite objects atomically.
+
+ReplaceObj writes the object from r, replacing an existing one: Readers see
+either the old or the new content, and if it fails, the old object remains.
+Versioned stores create a new version and return it, others return 0.
+*/
+type ReplaceSvc interface{
+	ObjectSvc
+	ReplaceObj(objectId []byte,r io.Reader) (ver Version,err error)
+}
+
+/*
+Implemented by stores, that keep a CRC-32 (IEEE) of every object, that Appends
+extend.
+
+Checksum returns the length of the object and the CRC-32 of that many bytes.
+ok is false, if the checksum isn't known.
+*/
+type ChecksumSvc interface{
+	ObjectSvc
+	Checksum(objectId []byte) (lng int64,crc uint32,ok bool,err error)
+}
+
+// Implemented by stores, that wrap another store, such as middlewares.
+//
+// Wrappers implement all optional interfaces, like VersionSvc, and forward
+// them. Use AsVersionSvc, AsRetentionSvc, AsListSvc, AsStatSvc, AsUploadSvc,
+// AsFollowSvc, AsReplaceSvc and AsChecksumSvc to find out, whether the wrapped
+// store actually supports them.
+type Wrapper interface{
+	ObjectSvc
+	Unwrap() ObjectSvc
+}
+
+// Returns the innermost store, unwrapping all Wrappers.
+func Innermost(svc ObjectSvc) ObjectSvc {
+	for {
+		w,ok := svc.(Wrapper)
+		if !ok { return svc }
+		svc = w.Unwrap()
+	}
+}
+
+// Returns svc as VersionSvc, or nil, if the store isn't versioned.
+func AsVersionSvc(svc ObjectSvc) VersionSvc {
+	if _,ok := Innermost(svc).(VersionSvc); !ok { return nil }
+	vs,_ := svc.(VersionSvc)
+	return vs
+}
+
+// Returns svc as RetentionSvc, or nil, if the store doesn't support retention.
+func AsRetentionSvc(svc ObjectSvc) RetentionSvc {
+	if _,ok := Innermost(svc).(RetentionSvc); !ok { return nil }
+	rs,_ := svc.(RetentionSvc)
+	return rs
+}
+
+// Returns svc as ListSvc, or nil, if the store can't enumerate it's objects.
+func AsListSvc(svc ObjectSvc) ListSvc {
+	if _,ok := Innermost(svc).(ListSvc); !ok { return nil }
+	ls,_ := svc.(ListSvc)
+	return ls
+}
+
+// Returns svc as StatSvc, or nil, if the store doesn't support Stat.
+func AsStatSvc(svc ObjectSvc) StatSvc {
+	if _,ok := Innermost(svc).(StatSvc); !ok { return nil }
+	ss,_ := svc.(StatSvc)
+	return ss
+}
+
+// Returns svc as UploadSvc, or nil, if the store doesn't support multipart uploads.
+func AsUploadSvc(svc ObjectSvc) UploadSvc {
+	if _,ok := Innermost(svc).(UploadSvc); !ok { return nil }
+	us,_ := svc.(UploadSvc)
+	return us
+}
+
+// Returns svc as FollowSvc, or nil, if the store can't follow objects.
+func AsFollowSvc(svc ObjectSvc) FollowSvc {
+	if _,ok := Innermost(svc).(FollowSvc); !ok { return nil }
+	fs,_ := svc.(FollowSvc)
+	return fs
+}
+
+// Returns svc as ReplaceSvc, or nil, if the store can't overwrite objects atomically.
+func AsReplaceSvc(svc ObjectSvc) ReplaceSvc {
+	if _,ok := Innermost(svc).(ReplaceSvc); !ok { return nil }
+	rs,_ := svc.(ReplaceSvc)
+	return rs
+}
+
+// Returns svc as ChecksumSvc, or nil, if the store doesn't keep checksums.
+func AsChecksumSvc(svc ObjectSvc) ChecksumSvc {
+	if _,ok := Innermost(svc).(ChecksumSvc); !ok { return nil }
+	cs,_ := svc.(ChecksumSvc)
+	return cs
+}
+
 ///
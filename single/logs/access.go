@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package logs
+
+import (
+	"io"
+	"log"
+	"time"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/util/bconv"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// An entry of the access log.
+type AccessEntry struct{
+	Time      string  `json:"time"`
+	API       string  `json:"api"`
+	Remote    string  `json:"remote"`
+	Principal string  `json:"principal,omitempty"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Object    string  `json:"object,omitempty"`
+	
+	// The requested byte range, X-Offset and X-Length, or the Range header.
+	Range     []int64  `json:"range,omitempty"`
+	HTTPRange string   `json:"http_range,omitempty"`
+	
+	Status    int     `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	BytesIn   int     `json:"bytes_in"`
+	BytesOut  int     `json:"bytes_out"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+func now() string { return time.Now().UTC().Format(time.RFC3339Nano) }
+
+func principal(ctx *fasthttp.RequestCtx) string {
+	p,_ := ctx.UserValue(auth.PrincipalKey).(string)
+	return p
+}
+
+// Writes v as a JSON line to w.
+func writeLine(w io.Writer,v interface{}) {
+	data,err := json.Marshal(v)
+	if err==nil {
+		_,err = w.Write(append(data,'\n'))
+	}
+	if err!=nil { log.Println("logs:",err) }
+}
+
+/*
+Logs every request handled by next to w. api names the frontend, like "native"
+or "s3". For the native API, the object name and range are logged as well.
+*/
+func Access(w io.Writer,api string,next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	native := api=="native"
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		next(ctx)
+		e := AccessEntry{
+			Time: now(),
+			API: api,
+			Remote: ctx.RemoteIP().String(),
+			Principal: principal(ctx),
+			Method: string(ctx.Method()),
+			Path: string(ctx.Path()),
+			HTTPRange: string(ctx.Request.Header.Peek("Range")),
+			Status: ctx.Response.StatusCode(),
+			BytesIn: len(ctx.Request.Body()),
+		}
+		
+		// Body would read a stream into memory, the length of some is unknown.
+		if !ctx.Response.IsBodyStream() {
+			e.BytesOut = len(ctx.Response.Body())
+		} else if n := ctx.Response.Header.ContentLength(); n>=0 {
+			e.BytesOut = n
+		}
+		if native {
+			if _,obj,ok := proto.Match(e.Method,e.Path); ok { e.Object = obj }
+			off,lng := ctx.Request.Header.Peek("X-Offset"),ctx.Request.Header.Peek("X-Length")
+			if off!=nil || lng!=nil {
+				e.Range = make([]int64,2)
+				e.Range[0],_ = bconv.ParseUint64(off)
+				e.Range[1],_ = bconv.ParseUint64(lng)
+			}
+		}
+		if e.Status>=400 {
+			e.Error = proto.ClassOf(proto.ErrorOf(e.Status,func(name string) string {
+				return string(ctx.Response.Header.Peek(name))
+			}))
+		}
+		e.LatencyMs = float64(time.Since(start))/float64(time.Millisecond)
+		writeLine(w,e)
+	}
+}
+
+///
@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package logs
+
+import (
+	"io"
+	"time"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/util/bconv"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// An entry of the audit log.
+type AuditEntry struct{
+	Time      string `json:"time"`
+	API       string `json:"api"`
+	Remote    string `json:"remote"`
+	Principal string `json:"principal,omitempty"`
+	
+	// One of "put", "append", "delete", "delete_version", "set_retention"
+	// and "complete_upload".
+	Op      string `json:"op"`
+	Object  string `json:"object"`
+	Version string `json:"version,omitempty"`
+	
+	// The written byte range of put and append.
+	Range []int64 `json:"range,omitempty"`
+	
+	// The retention of set_retention.
+	RetainUntil int64 `json:"retain_until,omitempty"`
+	LegalHold   bool  `json:"legal_hold,omitempty"`
+}
+
+func header(h *fasthttp.ResponseHeader,name string) int64 {
+	v,_ := bconv.ParseUint64(h.Peek(name))
+	return v
+}
+
+/*
+Logs every successful mutation of a request, that is handled by next, to w.
+api names the frontend, like Access. Requests of the native API are matched
+against its routes, other frontends record their mutations, see
+proto.Mutation. Requests, that failed, are in the access log only.
+*/
+func Audit(w io.Writer,api string,next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+		if code := ctx.Response.StatusCode(); code<200 || code>=300 { return }
+		e := AuditEntry{
+			API: api,
+			Remote: ctx.RemoteIP().String(),
+			Principal: principal(ctx),
+		}
+		if ms,ok := ctx.UserValue(proto.MutationsKey).([]proto.Mutation); ok {
+			for _,m := range ms {
+				e.Op,e.Object,e.Range = m.Op,m.Object,m.Range
+				e.Version = ""
+				if m.Version!=0 { e.Version = m.Version.String() }
+				e.Time = now()
+				writeLine(w,e)
+			}
+			return
+		}
+		route,obj,ok := proto.Match(string(ctx.Method()),string(ctx.Path()))
+		if !ok || route==nil { return }
+		e.Object = obj
+		e.Version = string(ctx.Response.Header.Peek("X-Version"))
+		switch route.Op {
+		case proto.OpPut:
+			e.Op = "put"
+			e.Range = []int64{0,int64(len(ctx.Request.Body()))}
+		case proto.OpAppend:
+			e.Op = "append"
+			e.Range = []int64{header(&ctx.Response.Header,"X-Offset"),header(&ctx.Response.Header,"X-Length")}
+		case proto.OpDelete:
+			e.Op = "delete"
+			if v := ctx.QueryArgs().Peek("version"); len(v)!=0 {
+				e.Op = "delete_version"
+				e.Version = string(v)
+			}
+		case proto.OpSetRetention:
+			e.Op = "set_retention"
+			e.RetainUntil,_ = bconv.ParseUint64(ctx.Request.Header.Peek("X-Retain-Until"))
+			e.LegalHold = string(ctx.Request.Header.Peek("X-Legal-Hold"))=="1"
+		case proto.OpUpload:
+			// Initiating an upload doesn't change any object.
+			if len(ctx.QueryArgs().Peek("upload"))==0 { return }
+			e.Op = "complete_upload"
+		case proto.OpBatch:
+			auditBatch(w,ctx,e)
+			return
+		default:
+			return
+		}
+		e.Time = now()
+		writeLine(w,e)
+	}
+}
+
+// Logs the successful mutations of a batch, like the equivalent requests.
+func auditBatch(w io.Writer,ctx *fasthttp.RequestCtx,e AuditEntry) {
+	var bops []proto.BatchOp
+	var res []proto.BatchResult
+	if json.Unmarshal(ctx.Request.Body(),&bops)!=nil || json.Unmarshal(ctx.Response.Body(),&res)!=nil || len(res)!=len(bops) { return }
+	for i := range bops {
+		if res[i].Status<200 || res[i].Status>=300 { continue }
+		e.Object = bops[i].Object
+		e.Version = ""
+		if res[i].Version!=0 { e.Version = res[i].Version.String() }
+		switch bops[i].Op {
+		case "put":
+			e.Op = "put"
+			e.Range = []int64{0,int64(len(bops[i].Data))}
+		case "append":
+			e.Op = "append"
+			e.Range = []int64{res[i].Offset,res[i].Length}
+		case "delete":
+			e.Op = "delete"
+			e.Range = nil
+			if bops[i].Version!=0 {
+				e.Op = "delete_version"
+				e.Version = bops[i].Version.String()
+			}
+		default:
+			continue
+		}
+		e.Time = now()
+		writeLine(w,e)
+	}
+}
+
+/*
+Appends the log to an object of the store, a new one every day, named
+Prefix followed by the date, like "audit-2006-01-02".
+
+The object is appended to directly, thus the writes are neither audited, nor
+subject to authorization. Svc should be subject to read only mode, though, so
+that replicas don't write audit objects of their own.
+*/
+type ObjectWriter struct{
+	Svc    single.ObjectSvc
+	Prefix string
+}
+
+// Appends p to the object of the current day, as a whole.
+func (ow ObjectWriter) Write(p []byte) (n int,err error) {
+	name := ow.Prefix+time.Now().UTC().Format("2006-01-02")
+	if _,err = ow.Svc.Append([]byte(name),p); err!=nil { return }
+	return len(p),nil
+}
+
+///
@@ -0,0 +1,221 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package logs_test
+
+import (
+	"io"
+	"net"
+	"bytes"
+	"strings"
+	"time"
+	"reflect"
+	"testing"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/logs"
+	"github.com/byte-mug/hblobstore/single/files"
+	sfhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+	s3api "github.com/byte-mug/hblobstore/single/s3-api"
+)
+
+var remote = &net.TCPAddr{IP:net.IPv4(192,0,2,1),Port:4711}
+
+// Performs a request as principal "app", like the authentication would set it.
+func do(h fasthttp.RequestHandler,method,uri string,body []byte,hdr ...string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.SetBody(body)
+	for i := 0; i+1<len(hdr); i += 2 { req.Header.Set(hdr[i],hdr[i+1]) }
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(&req,remote,nil)
+	ctx.SetUserValue(auth.PrincipalKey,"app")
+	h(ctx)
+	return ctx
+}
+
+func entries(t *testing.T,buf *bytes.Buffer,v interface{}) {
+	t.Helper()
+	var lines []json.RawMessage
+	for _,line := range strings.SplitAfter(buf.String(),"\n") {
+		if line=="" { continue }
+		if !strings.HasSuffix(line,"\n") { t.Fatalf("unterminated line %q",line) }
+		lines = append(lines,json.RawMessage(line))
+	}
+	data,_ := json.Marshal(lines)
+	if err := json.Unmarshal(data,v); err!=nil { t.Fatal(err) }
+	buf.Reset()
+}
+
+func store(t *testing.T) single.ObjectSvc {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{Versioning:true})
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		if c,ok := svc.(io.Closer); ok { c.Close() }
+	})
+	return svc
+}
+
+// Checks the times of the entries, and clears them, as well as the latency.
+func clearTimes(t *testing.T,acc []logs.AccessEntry,aud []logs.AuditEntry) {
+	t.Helper()
+	for i := range acc {
+		if _,err := time.Parse(time.RFC3339Nano,acc[i].Time); err!=nil || acc[i].LatencyMs<0 { t.Errorf("access %d: %q %v",i,acc[i].Time,acc[i].LatencyMs) }
+		acc[i].Time,acc[i].LatencyMs = "",0
+	}
+	for i := range aud {
+		if _,err := time.Parse(time.RFC3339Nano,aud[i].Time); err!=nil { t.Errorf("audit %d: %q",i,aud[i].Time) }
+		aud[i].Time = ""
+	}
+}
+
+func check(t *testing.T,kind string,got,want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got,want) { t.Errorf("%s:\n got %+v\nwant %+v",kind,got,want) }
+}
+
+func TestNative(t *testing.T) {
+	svc := store(t)
+	router := fhr.New()
+	sfhapi.RegisterObjectSvc(svc,router)
+	var access,audit bytes.Buffer
+	h := logs.Access(&access,"native",logs.Audit(&audit,"native",router.Handler))
+	
+	v1 := string(do(h,"PUT","/o/a",[]byte("hello")).Response.Header.Peek("X-Version"))
+	v2 := string(do(h,"PUT","/o/a",[]byte("world")).Response.Header.Peek("X-Version"))
+	do(h,"POST","/o/a",[]byte("xy"))
+	do(h,"GET","/o/a",nil,"X-Offset","1","X-Length","3")
+	do(h,"GET","/o/missing",nil,"Range","bytes=0-1")
+	do(h,"POST","/b",[]byte(`[{"op":"put","object":"b","data":"YWJj"},{"op":"delete","object":"missing"},{"op":"append","object":"b","data":"ZA=="}]`))
+	do(h,"PUT","/r/b",nil,"X-Retain-Until","4102444800","X-Legal-Hold","1")
+	do(h,"DELETE","/o/b",nil)
+	do(h,"DELETE","/o/a?version="+v1,nil)
+	do(h,"DELETE","/o/a",nil)
+	if v1=="" || v2=="" { t.Fatal("no versions") }
+	
+	var acc []logs.AccessEntry
+	var aud []logs.AuditEntry
+	entries(t,&access,&acc)
+	entries(t,&audit,&aud)
+	clearTimes(t,acc,aud)
+	
+	ae := func(method,path,object string,status int,in,out int) logs.AccessEntry {
+		return logs.AccessEntry{API:"native",Remote:"192.0.2.1",Principal:"app",Method:method,Path:path,Object:object,Status:status,BytesIn:in,BytesOut:out}
+	}
+	wantAcc := []logs.AccessEntry{
+		ae("PUT","/o/a","a",201,5,0),
+		ae("PUT","/o/a","a",201,5,0),
+		ae("POST","/o/a","a",201,2,0),
+		ae("GET","/o/a","a",200,0,3),
+		ae("GET","/o/missing","missing",404,0,acc[4].BytesOut),
+		ae("POST","/b","",200,119,acc[5].BytesOut),
+		ae("PUT","/r/b","b",204,0,0),
+		ae("DELETE","/o/b","b",acc[7].Status,0,acc[7].BytesOut),
+		ae("DELETE","/o/a","a",acc[8].Status,0,0),
+		ae("DELETE","/o/a","a",acc[9].Status,0,0),
+	}
+	if len(acc)!=len(wantAcc) { t.Fatalf("%d access entries: %+v",len(acc),acc) }
+	wantAcc[3].Range = []int64{1,3}
+	wantAcc[4].HTTPRange,wantAcc[4].Error = "bytes=0-1","not_found"
+	wantAcc[7].Error = "retained"
+	check(t,"access",acc,wantAcc)
+	if acc[7].Status<400 || acc[8].Status>=300 || acc[9].Status>=300 { t.Errorf("deletes: %d %d %d",acc[7].Status,acc[8].Status,acc[9].Status) }
+	
+	de := func(op,object,version string,rng ...int64) logs.AuditEntry {
+		e := logs.AuditEntry{API:"native",Remote:"192.0.2.1",Principal:"app",Op:op,Object:object,Version:version}
+		if rng!=nil { e.Range = rng }
+		return e
+	}
+	wantAud := []logs.AuditEntry{
+		de("put","a",v1,0,5),
+		de("put","a",v2,0,5),
+		de("append","a","",5,2),
+		de("put","b",aud[3].Version,0,3),
+		de("append","b","",3,1),
+		de("set_retention","b",""),
+		de("delete_version","a",v1),
+		de("delete","a",""),
+	}
+	if len(aud)!=len(wantAud) { t.Fatalf("%d audit entries: %+v",len(aud),aud) }
+	wantAud[5].RetainUntil,wantAud[5].LegalHold = 4102444800,true
+	check(t,"audit",aud,wantAud)
+	if aud[3].Version=="" || aud[3].Version==v2 { t.Errorf("batch put version %q",aud[3].Version) }
+}
+
+func TestS3(t *testing.T) {
+	svc := store(t)
+	var access,audit bytes.Buffer
+	s3h := s3api.New(map[string]s3api.Bucket{"b":{Svc:svc,Prefix:"s3/"}},nil).Handle
+	h := logs.Access(&access,"s3",logs.Audit(&audit,"s3",s3h))
+	
+	ver := string(do(h,"PUT","/b/k",[]byte("data")).Response.Header.Peek("X-Amz-Version-Id"))
+	do(h,"GET","/b/k",nil,"Range","bytes=1-2")
+	do(h,"GET","/b/missing",nil)
+	do(h,"PUT","/b/c",nil,"X-Amz-Copy-Source","/b/k")
+	do(h,"DELETE","/b/k",nil)
+	
+	var acc []logs.AccessEntry
+	var aud []logs.AuditEntry
+	entries(t,&access,&acc)
+	entries(t,&audit,&aud)
+	clearTimes(t,acc,aud)
+	
+	// The S3 frontend doesn't log objects and X-Offset ranges, its paths aren't native routes.
+	ae := func(method,path string,status int,in,out int) logs.AccessEntry {
+		return logs.AccessEntry{API:"s3",Remote:"192.0.2.1",Principal:"app",Method:method,Path:path,Status:status,BytesIn:in,BytesOut:out}
+	}
+	if len(acc)!=5 { t.Fatalf("%d access entries: %+v",len(acc),acc) }
+	wantAcc := []logs.AccessEntry{
+		ae("PUT","/b/k",200,4,0),
+		ae("GET","/b/k",206,0,2),
+		ae("GET","/b/missing",404,0,acc[2].BytesOut),
+		ae("PUT","/b/c",200,0,acc[3].BytesOut),
+		ae("DELETE","/b/k",204,0,0),
+	}
+	wantAcc[1].HTTPRange = "bytes=1-2"
+	wantAcc[2].Error = "not_found"
+	check(t,"access",acc,wantAcc)
+	
+	// The mutations name the objects of the store.
+	de := func(op,object,version string,rng ...int64) logs.AuditEntry {
+		e := logs.AuditEntry{API:"s3",Remote:"192.0.2.1",Principal:"app",Op:op,Object:object,Version:version}
+		if rng!=nil { e.Range = rng }
+		return e
+	}
+	if len(aud)!=3 { t.Fatalf("%d audit entries: %+v",len(aud),aud) }
+	check(t,"audit",aud,[]logs.AuditEntry{
+		de("put","s3/k",ver,0,4),
+		de("put","s3/c",aud[1].Version,0,4),
+		de("delete","s3/k",""),
+	})
+	if ver=="" { t.Error("no version") }
+}
+
+///
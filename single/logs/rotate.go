@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Structured logs of the object service: an access log of every HTTP request,
+and an audit log of the mutations, both as JSON lines.
+
+Access and Audit are fasthttp middlewares. Their output goes to any io.Writer,
+like a RotatingFile or an ObjectWriter, which must accept concurrent writes
+of whole lines.
+*/
+package logs
+
+import (
+	"os"
+	"fmt"
+	"sync"
+)
+
+/*
+A log file, that is rotated, once it reaches a size. The rotated files are
+named "{path}.1" (the newest) to "{path}.{keep}", older ones are removed.
+*/
+type RotatingFile struct{
+	path string
+	max  int64
+	keep int
+	
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Opens path for appending. If max is 0, the file is never rotated.
+func OpenRotating(path string,max int64,keep int) (rf *RotatingFile,err error) {
+	rf = &RotatingFile{path:path,max:max,keep:keep}
+	if err = rf.open(); err!=nil { return nil,err }
+	return
+}
+
+func (rf *RotatingFile) open() (err error) {
+	f,err := os.OpenFile(rf.path,os.O_WRONLY|os.O_APPEND|os.O_CREATE,0644)
+	if err!=nil { return }
+	st,err := f.Stat()
+	if err!=nil {
+		f.Close()
+		return
+	}
+	rf.f,rf.size = f,st.Size()
+	return
+}
+
+func (rf *RotatingFile) rotate() (err error) {
+	rf.f.Close()
+	rf.f = nil
+	for i := rf.keep; i>0; i-- {
+		src := rf.path
+		if i>1 { src = fmt.Sprintf("%s.%d",rf.path,i-1) }
+		os.Rename(src,fmt.Sprintf("%s.%d",rf.path,i))
+	}
+	if rf.keep==0 { os.Remove(rf.path) }
+	return rf.open()
+}
+
+// Writes p as a whole. The file is rotated before, if p doesn't fit.
+func (rf *RotatingFile) Write(p []byte) (n int,err error) {
+	rf.mu.Lock(); defer rf.mu.Unlock()
+	if rf.f==nil {
+		// A previous rotation failed.
+		if err = rf.open(); err!=nil { return }
+	}
+	if rf.max>0 && rf.size>0 && rf.size+int64(len(p))>rf.max {
+		if err = rf.rotate(); err!=nil { return }
+	}
+	n,err = rf.f.Write(p)
+	rf.size += int64(n)
+	return
+}
+
+// Reopens the file, after it was moved away by an external tool, like logrotate.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock(); defer rf.mu.Unlock()
+	if rf.f!=nil { rf.f.Close() }
+	rf.f = nil
+	return rf.open()
+}
+
+func (rf *RotatingFile) Close() (err error) {
+	rf.mu.Lock(); defer rf.mu.Unlock()
+	if rf.f!=nil { err = rf.f.Close() }
+	rf.f = nil
+	return
+}
+
+///
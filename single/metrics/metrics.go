@@ -0,0 +1,271 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Collects metrics of the object service and exposes them in the Prometheus
+text format, without depending on the Prometheus client library.
+
+Wrap instruments a store, Handler instruments the HTTP frontend, and
+Register adds the route "/metrics".
+*/
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"bytes"
+	"strconv"
+	"strings"
+	"net/http"
+	"sync/atomic"
+	"time"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// The upper bounds of the latency histograms, in seconds.
+var buckets = [...]float64{.0005,.001,.0025,.005,.01,.025,.05,.1,.25,.5,1,2.5,5,10}
+
+type histogram struct{
+	// The last count is the +Inf bucket.
+	counts [len(buckets)+1]uint64
+	sum    uint64 // nanoseconds
+}
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(buckets[:],s)
+	atomic.AddUint64(&h.counts[i],1)
+	atomic.AddUint64(&h.sum,uint64(d))
+}
+func (h *histogram) empty() bool {
+	for i := range h.counts {
+		if atomic.LoadUint64(&h.counts[i])!=0 { return false }
+	}
+	return true
+}
+// Writes the series name_bucket, name_sum and name_count.
+func (h *histogram) writeTo(b *bytes.Buffer,name,labels string) {
+	var n uint64
+	for i := range h.counts {
+		n += atomic.LoadUint64(&h.counts[i])
+		le := "+Inf"
+		if i<len(buckets) { le = strconv.FormatFloat(buckets[i],'g',-1,64) }
+		writeSample(b,name+"_bucket",joinLabels(labels,`le="`+le+`"`),strconv.FormatUint(n,10))
+	}
+	writeSample(b,name+"_sum",labels,strconv.FormatFloat(time.Duration(atomic.LoadUint64(&h.sum)).Seconds(),'g',-1,64))
+	writeSample(b,name+"_count",labels,strconv.FormatUint(n,10))
+}
+
+func joinLabels(a,b string) string {
+	if a=="" { return b }
+	return a+","+b
+}
+func writeSample(b *bytes.Buffer,name,labels,value string) {
+	b.WriteString(name)
+	if labels!="" {
+		b.WriteByte('{')
+		b.WriteString(labels)
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+func writeHeader(b *bytes.Buffer,name,typ,help string) {
+	b.WriteString("# HELP "+name+" "+help+"\n# TYPE "+name+" "+typ+"\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`,`\\`,`"`,`\"`,"\n",`\n`)
+
+// Formats label pairs, like `op="put"`, from alternating names and values.
+func labels(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1<len(kv); i += 2 {
+		if i>0 { b.WriteByte(',') }
+		b.WriteString(kv[i]+`="`+labelEscaper.Replace(kv[i+1])+`"`)
+	}
+	return b.String()
+}
+
+type gauge struct{
+	name,help string
+	fn func() float64
+}
+
+// The key of the HTTP request counters.
+type reqKey struct{
+	method string
+	code   int
+	class  string
+}
+
+type Metrics struct{
+	// Store operations, indexed by op.
+	ops [numOps]opStats
+	
+	// Errors of store operations.
+	errMu  sync.RWMutex
+	errors map[errKey]*uint64
+	
+	read,written uint64
+	
+	// HTTP requests.
+	reqMu    sync.RWMutex
+	requests map[reqKey]*uint64
+	latency  [numMethods]histogram
+	reqBytes,respBytes uint64
+	
+	gaugeMu sync.Mutex
+	gauges  []gauge
+}
+
+func New() *Metrics {
+	return &Metrics{
+		errors: make(map[errKey]*uint64),
+		requests: make(map[reqKey]*uint64),
+	}
+}
+
+// Adds a gauge, whose value is obtained by calling fn on every scrape.
+func (m *Metrics) GaugeFunc(name,help string,fn func() float64) {
+	m.gaugeMu.Lock(); defer m.gaugeMu.Unlock()
+	m.gauges = append(m.gauges,gauge{name,help,fn})
+}
+
+// Returns the request counter of key, creating it, if necessary.
+func (m *Metrics) request(key reqKey) *uint64 {
+	m.reqMu.RLock()
+	c := m.requests[key]
+	m.reqMu.RUnlock()
+	if c!=nil { return c }
+	m.reqMu.Lock(); defer m.reqMu.Unlock()
+	if c = m.requests[key]; c==nil {
+		c = new(uint64)
+		m.requests[key] = c
+	}
+	return c
+}
+
+// The methods, that are distinguished by the HTTP metrics.
+var methods = [...]string{"GET","HEAD","PUT","POST","DELETE","OPTIONS","other"}
+const numMethods = len(methods)
+
+func methodIndex(method []byte) int {
+	for i,m := range methods[:numMethods-1] {
+		if string(method)==m { return i }
+	}
+	return numMethods-1
+}
+
+/*
+Wraps an HTTP handler, counting the requests by method, status code and the
+error class (see proto.ClassOf) of error responses, and recording their
+latency and size. For streamed responses, the latency ends, when the handler
+returns.
+*/
+func (m *Metrics) Handler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		next(ctx)
+		mi := methodIndex(ctx.Method())
+		m.latency[mi].observe(time.Since(start))
+		
+		code,class := ctx.Response.StatusCode(),""
+		if code>=400 {
+			class = proto.ClassOf(proto.ErrorOf(code,func(name string) string {
+				return string(ctx.Response.Header.Peek(name))
+			}))
+		}
+		atomic.AddUint64(m.request(reqKey{methods[mi],code,class}),1)
+		
+		atomic.AddUint64(&m.reqBytes,uint64(len(ctx.Request.Body())))
+		// Body would read a stream into memory, streams of unknown length aren't counted.
+		if !ctx.Response.IsBodyStream() {
+			atomic.AddUint64(&m.respBytes,uint64(len(ctx.Response.Body())))
+		} else if n := ctx.Response.Header.ContentLength(); n>=0 {
+			atomic.AddUint64(&m.respBytes,uint64(n))
+		}
+	}
+}
+
+// Writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64,error) {
+	var b bytes.Buffer
+	m.writeStore(&b)
+	
+	writeHeader(&b,"hblobstore_http_requests_total","counter","HTTP requests by method, status code and error class.")
+	type entry struct{
+		reqKey
+		c *uint64
+	}
+	m.reqMu.RLock()
+	ents := make([]entry,0,len(m.requests))
+	for k,c := range m.requests { ents = append(ents,entry{k,c}) }
+	m.reqMu.RUnlock()
+	sort.Slice(ents,func(i,j int) bool {
+		a,b := ents[i],ents[j]
+		if a.method!=b.method { return a.method<b.method }
+		if a.code!=b.code { return a.code<b.code }
+		return a.class<b.class
+	})
+	for _,e := range ents {
+		writeSample(&b,"hblobstore_http_requests_total",labels("method",e.method,"code",strconv.Itoa(e.code),"error",e.class),strconv.FormatUint(atomic.LoadUint64(e.c),10))
+	}
+	writeHeader(&b,"hblobstore_http_request_duration_seconds","histogram","Latency of HTTP requests by method.")
+	for i := range m.latency {
+		if m.latency[i].empty() { continue }
+		m.latency[i].writeTo(&b,"hblobstore_http_request_duration_seconds",labels("method",methods[i]))
+	}
+	writeHeader(&b,"hblobstore_http_request_bytes_total","counter","Bytes received in HTTP request bodies.")
+	writeSample(&b,"hblobstore_http_request_bytes_total","",strconv.FormatUint(atomic.LoadUint64(&m.reqBytes),10))
+	writeHeader(&b,"hblobstore_http_response_bytes_total","counter","Bytes sent in HTTP response bodies.")
+	writeSample(&b,"hblobstore_http_response_bytes_total","",strconv.FormatUint(atomic.LoadUint64(&m.respBytes),10))
+	
+	m.gaugeMu.Lock()
+	gauges := m.gauges
+	m.gaugeMu.Unlock()
+	for _,g := range gauges {
+		writeHeader(&b,g.name,"gauge",g.help)
+		writeSample(&b,g.name,"",strconv.FormatFloat(g.fn(),'g',-1,64))
+	}
+	return b.WriteTo(w)
+}
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Serves the metrics, thus m can be mounted as "/metrics" on a net/http server.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type",contentType)
+	m.WriteTo(w)
+}
+
+// Serves the metrics on fasthttp, see RegisterMetrics of package fasthttp-api.
+func (m *Metrics) Serve(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType(contentType)
+	m.WriteTo(ctx)
+}
+
+///
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"strconv"
+	"testing"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+// Returns the samples of the output by series, and checks, that every series
+// belongs to a family declared by a preceding TYPE line.
+func parse(t *testing.T,out string) map[string]string {
+	t.Helper()
+	types := make(map[string]string)
+	samples := make(map[string]string)
+	for _,line := range strings.Split(strings.TrimSuffix(out,"\n"),"\n") {
+		if strings.HasPrefix(line,"# ") {
+			f := strings.SplitN(line," ",4)
+			if len(f)<4 || (f[1]!="HELP" && f[1]!="TYPE") { t.Fatalf("bad comment %q",line) }
+			if f[1]=="TYPE" { types[f[2]] = f[3] }
+			continue
+		}
+		i := strings.LastIndexByte(line,' ')
+		if i<0 { t.Fatalf("bad sample %q",line) }
+		series,value := line[:i],line[i+1:]
+		if _,err := strconv.ParseFloat(value,64); err!=nil { t.Fatalf("bad value %q",line) }
+		name := series
+		if j := strings.IndexByte(name,'{'); j>=0 { name = name[:j] }
+		typ,ok := types[name]
+		for _,suf := range []string{"_bucket","_sum","_count"} {
+			if !ok && strings.HasSuffix(name,suf) {
+				typ,ok = types[strings.TrimSuffix(name,suf)]
+				if typ!="histogram" { ok = false }
+			}
+		}
+		if !ok { t.Fatalf("sample %q without TYPE",line) }
+		if _,dup := samples[series]; dup { t.Fatalf("duplicate series %q",series) }
+		samples[series] = value
+	}
+	return samples
+}
+
+// Checks the buckets of a histogram: cumulative, ending with +Inf, that equals _count.
+func checkHistogram(t *testing.T,out,name,labels string,count int) {
+	t.Helper()
+	var last uint64
+	var les []string
+	for _,line := range strings.Split(out,"\n") {
+		if !strings.HasPrefix(line,name+"_bucket{"+labels+",le=") { continue }
+		i := strings.LastIndexByte(line,' ')
+		n,_ := strconv.ParseUint(line[i+1:],10,64)
+		if n<last { t.Errorf("%s: bucket %q below %d",name,line,last) }
+		last = n
+		les = append(les,line[len(name+"_bucket{"+labels+",le=\""):strings.LastIndexByte(line,'"')])
+	}
+	if len(les)!=len(buckets)+1 || les[len(les)-1]!="+Inf" {
+		t.Fatalf("%s{%s}: buckets %q",name,labels,les)
+	}
+	if last!=uint64(count) { t.Errorf("%s{%s}: +Inf %d, want %d",name,labels,last,count) }
+	s := parse(t,out)
+	if s[name+"_count{"+labels+"}"]!=strconv.Itoa(count) {
+		t.Errorf("%s_count{%s} = %q, want %d",name,labels,s[name+"_count{"+labels+"}"],count)
+	}
+	if _,ok := s[name+"_sum{"+labels+"}"]; !ok { t.Errorf("%s_sum{%s} missing",name,labels) }
+}
+
+func TestWriteTo(t *testing.T) {
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	m := New()
+	s := Wrap(svc,m)
+	m.GaugeFunc("hblobstore_test_gauge","A test gauge.",func() float64 { return 2.5 })
+	
+	if err = s.PutObj([]byte("a"),[]byte("hello")); err!=nil { t.Fatal(err) }
+	var buf bytes.Buffer
+	if err = s.ReadObjTo([]byte("a"),single.ByteRange{0,5},&buf); err!=nil || buf.String()!="hello" { t.Fatal(buf.String(),err) }
+	if err = s.ReadObjTo([]byte("b"),single.ByteRange{0,5},&buf); single.BoilDownError(err)!=single.ENotFound { t.Fatal(err) }
+	
+	serve := func(method,body string,code int,resp string) {
+		ctx := new(fasthttp.RequestCtx)
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetBodyString(body)
+		m.Handler(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(code)
+			ctx.SetBodyString(resp)
+		})(ctx)
+	}
+	serve("PUT","xyz",404,"")
+	serve("PUT","abc",404,"")
+	serve("PATCH","",200,"ok")
+	
+	var out bytes.Buffer
+	if _,err = m.WriteTo(&out); err!=nil { t.Fatal(err) }
+	o := out.String()
+	for _,line := range []string{
+		"# HELP hblobstore_store_operations_total Store operations by operation.",
+		"# TYPE hblobstore_store_operations_total counter",
+		"# TYPE hblobstore_store_operation_duration_seconds histogram",
+		"# HELP hblobstore_test_gauge A test gauge.",
+		"# TYPE hblobstore_test_gauge gauge",
+	} {
+		if !strings.Contains(o,line+"\n") { t.Errorf("missing %q",line) }
+	}
+	s0 := parse(t,o)
+	for series,want := range map[string]string{
+		`hblobstore_store_operations_total{op="put"}`: "1",
+		`hblobstore_store_operations_total{op="read"}`: "2",
+		`hblobstore_store_errors_total{op="read",class="not_found"}`: "1",
+		`hblobstore_store_read_bytes_total`: "5",
+		`hblobstore_store_written_bytes_total`: "5",
+		`hblobstore_http_requests_total{method="PUT",code="404",error="not_found"}`: "2",
+		`hblobstore_http_requests_total{method="other",code="200",error=""}`: "1",
+		`hblobstore_http_request_bytes_total`: "6",
+		`hblobstore_http_response_bytes_total`: "2",
+		`hblobstore_test_gauge`: "2.5",
+	} {
+		if s0[series]!=want { t.Errorf("%s = %q, want %q",series,s0[series],want) }
+	}
+	// Operations, that weren't called, are left out.
+	if _,ok := s0[`hblobstore_store_operations_total{op="delete"}`]; ok { t.Error("delete reported") }
+	checkHistogram(t,o,"hblobstore_store_operation_duration_seconds",`op="read"`,2)
+	checkHistogram(t,o,"hblobstore_http_request_duration_seconds",`method="PUT"`,2)
+	checkHistogram(t,o,"hblobstore_http_request_duration_seconds",`method="other"`,1)
+}
+
+func TestLabels(t *testing.T) {
+	if l := labels("a","x","b","q\"\\\nz"); l!=`a="x",b="q\"\\\nz"` { t.Errorf("labels = %s",l) }
+	if l := labels(); l!="" { t.Errorf("labels = %s",l) }
+	if l := joinLabels("",`le="1"`); l!=`le="1"` { t.Errorf("joinLabels = %s",l) }
+}
+
+///
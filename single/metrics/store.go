@@ -0,0 +1,353 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package metrics
+
+import (
+	"os"
+	"io"
+	"sort"
+	"bytes"
+	"strconv"
+	"sync/atomic"
+	"time"
+	
+	"unsafe"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// The operations of a store.
+const (
+	opInfo = iota
+	opRead
+	opPut
+	opAppend
+	opDelete
+	opStat
+	opList
+	opReadVersion
+	opInfoVersion
+	opListVersions
+	opDeleteVersion
+	opGetRetention
+	opSetRetention
+	opCreateUpload
+	opUploadPart
+	opListParts
+	opCompleteUpload
+	opAbortUpload
+	opFollow
+	numOps
+)
+var opNames = [numOps]string{
+	"info","read","put","append","delete","stat","list",
+	"read_version","info_version","list_versions","delete_version",
+	"get_retention","set_retention",
+	"create_upload","upload_part","list_parts","complete_upload","abort_upload",
+	"follow",
+}
+
+type opStats struct{
+	calls   uint64
+	latency histogram
+}
+
+type errKey struct{
+	op    int
+	class string
+}
+
+// Records a finished operation. Use as "defer m.done(op,time.Now(),&err)".
+func (m *Metrics) done(op int,start time.Time,err *error) {
+	o := &m.ops[op]
+	atomic.AddUint64(&o.calls,1)
+	o.latency.observe(time.Since(start))
+	if *err==nil { return }
+	
+	key := errKey{op,proto.ClassOf(*err)}
+	m.errMu.RLock()
+	c := m.errors[key]
+	m.errMu.RUnlock()
+	if c==nil {
+		m.errMu.Lock()
+		if c = m.errors[key]; c==nil {
+			c = new(uint64)
+			m.errors[key] = c
+		}
+		m.errMu.Unlock()
+	}
+	atomic.AddUint64(c,1)
+}
+
+func (m *Metrics) writeStore(b *bytes.Buffer) {
+	writeHeader(b,"hblobstore_store_operations_total","counter","Store operations by operation.")
+	for i := range m.ops {
+		if n := atomic.LoadUint64(&m.ops[i].calls); n!=0 {
+			writeSample(b,"hblobstore_store_operations_total",labels("op",opNames[i]),strconv.FormatUint(n,10))
+		}
+	}
+	
+	type entry struct{
+		errKey
+		c *uint64
+	}
+	m.errMu.RLock()
+	ents := make([]entry,0,len(m.errors))
+	for k,c := range m.errors { ents = append(ents,entry{k,c}) }
+	m.errMu.RUnlock()
+	sort.Slice(ents,func(i,j int) bool {
+		if ents[i].op!=ents[j].op { return ents[i].op<ents[j].op }
+		return ents[i].class<ents[j].class
+	})
+	writeHeader(b,"hblobstore_store_errors_total","counter","Failed store operations by operation and error class.")
+	for _,e := range ents {
+		writeSample(b,"hblobstore_store_errors_total",labels("op",opNames[e.op],"class",e.class),strconv.FormatUint(atomic.LoadUint64(e.c),10))
+	}
+	
+	writeHeader(b,"hblobstore_store_operation_duration_seconds","histogram","Latency of store operations.")
+	for i := range m.ops {
+		if m.ops[i].latency.empty() { continue }
+		m.ops[i].latency.writeTo(b,"hblobstore_store_operation_duration_seconds",labels("op",opNames[i]))
+	}
+	writeHeader(b,"hblobstore_store_read_bytes_total","counter","Bytes read from objects.")
+	writeSample(b,"hblobstore_store_read_bytes_total","",strconv.FormatUint(atomic.LoadUint64(&m.read),10))
+	writeHeader(b,"hblobstore_store_written_bytes_total","counter","Bytes written to objects.")
+	writeSample(b,"hblobstore_store_written_bytes_total","",strconv.FormatUint(atomic.LoadUint64(&m.written),10))
+}
+
+// Counts the bytes, that are written into a Sink.
+type countSink struct{
+	single.Sink
+	n *uint64
+}
+func (s countSink) Write(p []byte) (n int,err error) {
+	n,err = s.Sink.Write(p)
+	atomic.AddUint64(s.n,uint64(n))
+	return
+}
+func (s countSink) SetBody(data []byte) {
+	atomic.AddUint64(s.n,uint64(len(data)))
+	single.WriteBody(s.Sink,data)
+}
+
+// Counts the bytes, that are read from a Reader.
+type countReader struct{
+	r io.Reader
+	n int64
+}
+func (c *countReader) Read(p []byte) (n int,err error) {
+	n,err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+// Like countSink, for Sinks, that implement single.FileSink.
+type countFileSink struct{
+	countSink
+	fs single.FileSink
+}
+func (s countFileSink) SendFile(f *os.File,off,n int64,done func()) error {
+	atomic.AddUint64(s.n,uint64(n))
+	return s.fs.SendFile(f,off,n,done)
+}
+
+func (m *Metrics) sink(sink single.Sink) single.Sink {
+	cs := countSink{sink,&m.read}
+	if fs,ok := sink.(single.FileSink); ok { return countFileSink{cs,fs} }
+	return cs
+}
+
+/*
+Instruments a store, counting it's operations, errors and transferred bytes,
+and recording their latency.
+*/
+type Store struct{
+	svc single.ObjectSvc
+	m   *Metrics
+}
+
+func Wrap(svc single.ObjectSvc,m *Metrics) *Store {
+	return &Store{svc:svc,m:m}
+}
+
+func (s *Store) Unwrap() single.ObjectSvc { return s.svc }
+
+func (s *Store) PutObj(objectId []byte,data []byte) (err error) {
+	defer s.m.done(opPut,time.Now(),&err)
+	if err = s.svc.PutObj(objectId,data); err==nil { atomic.AddUint64(&s.m.written,uint64(len(data))) }
+	return
+}
+func (s *Store) Append(objectId []byte,data []byte) (pos single.ByteRange,err error) {
+	defer s.m.done(opAppend,time.Now(),&err)
+	if pos,err = s.svc.Append(objectId,data); err==nil { atomic.AddUint64(&s.m.written,uint64(pos[1])) }
+	return
+}
+func (s *Store) ReadObj(objectId []byte,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	defer s.m.done(opRead,time.Now(),&err)
+	return s.svc.ReadObj(objectId,pos,ops,dst)
+}
+func (s *Store) ReadObjTo(objectId []byte,pos single.ByteRange,sink single.Sink) (err error) {
+	defer s.m.done(opRead,time.Now(),&err)
+	return single.ReadTo(s.svc,objectId,pos,s.m.sink(sink))
+}
+func (s *Store) DeleteObj(objectId []byte) (err error) {
+	defer s.m.done(opDelete,time.Now(),&err)
+	return s.svc.DeleteObj(objectId)
+}
+func (s *Store) Info(objectId []byte) (lng int64,err error) {
+	defer s.m.done(opInfo,time.Now(),&err)
+	return s.svc.Info(objectId)
+}
+
+func (s *Store) PutVersion(objectId []byte,data []byte) (ver single.Version,err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	defer s.m.done(opPut,time.Now(),&err)
+	if ver,err = vs.PutVersion(objectId,data); err==nil { atomic.AddUint64(&s.m.written,uint64(len(data))) }
+	return
+}
+func (s *Store) ReplaceObj(objectId []byte,r io.Reader) (ver single.Version,err error) {
+	rs,ok := s.svc.(single.ReplaceSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	defer s.m.done(opPut,time.Now(),&err)
+	cr := &countReader{r:r}
+	if ver,err = rs.ReplaceObj(objectId,cr); err==nil { atomic.AddUint64(&s.m.written,uint64(cr.n)) }
+	return
+}
+func (s *Store) ReadVersion(objectId []byte,ver single.Version,pos single.ByteRange, ops *single.RdOps, dst unsafe.Pointer) (err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opReadVersion,time.Now(),&err)
+	return vs.ReadVersion(objectId,ver,pos,ops,dst)
+}
+func (s *Store) ReadVersionTo(objectId []byte,ver single.Version,pos single.ByteRange,sink single.Sink) (err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opReadVersion,time.Now(),&err)
+	return single.ReadVersionTo(vs,objectId,ver,pos,s.m.sink(sink))
+}
+func (s *Store) InfoVersion(objectId []byte,ver single.Version) (lng int64,err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	defer s.m.done(opInfoVersion,time.Now(),&err)
+	return vs.InfoVersion(objectId,ver)
+}
+func (s *Store) ListVersions(objectId []byte) (vers []single.VersionInfo,err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	defer s.m.done(opListVersions,time.Now(),&err)
+	return vs.ListVersions(objectId)
+}
+func (s *Store) DeleteVersion(objectId []byte,ver single.Version) (err error) {
+	vs,ok := s.svc.(single.VersionSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opDeleteVersion,time.Now(),&err)
+	return vs.DeleteVersion(objectId,ver)
+}
+
+func (s *Store) GetRetention(objectId []byte) (ret single.Retention,err error) {
+	rs,ok := s.svc.(single.RetentionSvc)
+	if !ok { return ret,single.EOpNotSupp }
+	defer s.m.done(opGetRetention,time.Now(),&err)
+	return rs.GetRetention(objectId)
+}
+func (s *Store) SetRetention(objectId []byte,ret single.Retention) (err error) {
+	rs,ok := s.svc.(single.RetentionSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opSetRetention,time.Now(),&err)
+	return rs.SetRetention(objectId,ret)
+}
+
+func (s *Store) ListObjs(prefix, after []byte, limit int) (names [][]byte,err error) {
+	ls,ok := s.svc.(single.ListSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	defer s.m.done(opList,time.Now(),&err)
+	return ls.ListObjs(prefix,after,limit)
+}
+
+func (s *Store) Stat(objectId []byte) (st single.ObjectStat,err error) {
+	ss,ok := s.svc.(single.StatSvc)
+	if !ok { return st,single.EOpNotSupp }
+	defer s.m.done(opStat,time.Now(),&err)
+	return ss.Stat(objectId)
+}
+func (s *Store) Checksum(objectId []byte) (lng int64,crc uint32,ok bool,err error) {
+	cs,isCs := s.svc.(single.ChecksumSvc)
+	if !isCs { return 0,0,false,single.EOpNotSupp }
+	defer s.m.done(opStat,time.Now(),&err)
+	return cs.Checksum(objectId)
+}
+
+func (s *Store) CreateUpload(objectId []byte) (uploadId string,err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return "",single.EOpNotSupp }
+	defer s.m.done(opCreateUpload,time.Now(),&err)
+	return us.CreateUpload(objectId)
+}
+func (s *Store) UploadPart(objectId []byte,uploadId string,part int,data []byte) (err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opUploadPart,time.Now(),&err)
+	if err = us.UploadPart(objectId,uploadId,part,data); err==nil { atomic.AddUint64(&s.m.written,uint64(len(data))) }
+	return
+}
+func (s *Store) ListParts(objectId []byte,uploadId string) (parts []single.PartInfo,err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return nil,single.EOpNotSupp }
+	defer s.m.done(opListParts,time.Now(),&err)
+	return us.ListParts(objectId,uploadId)
+}
+func (s *Store) CompleteUpload(objectId []byte,uploadId string,parts []int) (ver single.Version,err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return 0,single.EOpNotSupp }
+	defer s.m.done(opCompleteUpload,time.Now(),&err)
+	return us.CompleteUpload(objectId,uploadId,parts)
+}
+func (s *Store) AbortUpload(objectId []byte,uploadId string) (err error) {
+	us,ok := s.svc.(single.UploadSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opAbortUpload,time.Now(),&err)
+	return us.AbortUpload(objectId,uploadId)
+}
+
+// The latency of follow is the time, that it was followed.
+func (s *Store) Follow(objectId []byte,off int64,done <-chan struct{},fn func(data []byte) error) (err error) {
+	fs,ok := s.svc.(single.FollowSvc)
+	if !ok { return single.EOpNotSupp }
+	defer s.m.done(opFollow,time.Now(),&err)
+	return fs.Follow(objectId,off,done,func(data []byte) error {
+		atomic.AddUint64(&s.m.read,uint64(len(data)))
+		return fn(data)
+	})
+}
+
+// Closes the wrapped store, if it is an io.Closer.
+func (s *Store) Close() error {
+	if c,ok := s.svc.(io.Closer); ok { return c.Close() }
+	return nil
+}
+
+///
@@ -0,0 +1,58 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package nhapi
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"net/http"
+)
+
+/*
+Wraps the http.ResponseWriter as single.Sink. Headers can be changed until
+the body is written, which commits the status code 200.
+*/
+type response struct{
+	w     http.ResponseWriter
+	wrote bool
+}
+func (r *response) Write(p []byte) (int,error) {
+	r.wrote = true
+	return r.w.Write(p)
+}
+
+// Net/http uses sendfile(2), when copying from an *io.LimitedReader of a file.
+func (r *response) SendFile(f *os.File,off,n int64,done func()) (err error) {
+	defer done()
+	defer f.Close()
+	if _,err = f.Seek(off,io.SeekStart); err!=nil { return }
+	r.w.Header().Set("Content-Length",strconv.FormatInt(n,10))
+	r.wrote = true
+	_,err = io.Copy(r.w,&io.LimitedReader{R:f,N:n})
+	return
+}
+
+///
@@ -0,0 +1,58 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package nhapi
+
+import (
+	"io"
+	"net/http"
+	"encoding/json"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// Executes the batch in the body, see proto.BatchOp, authorizing every operation.
+func (h *Handler) postBatch(w http.ResponseWriter, r *http.Request, id *auth.Identity) {
+	data,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var bops []proto.BatchOp
+	if json.Unmarshal(data,&bops)!=nil || len(bops)>proto.MaxBatch {
+		setError(single.EInvalid,w,false)
+		return
+	}
+	var authorize func(op proto.Op,object string) error
+	if h.auth!=nil {
+		authorize = func(op proto.Op,object string) error { return h.auth.Authorize(id,op,object) }
+	}
+	res := h.b.Run(bops,authorize)
+	if res==nil { res = []proto.BatchResult{} }
+	writeJSON(w,res)
+}
+
+///
@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package nhapi
+
+import (
+	"context"
+	"strconv"
+	"net/http"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+// Streams the object from X-Offset, following appends, see proto.MaxFollow.
+func (h *Handler) followObject(w http.ResponseWriter, r *http.Request, obj []byte, arg string) {
+	d,err := proto.ParseFollow(arg)
+	switch {
+	case err!=nil:
+	case h.fs==nil: err = single.EOpNotSupp
+	case r.URL.Query().Get("version")!="": err = single.EInvalid
+	}
+	if err==nil { _,err = h.svc.Info(obj) }
+	if err!=nil {
+		setError(err,w,true)
+		return
+	}
+	off := headerInt(r,"X-Offset")
+	if off<0 { off = 0 }
+	w.Header().Set("X-Offset",strconv.FormatInt(off,10))
+	w.Header().Set("Content-Type","application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	fl,_ := w.(http.Flusher)
+	if fl!=nil { fl.Flush() }
+	
+	// Ends on timeout, or when the client goes away.
+	ctx,cancel := context.WithTimeout(r.Context(),d)
+	defer cancel()
+	
+	// The stream just ends, once the object is deleted.
+	h.fs.Follow(obj,off,ctx.Done(),func(data []byte) (err error) {
+		if _,err = w.Write(data); err!=nil { return }
+		if fl!=nil { fl.Flush() }
+		return
+	})
+}
+
+///
@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package nhapi_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"strconv"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	
+	"github.com/valyala/fasthttp"
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+	nhapi "github.com/byte-mug/hblobstore/single/nethttp-api"
+	sfhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+)
+
+func testStore(t *testing.T,opts files.Options) single.ObjectSvc {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),opts)
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		if c,ok := svc.(io.Closer); ok { c.Close() }
+	})
+	return svc
+}
+
+// Serves a new store with both frontends. Returns their base URLs.
+func testServers(t *testing.T,opts files.Options) (nethttp,fast string) {
+	t.Helper()
+	hs := httptest.NewServer(nhapi.New(testStore(t,opts)))
+	t.Cleanup(hs.Close)
+	router := fhr.New()
+	sfhapi.RegisterObjectSvc(testStore(t,opts),router)
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	go fasthttp.Serve(ln,router.Handler)
+	t.Cleanup(func() { ln.Close() })
+	return hs.URL,"http://"+ln.Addr().String()
+}
+
+type parityReq struct{
+	method,path,body string
+	hdr []string
+	
+	// The body and the X-Version and X-Upload-Id headers differ between the stores.
+	volatile bool
+}
+
+// The parts of a response, that must be equal.
+func result(t *testing.T,base string,r parityReq) string {
+	t.Helper()
+	req,err := http.NewRequest(r.method,base+r.path,strings.NewReader(r.body))
+	if err!=nil { t.Fatal(err) }
+	for i := 0; i+1<len(r.hdr); i += 2 { req.Header.Set(r.hdr[i],r.hdr[i+1]) }
+	resp,err := http.DefaultClient.Do(req)
+	if err!=nil { t.Fatal(err) }
+	defer resp.Body.Close()
+	body,_ := io.ReadAll(resp.Body)
+	s := resp.Status[:3]
+	for _,h := range []string{"X-Offset","X-Length","X-Retain-Until","X-Legal-Hold","X-Error","Content-Type"} {
+		if v := resp.Header.Get(h); v!="" { s += " "+h+"="+v }
+	}
+	if !r.volatile { s += " "+string(body) }
+	return s
+}
+
+func testParity(t *testing.T,opts files.Options,reqs []parityReq) {
+	nethttp,fast := testServers(t,opts)
+	for _,r := range reqs {
+		a,b := result(t,nethttp,r),result(t,fast,r)
+		if a!=b { t.Errorf("%s %s:\nnet/http %s\nfasthttp %s",r.method,r.path,a,b) }
+	}
+}
+
+func TestParity(t *testing.T) {
+	testParity(t,files.Options{},[]parityReq{
+		{method:"PUT",path:"/o/a",body:"hello"},
+		{method:"PUT",path:"/o/a",body:"again"},
+		{method:"POST",path:"/o/a",body:" world"},
+		{method:"POST",path:"/o/n",body:"new"},
+		{method:"GET",path:"/o/a"},
+		{method:"GET",path:"/o/a",hdr:[]string{"X-Offset","6","X-Length","3"}},
+		{method:"GET",path:"/o/a",hdr:[]string{"X-Offset","20"}},
+		{method:"GET",path:"/o/missing"},
+		{method:"GET",path:"/o/a?version=1"},
+		{method:"GET",path:"/o/a?version=x"},
+		{method:"OPTIONS",path:"/o/a"},
+		{method:"OPTIONS",path:"/o/missing"},
+		{method:"GET",path:"/v/a"},
+		{method:"GET",path:"/l"},
+		{method:"GET",path:"/l?prefix=a&limit=1"},
+		{method:"GET",path:"/l?after=a"},
+		{method:"PUT",path:"/r/n",hdr:[]string{"X-Retain-Until","4102444800"}},
+		{method:"OPTIONS",path:"/r/n"},
+		{method:"DELETE",path:"/o/n"},
+		{method:"PUT",path:"/r/n",hdr:[]string{"X-Retain-Until","0"}},
+		{method:"PUT",path:"/r/a",hdr:[]string{"X-Legal-Hold","1"}},
+		{method:"DELETE",path:"/o/a"},
+		{method:"PUT",path:"/r/a"},
+		{method:"OPTIONS",path:"/r/a"},
+		{method:"DELETE",path:"/o/missing"},
+		{method:"POST",path:"/b",body:`[{"op":"put","object":"c","data":"Yw=="},{"op":"read","object":"c"},{"op":"read","object":"missing"}]`},
+		{method:"POST",path:"/b",body:`[{"op":"nope"}]`},
+		{method:"POST",path:"/b",body:`{`},
+		{method:"POST",path:"/u/up",volatile:true},
+		{method:"PUT",path:"/u/up?upload=00000000000000000000000000000000&part=1",body:"x"},
+		{method:"GET",path:"/u/up?upload=00000000000000000000000000000000"},
+		{method:"DELETE",path:"/u/up?upload=nope"},
+	})
+}
+
+func TestParityVersioned(t *testing.T) {
+	testParity(t,files.Options{Versioning:true},[]parityReq{
+		{method:"PUT",path:"/o/a",body:"one",volatile:true},
+		{method:"PUT",path:"/o/a",body:"two",volatile:true},
+		{method:"POST",path:"/o/a",body:"!"},
+		{method:"GET",path:"/o/a"},
+		{method:"GET",path:"/v/a",volatile:true},
+		{method:"DELETE",path:"/o/a"},
+		{method:"GET",path:"/o/a"},
+		{method:"OPTIONS",path:"/o/a"},
+		{method:"GET",path:"/o/a?version=1"},
+		{method:"DELETE",path:"/o/a?version=1"},
+	})
+}
+
+// Like fasthttp, bodies beyond the limit are rejected with 400.
+func TestBodyLimit(t *testing.T) {
+	svc := testStore(t,files.Options{})
+	h := nhapi.New(svc)
+	h.MaxRequestBodySize = 8
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+	for _,r := range []struct{
+		method,path,body string
+		code int
+	}{
+		{"PUT","/o/a","12345678",201},
+		{"PUT","/o/b","123456789",400},
+		{"POST","/o/a","123456789",400},
+		{"POST","/b",`[{"op":"put","object":"c","data":"YQ=="}]`,400},
+		{"PUT","/u/up?upload=00000000000000000000000000000000&part=1","123456789",400},
+		{"POST","/o/a","9",201},
+	} {
+		if s := result(t,hs.URL,parityReq{method:r.method,path:r.path,body:r.body,volatile:true}); s[:3]!=strconv.Itoa(r.code) {
+			t.Errorf("%s %s with %d bytes: %s",r.method,r.path,len(r.body),s)
+		}
+	}
+	if _,err := svc.Info([]byte("b")); err!=single.ENotFound { t.Errorf("b: %v",err) }
+	if lng,err := svc.Info([]byte("a")); lng!=9 || err!=nil { t.Errorf("a: %d %v",lng,err) }
+	if _,err := svc.Info([]byte("c")); err!=single.ENotFound { t.Errorf("c: %v",err) }
+}
+
+///
@@ -0,0 +1,348 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Exposes single.ObjectSvc as net/http Handler, with the same routes and error
+responses as single/fasthttp-api.
+*/
+package nhapi
+
+import (
+	"io"
+	"bytes"
+	"strconv"
+	"net/http"
+	"crypto/x509"
+	"encoding/json"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/batch"
+	"github.com/byte-mug/hblobstore/single/proto"
+	"github.com/byte-mug/hblobstore/util/bconv"
+)
+
+func setError(err error,w http.ResponseWriter, isR bool) {
+	code,header,value := proto.StatusOf(err,isR)
+	if header!="" { w.Header().Add(header,value) }
+	w.WriteHeader(code)
+	if err!=nil { io.WriteString(w,err.Error()) }
+}
+
+func headerInt(r *http.Request,name string) int64 {
+	v,_ := bconv.ParseUint64([]byte(r.Header.Get(name)))
+	return v
+}
+
+// Parses the optional "version" query argument.
+func queryVersion(r *http.Request) (ver single.Version,err error) {
+	if arg := r.URL.Query().Get("version"); arg!="" {
+		if ver,err = single.ParseVersion([]byte(arg)); err!=nil { err = single.ENotFound }
+	}
+	return
+}
+
+func writeJSON(w http.ResponseWriter,v interface{}) {
+	data,_ := json.Marshal(v)
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+type Handler struct{
+	// The limit of request bodies, like fasthttp.Server.MaxRequestBodySize.
+	// Zero means fasthttp.DefaultMaxRequestBodySize. Like fasthttp, larger
+	// bodies are rejected with 400.
+	MaxRequestBodySize int
+	
+	auth *auth.Auth
+	svc single.ObjectSvc
+	vs  single.VersionSvc
+	rs  single.RetentionSvc
+	ls  single.ListSvc
+	us  single.UploadSvc
+	fs  single.FollowSvc
+	b   *batch.Batch
+}
+
+func New(svc single.ObjectSvc) *Handler {
+	return NewAuth(svc,nil)
+}
+
+// Like New, but requests are checked by a, if not nil.
+func NewAuth(svc single.ObjectSvc,a *auth.Auth) *Handler {
+	return &Handler{
+		auth: a,
+		svc: svc,
+		vs: single.AsVersionSvc(svc),
+		rs: single.AsRetentionSvc(svc),
+		ls: single.AsListSvc(svc),
+		us: single.AsUploadSvc(svc),
+		fs: single.AsFollowSvc(svc),
+		b: batch.New(svc,batch.DefaultWorkers),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route,object,found := proto.Match(r.Method,r.URL.Path)
+	if route==nil {
+		if found {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		} else {
+			http.NotFound(w,r)
+		}
+		return
+	}
+	limit := h.MaxRequestBodySize
+	if limit<=0 { limit = fasthttp.DefaultMaxRequestBodySize }
+	r.Body = http.MaxBytesReader(w,r.Body,int64(limit))
+	
+	var id auth.Identity
+	if h.auth!=nil {
+		var ok bool
+		if id,ok = h.check(w,r,route.Op,object); !ok { return }
+	}
+	obj := []byte(object)
+	switch route.Op {
+	case proto.OpInfo: h.headObject(w,r,obj)
+	case proto.OpRead: h.getObject(w,r,obj)
+	case proto.OpPut: h.putObject(w,r,obj)
+	case proto.OpAppend: h.postObject(w,r,obj)
+	case proto.OpDelete: h.deleteObject(w,r,obj)
+	case proto.OpListVersions: h.listVersions(w,r,obj)
+	case proto.OpGetRetention: h.headRetention(w,r,obj)
+	case proto.OpSetRetention: h.putRetention(w,r,obj)
+	case proto.OpList: h.listObjects(w,r)
+	case proto.OpUpload: h.postUpload(w,r,obj)
+	case proto.OpUploadPart: h.putPart(w,r,obj)
+	case proto.OpListParts: h.listParts(w,r,obj)
+	case proto.OpAbortUpload: h.abortUpload(w,r,obj)
+	case proto.OpBatch: h.postBatch(w,r,&id)
+	}
+}
+
+// Exposes a *http.Request as auth.Request.
+type authReq struct{
+	r *http.Request
+}
+func (r authReq) Method() string { return r.r.Method }
+func (r authReq) Path() string { return r.r.URL.Path }
+func (r authReq) RawQuery() string { return r.r.URL.RawQuery }
+func (r authReq) Header(name string) string { return r.r.Header.Get(name) }
+func (r authReq) VerifiedChains() [][]*x509.Certificate {
+	if r.r.TLS==nil { return nil }
+	return r.r.TLS.VerifiedChains
+}
+
+/*
+Checks the request. A signed body is read and verified up front. The operations
+of batches are authorized by postBatch.
+*/
+func (h *Handler) check(w http.ResponseWriter, r *http.Request, op proto.Op, object string) (id auth.Identity,ok bool) {
+	var err error
+	switch op {
+	case proto.OpList: id,err = h.auth.Check(authReq{r},op,r.URL.Query().Get("prefix"))
+	case proto.OpBatch: id,err = h.auth.Authenticate(authReq{r})
+	default: id,err = h.auth.Check(authReq{r},op,object)
+	}
+	if err==nil && id.Payload!="" {
+		var data []byte
+		if data,err = io.ReadAll(r.Body); err!=nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = id.CheckPayload(data)
+		r.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	if err!=nil {
+		setError(err,w,false)
+		return
+	}
+	return id,true
+}
+
+func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	ver,err := queryVersion(r)
+	var sz int64
+	switch {
+	case err!=nil:
+	case ver==0: sz,err = h.svc.Info(obj)
+	case h.vs==nil: err = single.EOpNotSupp
+	default: sz,err = h.vs.InfoVersion(obj,ver)
+	}
+	if err!=nil {
+		setError(err,w,true)
+		return
+	}
+	w.Header().Set("X-Length",strconv.FormatInt(sz,10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if q := r.URL.Query(); q.Has("follow") {
+		h.followObject(w,r,obj,q.Get("follow"))
+		return
+	}
+	var rang single.ByteRange
+	rang[0],_ = bconv.ParseUint64([]byte(r.Header.Get("X-Offset")))
+	rang[1],_ = bconv.ParseUint64([]byte(r.Header.Get("X-Length")))
+	
+	resp := &response{w:w}
+	ver,err := queryVersion(r)
+	switch {
+	case err!=nil:
+	case ver==0: err = single.ReadTo(h.svc,obj,rang,resp)
+	case h.vs==nil: err = single.EOpNotSupp
+	default: err = single.ReadVersionTo(h.vs,obj,ver,rang,resp)
+	}
+	if err==nil {
+		if !resp.wrote { w.WriteHeader(http.StatusOK) }
+	} else if !resp.wrote {
+		setError(err,w,true)
+	} else {
+		// The status has been sent, abort the truncated response.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	data,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var ver single.Version
+	if h.vs!=nil {
+		ver,err = h.vs.PutVersion(obj,data)
+	} else {
+		err = h.svc.PutObj(obj,data)
+	}
+	if err!=nil {
+		setError(err,w,false)
+		return
+	}
+	if ver!=0 { w.Header().Set("X-Version",ver.String()) }
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) postObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	data,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rang,err := h.svc.Append(obj,data)
+	if err!=nil {
+		setError(err,w,false)
+		return
+	}
+	w.Header().Set("X-Offset",strconv.FormatInt(rang[0],10))
+	w.Header().Set("X-Length",strconv.FormatInt(rang[1],10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, obj []byte) {
+	ver,err := queryVersion(r)
+	switch {
+	case err!=nil:
+	case ver==0: err = h.svc.DeleteObj(obj)
+	case h.vs==nil: err = single.EOpNotSupp
+	default: err = h.vs.DeleteVersion(obj,ver)
+	}
+	if err!=nil {
+		setError(err,w,false)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) listVersions(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.vs==nil {
+		setError(single.EOpNotSupp,w,true)
+		return
+	}
+	vers,err := h.vs.ListVersions(obj)
+	if err!=nil {
+		setError(err,w,true)
+		return
+	}
+	if vers==nil { vers = []single.VersionInfo{} }
+	writeJSON(w,vers)
+}
+
+func (h *Handler) headRetention(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.rs==nil {
+		setError(single.EOpNotSupp,w,true)
+		return
+	}
+	ret,err := h.rs.GetRetention(obj)
+	if err!=nil {
+		setError(err,w,true)
+		return
+	}
+	w.Header().Set("X-Retain-Until",strconv.FormatInt(ret.Until,10))
+	if ret.LegalHold {
+		w.Header().Set("X-Legal-Hold","1")
+	} else {
+		w.Header().Set("X-Legal-Hold","0")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) putRetention(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.rs==nil {
+		setError(single.EOpNotSupp,w,false)
+		return
+	}
+	var ret single.Retention
+	ret.Until = headerInt(r,"X-Retain-Until")
+	if ret.Until<0 { ret.Until = 0 }
+	ret.LegalHold = r.Header.Get("X-Legal-Hold")=="1"
+	if err := h.rs.SetRetention(obj,ret); err!=nil {
+		setError(err,w,false)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request) {
+	if h.ls==nil {
+		setError(single.EOpNotSupp,w,true)
+		return
+	}
+	q := r.URL.Query()
+	limit,err := strconv.Atoi(q.Get("limit"))
+	if err!=nil || limit<=0 || limit>1000 { limit = 1000 }
+	names,err := h.ls.ListObjs([]byte(q.Get("prefix")),[]byte(q.Get("after")),limit)
+	if err!=nil {
+		setError(err,w,true)
+		return
+	}
+	strs := make([]string,len(names))
+	for i,name := range names { strs[i] = string(name) }
+	writeJSON(w,strs)
+}
+
+///
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package nhapi
+
+import (
+	"io"
+	"strconv"
+	"net/http"
+	"encoding/json"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+func (h *Handler) postUpload(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,w,false)
+		return
+	}
+	body,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uploadId := r.URL.Query().Get("upload")
+	if uploadId=="" {
+		id,err := h.us.CreateUpload(obj)
+		if err!=nil {
+			setError(err,w,false)
+			return
+		}
+		w.Header().Set("X-Upload-Id",id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	var parts []int
+	if len(body)!=0 && json.Unmarshal(body,&parts)!=nil {
+		setError(single.EInvalid,w,false)
+		return
+	}
+	ver,err := h.us.CompleteUpload(obj,uploadId,parts)
+	if err!=nil {
+		setError(err,w,false)
+		return
+	}
+	if ver!=0 { w.Header().Set("X-Version",ver.String()) }
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) putPart(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,w,false)
+		return
+	}
+	q := r.URL.Query()
+	part,err := strconv.Atoi(q.Get("part"))
+	if err!=nil {
+		setError(single.EInvalid,w,false)
+		return
+	}
+	data,err := io.ReadAll(r.Body)
+	if err!=nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = h.us.UploadPart(obj,q.Get("upload"),part,data); err!=nil {
+		setError(err,w,false)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) listParts(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,w,true)
+		return
+	}
+	parts,err := h.us.ListParts(obj,r.URL.Query().Get("upload"))
+	if err!=nil {
+		setError(err,w,true)
+		return
+	}
+	if parts==nil { parts = []single.PartInfo{} }
+	writeJSON(w,parts)
+}
+
+func (h *Handler) abortUpload(w http.ResponseWriter, r *http.Request, obj []byte) {
+	if h.us==nil {
+		setError(single.EOpNotSupp,w,false)
+		return
+	}
+	if err := h.us.AbortUpload(obj,r.URL.Query().Get("upload")); err!=nil {
+		setError(err,w,false)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+///
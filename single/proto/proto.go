@@ -0,0 +1,252 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Describes the HTTP protocol of the object service, that is shared by the
+frontends (single/fasthttp-api, single/nethttp-api) and the client.
+*/
+package proto
+
+import (
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+/*
+A GET of an object with the query argument "follow={seconds}" streams the
+object from X-Offset, and the data, that is appended to it, for that long, or
+until the object is deleted.
+*/
+const MaxFollow = time.Hour
+
+// Parses the argument "follow". The duration is limited to MaxFollow.
+func ParseFollow(arg string) (d time.Duration,err error) {
+	secs,err := strconv.ParseUint(arg,10,32)
+	if err!=nil { return 0,single.EInvalid }
+	d = time.Duration(secs)*time.Second
+	if d>MaxFollow { d = MaxFollow }
+	return
+}
+
+/*
+An operation of a batch. The body of a batch is a JSON array of them, and the
+response a JSON array of a BatchResult for each, in the same order.
+
+Op is one of "info", "read", "put", "append" and "delete". Version selects a
+version for info, read and delete. Offset and Length select the range of
+read, a Length of 0 reads to the end. Data is the content of put and append.
+*/
+type BatchOp struct{
+	Op      string         `json:"op"`
+	Object  string         `json:"object"`
+	Version single.Version `json:"version,omitempty"`
+	Offset  int64          `json:"offset,omitempty"`
+	Length  int64          `json:"length,omitempty"`
+	Data    []byte         `json:"data,omitempty"`
+}
+
+/*
+The result of a BatchOp. Status is the status code of the equivalent request,
+and Error the class of the error, see ClassOf. Offset and Length are the
+written range of append, or the length of info, and Data the content of read.
+Version is the version, that was created by put on versioned stores.
+*/
+type BatchResult struct{
+	Status  int            `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Offset  int64          `json:"offset,omitempty"`
+	Length  int64          `json:"length,omitempty"`
+	Version single.Version `json:"version,omitempty"`
+	Data    []byte         `json:"data,omitempty"`
+}
+
+// Returns the error of the result, or nil.
+func (r *BatchResult) Err() error {
+	if r.Error=="" { return nil }
+	for _,s := range statuses {
+		if s.class==r.Error { return s.err }
+	}
+	return errors.New("batch: "+r.Error)
+}
+
+/*
+A mutation of an object, for the audit log. Frontends, whose requests don't
+match the Routes, like S3, store the successful mutations of a request as
+[]Mutation under the user value MutationsKey. Op is named like the operations
+of the audit log, Range is the written byte range, if known.
+*/
+type Mutation struct{
+	Op      string
+	Object  string
+	Version single.Version
+	Range   []int64
+}
+
+const MutationsKey = "mutations"
+
+// Limits of batches: the number of operations, and the length of each read.
+const (
+	MaxBatch     = 1000
+	MaxBatchRead = 1<<20
+)
+
+// Operations of the object service.
+type Op int
+const (
+	OpInfo Op = iota
+	OpRead
+	OpPut
+	OpAppend
+	OpDelete
+	OpListVersions
+	OpGetRetention
+	OpSetRetention
+	OpList
+	
+	// Multipart uploads. OpUpload initiates an upload, or completes it, if
+	// the query names one.
+	OpUpload
+	OpUploadPart
+	OpListParts
+	OpAbortUpload
+	
+	// A batch of operations, that are authorized one by one, see BatchOp.
+	OpBatch
+)
+
+type Route struct{
+	Method string
+	Path   string
+	Op     Op
+}
+
+// The routes. ":object" matches a single path segment.
+var Routes = []Route{
+	{"OPTIONS","/o/:object",OpInfo},
+	{"GET"    ,"/o/:object",OpRead},
+	{"PUT"    ,"/o/:object",OpPut},
+	{"POST"   ,"/o/:object",OpAppend},
+	{"DELETE" ,"/o/:object",OpDelete},
+	{"GET"    ,"/v/:object",OpListVersions},
+	{"OPTIONS","/r/:object",OpGetRetention},
+	{"PUT"    ,"/r/:object",OpSetRetention},
+	{"GET"    ,"/l"        ,OpList},
+	{"POST"   ,"/u/:object",OpUpload},
+	{"PUT"    ,"/u/:object",OpUploadPart},
+	{"GET"    ,"/u/:object",OpListParts},
+	{"DELETE" ,"/u/:object",OpAbortUpload},
+	{"POST"   ,"/b"        ,OpBatch},
+}
+
+func (r *Route) match(path string) (object string,ok bool) {
+	i := strings.Index(r.Path,":")
+	if i<0 { return "",path==r.Path }
+	object = strings.TrimPrefix(path,r.Path[:i])
+	if len(object)==len(path) || !ValidObject(object) { return "",false }
+	return object,true
+}
+
+// Reports, whether name can be used as object, like ":object" in a route.
+func ValidObject(name string) bool {
+	return name!="" && strings.IndexByte(name,'/')<0
+}
+
+// Finds the route of a request. If only the method doesn't match, found is true.
+func Match(method,path string) (route *Route,object string,found bool) {
+	for i := range Routes {
+		r := &Routes[i]
+		obj,ok := r.match(path)
+		if !ok { continue }
+		found = true
+		if r.Method==method { return r,obj,true }
+	}
+	return
+}
+
+type status struct{
+	err    error
+	code   int
+	header string
+	value  string
+	class  string
+}
+
+// The error responses. Entries with header come first, thus ErrorOf prefers them.
+var statuses = []status{
+	{single.EIsReadOnly        ,405,"X-Reason","read_only","read_only"},
+	{single.EServerAccessDenied,500,"X-Error" ,"access_denied","access_denied"},
+	{single.EDiskFailure       ,500,"X-Error" ,"disk_failure","disk_failure"},
+	{single.EBeingDeleted      ,500,"X-Error" ,"being_deleted","being_deleted"},
+	{single.ERetained          ,403,"X-Reason","retained","retained"},
+	{single.EUnauthorized      ,401,"WWW-Authenticate",`Bearer realm="hblobstore"`,"unauthorized"},
+	{single.EForbidden         ,403,"X-Reason","forbidden","forbidden"},
+	{single.EOpNotSupp         ,501,"","","not_supported"},
+	{single.ENotFound          ,404,"","","not_found"},
+	{single.EExist             ,409,"","","exists"},
+	{single.EInvalid           ,400,"","","invalid"},
+}
+
+// Returns a short name of err, like "not_found", for logs and metrics.
+// Errors, that aren't from package single, are "unknown".
+func ClassOf(err error) string {
+	if err==nil { return "" }
+	err = single.BoilDownError(err)
+	for _,s := range statuses {
+		if s.err==err { return s.class }
+	}
+	return "unknown"
+}
+
+/*
+Returns the status code and an optional header of the error response of err.
+isR is true for read operations, where EBeingDeleted is reported as ENotFound.
+*/
+func StatusOf(err error,isR bool) (code int,header,value string) {
+	err = single.BoilDownError(err)
+	switch {
+	case err==nil:
+		return 500,"X-Error","nil_error"
+	case err==single.EBeingDeleted && isR:
+		err = single.ENotFound
+	}
+	for _,s := range statuses {
+		if s.err==err { return s.code,s.header,s.value }
+	}
+	return 500,"X-Error","unknown_error"
+}
+
+// Returns the error of an error response, or nil, if it isn't known.
+func ErrorOf(code int,header func(name string) string) error {
+	for _,s := range statuses {
+		if s.code!=code { continue }
+		if s.header=="" || header(s.header)==s.value { return s.err }
+	}
+	return nil
+}
+
+///
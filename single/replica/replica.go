@@ -0,0 +1,400 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+Asynchronous replication of a store from a primary server.
+
+The replica follows the event log of the primary, see single/events, and
+applies the events in order: created objects are copied, appends are copied by
+their byte range, if the replica has the object up to the offset, and deleted
+objects are removed. Everything else, like an object, that changed meanwhile,
+is repaired by copying the object again. A full resynchronization lists both
+stores and copies the objects, whose length or SHA-256 differs. It runs
+initially, and whenever events were lost, since the primary dropped their
+segment.
+
+Objects are copied into a temporary file, that replaces the local object
+atomically, thus the store must implement single.ReplaceSvc.
+
+The replica holds the latest versions only, it's versions are numbered on
+their own.
+*/
+package replica
+
+import (
+	"os"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"bytes"
+	"errors"
+	"crypto/sha256"
+	"encoding/json"
+	"path/filepath"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/events"
+)
+
+// Objects are copied in pieces of this size.
+const copyChunk = 4<<20
+
+// Limits of the polling.
+const (
+	pollWait   = 30*time.Second
+	maxBackoff = 30*time.Second
+)
+
+var ErrPromoted = errors.New("replica: promoted to primary")
+
+// The primary, usually a *client.Client.
+type Source interface{
+	single.ObjectSvc
+	single.ListSvc
+	Events(cursor uint64,wait time.Duration) (evs []events.Event,next,last uint64,err error)
+}
+
+// Persisted in the state file.
+type state struct{
+	Cursor   uint64 `json:"cursor"`
+	Synced   bool   `json:"synced"`
+	Promoted bool   `json:"promoted"`
+}
+
+type Replica struct{
+	src   Source
+	dst   single.ObjectSvc
+	rs    single.ReplaceSvc
+	pth   string
+	name  string
+	
+	mu       sync.Mutex
+	st       state
+	last     uint64
+	upToDate time.Time
+	resyncs  int
+	err      error
+	
+	// Serializes save.
+	sl   sync.Mutex
+	
+	// Held while the store is written to, so that nothing is written after stop is closed.
+	wl   sync.Mutex
+	stop chan struct{}
+	once sync.Once
+}
+
+/*
+Creates a replica of src, that writes to dst, and keeps it's state in the file
+pth. name describes the primary in the status. Fails with ErrPromoted, if the
+replica was promoted before, and with single.EOpNotSupp, if dst can't replace
+objects.
+*/
+func New(src Source,dst single.ObjectSvc,pth,name string) (*Replica,error) {
+	rs := single.AsReplaceSvc(dst)
+	if rs==nil { return nil,single.EOpNotSupp }
+	r := &Replica{src:src,dst:dst,rs:rs,pth:pth,name:name,upToDate:time.Now(),stop:make(chan struct{})}
+	data,err := os.ReadFile(pth)
+	switch {
+	case os.IsNotExist(err):
+	case err!=nil: return nil,err
+	default:
+		if err = json.Unmarshal(data,&r.st); err!=nil { return nil,errors.New("replica: "+pth+": "+err.Error()) }
+	}
+	if r.st.Promoted { return nil,ErrPromoted }
+	return r,nil
+}
+
+// Writes the state file durably, by syncing it, and it's directory after the rename.
+func (r *Replica) save() (err error) {
+	r.sl.Lock(); defer r.sl.Unlock()
+	r.mu.Lock()
+	data,_ := json.Marshal(&r.st)
+	r.mu.Unlock()
+	tmp := r.pth+".tmp"
+	f,err := os.Create(tmp)
+	if err!=nil { return }
+	if _,err = f.Write(data); err==nil { err = f.Sync() }
+	if cerr := f.Close(); err==nil { err = cerr }
+	if err==nil { err = os.Rename(tmp,r.pth) }
+	if err!=nil { return }
+	d,err := os.Open(filepath.Dir(r.pth))
+	if err!=nil { return }
+	defer d.Close()
+	return d.Sync()
+}
+
+// Starts replicating in the background.
+func (r *Replica) Start() {
+	go r.run()
+}
+
+func (r *Replica) stopped() bool {
+	select {
+	case <-r.stop: return true
+	default: return false
+	}
+}
+
+// Waits for d, returns false, if the replica is stopped meanwhile.
+func (r *Replica) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-r.stop: return false
+	case <-t.C: return true
+	}
+}
+
+func (r *Replica) fail(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+	if err!=nil { log.Println("replica:",err) }
+}
+
+var errStopped = errors.New("replica: stopped")
+
+// Calls fn, unless the replica is stopped.
+func (r *Replica) write(fn func() error) error {
+	r.wl.Lock(); defer r.wl.Unlock()
+	if r.stopped() { return errStopped }
+	return fn()
+}
+
+func (r *Replica) run() {
+	backoff := time.Second
+	for !r.stopped() {
+		err := r.step()
+		if err==errStopped { return }
+		r.fail(err)
+		if err==nil {
+			backoff = time.Second
+			continue
+		}
+		if !r.sleep(backoff) { return }
+		if backoff *= 2; backoff>maxBackoff { backoff = maxBackoff }
+	}
+}
+
+// Polls the primary for events and applies them.
+func (r *Replica) step() (err error) {
+	r.mu.Lock()
+	cursor,synced := r.st.Cursor,r.st.Synced
+	r.mu.Unlock()
+	wait := pollWait
+	if !synced { wait = 0 }
+	evs,next,last,err := r.src.Events(cursor,wait)
+	if err!=nil { return }
+	r.mu.Lock()
+	r.last = last
+	r.mu.Unlock()
+	
+	// Events were lost, or the primary's log was reset.
+	if !synced || (len(evs)!=0 && evs[0].Seq>cursor+1) || last<cursor {
+		return r.resync(last)
+	}
+	for i := range evs {
+		if err = r.write(func() error { return r.apply(&evs[i]) }); err!=nil { return }
+		r.advance(evs[i].Seq,last)
+	}
+	if next>cursor { r.advance(next,last) }
+	return r.save()
+}
+
+func (r *Replica) advance(cursor,last uint64) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	r.st.Cursor = cursor
+	if cursor>=last { r.upToDate = time.Now() }
+}
+
+// Copies every object of the primary, whose length or content differs, and removes the others.
+func (r *Replica) resync(last uint64) (err error) {
+	log.Println("replica: resynchronizing with",r.name)
+	ls := single.AsListSvc(r.dst)
+	if ls==nil { return single.EOpNotSupp }
+	var after []byte
+	for {
+		names,err := r.src.ListObjs(nil,after,1000)
+		if err!=nil { return err }
+		if len(names)==0 { break }
+		if err = r.removeBetween(ls,after,names[len(names)-1],names); err!=nil { return err }
+		for _,name := range names {
+			if err = r.write(func() error { return r.resyncObject(name) }); err!=nil { return err }
+		}
+		after = names[len(names)-1]
+	}
+	
+	// The objects after the last one of the primary.
+	if err = r.removeBetween(ls,after,nil,nil); err!=nil { return }
+	r.mu.Lock()
+	r.st.Cursor,r.st.Synced = last,true
+	r.resyncs++
+	r.mu.Unlock()
+	r.advance(last,last)
+	return r.save()
+}
+
+/*
+Removes the local objects after from, up to and including to, that are not in
+names. If to is nil, the objects after from are removed.
+*/
+func (r *Replica) removeBetween(ls single.ListSvc,from,to []byte,names [][]byte) error {
+	keep := make(map[string]bool,len(names))
+	for _,name := range names { keep[string(name)] = true }
+	for {
+		local,err := ls.ListObjs(nil,from,1000)
+		if err!=nil { return err }
+		if len(local)==0 { return nil }
+		for _,name := range local {
+			if to!=nil && bytes.Compare(name,to)>0 { return nil }
+			if keep[string(name)] { continue }
+			if err = r.write(func() error { return r.remove(name) }); err!=nil { return err }
+		}
+		from = local[len(local)-1]
+	}
+}
+
+func (r *Replica) resyncObject(name []byte) error {
+	lng,err := r.src.Info(name)
+	if err!=nil { return gone(err) }
+	if have,err := r.dst.Info(name); err==nil && have==lng {
+		a,err := sum(r.src,name,lng)
+		if err!=nil { return gone(err) }
+		if b,err := sum(r.dst,name,lng); err==nil && bytes.Equal(a,b) { return nil }
+	}
+	return r.copyObject(name)
+}
+
+// Reads lng bytes of the object into w, in pieces of copyChunk.
+func readTo(svc single.ObjectSvc,name []byte,lng int64,w io.Writer) (err error) {
+	for off := int64(0); off<lng; off += copyChunk {
+		n := lng-off
+		if n>copyChunk { n = copyChunk }
+		if err = single.ReadTo(svc,name,single.ByteRange{off,n},w); err!=nil { return }
+	}
+	return
+}
+
+// Returns the SHA-256 of the first lng bytes of the object.
+func sum(svc single.ObjectSvc,name []byte,lng int64) ([]byte,error) {
+	h := sha256.New()
+	if err := readTo(svc,name,lng,h); err!=nil { return nil,err }
+	return h.Sum(nil),nil
+}
+
+func gone(err error) error {
+	switch single.BoilDownError(err) {
+	case single.ENotFound,single.EBeingDeleted: return nil
+	}
+	return err
+}
+
+func (r *Replica) remove(name []byte) error {
+	return gone(r.dst.DeleteObj(name))
+}
+
+func (r *Replica) apply(e *events.Event) (err error) {
+	name := []byte(e.Object)
+	switch {
+	case e.Type==events.Expired:
+		// Uploads aren't replicated.
+		return nil
+	case e.Type==events.Deleted && e.Version=="":
+		return r.remove(name)
+	case e.Type==events.Appended && len(e.Range)==2:
+		have,err := r.dst.Info(name)
+		switch {
+		case err!=nil && gone(err)!=nil:
+			return err
+		case err==nil && have>=e.Range[0]+e.Range[1]:
+			return nil
+		case err==nil && have==e.Range[0]:
+			var buf bytes.Buffer
+			err = single.ReadTo(r.src,name,single.ByteRange{e.Range[0],e.Range[1]},&buf)
+			if err==nil && int64(buf.Len())==e.Range[1] {
+				_,err = r.dst.Append(name,buf.Bytes())
+				return err
+			}
+			if gone(err)!=nil { return err }
+		}
+	}
+	// Created objects, deleted versions, and the rest.
+	return r.copyObject(name)
+}
+
+// Replaces the local object with the one of the primary, or removes it, if the primary has none.
+func (r *Replica) copyObject(name []byte) (err error) {
+	lng,err := r.src.Info(name)
+	if err!=nil {
+		if gone(err)!=nil { return }
+		return r.remove(name)
+	}
+	
+	// The local object stays, until the copy is complete.
+	pr,pw := io.Pipe()
+	go func() { pw.CloseWithError(readTo(r.src,name,lng,pw)) }()
+	_,err = r.rs.ReplaceObj(name,pr)
+	pr.Close()
+	return gone(err)
+}
+
+/*
+Stops replicating and makes the promotion permanent. Events, that weren't
+applied yet, are lost.
+*/
+func (r *Replica) Promote() (err error) {
+	r.Stop()
+	r.mu.Lock()
+	r.st.Promoted = true
+	r.mu.Unlock()
+	return r.save()
+}
+
+// Stops replicating, and waits for the write in progress, if any.
+func (r *Replica) Stop() {
+	r.once.Do(func(){ close(r.stop) })
+	r.wl.Lock()
+	r.wl.Unlock()
+}
+
+func (r *Replica) Promoted() bool {
+	r.mu.Lock(); defer r.mu.Unlock()
+	return r.st.Promoted
+}
+
+func (r *Replica) Status() (st single.ReplicationStatus) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	st.Primary = r.name
+	st.Applied,st.Last = r.st.Cursor,r.last
+	if st.Last>st.Applied { st.LagEvents = st.Last-st.Applied }
+	if st.LagEvents!=0 || !r.st.Synced { st.LagSeconds = time.Since(r.upToDate).Seconds() }
+	st.Resyncs = r.resyncs
+	if r.err!=nil { st.Error = r.err.Error() }
+	st.Promoted = r.st.Promoted
+	return
+}
+
+///
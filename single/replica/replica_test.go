@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package replica_test
+
+import (
+	"io"
+	"net"
+	"bytes"
+	"testing"
+	"time"
+	"path/filepath"
+	
+	"github.com/valyala/fasthttp"
+	fhr "github.com/byte-mug/golibs/radixroute/fasthttpradix"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+	"github.com/byte-mug/hblobstore/single/admin"
+	"github.com/byte-mug/hblobstore/single/guard"
+	"github.com/byte-mug/hblobstore/single/events"
+	"github.com/byte-mug/hblobstore/single/client"
+	"github.com/byte-mug/hblobstore/single/replica"
+	sfhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+)
+
+func testStore(t *testing.T) single.ObjectSvc {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		if c,ok := svc.(io.Closer); ok { c.Close() }
+	})
+	return svc
+}
+
+// Serves a store with an event log on a loopback port. Returns the store and a client of it.
+func testPrimary(t *testing.T) (single.ObjectSvc,*client.Client) {
+	t.Helper()
+	l,err := events.Open(t.TempDir(),events.Options{})
+	if err!=nil { t.Fatal(err) }
+	svc := events.Wrap(testStore(t),l)
+	router := fhr.New()
+	sfhapi.RegisterObjectSvc(svc,router)
+	sfhapi.RegisterEvents(l,router,nil)
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	go fasthttp.Serve(ln,router.Handler)
+	c,err := client.New("http://"+ln.Addr().String(),client.Options{Timeout:10*time.Second})
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		c.Close()
+		ln.Close()
+		l.Close()
+	})
+	return svc,c
+}
+
+func testReplica(t *testing.T,src replica.Source,dst single.ObjectSvc,pth string) *replica.Replica {
+	t.Helper()
+	r,err := replica.New(src,dst,pth,"primary")
+	if err!=nil { t.Fatal(err) }
+	t.Cleanup(r.Stop)
+	return r
+}
+
+// The content of the object, or "" if it doesn't exist.
+func content(svc single.ObjectSvc,name string) string {
+	var buf bytes.Buffer
+	if single.ReadTo(svc,[]byte(name),single.ByteRange{},&buf)!=nil { return "" }
+	return buf.String()
+}
+
+// Waits, until dst has the objects of want, and nothing else, and the replica has no lag.
+func waitFor(t *testing.T,r *replica.Replica,dst single.ObjectSvc,want map[string]string) {
+	t.Helper()
+	deadline := time.Now().Add(10*time.Second)
+	for {
+		names,err := single.AsListSvc(dst).ListObjs(nil,nil,100)
+		ok := err==nil && len(names)==len(want)
+		for name,data := range want {
+			if content(dst,name)!=data { ok = false }
+		}
+		st := r.Status()
+		if ok && st.Error=="" && st.LagEvents==0 && st.Applied==st.Last { return }
+		if time.Now().After(deadline) { t.Fatalf("replica: %q, status %+v",names,st) }
+		time.Sleep(10*time.Millisecond)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	svc,c := testPrimary(t)
+	svc.PutObj([]byte("a"),[]byte("hello"))
+	svc.PutObj([]byte("b"),[]byte("data"))
+	
+	dst := testStore(t)
+	pth := filepath.Join(t.TempDir(),"state.json")
+	r := testReplica(t,c,dst,pth)
+	if st := r.Status(); st.Applied!=0 || st.LagSeconds<=0 { t.Fatalf("status before the start: %+v",st) }
+	r.Start()
+	waitFor(t,r,dst,map[string]string{"a":"hello","b":"data"})
+	if st := r.Status(); st.Resyncs!=1 || st.Last==0 || st.LagSeconds!=0 { t.Fatalf("status after the resync: %+v",st) }
+	
+	svc.Append([]byte("a"),[]byte(" world"))
+	svc.DeleteObj([]byte("b"))
+	svc.PutObj([]byte("c"),[]byte("new"))
+	single.AsReplaceSvc(svc).ReplaceObj([]byte("c"),bytes.NewReader([]byte("replaced")))
+	waitFor(t,r,dst,map[string]string{"a":"hello world","c":"replaced"})
+	if st := r.Status(); st.Resyncs!=1 { t.Fatalf("the events weren't applied: %+v",st) }
+	
+	if err := r.Promote(); err!=nil { t.Fatal(err) }
+	if !r.Promoted() || !r.Status().Promoted { t.Fatal("not promoted") }
+	svc.PutObj([]byte("d"),[]byte("after"))
+	time.Sleep(50*time.Millisecond)
+	if s := content(dst,"d"); s!="" { t.Fatalf("replicated after the promotion: %q",s) }
+	if _,err := replica.New(c,dst,pth,"primary"); err!=replica.ErrPromoted { t.Fatalf("reopen: %v",err) }
+}
+
+// A promoted replica assumes the configured read only mode.
+func TestPromoteReadOnly(t *testing.T) {
+	_,c := testPrimary(t)
+	for _,ro := range []bool{false,true} {
+		g := guard.New(testStore(t))
+		g.SetReadOnly(ro)
+		adm := admin.New(g,0)
+		adm.SetReplica(testReplica(t,c,g.Unwrap(),filepath.Join(t.TempDir(),"state.json")))
+		if !g.ReadOnly() || !adm.Replicating() { t.Fatal("the replica is writable") }
+		adm.SetReadOnly(false)
+		if !g.ReadOnly() { t.Fatal("the replica is writable") }
+		adm.SetReadOnly(ro)
+		
+		st,err := adm.Promote()
+		if err!=nil { t.Fatal(err) }
+		if !st.Promoted || st.ReadOnly!=ro || g.ReadOnly()!=ro { t.Fatalf("read_only %v: status %+v, guard %v",ro,st,g.ReadOnly()) }
+		if err = g.PutObj([]byte("a"),[]byte("x")); (err==nil)==ro { t.Fatalf("read_only %v: put %v",ro,err) }
+		
+		// Promoting again changes nothing.
+		if st,err = adm.Promote(); err!=nil || st.ReadOnly!=ro { t.Fatalf("read_only %v: promote again: %+v %v",ro,st,err) }
+		adm.SetReadOnly(false)
+		if st,err = adm.Replication(); err!=nil || st.ReadOnly || g.ReadOnly() { t.Fatalf("read_only %v: after SetReadOnly: %+v %v",ro,st,err) }
+	}
+}
+
+// The resynchronization compares the content, not only the length.
+func TestResync(t *testing.T) {
+	svc,c := testPrimary(t)
+	svc.PutObj([]byte("a"),[]byte("hello"))
+	svc.PutObj([]byte("b"),[]byte("same"))
+	dst := testStore(t)
+	dst.PutObj([]byte("a"),[]byte("HELLO"))
+	dst.PutObj([]byte("b"),[]byte("same"))
+	dst.PutObj([]byte("z"),[]byte("stale"))
+	
+	r := testReplica(t,c,dst,filepath.Join(t.TempDir(),"state.json"))
+	r.Start()
+	waitFor(t,r,dst,map[string]string{"a":"hello","b":"same"})
+}
+
+///
@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package s3api
+
+import (
+	"strconv"
+	"strings"
+	"net/url"
+	"encoding/xml"
+	"encoding/base64"
+	
+	"github.com/byte-mug/hblobstore/single"
+)
+
+type listEntry struct{
+	Key          string
+	LastModified string
+	Size         int64
+	StorageClass string
+}
+type listPrefix struct{
+	Prefix string
+}
+
+// The response of ListObjects and ListObjectsV2, which differ in the markers.
+type listResult struct{
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	Delimiter      string `xml:",omitempty"`
+	MaxKeys        int
+	EncodingType   string `xml:",omitempty"`
+	IsTruncated    bool
+	Marker         *string `xml:",omitempty"`
+	NextMarker     string  `xml:",omitempty"`
+	
+	KeyCount              *int    `xml:",omitempty"`
+	StartAfter            string  `xml:",omitempty"`
+	ContinuationToken     string  `xml:",omitempty"`
+	NextContinuationToken string  `xml:",omitempty"`
+	
+	Contents       []listEntry
+	CommonPrefixes []listPrefix
+}
+
+// The names are listed in pages of this size.
+const listPage = 1000
+
+/*
+Lists the objects. The keys are listed in the order of their object names,
+which differs from S3's order, if they contain "%" or "/". CommonPrefixes are
+skipped over, by continuing after their object name prefix.
+*/
+func (r *request) listObjects() {
+	if r.b.ls==nil {
+		setError(r.RequestCtx,single.EOpNotSupp,true)
+		return
+	}
+	args := r.QueryArgs()
+	v2 := string(args.Peek("list-type"))=="2"
+	prefix := string(args.Peek("prefix"))
+	delim := string(args.Peek("delimiter"))
+	maxKeys,err := strconv.Atoi(string(args.Peek("max-keys")))
+	if err!=nil || maxKeys<0 || maxKeys>1000 { maxKeys = 1000 }
+	
+	res := &listResult{Name:r.b.name,Prefix:prefix,Delimiter:delim,MaxKeys:maxKeys}
+	
+	// The object name to continue after.
+	var after string
+	if v2 {
+		res.StartAfter = string(args.Peek("start-after"))
+		res.ContinuationToken = string(args.Peek("continuation-token"))
+		if res.StartAfter!="" { after = r.b.Prefix+escapeKey(res.StartAfter) }
+		if res.ContinuationToken!="" {
+			tok,err := base64.RawURLEncoding.DecodeString(res.ContinuationToken)
+			if err!=nil {
+				writeError(r.RequestCtx,400,"InvalidArgument","Invalid continuation token")
+				return
+			}
+			after = string(tok)
+		}
+	} else {
+		marker := string(args.Peek("marker"))
+		res.Marker = &marker
+		if marker!="" { after = r.b.Prefix+escapeKey(marker) }
+	}
+	
+	pfx := []byte(r.b.Prefix+escapeKey(prefix))
+	lastKey := ""
+	count := 0
+	listing:
+	for count<maxKeys {
+		names,err := r.b.ls.ListObjs(pfx,[]byte(after),listPage)
+		if err!=nil {
+			setError(r.RequestCtx,err,true)
+			return
+		}
+		if len(names)==0 { break }
+		for _,name := range names {
+			if count>=maxKeys {
+				res.IsTruncated = true
+				break listing
+			}
+			after = string(name)
+			key,ok := r.b.name2key(name)
+			if !ok { continue }
+			if delim!="" {
+				if i := strings.Index(key[len(prefix):],delim); i>=0 {
+					cp := key[:len(prefix)+i+len(delim)]
+					res.CommonPrefixes = append(res.CommonPrefixes,listPrefix{cp})
+					count++
+					lastKey = cp
+					
+					// Object names never contain 0xff, thus this skips the prefix.
+					after = r.b.Prefix+escapeKey(cp)+"\xff"
+					continue listing
+				}
+			}
+			st,err := r.b.stat(name,0)
+			if err!=nil { continue } // Deleted meanwhile.
+			e := listEntry{Key:key,Size:st.Length,StorageClass:"STANDARD"}
+			if !st.ModTime.IsZero() { e.LastModified = st.ModTime.UTC().Format(timeFormat) }
+			res.Contents = append(res.Contents,e)
+			count++
+			lastKey = key
+		}
+		if len(names)<listPage { break }
+	}
+	if !res.IsTruncated && count>=maxKeys {
+		more,err := r.b.ls.ListObjs(pfx,[]byte(after),1)
+		res.IsTruncated = err==nil && len(more)!=0
+	}
+	if res.IsTruncated {
+		if v2 {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(after))
+		} else if delim!="" {
+			res.NextMarker = lastKey
+		}
+	}
+	if v2 { res.KeyCount = &count }
+	if string(args.Peek("encoding-type"))=="url" { res.urlEncode() }
+	writeXML(r.RequestCtx,200,res)
+}
+
+func (res *listResult) urlEncode() {
+	res.EncodingType = "url"
+	res.Prefix = url.QueryEscape(res.Prefix)
+	res.Delimiter = url.QueryEscape(res.Delimiter)
+	res.StartAfter = url.QueryEscape(res.StartAfter)
+	res.NextMarker = url.QueryEscape(res.NextMarker)
+	for i := range res.Contents { res.Contents[i].Key = url.QueryEscape(res.Contents[i].Key) }
+	for i := range res.CommonPrefixes { res.CommonPrefixes[i].Prefix = url.QueryEscape(res.CommonPrefixes[i].Prefix) }
+}
+
+///
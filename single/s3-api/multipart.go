@@ -0,0 +1,275 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package s3api
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"strings"
+	"time"
+	"strconv"
+	"sync/atomic"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+/*
+Multipart uploads are kept in reserved objects of the bucket's store:
+".mpu-<id>" holds the key, ".mpu-<id>.<part>" the parts. On completion, the
+parts are streamed into the object, like a PutObject, verifying their ETags on
+the way: A missing or mismatching part fails the write, leaving the old object
+in place. Uploads, that weren't modified for the UploadExpiry, are removed by
+CreateMultipartUpload, like the uploads of package files.
+*/
+const maxParts = 10000
+
+// Uploads, that weren't modified for this duration, are removed by default.
+const defaultUploadExpiry = 24*time.Hour
+
+/*
+Returns, whether the object name is an upload or a part of one, in the buckets
+with one of the prefixes. These should be exempt from a default retention, see
+files.Options.NoRetention, since abandoned uploads couldn't be removed otherwise.
+*/
+func UploadObjects(prefixes []string) func(name []byte) bool {
+	return func(name []byte) bool {
+		for _,p := range prefixes {
+			if bytes.HasPrefix(name,[]byte(p+".mpu-")) { return true }
+		}
+		return false
+	}
+}
+
+func (b *bucket) uploadName(id string) []byte {
+	return []byte(b.Prefix+".mpu-"+id)
+}
+func (b *bucket) partName(id string,part int) []byte {
+	return []byte(fmt.Sprintf("%s.mpu-%s.%05d",b.Prefix,id,part))
+}
+
+func validID(id string) bool {
+	_,err := hex.DecodeString(id)
+	return err==nil && len(id)==32
+}
+
+// Checks, that the upload exists and belongs to the key.
+func (r *request) upload(id string) bool {
+	data,err := r.b.read(r.b.uploadName(id))
+	if err==nil && string(data)!=r.key { err = single.ENotFound }
+	if err==single.ENotFound {
+		writeError(r.RequestCtx,404,"NoSuchUpload","The specified upload does not exist")
+		return false
+	}
+	if err!=nil {
+		setError(r.RequestCtx,err,true)
+		return false
+	}
+	return true
+}
+
+func (r *request) createUpload() {
+	var raw [16]byte
+	if _,err := rand.Read(raw[:]); err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	id := hex.EncodeToString(raw[:])
+	go r.b.sweepUploads()
+	if _,err := r.b.put(r.b.uploadName(id),strings.NewReader(r.key)); err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	writeXML(r.RequestCtx,200,&struct{
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket:r.b.name,Key:r.key,UploadId:id})
+}
+
+func (r *request) multipartOp(id string) {
+	if !validID(id) {
+		writeError(r.RequestCtx,404,"NoSuchUpload","The specified upload does not exist")
+		return
+	}
+	switch {
+	case r.IsPut():
+		if r.allow(proto.OpPut,r.b.name,r.key) && r.upload(id) { r.uploadPart(id) }
+	case r.IsPost():
+		if r.allow(proto.OpPut,r.b.name,r.key) && r.upload(id) { r.completeUpload(id) }
+	case r.IsDelete():
+		if r.allow(proto.OpPut,r.b.name,r.key) && r.upload(id) { r.abortUpload(id) }
+	default:
+		setError(r.RequestCtx,single.EOpNotSupp,true)
+	}
+}
+
+func (r *request) uploadPart(id string) {
+	part,err := strconv.Atoi(string(r.QueryArgs().Peek("partNumber")))
+	if err!=nil || part<1 || part>maxParts {
+		writeError(r.RequestCtx,400,"InvalidArgument","Part number must be an integer between 1 and 10000")
+		return
+	}
+	if _,err = r.b.put(r.b.partName(id,part),bytes.NewReader(r.body)); err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	r.Response.Header.Set("ETag",etag(r.body))
+	r.SetStatusCode(200)
+}
+
+// Removes the upload and its parts.
+func (b *bucket) removeUpload(id string) {
+	if b.ls!=nil {
+		pfx := b.uploadName(id+".")
+		names,_ := b.ls.ListObjs(pfx,nil,maxParts)
+		for _,name := range names { b.Svc.DeleteObj(name) }
+	}
+	b.Svc.DeleteObj(b.uploadName(id))
+}
+
+func (b *bucket) uploadExpiry() time.Duration {
+	if b.UploadExpiry>0 { return b.UploadExpiry }
+	return defaultUploadExpiry
+}
+
+// Returns the latest modification of the upload and its parts.
+func (b *bucket) uploadModTime(id string) (mtime time.Time,err error) {
+	names,err := b.ls.ListObjs(b.uploadName(id+"."),nil,maxParts)
+	if err!=nil { return }
+	for _,name := range append(names,b.uploadName(id)) {
+		st,err := b.ss.Stat(name)
+		if err==single.ENotFound { continue }
+		if err!=nil { return mtime,err }
+		if st.ModTime.After(mtime) { mtime = st.ModTime }
+	}
+	return
+}
+
+// Removes stale uploads, at most once per quarter of the expiry.
+func (b *bucket) sweepUploads() {
+	if b.ls==nil || b.ss==nil { return }
+	exp := b.uploadExpiry()
+	now := time.Now()
+	last := atomic.LoadInt64(&b.sweep)
+	if now.Sub(time.Unix(0,last))<exp/4 || !atomic.CompareAndSwapInt64(&b.sweep,last,now.UnixNano()) { return }
+	
+	pfx := b.uploadName("")
+	var after []byte
+	for {
+		names,err := b.ls.ListObjs(pfx,after,1000)
+		if err!=nil || len(names)==0 { return }
+		after = names[len(names)-1]
+		for _,name := range names {
+			// The parts are removed with their upload.
+			id := string(name[len(pfx):])
+			if !validID(id) { continue }
+			if mtime,err := b.uploadModTime(id); err!=nil || now.Sub(mtime)<exp { continue }
+			b.removeUpload(id)
+		}
+	}
+}
+
+// Fails the completion of an upload, if the part is missing or mismatches.
+type partError int
+func (e partError) Error() string { return "invalid part "+strconv.Itoa(int(e)) }
+
+type completeRequest struct{
+	Parts []struct{
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+func (r *request) completeUpload(id string) {
+	var req completeRequest
+	if err := xml.Unmarshal(r.body,&req); err!=nil || len(req.Parts)==0 {
+		writeError(r.RequestCtx,400,"MalformedXML","")
+		return
+	}
+	for i,p := range req.Parts {
+		if i>0 && p.PartNumber<=req.Parts[i-1].PartNumber {
+			writeError(r.RequestCtx,400,"InvalidPartOrder","The parts must be in ascending order")
+			return
+		}
+	}
+	
+	pr,pw := io.Pipe()
+	sums := make([]byte,0,len(req.Parts)*md5.Size)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _,p := range req.Parts {
+			h := md5.New()
+			err := single.ReadTo(r.b.Svc,r.b.partName(id,p.PartNumber),single.ByteRange{},io.MultiWriter(pw,h))
+			sum := h.Sum(nil)
+			tag := `"`+hex.EncodeToString(sum)+`"`
+			if err==nil && p.ETag!="" && p.ETag!=tag && `"`+p.ETag+`"`!=tag { err = single.ENotFound }
+			if err==single.ENotFound { err = partError(p.PartNumber) }
+			if err!=nil {
+				pw.CloseWithError(err)
+				return
+			}
+			sums = append(sums,sum...)
+		}
+		pw.Close()
+	}()
+	name := r.b.object(r.key)
+	ver,err := r.b.put(name,pr)
+	pr.Close()
+	<-done
+	if part,ok := err.(partError); ok {
+		writeError(r.RequestCtx,400,"InvalidPart","Part "+strconv.Itoa(int(part))+" is missing or its ETag does not match")
+		return
+	}
+	if err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	r.record("complete_upload",name,ver,nil)
+	r.b.removeUpload(id)
+	
+	total := md5.Sum(sums)
+	if ver!=0 { r.Response.Header.Set("X-Amz-Version-Id",ver.String()) }
+	writeXML(r.RequestCtx,200,&struct{
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket:r.b.name,Key:r.key,ETag:`"`+hex.EncodeToString(total[:])+"-"+strconv.Itoa(len(req.Parts))+`"`})
+}
+
+func (r *request) abortUpload(id string) {
+	r.b.removeUpload(id)
+	r.SetStatusCode(204)
+}
+
+///
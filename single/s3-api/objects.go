@@ -0,0 +1,336 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package s3api
+
+import (
+	"io"
+	"sort"
+	"time"
+	"bytes"
+	"strconv"
+	"strings"
+	"net/url"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/proto"
+	
+	fhapi "github.com/byte-mug/hblobstore/single/fasthttp-api"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"`+hex.EncodeToString(sum[:])+`"`
+}
+
+/*
+Writes an object from r. Unlike the store, S3 overwrites existing objects, thus
+the store must replace them atomically, see single.ReplaceSvc. Otherwise, the
+object is buffered and written as a new version, or, if that fails, EExist is
+returned, rather than deleting the old object first.
+*/
+func (b *bucket) put(name []byte,r io.Reader) (ver single.Version,err error) {
+	if b.rs!=nil { return b.rs.ReplaceObj(name,r) }
+	data,err := io.ReadAll(r)
+	if err!=nil { return }
+	if b.vs!=nil { return b.vs.PutVersion(name,data) }
+	err = b.Svc.PutObj(name,data)
+	return
+}
+
+// Reads a small object, like the key of an upload.
+func (b *bucket) read(name []byte) (data []byte,err error) {
+	var buf bytes.Buffer
+	err = single.ReadTo(b.Svc,name,single.ByteRange{},&buf)
+	return buf.Bytes(),err
+}
+
+// Streams an object, or a version of it, to the returned reader.
+func (b *bucket) open(name []byte,ver single.Version) io.ReadCloser {
+	pr,pw := io.Pipe()
+	go func() {
+		var err error
+		if ver!=0 {
+			err = single.ReadVersionTo(b.vs,name,ver,single.ByteRange{},pw)
+		} else {
+			err = single.ReadTo(b.Svc,name,single.ByteRange{},pw)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func (b *bucket) stat(name []byte,ver single.Version) (st single.ObjectStat,err error) {
+	switch {
+	case ver!=0: st.Length,err = b.vs.InfoVersion(name,ver)
+	case b.ss!=nil: st,err = b.ss.Stat(name)
+	default: st.Length,err = b.Svc.Info(name)
+	}
+	return
+}
+
+// Parses a version id. "null" refers to the latest version, like version 0.
+func (b *bucket) version(arg string) (single.Version,error) {
+	if arg=="" || arg=="null" { return 0,nil }
+	if b.vs==nil { return 0,single.EOpNotSupp }
+	ver,err := single.ParseVersion([]byte(arg))
+	if err!=nil || ver==0 { return 0,single.ENotFound }
+	return ver,nil
+}
+
+/*
+Parses a Range header. Invalid and multiple ranges are ignored, as allowed by
+RFC 7233, ok is false for unsatisfiable ones.
+*/
+func parseRange(h string,size int64) (first,n int64,partial,ok bool) {
+	spec := strings.TrimPrefix(h,"bytes=")
+	if spec==h || strings.Contains(spec,",") { return 0,size,false,true }
+	a,z,_ := strings.Cut(spec,"-")
+	if a=="" {
+		n,err := strconv.ParseInt(z,10,64)
+		if err!=nil || n<0 { return 0,size,false,true }
+		if n==0 || size==0 { return 0,0,false,false }
+		if n>size { n = size }
+		return size-n,n,true,true
+	}
+	first,err := strconv.ParseInt(a,10,64)
+	if err!=nil || first<0 { return 0,size,false,true }
+	last := size-1
+	if z!="" {
+		if last,err = strconv.ParseInt(z,10,64); err!=nil || last<first { return 0,size,false,true }
+		if last>=size { last = size-1 }
+	}
+	if first>=size { return 0,0,false,false }
+	return first,last-first+1,true,true
+}
+
+func (r *request) getObject() {
+	name := r.b.object(r.key)
+	ver,err := r.b.version(string(r.QueryArgs().Peek("versionId")))
+	if err!=nil {
+		setError(r.RequestCtx,err,true)
+		return
+	}
+	st,err := r.b.stat(name,ver)
+	if err!=nil {
+		setError(r.RequestCtx,err,true)
+		return
+	}
+	first,n,partial,ok := parseRange(string(r.Request.Header.Peek("Range")),st.Length)
+	if !ok {
+		r.Response.Header.Set("Content-Range","bytes */"+strconv.FormatInt(st.Length,10))
+		writeError(r.RequestCtx,416,"InvalidRange","The requested range is not satisfiable")
+		return
+	}
+	
+	h := &r.Response.Header
+	h.Set("Accept-Ranges","bytes")
+	if !st.ModTime.IsZero() { h.SetLastModified(st.ModTime) }
+	if ver!=0 { h.Set("X-Amz-Version-Id",ver.String()) }
+	h.SetContentType("application/octet-stream")
+	code := 200
+	if partial {
+		code = 206
+		h.Set("Content-Range","bytes "+strconv.FormatInt(first,10)+"-"+strconv.FormatInt(first+n-1,10)+"/"+strconv.FormatInt(st.Length,10))
+	}
+	if r.IsHead() {
+		r.SetStatusCode(code)
+		r.Response.SkipBody = true
+		h.SetContentLength(int(n))
+		return
+	}
+	if n==0 {
+		r.SetStatusCode(code)
+		return
+	}
+	rang := single.ByteRange{first,n}
+	if ver!=0 {
+		err = single.ReadVersionTo(r.b.vs,name,ver,rang,fhapi.Sink(r.RequestCtx))
+	} else {
+		err = single.ReadTo(r.b.Svc,name,rang,fhapi.Sink(r.RequestCtx))
+	}
+	if err!=nil {
+		setError(r.RequestCtx,err,true)
+		return
+	}
+	r.SetStatusCode(code)
+}
+
+func (r *request) putObject() {
+	if src := r.Request.Header.Peek("X-Amz-Copy-Source"); len(src)!=0 {
+		r.copyObject(string(src))
+		return
+	}
+	name := r.b.object(r.key)
+	ver,err := r.b.put(name,bytes.NewReader(r.body))
+	if err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	r.record("put",name,ver,[]int64{0,int64(len(r.body))})
+	r.Response.Header.Set("ETag",etag(r.body))
+	if ver!=0 { r.Response.Header.Set("X-Amz-Version-Id",ver.String()) }
+	r.SetStatusCode(200)
+}
+
+// Copies "<bucket>/<key>[?versionId=<version>]".
+func (r *request) copyObject(src string) {
+	src,err := url.PathUnescape(src)
+	if err!=nil {
+		writeError(r.RequestCtx,400,"InvalidArgument","Invalid copy source")
+		return
+	}
+	src,verArg,_ := strings.Cut(strings.TrimPrefix(src,"/"),"?versionId=")
+	bname,key,_ := strings.Cut(src,"/")
+	sb,ok := r.h.buckets[bname]
+	if !ok || key=="" {
+		writeError(r.RequestCtx,404,"NoSuchBucket","The source bucket does not exist")
+		return
+	}
+	if !r.allow(proto.OpRead,bname,key) { return }
+	ver,err := sb.version(verArg)
+	if err!=nil {
+		setError(r.RequestCtx,err,true)
+		return
+	}
+	
+	// The source is streamed into the destination, computing the ETag on the fly.
+	h := md5.New()
+	var n counter
+	rc := sb.open(sb.object(key),ver)
+	name := r.b.object(r.key)
+	ver,err = r.b.put(name,io.TeeReader(rc,io.MultiWriter(h,&n)))
+	rc.Close()
+	if err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	r.record("put",name,ver,[]int64{0,int64(n)})
+	if ver!=0 { r.Response.Header.Set("X-Amz-Version-Id",ver.String()) }
+	writeXML(r.RequestCtx,200,&struct{
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag:`"`+hex.EncodeToString(h.Sum(nil))+`"`,LastModified:time.Now().UTC().Format(timeFormat)})
+}
+
+// Counts the bytes written to it.
+type counter int64
+func (c *counter) Write(p []byte) (int,error) {
+	*c += counter(len(p))
+	return len(p),nil
+}
+
+// Deletes the object key, or a version of it, and records the mutation.
+func (r *request) delete(key,verArg string) error {
+	b := r.b
+	name := b.object(key)
+	ver,err := b.version(verArg)
+	switch {
+	case err!=nil:
+	case ver!=0: err = b.vs.DeleteVersion(name,ver)
+	default: err = b.Svc.DeleteObj(name)
+	}
+	switch {
+	case err==nil && ver!=0: r.record("delete_version",name,ver,nil)
+	case err==nil: r.record("delete",name,0,nil)
+	case err==single.ENotFound:
+		// Deleting a missing object succeeds in S3.
+		err = nil
+	}
+	return err
+}
+
+func (r *request) deleteObject() {
+	if err := r.delete(r.key,string(r.QueryArgs().Peek("versionId"))); err!=nil {
+		setError(r.RequestCtx,err,false)
+		return
+	}
+	r.SetStatusCode(204)
+}
+
+type deleteRequest struct{
+	Quiet   bool
+	Objects []struct{
+		Key       string
+		VersionId string
+	} `xml:"Object"`
+}
+type deleteError struct{
+	Key     string
+	Code    string
+	Message string
+}
+type deleteResult struct{
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct{
+		Key string
+	}
+	Errors []deleteError `xml:"Error"`
+}
+
+// Deletes up to 1000 objects. Each of them is authorized separately.
+func (r *request) deleteObjects() {
+	var req deleteRequest
+	if err := xml.Unmarshal(r.body,&req); err!=nil || len(req.Objects)>1000 {
+		writeError(r.RequestCtx,400,"MalformedXML","")
+		return
+	}
+	res := new(deleteResult)
+	for _,o := range req.Objects {
+		err := r.h.auth.Authorize(&r.id,proto.OpDelete,r.b.name+"/"+o.Key)
+		if err==nil { err = r.delete(o.Key,o.VersionId) }
+		if err!=nil {
+			code := "InternalError"
+			for _,s := range statuses { if s.err==err { code = s.s3code } }
+			res.Errors = append(res.Errors,deleteError{o.Key,code,err.Error()})
+		} else if !req.Quiet {
+			res.Deleted = append(res.Deleted,struct{ Key string }{o.Key})
+		}
+	}
+	writeXML(r.RequestCtx,200,res)
+}
+
+func (r *request) listBuckets() {
+	type bucketEntry struct{
+		Name         string
+		CreationDate string
+	}
+	res := &struct{
+		XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+		Buckets []bucketEntry `xml:"Buckets>Bucket"`
+	}{}
+	for name := range r.h.buckets {
+		res.Buckets = append(res.Buckets,bucketEntry{name,time.Unix(0,0).UTC().Format(timeFormat)})
+	}
+	sort.Slice(res.Buckets,func(i,j int) bool { return res.Buckets[i].Name<res.Buckets[j].Name })
+	writeXML(r.RequestCtx,200,res)
+}
+
+///
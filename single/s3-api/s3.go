@@ -0,0 +1,323 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+/*
+An S3 compatible frontend for single.ObjectSvc.
+
+Supported are ListBuckets, HeadBucket, GetBucketLocation, ListObjects(V2),
+PutObject, CopyObject, GetObject with Range, HeadObject, DeleteObject,
+DeleteObjects and the multipart upload. Buckets are configured statically,
+each one maps to a store and an object name prefix. Only path-style requests
+are supported, like "http://host/bucket/key".
+
+Keys are mapped to object names by escaping "%", "/" and a leading ".", thus
+object names starting with "." are reserved for the multipart uploads.
+
+Requests are authenticated by SigV4, using the HMAC keys of package auth as
+access keys, and authorized on "<bucket>/<key>".
+*/
+package s3api
+
+import (
+	"time"
+	"strings"
+	"net/url"
+	"crypto/x509"
+	"encoding/xml"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/auth"
+	"github.com/byte-mug/hblobstore/single/proto"
+)
+
+type Bucket struct{
+	Svc single.ObjectSvc
+	
+	// Prepended to the object names, thus several buckets can share a store.
+	Prefix string
+	
+	// Multipart uploads, that weren't modified for this duration, are
+	// removed. Defaults to a day.
+	UploadExpiry time.Duration
+}
+
+type bucket struct{
+	// The time of the last sweep of the uploads, in nanoseconds. First, for the alignment.
+	sweep int64
+	
+	Bucket
+	name string
+	vs   single.VersionSvc
+	ls   single.ListSvc
+	ss   single.StatSvc
+	rs   single.ReplaceSvc
+}
+
+// Escapes an S3 key, or a prefix of it, into an object name.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i<len(key); i++ {
+		switch c := key[i]; {
+		case c=='%': b.WriteString("%25")
+		case c=='/': b.WriteString("%2F")
+		case c=='.' && i==0: b.WriteString("%2E")
+		default: b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Reverts escapeKey. Reserved names are rejected.
+func unescapeKey(name string) (string,bool) {
+	if strings.HasPrefix(name,".") { return "",false }
+	key,err := url.PathUnescape(name)
+	return key,err==nil
+}
+
+func (b *bucket) name2key(name []byte) (string,bool) {
+	return unescapeKey(string(name[len(b.Prefix):]))
+}
+func (b *bucket) object(key string) []byte {
+	return []byte(b.Prefix+escapeKey(key))
+}
+
+type Handler struct{
+	buckets map[string]*bucket
+	auth    *auth.Auth
+}
+
+// Creates the frontend. Requests are checked by a, if not nil.
+func New(buckets map[string]Bucket,a *auth.Auth) *Handler {
+	h := &Handler{buckets:make(map[string]*bucket,len(buckets)),auth:a}
+	for name,b := range buckets {
+		h.buckets[name] = &bucket{
+			Bucket: b,
+			name: name,
+			vs: single.AsVersionSvc(b.Svc),
+			ls: single.AsListSvc(b.Svc),
+			ss: single.AsStatSvc(b.Svc),
+			rs: single.AsReplaceSvc(b.Svc),
+		}
+	}
+	return h
+}
+
+type errorResponse struct{
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+type s3status struct{
+	err    error
+	code   int
+	s3code string
+}
+
+var statuses = []s3status{
+	{single.ENotFound          ,404,"NoSuchKey"},
+	{single.EInvalid           ,400,"InvalidArgument"},
+	{single.EExist             ,409,"OperationAborted"},
+	{single.EBeingDeleted      ,409,"OperationAborted"},
+	{single.EIsReadOnly        ,403,"AccessDenied"},
+	{single.ERetained          ,403,"AccessDenied"},
+	{single.EUnauthorized      ,403,"AccessDenied"},
+	{single.EForbidden         ,403,"AccessDenied"},
+	{single.EOpNotSupp         ,501,"NotImplemented"},
+}
+
+func writeXML(ctx *fasthttp.RequestCtx,code int,v interface{}) {
+	data,_ := xml.Marshal(v)
+	ctx.SetContentType("application/xml")
+	ctx.SetStatusCode(code)
+	ctx.SetBodyString(xml.Header)
+	ctx.Write(data)
+}
+
+func writeError(ctx *fasthttp.RequestCtx,code int,s3code,msg string) {
+	writeXML(ctx,code,&errorResponse{Code:s3code,Message:msg,Resource:string(ctx.URI().PathOriginal())})
+}
+
+/*
+Writes the error response of err. isR is true for read operations, where
+EBeingDeleted is reported as NoSuchKey.
+*/
+func setError(ctx *fasthttp.RequestCtx,err error,isR bool) {
+	err = single.BoilDownError(err)
+	if err==single.EBeingDeleted && isR { err = single.ENotFound }
+	for _,s := range statuses {
+		if s.err==err {
+			writeError(ctx,s.code,s.s3code,err.Error())
+			return
+		}
+	}
+	writeError(ctx,500,"InternalError",err.Error())
+}
+
+// Exposes a *fasthttp.RequestCtx as auth.Request. S3 keys are not normalized.
+type authReq struct{
+	ctx  *fasthttp.RequestCtx
+	path string
+}
+func (r authReq) Method() string { return string(r.ctx.Method()) }
+func (r authReq) Path() string { return r.path }
+func (r authReq) RawQuery() string { return string(r.ctx.URI().QueryString()) }
+func (r authReq) Header(name string) string { return string(r.ctx.Request.Header.Peek(name)) }
+func (r authReq) VerifiedChains() [][]*x509.Certificate {
+	st := r.ctx.TLSConnectionState()
+	if st==nil { return nil }
+	return st.VerifiedChains
+}
+
+// The state of a request.
+type request struct{
+	*fasthttp.RequestCtx
+	h    *Handler
+	id   auth.Identity
+	b    *bucket
+	key  string
+	
+	// The decoded body.
+	body []byte
+}
+
+// Authenticates the request and decodes the body.
+func (r *request) authenticate(path string) (err error) {
+	if r.id,err = r.h.auth.Authenticate(authReq{r.RequestCtx,path}); err!=nil { return }
+	if r.id.Principal!="" { r.SetUserValue(auth.PrincipalKey,r.id.Principal) }
+	r.body = r.Request.Body()
+	if r.id.Stream!=nil {
+		r.body,err = r.id.Stream.Decode(r.body)
+		return
+	}
+	return r.id.CheckPayload(r.body)
+}
+
+// Records a successful mutation of the object name, for the audit log.
+func (r *request) record(op string,name []byte,ver single.Version,rng []int64) {
+	ms,_ := r.UserValue(proto.MutationsKey).([]proto.Mutation)
+	r.SetUserValue(proto.MutationsKey,append(ms,proto.Mutation{Op:op,Object:string(name),Version:ver,Range:rng}))
+}
+
+// Authorizes op on "<bucket>/<name>". Writes the error response on failure.
+func (r *request) allow(op proto.Op,bucketName,name string) bool {
+	if err := r.h.auth.Authorize(&r.id,op,bucketName+"/"+name); err!=nil {
+		setError(r.RequestCtx,err,false)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) Handle(ctx *fasthttp.RequestCtx) {
+	path,err := url.PathUnescape(string(ctx.URI().PathOriginal()))
+	if err!=nil || !strings.HasPrefix(path,"/") {
+		writeError(ctx,400,"InvalidURI","")
+		return
+	}
+	r := &request{RequestCtx:ctx,h:h}
+	if err = r.authenticate(path); err==single.EUnauthorized {
+		setError(ctx,err,false)
+		return
+	} else if err!=nil {
+		writeError(ctx,400,"IncompleteBody",err.Error())
+		return
+	}
+	
+	bname,key,_ := strings.Cut(path[1:],"/")
+	r.key = key
+	if bname=="" {
+		if !r.IsGet() {
+			writeError(ctx,405,"MethodNotAllowed","")
+		} else if r.allow(proto.OpList,"","") {
+			r.listBuckets()
+		}
+		return
+	}
+	var ok bool
+	if r.b,ok = h.buckets[bname]; !ok {
+		writeError(ctx,404,"NoSuchBucket","The specified bucket does not exist")
+		return
+	}
+	if key=="" {
+		r.bucketOp()
+	} else {
+		r.objectOp()
+	}
+}
+
+func (r *request) bucketOp() {
+	args := r.QueryArgs()
+	switch {
+	case r.IsHead():
+		if r.allow(proto.OpInfo,r.b.name,"") { r.SetStatusCode(200) }
+	case r.IsPut():
+		// Buckets are configured statically, thus they exist already.
+		if r.allow(proto.OpPut,r.b.name,"") { r.SetStatusCode(200) }
+	case r.IsGet() && args.Has("location"):
+		if r.allow(proto.OpInfo,r.b.name,"") {
+			writeXML(r.RequestCtx,200,&struct{
+				XMLName xml.Name `xml:"LocationConstraint"`
+			}{})
+		}
+	case r.IsGet() && args.Has("versioning"):
+		if r.allow(proto.OpInfo,r.b.name,"") {
+			v := &struct{
+				XMLName xml.Name `xml:"VersioningConfiguration"`
+				Status  string   `xml:",omitempty"`
+			}{}
+			if r.b.vs!=nil { v.Status = "Enabled" }
+			writeXML(r.RequestCtx,200,v)
+		}
+	case r.IsGet() && args.Has("uploads"):
+		setError(r.RequestCtx,single.EOpNotSupp,true)
+	case r.IsGet():
+		if r.allow(proto.OpList,r.b.name,string(args.Peek("prefix"))) { r.listObjects() }
+	case r.IsPost() && args.Has("delete"):
+		r.deleteObjects()
+	default:
+		writeError(r.RequestCtx,405,"MethodNotAllowed","")
+	}
+}
+
+func (r *request) objectOp() {
+	args := r.QueryArgs()
+	switch {
+	case args.Has("uploadId"):
+		r.multipartOp(string(args.Peek("uploadId")))
+	case r.IsPost() && args.Has("uploads"):
+		if r.allow(proto.OpPut,r.b.name,r.key) { r.createUpload() }
+	case r.IsGet() || r.IsHead():
+		if r.allow(proto.OpRead,r.b.name,r.key) { r.getObject() }
+	case r.IsPut():
+		if r.allow(proto.OpPut,r.b.name,r.key) { r.putObject() }
+	case r.IsDelete():
+		if r.allow(proto.OpDelete,r.b.name,r.key) { r.deleteObject() }
+	default:
+		writeError(r.RequestCtx,405,"MethodNotAllowed","")
+	}
+}
+
+///
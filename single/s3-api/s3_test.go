@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package s3api
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"sync/atomic"
+	
+	"github.com/valyala/fasthttp"
+	"github.com/byte-mug/hblobstore/single"
+	"github.com/byte-mug/hblobstore/single/files"
+)
+
+func testHandler(t *testing.T) (*Handler,single.ObjectSvc) {
+	t.Helper()
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{})
+	if err!=nil { t.Fatal(err) }
+	return New(map[string]Bucket{"b":{Svc:svc}},nil),svc
+}
+
+// Performs a request, and returns the status and body.
+func do(h *Handler,method,uri string,body []byte,hdr ...string) (int,string) {
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody(body)
+	for i := 0; i+1<len(hdr); i += 2 { ctx.Request.Header.Set(hdr[i],hdr[i+1]) }
+	h.Handle(ctx)
+	return ctx.Response.StatusCode(),string(ctx.Response.Body())
+}
+
+func readObj(t *testing.T,svc single.ObjectSvc,name string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := single.ReadTo(svc,[]byte(name),single.ByteRange{},&buf); err!=nil { t.Fatalf("read %s: %v",name,err) }
+	return buf.String()
+}
+
+func TestPutOverwrites(t *testing.T) {
+	h,svc := testHandler(t)
+	for _,s := range []string{"one","two"} {
+		if code,body := do(h,"PUT","/b/k",[]byte(s)); code!=200 { t.Fatalf("put %s: %d %s",s,code,body) }
+	}
+	if s := readObj(t,svc,"k"); s!="two" { t.Fatalf("k = %q",s) }
+	
+	code,body := do(h,"PUT","/b/c",nil,"X-Amz-Copy-Source","/b/k")
+	if code!=200 || !bytes.Contains([]byte(body),[]byte(strings.Trim(etag([]byte("two")),`"`))) { t.Fatalf("copy: %d %s",code,body) }
+	if s := readObj(t,svc,"c"); s!="two" { t.Fatalf("c = %q",s) }
+	if code,_ = do(h,"PUT","/b/c",nil,"X-Amz-Copy-Source","/b/missing"); code!=404 { t.Fatalf("copy of a missing object: %d",code) }
+	if s := readObj(t,svc,"c"); s!="two" { t.Fatalf("c after a failed copy = %q",s) }
+}
+
+var uploadID = regexp.MustCompile(`<UploadId>([0-9a-f]+)</UploadId>`)
+
+func TestCompleteUpload(t *testing.T) {
+	h,svc := testHandler(t)
+	if code,_ := do(h,"PUT","/b/k",[]byte("old")); code!=200 { t.Fatalf("put: %d",code) }
+	code,body := do(h,"POST","/b/k?uploads",nil)
+	m := uploadID.FindStringSubmatch(body)
+	if code!=200 || m==nil { t.Fatalf("create: %d %s",code,body) }
+	id := m[1]
+	for i,s := range []string{"aa","bb"} {
+		if code,body = do(h,"PUT","/b/k?uploadId="+id+"&partNumber="+string(rune('1'+i)),[]byte(s)); code!=200 { t.Fatalf("part %d: %d %s",i+1,code,body) }
+	}
+	
+	// A mismatching ETag fails the completion, and keeps the old object.
+	bad := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber></Part><Part><PartNumber>2</PartNumber><ETag>"0"</ETag></Part></CompleteMultipartUpload>`
+	if code,body = do(h,"POST","/b/k?uploadId="+id,[]byte(bad)); code!=400 { t.Fatalf("bad completion: %d %s",code,body) }
+	if s := readObj(t,svc,"k"); s!="old" { t.Fatalf("k after a failed completion = %q",s) }
+	
+	good := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>`+etag([]byte("aa"))+`</ETag></Part><Part><PartNumber>2</PartNumber></Part></CompleteMultipartUpload>`
+	if code,body = do(h,"POST","/b/k?uploadId="+id,[]byte(good)); code!=200 { t.Fatalf("completion: %d %s",code,body) }
+	if s := readObj(t,svc,"k"); s!="aabb" { t.Fatalf("k = %q",s) }
+}
+
+// Abandoned uploads are removed, although the store retains new objects.
+func TestUploadSweep(t *testing.T) {
+	svc,err := files.ServeFileOpts(t.TempDir(),files.Options{DefaultRetention:time.Hour,NoRetention:UploadObjects([]string{""})})
+	if err!=nil { t.Fatal(err) }
+	h := New(map[string]Bucket{"b":{Svc:svc,UploadExpiry:50*time.Millisecond}},nil)
+	code,body := do(h,"POST","/b/k?uploads",nil)
+	m := uploadID.FindStringSubmatch(body)
+	if code!=200 || m==nil { t.Fatalf("create: %d %s",code,body) }
+	if code,body = do(h,"PUT","/b/k?uploadId="+m[1]+"&partNumber=1",[]byte("aa")); code!=200 { t.Fatalf("part: %d %s",code,body) }
+	
+	b := h.buckets["b"]
+	time.Sleep(100*time.Millisecond)
+	if code,body = do(h,"POST","/b/k2?uploads",nil); code!=200 { t.Fatalf("second create: %d %s",code,body) }
+	atomic.StoreInt64(&b.sweep,0)
+	b.sweepUploads()
+	names,err := single.AsListSvc(svc).ListObjs(nil,nil,0)
+	if err!=nil || len(names)!=1 || !bytes.HasPrefix(names[0],[]byte(".mpu-")) { t.Fatalf("objects after the sweep: %q, %v",names,err) }
+	if code,_ = do(h,"PUT","/b/k?uploadId="+m[1]+"&partNumber=2",[]byte("bb")); code!=404 { t.Fatalf("part of a removed upload: %d",code) }
+}
+
+///
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2020 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package single
+
+import (
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+/*
+Receives the content of an object. This is the type-safe replacement of
+RdOps and its unsafe.Pointer.
+
+A Sink may implement BodySetter, FileSink and io.ReaderFrom as fast paths.
+*/
+type Sink interface{
+	io.Writer
+}
+
+// Implemented by Sinks, that can take the whole content at once, like
+// *fasthttp.RequestCtx. The Sink may retain data.
+type BodySetter interface{
+	SetBody(data []byte)
+}
+
+/*
+Implemented by Sinks, that can send a range of a file directly, using
+sendfile(2), where possible.
+
+SendFile takes ownership of f and done, even on error. The Sink closes f and
+calls done, once the range has been sent or discarded, which might happen
+after SendFile has returned.
+*/
+type FileSink interface{
+	SendFile(f *os.File,off,n int64,done func()) error
+}
+
+// Implemented by stores, that support the Sink-based read API.
+type SinkSvc interface{
+	ObjectSvc
+	ReadObjTo(objectId []byte,pos ByteRange,sink Sink) (err error)
+}
+
+// Implemented by versioned stores, that support the Sink-based read API.
+type VersionSinkSvc interface{
+	VersionSvc
+	ReadVersionTo(objectId []byte,ver Version,pos ByteRange,sink Sink) (err error)
+}
+
+// Writes data into sink, using the BodySetter fast path, if possible.
+func WriteBody(sink Sink,data []byte) (err error) {
+	if bs,ok := sink.(BodySetter); ok {
+		bs.SetBody(data)
+		return
+	}
+	_,err = sink.Write(data)
+	return
+}
+
+// Adapts a Sink to the RdOps API.
+type sinkBox struct{
+	sink Sink
+}
+var sinkBoxes = sync.Pool{New:func() interface{} { return new(sinkBox) }}
+
+func sinkCast(p unsafe.Pointer) *sinkBox {
+	return (*sinkBox)(p)
+}
+func sinkSetBody(p unsafe.Pointer,data []byte) {
+	WriteBody(sinkCast(p).sink,data)
+}
+func sinkGetBodyBuffer(p unsafe.Pointer) io.Writer {
+	return sinkCast(p).sink
+}
+
+var sinkOps = RdOps{
+	SetBody: sinkSetBody,
+	GetBodyBuffer: sinkGetBodyBuffer,
+}
+
+/*
+Reads an object into sink. Stores, that don't implement SinkSvc, are read
+using ReadObj.
+*/
+func ReadTo(svc ObjectSvc,objectId []byte,pos ByteRange,sink Sink) (err error) {
+	if ss,ok := svc.(SinkSvc); ok { return ss.ReadObjTo(objectId,pos,sink) }
+	b := sinkBoxes.Get().(*sinkBox)
+	b.sink = sink
+	err = svc.ReadObj(objectId,pos,&sinkOps,unsafe.Pointer(b))
+	b.sink = nil
+	sinkBoxes.Put(b)
+	return
+}
+
+// Like ReadTo, but reads a specific version.
+func ReadVersionTo(svc VersionSvc,objectId []byte,ver Version,pos ByteRange,sink Sink) (err error) {
+	if ss,ok := svc.(VersionSinkSvc); ok { return ss.ReadVersionTo(objectId,ver,pos,sink) }
+	b := sinkBoxes.Get().(*sinkBox)
+	b.sink = sink
+	err = svc.ReadVersion(objectId,ver,pos,&sinkOps,unsafe.Pointer(b))
+	b.sink = nil
+	sinkBoxes.Put(b)
+	return
+}
+
+///
@@ -23,6 +23,11 @@ SOFTWARE.
 
 package single
 
+import (
+	"strconv"
+	"time"
+)
+
 // Represents a Byte-Range.
 type ByteRange [2]int64
 
@@ -44,4 +49,104 @@ func (b ByteRange) Length64() (int64,bool) {
 	return b[1],b[1]>0
 }
 
+// Identifies a version of an object. The zero value denotes the latest version.
+type Version uint64
+
+func (v Version) String() string {
+	return string(v.AppendTo(make([]byte,0,16)))
+}
+// Appends the version as a fixed-width, 16 digit hex-string.
+func (v Version) AppendTo(dst []byte) []byte {
+	const digits = "0123456789abcdef"
+	for i := 60; i>=0; i-=4 {
+		dst = append(dst,digits[(v>>uint(i))&0xf])
+	}
+	return dst
+}
+func (v Version) MarshalText() ([]byte,error) {
+	return v.AppendTo(make([]byte,0,16)),nil
+}
+func (v *Version) UnmarshalText(s []byte) (err error) {
+	*v,err = ParseVersion(s)
+	return
+}
+
+// Parses a version string, as generated by Version.String().
+func ParseVersion(s []byte) (Version,error) {
+	u,err := strconv.ParseUint(string(s),16,64)
+	return Version(u),err
+}
+
+// Describes a single version of an object.
+type VersionInfo struct{
+	Version Version `json:"version"`
+	Length  int64   `json:"length"`
+	
+	// True, if this version is a delete marker.
+	Deleted bool    `json:"deleted"`
+}
+
+// The metadata of an object.
+type ObjectStat struct{
+	Length  int64
+	ModTime time.Time
+}
+
+// The highest part number of a multipart upload.
+const MaxParts = 10000
+
+// Describes an uploaded part of a multipart upload.
+type PartInfo struct{
+	Part   int   `json:"part"`
+	Length int64 `json:"length"`
+}
+
+// Describes a snapshot of a store.
+type SnapshotInfo struct{
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Objects int       `json:"objects"`
+	Bytes   int64     `json:"bytes"`
+	
+	// True, if the objects were cloned, rather than hard-linked.
+	Reflink bool      `json:"reflink"`
+}
+
+// Describes the state of a replica.
+type ReplicationStatus struct{
+	Primary string `json:"primary"`
+	
+	// The last event of the primary, that was applied, and the last one, that is known.
+	Applied uint64 `json:"applied"`
+	Last    uint64 `json:"last"`
+	
+	// The events, that aren't applied yet, and the time since the replica was up to date.
+	LagEvents  uint64  `json:"lag_events"`
+	LagSeconds float64 `json:"lag_seconds"`
+	
+	// The number of full resynchronizations, and the last error, if any.
+	Resyncs int    `json:"resyncs"`
+	Error   string `json:"error,omitempty"`
+	
+	Promoted bool `json:"promoted"`
+	
+	// Whether the store is read only, reported by the admin API. A promoted
+	// replica remains read only, if it's configured so.
+	ReadOnly bool `json:"read_only"`
+}
+
+// Describes the write-once retention of an object.
+type Retention struct{
+	// Unix time in seconds, until which the object can not be modified.
+	Until int64
+	
+	// Keeps the object, regardless of Until.
+	LegalHold bool
+}
+
+// Returns true, if the object is protected at the time now.
+func (r Retention) Active(now time.Time) bool {
+	return r.LegalHold || r.Until>now.Unix()
+}
+
 //
@@ -172,6 +172,16 @@ func (s *Strpool) Load(k []byte) (string,bool) {
 func (s *Strpool) Delete(k []byte) {
 	if e := s.get(k); e!=nil { e.set("",false) }
 }
+// Returns the number of stored strings. Takes time proportional to the size of the pool.
+func (s *Strpool) Len() (n int) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	m := s.rm
+	if s.isAmended() { m = s.wm }
+	for _,e := range m {
+		if _,ok := e.get(); ok { n++ }
+	}
+	return
+}
 func (s *Strpool) LoadOrStore(k []byte,val string) (actual string,loaded bool) {
 	var ok bool
 	for {